@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	attrdef_command = app.Command(
+		"attrdef", "Dump the attribute definitions in $AttrDef.")
+
+	attrdef_command_file_arg = imageFileArg(attrdef_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	attrdef_command_image_offset = attrdef_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+)
+
+func doAttrDef() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *attrdef_command_image_offset,
+		Reader: getReader(attrdef_command_file_arg),
+	}, 1024, 10000)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	registry, err := ntfs_ctx.LoadAttrDef()
+	kingpin.FatalIfError(err, "Can not parse $AttrDef")
+
+	for _, definition := range registry.Definitions() {
+		serialized, err := json.Marshal(definition)
+		kingpin.FatalIfError(err, "Marshal")
+		fmt.Println(string(serialized))
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case attrdef_command.FullCommand():
+			doAttrDef()
+		default:
+			return false
+		}
+		return true
+	})
+}
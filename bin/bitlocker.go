@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	recovery_password_flag = app.Flag(
+		"recovery_password", "BitLocker recovery password (e.g. 123456-...).",
+	).String()
+
+	startup_key_flag = app.Flag(
+		"startup_key", "Path to a BitLocker startup key (.BEK) file.",
+	).String()
+
+	fvek_flag = app.Flag(
+		"fvek", "The BitLocker FVEK as a hex string.",
+	).String()
+
+	bitlocker_command = app.Command(
+		"bitlocker", "Show the BitLocker metadata of a volume.")
+
+	bitlocker_command_file_arg = imageFileArg(bitlocker_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+)
+
+func getBitLockerKeys() *parser.BitLockerKeys {
+	result := &parser.BitLockerKeys{
+		RecoveryPassword: *recovery_password_flag,
+	}
+
+	if *startup_key_flag != "" {
+		data, err := os.ReadFile(*startup_key_flag)
+		kingpin.FatalIfError(err, "Can not read startup key")
+		result.StartupKey = data
+	}
+
+	if *fvek_flag != "" {
+		data, err := hex.DecodeString(strings.TrimSpace(*fvek_flag))
+		kingpin.FatalIfError(err, "Invalid FVEK")
+		result.FVEK = data
+	}
+
+	return result
+}
+
+// Decrypt the volume if it is BitLocker encrypted.
+func openBitLocker(volume io.ReaderAt) io.ReaderAt {
+	if !parser.IsBitLocker(volume) {
+		return volume
+	}
+
+	reader, err := parser.NewBitLockerReader(volume, getBitLockerKeys())
+	kingpin.FatalIfError(err,
+		"Can not unlock BitLocker volume (try --recovery_password, --startup_key or --fvek)")
+
+	return reader
+}
+
+func doBitLocker() {
+	metadata, err := parser.GetBitLockerMetadata(
+		getReader(bitlocker_command_file_arg.Volume()))
+	kingpin.FatalIfError(err, "Can not read BitLocker metadata")
+
+	serialized, err := json.MarshalIndent(metadata, " ", " ")
+	kingpin.FatalIfError(err, "Marshal")
+
+	fmt.Println(string(serialized))
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case bitlocker_command.FullCommand():
+			doBitLocker()
+		default:
+			return false
+		}
+		return true
+	})
+}
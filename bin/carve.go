@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -13,9 +14,13 @@ var (
 	carve_command = app.Command(
 		"carve", "Carve USN records from the disk.")
 
-	carve_command_file_arg = carve_command.Arg(
+	carve_command_file_arg = imageFileArg(carve_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
+
+	carve_command_unallocated = carve_command.Flag(
+		"unallocated", "Only carve the unallocated clusters.",
+	).Bool()
 )
 
 const carve_template = `
@@ -30,7 +35,7 @@ SourceInfo: %s
 
 func doCarve() {
 	reader, _ := parser.NewPagedReader(
-		getReader(*carve_command_file_arg), 1024, 10000)
+		getReader(carve_command_file_arg), 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
 	kingpin.FatalIfError(err, "Can not open filesystem")
@@ -38,8 +43,19 @@ func doCarve() {
 	size := ntfs_ctx.Boot.VolumeSize() * int64(ntfs_ctx.Boot.Sector_size())
 	fmt.Printf("VolumeSize %v\n", size)
 
+	var stream io.ReaderAt = reader
+	if *carve_command_unallocated {
+		unallocated, err := parser.NewUnallocatedReader(
+			context.Background(), ntfs_ctx)
+		kingpin.FatalIfError(err, "Can not read $Bitmap")
+
+		stream = unallocated
+		size = unallocated.Size()
+		fmt.Printf("Unallocated %v\n", size)
+	}
+
 	for record := range parser.CarveUSN(
-		context.Background(), ntfs_ctx, reader, size) {
+		context.Background(), ntfs_ctx, stream, size) {
 
 		filename := record.Filename()
 
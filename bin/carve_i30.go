@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	carve_i30_command = app.Command(
+		"carve_i30", "Carve INDX records from a disk image.")
+
+	carve_i30_command_file_arg = imageFileArg(carve_i30_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	carve_i30_command_image_offset = carve_i30_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	carve_i30_command_unallocated = carve_i30_command.Flag(
+		"unallocated", "Only carve the unallocated clusters.",
+	).Bool()
+)
+
+func doCarveI30() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *carve_i30_command_image_offset,
+		Reader: getReader(carve_i30_command_file_arg),
+	}, 1024, 10000)
+
+	var stream io.ReaderAt = reader
+	size := carve_i30_command_file_arg.Size() - *carve_i30_command_image_offset
+
+	// Without a file system the parent directories are not
+	// resolved.
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	if err != nil {
+		ntfs_ctx = nil
+	}
+
+	if *carve_i30_command_unallocated {
+		if ntfs_ctx == nil {
+			kingpin.Fatalf("--unallocated requires a filesystem")
+		}
+
+		unallocated, err := parser.NewUnallocatedReader(
+			context.Background(), ntfs_ctx)
+		kingpin.FatalIfError(err, "Can not read $Bitmap")
+
+		stream = unallocated
+		size = unallocated.Size()
+	}
+
+	for record := range parser.CarveI30(
+		context.Background(), ntfs_ctx, stream, size) {
+		serialized, err := json.Marshal(record)
+		kingpin.FatalIfError(err, "Marshal")
+
+		fmt.Println(string(serialized))
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case carve_i30_command.FullCommand():
+			doCarveI30()
+		default:
+			return false
+		}
+		return true
+	})
+}
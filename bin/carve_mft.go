@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	carve_mft_command = app.Command(
+		"carve_mft", "Carve MFT entries from a disk or memory image.")
+
+	carve_mft_command_file_arg = imageFileArg(carve_mft_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	carve_mft_command_image_offset = carve_mft_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	carve_mft_command_unallocated = carve_mft_command.Flag(
+		"unallocated", "Only carve the unallocated clusters.",
+	).Bool()
+)
+
+type CarvedHighlights struct {
+	*parser.MFTCarvedRecord
+	FullPath string
+}
+
+func doCarveMFT() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *carve_mft_command_image_offset,
+		Reader: getReader(carve_mft_command_file_arg),
+	}, 1024, 10000)
+
+	var stream io.ReaderAt = reader
+	size := carve_mft_command_file_arg.Size() - *carve_mft_command_image_offset
+
+	// Carving works without a file system (e.g. memory images or
+	// reformatted disks).
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	if err != nil {
+		ntfs_ctx = nil
+	}
+
+	if *carve_mft_command_unallocated {
+		if ntfs_ctx == nil {
+			kingpin.Fatalf("--unallocated requires a filesystem")
+		}
+
+		unallocated, err := parser.NewUnallocatedReader(
+			context.Background(), ntfs_ctx)
+		kingpin.FatalIfError(err, "Can not read $Bitmap")
+
+		stream = unallocated
+		size = unallocated.Size()
+	}
+
+	for record := range parser.CarveMFT(
+		context.Background(), ntfs_ctx, stream, size) {
+		serialized, err := json.Marshal(CarvedHighlights{
+			MFTCarvedRecord: record,
+			FullPath:        record.FullPath(),
+		})
+		kingpin.FatalIfError(err, "Marshal")
+
+		fmt.Println(string(serialized))
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case carve_mft_command.FullCommand():
+			doCarveMFT()
+		default:
+			return false
+		}
+		return true
+	})
+}
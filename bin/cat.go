@@ -12,9 +12,9 @@ var (
 	cat_command = app.Command(
 		"cat", "Dump file stream.")
 
-	cat_command_file_arg = cat_command.Arg(
+	cat_command_file_arg = imageFileArg(cat_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	cat_command_arg = cat_command.Arg(
 		"path", "The path to extract to an MFT entry.",
@@ -28,6 +28,14 @@ var (
 		"image_offset", "The offset in the image to use.",
 	).Int64()
 
+	cat_command_efs_raw = cat_command.Flag(
+		"efs_raw", "Export an EFS encrypted file in the ReadEncryptedFileRaw format.",
+	).Bool()
+
+	cat_command_ea = cat_command.Flag(
+		"ea", "Dump the raw value of this extended attribute.",
+	).String()
+
 	cat_command_output_file = cat_command.Flag(
 		"out", "Write to this file",
 	).OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
@@ -36,18 +44,39 @@ var (
 func doCAT() {
 	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
 		Offset: *cat_command_image_offset,
-		Reader: getReader(*cat_command_file_arg),
+		Reader: getReader(cat_command_file_arg),
 	}, 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
 	kingpin.FatalIfError(err, "Can not open filesystem")
 
-	mft_entry, err := GetMFTEntry(ntfs_ctx, *cat_command_arg)
+	ntfs_ctx, path := openSnapshotPath(ntfs_ctx, reader, *cat_command_arg)
+
+	mft_entry, err := GetMFTEntry(ntfs_ctx, path)
 	kingpin.FatalIfError(err, "Can not open path")
 
+	var fd io.WriteCloser = os.Stdout
+	if *cat_command_output_file != nil {
+		fd = *cat_command_output_file
+		defer fd.Close()
+	}
+
+	if *cat_command_efs_raw {
+		err = parser.ExportEncryptedFileRaw(ntfs_ctx, mft_entry, fd)
+		kingpin.FatalIfError(err, "Can not export encrypted file")
+		return
+	}
+
+	if *cat_command_ea != "" {
+		ea, err := parser.GetExtendedAttribute(ntfs_ctx, mft_entry, *cat_command_ea)
+		kingpin.FatalIfError(err, "Can not read extended attribute")
+		fd.Write(ea.Value)
+		return
+	}
+
 	var ads_name string = ""
 	// Access by mft id (e.g. 1234-128-6)
-	_, attr_type, attr_id, ads_name, err := parser.ParseMFTId(*cat_command_arg)
+	_, attr_type, attr_id, ads_name, err := parser.ParseMFTId(path)
 	if err != nil {
 		attr_type = 128 // $DATA
 	}
@@ -56,12 +85,6 @@ func doCAT() {
 		uint64(attr_type), uint16(attr_id), ads_name)
 	kingpin.FatalIfError(err, "Can not open stream")
 
-	var fd io.WriteCloser = os.Stdout
-	if *cat_command_output_file != nil {
-		fd = *cat_command_output_file
-		defer fd.Close()
-	}
-
 	buf := make([]byte, 1024*1024*10)
 	offset := *cat_command_offset
 	for {
@@ -11,9 +11,9 @@ var (
 	check_command = app.Command(
 		"check", "Check for some sanity.")
 
-	check_command_file_arg = check_command.Arg(
+	check_command_file_arg = imageFileArg(check_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	check_command_image_offset = check_command.Flag(
 		"image_offset", "The offset in the image to use.",
@@ -29,7 +29,7 @@ var (
 func doCheck() {
 	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
 		Offset: *check_command_image_offset,
-		Reader: getReader(*check_command_file_arg),
+		Reader: getReader(check_command_file_arg),
 	}, 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	ewf_command = app.Command(
+		"ewf", "Show the case information of an EWF (E01/Ex01) image.")
+
+	ewf_command_file_arg = ewf_command.Arg(
+		"file", "The first segment of the image (e.g. image.E01)",
+	).Required().String()
+
+	ewf_command_verify = ewf_command.Flag(
+		"verify", "Hash the media and compare with the stored hashes.",
+	).Bool()
+)
+
+type EWFInfo struct {
+	Header      map[string]string
+	ChunkSize   int64
+	SectorSize  int64
+	SectorCount int64
+	Size        int64
+	StoredMD5   string                  `json:"StoredMD5,omitempty"`
+	StoredSHA1  string                  `json:"StoredSHA1,omitempty"`
+	Verify      *parser.EWFVerification `json:"Verify,omitempty"`
+}
+
+func doEWF() {
+	ewf, err := parser.OpenEWF(*ewf_command_file_arg)
+	kingpin.FatalIfError(err, "Can not open EWF image")
+	defer ewf.Close()
+
+	info := &EWFInfo{
+		Header:      ewf.Header,
+		ChunkSize:   ewf.ChunkSize,
+		SectorSize:  ewf.SectorSize,
+		SectorCount: ewf.SectorCount,
+		Size:        ewf.Size(),
+		StoredMD5:   ewf.StoredMD5,
+		StoredSHA1:  ewf.StoredSHA1,
+	}
+
+	if *ewf_command_verify {
+		info.Verify, err = ewf.Verify(context.Background())
+		kingpin.FatalIfError(err, "Verify")
+	}
+
+	serialized, err := json.MarshalIndent(info, " ", " ")
+	kingpin.FatalIfError(err, "Marshal")
+	fmt.Println(string(serialized))
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case ewf_command.FullCommand():
+			doEWF()
+		default:
+			return false
+		}
+		return true
+	})
+}
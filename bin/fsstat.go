@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	fsstat_command = app.Command(
+		"fsstat", "Show the volume label, version, flags and geometry.")
+
+	fsstat_command_file_arg = imageFileArg(fsstat_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	fsstat_command_image_offset = fsstat_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+)
+
+func doFSStat() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *fsstat_command_image_offset,
+		Reader: getReader(fsstat_command_file_arg),
+	}, 1024, 10000)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	info, err := ntfs_ctx.VolumeInfo()
+	kingpin.FatalIfError(err, "Can not read $Volume")
+
+	serialized, err := json.MarshalIndent(info, " ", " ")
+	kingpin.FatalIfError(err, "Marshal")
+	fmt.Println(string(serialized))
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case fsstat_command.FullCommand():
+			doFSStat()
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	history_command = app.Command(
+		"history", "Show a file in each shadow copy and the live volume.")
+
+	history_command_file_arg = imageFileArg(history_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	history_command_arg = history_command.Arg(
+		"path", "The path or MFT id of the file.",
+	).Required().String()
+
+	history_command_image_offset = history_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	diff_command = app.Command(
+		"diff", "Compare the files in two shadow copies.")
+
+	diff_command_file_arg = imageFileArg(diff_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	diff_command_from = diff_command.Flag(
+		"from", "The ordinal of the shadow copy to compare from (0 is the live volume).",
+	).Required().Int()
+
+	diff_command_to = diff_command.Flag(
+		"to", "The ordinal of the shadow copy to compare to (0 is the live volume).",
+	).Default("0").Int()
+
+	diff_command_image_offset = diff_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+)
+
+func doHistory() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *history_command_image_offset,
+		Reader: getReader(history_command_file_arg),
+	}, 1024, 10000)
+
+	versions, err := parser.GetVolumeVersions(reader)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	for _, version := range parser.GetFileHistory(
+		context.Background(), versions, *history_command_arg) {
+		serialized, err := json.MarshalIndent(version, " ", " ")
+		kingpin.FatalIfError(err, "Marshal")
+
+		fmt.Println(string(serialized))
+	}
+}
+
+// Find the version of the volume by the ordinal of the shadow copy
+// listed by the vss command - 0 is the live volume.
+func getVolumeVersion(versions []*parser.VolumeVersion,
+	ordinal int) *parser.NTFSContext {
+	for _, version := range versions {
+		if version.SnapshotOrdinal == ordinal {
+			return version.NTFS
+		}
+	}
+	kingpin.Fatalf("Shadow copy %v not found", ordinal)
+	return nil
+}
+
+func doDiff() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *diff_command_image_offset,
+		Reader: getReader(diff_command_file_arg),
+	}, 1024, 10000)
+
+	versions, err := parser.GetVolumeVersions(reader)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	changes, err := parser.DiffVolumes(context.Background(),
+		getVolumeVersion(versions, *diff_command_from),
+		getVolumeVersion(versions, *diff_command_to))
+	kingpin.FatalIfError(err, "Can not read $MFT")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Change", "MFT Id", "Seq", "Old Path", "New Path", "Old Size", "New Size", "Changes",
+	})
+	defer table.Render()
+
+	for change := range changes {
+		table.Append([]string{
+			change.Change,
+			fmt.Sprintf("%v", change.EntryNumber),
+			fmt.Sprintf("%v", change.SequenceNumber),
+			change.OldPath,
+			change.NewPath,
+			fmt.Sprintf("%v", change.OldSize),
+			fmt.Sprintf("%v", change.NewSize),
+			strings.Join(change.Changes, ","),
+		})
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case history_command.FullCommand():
+			doHistory()
+		case diff_command.FullCommand():
+			doDiff()
+		default:
+			return false
+		}
+		return true
+	})
+}
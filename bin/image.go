@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	partition_flag = app.Flag(
+		"partition", "Open this partition of the disk image (e.g. 3).",
+	).Int()
+
+	partition_suffix_regex = regexp.MustCompile(`^(.+)#p(\d+)$`)
+)
+
+// An image file argument. The path may select a partition with a #pN
+// suffix (e.g. image.dd#p3), otherwise the --partition flag is used.
+type ImageFile struct {
+	Path      string
+	Partition int
+
+	image      io.ReaderAt
+	image_size int64
+
+	// The selected partition before any decryption.
+	volume_once sync.Once
+	volume      io.ReaderAt
+
+	once   sync.Once
+	reader io.ReaderAt
+	size   int64
+}
+
+func (self *ImageFile) Set(value string) error {
+	self.Path = value
+
+	// A file name may legitimately contain the suffix.
+	_, err := os.Stat(value)
+	if err != nil {
+		match := partition_suffix_regex.FindStringSubmatch(value)
+		if match != nil {
+			self.Path = match[1]
+			self.Partition, _ = strconv.Atoi(match[2])
+		}
+	}
+
+	self.image, self.image_size, err = openImage(self.Path)
+	return err
+}
+
+// Open the disk image, detecting container formats.
+func openImage(path string) (io.ReaderAt, int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if parser.IsEWF(fd) {
+		fd.Close()
+
+		ewf, err := parser.OpenEWF(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return ewf, ewf.Size(), nil
+	}
+
+	if parser.IsVMDK(fd) {
+		fd.Close()
+
+		vmdk, err := parser.OpenVMDK(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return vmdk, vmdk.Size(), nil
+	}
+
+	// Raw images split into image.001, image.002 ...
+	if len(parser.SplitSegmentPaths(path)) > 1 {
+		fd.Close()
+
+		split, err := parser.OpenSplitImage(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return split, split.Size(), nil
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+
+	// Differencing disks find their parents next to the child.
+	if parser.IsVHDX(fd) {
+		vhdx, err := parser.NewVHDXReader(fd, parser.NewFileParentResolver(path))
+		if err != nil {
+			fd.Close()
+			return nil, 0, err
+		}
+		return vhdx, vhdx.Size(), nil
+	}
+
+	if parser.IsVHD(fd, stat.Size()) {
+		vhd, err := parser.NewVHDReader(fd, stat.Size(),
+			parser.NewFileParentResolver(path))
+		if err != nil {
+			fd.Close()
+			return nil, 0, err
+		}
+		return vhd, vhd.Size(), nil
+	}
+
+	return fd, stat.Size(), nil
+}
+
+func (self *ImageFile) String() string {
+	return self.Path
+}
+
+func (self *ImageFile) Name() string {
+	return self.Path
+}
+
+// The partition is resolved on first use because the --partition
+// flag may be parsed after the argument.
+func (self *ImageFile) Volume() io.ReaderAt {
+	self.volume_once.Do(func() {
+		self.volume = self.image
+		self.size = self.image_size
+
+		if self.Partition == 0 {
+			self.Partition = *partition_flag
+		}
+
+		if self.Partition > 0 {
+			partition, err := parser.GetPartition(
+				self.image, self.size, self.Partition)
+			kingpin.FatalIfError(err, "Can not open partition %v",
+				self.Partition)
+
+			self.volume = partition.Reader(self.image)
+			self.size = partition.Size
+		}
+	})
+	return self.volume
+}
+
+// BitLocker volumes are decrypted transparently.
+func (self *ImageFile) open() {
+	self.once.Do(func() {
+		self.reader = openBitLocker(self.Volume())
+	})
+}
+
+// The offset of the selected partition in the disk image.
+func (self *ImageFile) Offset() int64 {
+	offset_reader, ok := self.Volume().(*parser.OffsetReader)
+	if ok {
+		return offset_reader.Offset
+	}
+	return 0
+}
+
+// Arguments for the Sleuthkit tools to open the same volume.
+func (self *ImageFile) TSKArgs() []string {
+	offset := self.Offset()
+	if offset == 0 {
+		return []string{self.Path}
+	}
+	return []string{"-o", strconv.FormatInt(offset/512, 10), self.Path}
+}
+
+func (self *ImageFile) Size() int64 {
+	self.open()
+	return self.size
+}
+
+func (self *ImageFile) ReadAt(buf []byte, offset int64) (int, error) {
+	self.open()
+	return self.reader.ReadAt(buf, offset)
+}
+
+func imageFileArg(settings kingpin.Settings) *ImageFile {
+	result := &ImageFile{}
+	settings.SetValue(result)
+	return result
+}
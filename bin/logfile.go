@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	logfile_command = app.Command(
+		"logfile", "Parse the $LogFile journal.")
+
+	logfile_command_file_arg = imageFileArg(logfile_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	logfile_command_image_offset = logfile_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	logfile_command_extracted = logfile_command.Flag(
+		"extracted", "The file is an extracted $LogFile rather than an image.",
+	).Bool()
+
+	logfile_command_restart = logfile_command.Flag(
+		"restart", "Only show the restart pages.",
+	).Bool()
+
+	logfile_command_lsn = logfile_command.Flag(
+		"lsn", "Only show the record with this LSN.",
+	).Uint64()
+
+	logfile_command_events = logfile_command.Flag(
+		"events", "Replay the log and show file system events instead of records.",
+	).Bool()
+
+	logfile_command_cluster_size = logfile_command.Flag(
+		"cluster_size", "Cluster size for an extracted $LogFile.",
+	).Default("4096").Int64()
+
+	logfile_command_record_size = logfile_command.Flag(
+		"record_size", "MFT entry size for an extracted $LogFile.",
+	).Default("1024").Int64()
+)
+
+func doLogFile() {
+	var log_file *parser.LogFile
+	var err error
+
+	cluster_size := *logfile_command_cluster_size
+	record_size := *logfile_command_record_size
+
+	if *logfile_command_extracted {
+		log_file, err = parser.NewLogFile(
+			getReader(logfile_command_file_arg),
+			logfile_command_file_arg.Size())
+		kingpin.FatalIfError(err, "Can not parse $LogFile")
+
+	} else {
+		reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+			Offset: *logfile_command_image_offset,
+			Reader: getReader(logfile_command_file_arg),
+		}, 1024, 10000)
+
+		ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+		kingpin.FatalIfError(err, "Can not open filesystem")
+
+		log_file, err = parser.OpenLogFile(ntfs_ctx)
+		kingpin.FatalIfError(err, "Can not open $LogFile")
+
+		cluster_size = ntfs_ctx.ClusterSize
+		record_size = ntfs_ctx.GetRecordSize()
+	}
+
+	if *logfile_command_restart {
+		for _, restart := range log_file.RestartPages {
+			serialized, err := json.MarshalIndent(restart, " ", " ")
+			kingpin.FatalIfError(err, "Marshal")
+			fmt.Println(string(serialized))
+		}
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	if *logfile_command_events {
+		for event := range parser.ParseLogFileEvents(context.Background(),
+			log_file, cluster_size, record_size) {
+			err = encoder.Encode(event)
+			kingpin.FatalIfError(err, "Marshal")
+		}
+		return
+	}
+
+	for record := range log_file.Records(context.Background()) {
+		if *logfile_command_lsn != 0 && record.Lsn != *logfile_command_lsn {
+			continue
+		}
+
+		err = encoder.Encode(record)
+		kingpin.FatalIfError(err, "Marshal")
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case "logfile":
+			doLogFile()
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -15,9 +15,9 @@ var (
 	ls_command = app.Command(
 		"ls", "List files.")
 
-	ls_command_file_arg = ls_command.Arg(
+	ls_command_file_arg = imageFileArg(ls_command.Arg(
 		"file", "The image file to inspect",
-	).Required().OpenFile(os.O_RDONLY, os.FileMode(0666))
+	).Required())
 
 	ls_command_arg = ls_command.Arg(
 		"path", "The path to list or an MFT entry.",
@@ -33,13 +33,15 @@ var (
 func doLS() {
 	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
 		Offset: *ls_command_image_offset,
-		Reader: getReader(*ls_command_file_arg),
+		Reader: getReader(ls_command_file_arg),
 	}, 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
 	kingpin.FatalIfError(err, "Can not open filesystem")
 
-	dir, err := GetMFTEntry(ntfs_ctx, *ls_command_arg)
+	ntfs_ctx, path := openSnapshotPath(ntfs_ctx, reader, *ls_command_arg)
+
+	dir, err := GetMFTEntry(ntfs_ctx, path)
 	kingpin.FatalIfError(err, "Can not open path")
 
 	table := tablewriter.NewWriter(os.Stdout)
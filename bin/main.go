@@ -16,6 +16,9 @@ var (
 	verbose_flag = app.Flag(
 		"verbose", "Show verbose information").Bool()
 
+	follow_reparse_flag = app.Flag(
+		"follow_reparse", "Follow junctions and symlinks when opening paths").Bool()
+
 	command_handlers []CommandHandler
 )
 
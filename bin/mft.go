@@ -18,9 +18,9 @@ var (
 		"file", "The $MFT file to process",
 	).File()
 
-	mft_command_image_arg = mft_command.Flag(
+	mft_command_image_arg = imageFileArg(mft_command.Flag(
 		"image", "An image containing an $MFT",
-	).File()
+	))
 
 	mft_command_image_offset = mft_command.Flag(
 		"image_offset", "The offset in the image to use.",
@@ -60,7 +60,7 @@ func doMFTFromImage() {
 
 	reader, err := parser.NewPagedReader(&parser.OffsetReader{
 		Offset: *mft_command_image_offset,
-		Reader: getReader(*mft_command_image_arg),
+		Reader: getReader(mft_command_image_arg),
 	}, 0x400, 10000)
 	kingpin.FatalIfError(err, "Can not open image")
 
@@ -101,7 +101,7 @@ func init() {
 		case "mft":
 			if *mft_command_file_arg != nil {
 				doMFTFromFile()
-			} else if *mft_command_image_arg != nil {
+			} else if mft_command_image_arg.Path != "" {
 				doMFTFromImage()
 			}
 		default:
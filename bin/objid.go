@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	objid_command = app.Command(
+		"objid", "Dump the object ids in $ObjId:$O.")
+
+	objid_command_file_arg = imageFileArg(objid_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	objid_command_image_offset = objid_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	objid_command_id = objid_command.Flag(
+		"id", "Only show this object id (e.g. from a LNK file).",
+	).String()
+)
+
+// An object id with the path of its file.
+type objectIdRow struct {
+	*parser.ObjectId
+	FullPath string `json:"FullPath,omitempty"`
+	Error    string `json:"Error,omitempty"`
+}
+
+func resolveObjectId(ntfs_ctx *parser.NTFSContext,
+	object_id *parser.ObjectId) *objectIdRow {
+	result := &objectIdRow{ObjectId: object_id}
+
+	mft_entry, err := ntfs_ctx.GetMFT(object_id.MFTID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// The entry was reused since the id was assigned.
+	if mft_entry.Sequence_value() != object_id.SequenceNumber {
+		result.Error = fmt.Sprintf("MFT entry %v has sequence %v",
+			object_id.MFTID, mft_entry.Sequence_value())
+		return result
+	}
+
+	result.FullPath = parser.GetFullPath(ntfs_ctx, mft_entry)
+	return result
+}
+
+func doObjId() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *objid_command_image_offset,
+		Reader: getReader(objid_command_file_arg),
+	}, 1024, 10000)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	if *objid_command_id != "" {
+		object_id, err := parser.LookupObjectId(ntfs_ctx, *objid_command_id)
+		kingpin.FatalIfError(err, "Can not find object id")
+
+		serialized, err := json.MarshalIndent(
+			resolveObjectId(ntfs_ctx, object_id), " ", " ")
+		kingpin.FatalIfError(err, "Marshal")
+		fmt.Println(string(serialized))
+		return
+	}
+
+	object_ids, err := parser.ParseObjIdIndex(ntfs_ctx)
+	kingpin.FatalIfError(err, "Can not parse $ObjId")
+
+	for _, object_id := range object_ids {
+		serialized, err := json.Marshal(resolveObjectId(ntfs_ctx, object_id))
+		kingpin.FatalIfError(err, "Marshal")
+		fmt.Println(string(serialized))
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case objid_command.FullCommand():
+			doObjId()
+		default:
+			return false
+		}
+		return true
+	})
+}
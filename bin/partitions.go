@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	partitions_command = app.Command(
+		"partitions", "List the partitions of a disk image.")
+
+	partitions_command_file_arg = imageFileArg(partitions_command.Arg(
+		"file", "The disk image to inspect",
+	).Required())
+)
+
+func doPartitions() {
+	partitions, err := parser.GetPartitions(
+		getReader(partitions_command_file_arg),
+		partitions_command_file_arg.Size())
+	kingpin.FatalIfError(err, "Can not read partition table")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Partition", "Scheme", "Type", "Name", "Offset", "Size", "NTFS",
+	})
+	defer table.Render()
+
+	for _, partition := range partitions {
+		type_name := partition.TypeName
+		if type_name == "" {
+			type_name = partition.Type
+		}
+
+		table.Append([]string{
+			fmt.Sprintf("%v", partition.Index),
+			partition.Scheme,
+			type_name,
+			partition.Name,
+			fmt.Sprintf("%v", partition.Offset),
+			fmt.Sprintf("%v", partition.Size),
+			fmt.Sprintf("%v", partition.IsNTFS),
+		})
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case partitions_command.FullCommand():
+			doPartitions()
+		default:
+			return false
+		}
+		return true
+	})
+}
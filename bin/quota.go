@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	quota_command = app.Command(
+		"quota", "Dump the quota entries in $Quota:$Q.")
+
+	quota_command_file_arg = imageFileArg(quota_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	quota_command_image_offset = quota_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	quota_command_census = quota_command.Flag(
+		"census", "Count the files and bytes charged to each owner.",
+	).Bool()
+)
+
+func doQuota() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *quota_command_image_offset,
+		Reader: getReader(quota_command_file_arg),
+	}, 1024, 10000)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	if *quota_command_census {
+		usage, err := parser.QuotaCensus(ntfs_ctx)
+		kingpin.FatalIfError(err, "Can not count owners")
+
+		for _, owner := range usage {
+			serialized, err := json.Marshal(owner)
+			kingpin.FatalIfError(err, "Marshal")
+			fmt.Println(string(serialized))
+		}
+		return
+	}
+
+	entries, err := parser.ParseQuotaIndex(ntfs_ctx)
+	kingpin.FatalIfError(err, "Can not parse $Quota")
+
+	for _, entry := range entries {
+		serialized, err := json.Marshal(entry)
+		kingpin.FatalIfError(err, "Marshal")
+		fmt.Println(string(serialized))
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case quota_command.FullCommand():
+			doQuota()
+		default:
+			return false
+		}
+		return true
+	})
+}
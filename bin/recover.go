@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	recover_command = app.Command(
+		"recover", "List or extract deleted files.")
+
+	recover_command_file_arg = imageFileArg(recover_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	recover_command_image_offset = recover_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	recover_command_id = recover_command.Flag(
+		"id", "Extract the deleted file with this MFT id.",
+	).Default("-1").Int64()
+
+	recover_command_output_file = recover_command.Flag(
+		"out", "Write to this file",
+	).OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
+)
+
+func doRecover() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *recover_command_image_offset,
+		Reader: getReader(recover_command_file_arg),
+	}, 1024, 10000)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	if *recover_command_id < 0 {
+		for deleted := range parser.ScanDeletedFiles(
+			context.Background(), ntfs_ctx) {
+			serialized, err := json.Marshal(deleted)
+			kingpin.FatalIfError(err, "Marshal")
+			fmt.Println(string(serialized))
+		}
+		return
+	}
+
+	mft_entry, err := ntfs_ctx.GetMFT(*recover_command_id)
+	kingpin.FatalIfError(err, "Can not open MFT entry")
+
+	data, err := parser.OpenDeletedFile(ntfs_ctx, mft_entry)
+	kingpin.FatalIfError(err, "Can not open deleted file")
+
+	var fd io.WriteCloser = os.Stdout
+	if *recover_command_output_file != nil {
+		fd = *recover_command_output_file
+		defer fd.Close()
+	}
+
+	buf := make([]byte, 1024*1024*10)
+	offset := int64(0)
+	for {
+		n, _ := data.ReadAt(buf, offset)
+		if n == 0 {
+			return
+		}
+		fd.Write(buf[:n])
+		offset += int64(n)
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case "recover":
+			doRecover()
+		default:
+			return false
+		}
+		return true
+	})
+}
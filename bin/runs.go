@@ -16,9 +16,9 @@ var (
 		"record", "Path to read/write recorded data").
 		Default("").String()
 
-	runs_command_file_arg = runs_command.Arg(
+	runs_command_file_arg = imageFileArg(runs_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	runs_command_image_offset = runs_command.Flag(
 		"image_offset", "The offset in the image to use.",
@@ -47,7 +47,7 @@ func getReader(reader io.ReaderAt) io.ReaderAt {
 func doRuns() {
 	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
 		Offset: *runs_command_image_offset,
-		Reader: getReader(*runs_command_file_arg),
+		Reader: getReader(runs_command_file_arg),
 	}, 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+var (
+	secure_command = app.Command(
+		"secure", "Dump the security descriptors in $Secure.")
+
+	secure_command_file_arg = imageFileArg(secure_command.Arg(
+		"file", "The image file to inspect",
+	).Required())
+
+	secure_command_image_offset = secure_command.Flag(
+		"image_offset", "The offset in the image to use.",
+	).Int64()
+
+	secure_command_id = secure_command.Flag(
+		"id", "Only show this security id.",
+	).Uint32()
+)
+
+func doSecure() {
+	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
+		Offset: *secure_command_image_offset,
+		Reader: getReader(secure_command_file_arg),
+	}, 1024, 10000)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	kingpin.FatalIfError(err, "Can not open filesystem")
+
+	if *secure_command_id != 0 {
+		sd, err := parser.GetSecurityDescriptor(ntfs_ctx, *secure_command_id)
+		kingpin.FatalIfError(err, "Can not find security id")
+
+		serialized, err := json.MarshalIndent(sd, " ", " ")
+		kingpin.FatalIfError(err, "Marshal")
+		fmt.Println(string(serialized))
+		return
+	}
+
+	entries, err := parser.ParseSecureDescriptors(ntfs_ctx)
+	kingpin.FatalIfError(err, "Can not parse $Secure")
+
+	for _, entry := range entries {
+		serialized, err := json.Marshal(entry)
+		kingpin.FatalIfError(err, "Marshal")
+		fmt.Println(string(serialized))
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case "secure":
+			doSecure()
+		default:
+			return false
+		}
+		return true
+	})
+}
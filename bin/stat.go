@@ -15,9 +15,9 @@ var (
 	stat_command_i30 = stat_command.Flag(
 		"i30", "Carve out $I30 entries").Bool()
 
-	stat_command_file_arg = stat_command.Arg(
+	stat_command_file_arg = imageFileArg(stat_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	stat_command_image_offset = stat_command.Flag(
 		"image_offset", "The offset in the image to use.",
@@ -34,7 +34,7 @@ var (
 func doSTAT() {
 	reader, _ := parser.NewPagedReader(&parser.OffsetReader{
 		Offset: *stat_command_image_offset,
-		Reader: getReader(*stat_command_file_arg),
+		Reader: getReader(stat_command_file_arg),
 	}, 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
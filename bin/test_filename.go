@@ -13,9 +13,9 @@ var (
 	test_fn_command = app.Command(
 		"test_filename", "Test file path reconstruction by comparing with TSK.")
 
-	test_fn_command_file_arg = test_fn_command.Arg(
+	test_fn_command_file_arg = imageFileArg(test_fn_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	test_fn_command_ffind_path = test_fn_command.Flag(
 		"ffind_path", "Path to the ffind binary").
@@ -27,7 +27,7 @@ var (
 
 func calcFilenameWithTSK(inode string) (string, error) {
 	command := exec.Command(*test_fn_command_ffind_path,
-		(*test_fn_command_file_arg).Name(), inode)
+		append(test_fn_command_file_arg.TSKArgs(), inode)...)
 	output, err := command.CombinedOutput()
 	if err != nil {
 		return "", err
@@ -37,7 +37,7 @@ func calcFilenameWithTSK(inode string) (string, error) {
 }
 
 func doTestFn() {
-	reader, err := parser.NewPagedReader(*test_fn_command_file_arg, 1024, 10000)
+	reader, err := parser.NewPagedReader(test_fn_command_file_arg, 1024, 10000)
 	kingpin.FatalIfError(err, "Can not open MFT file")
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
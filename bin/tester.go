@@ -16,9 +16,9 @@ var (
 	test_command = app.Command(
 		"test", "Test file reading by comparing with TSK.")
 
-	test_command_file_arg = test_command.Arg(
+	test_command_file_arg = imageFileArg(test_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	test_command_icat_path = test_command.Flag(
 		"icat_path", "Path to the icat binary").
@@ -38,7 +38,7 @@ var (
 
 func calcHashWithTSK(inode string) (string, int64, error) {
 	command := exec.Command(*test_command_icat_path,
-		(*test_command_file_arg).Name(), inode)
+		append(test_command_file_arg.TSKArgs(), inode)...)
 	stdout_pipe, err := command.StdoutPipe()
 	if err != nil {
 		return "", 0, err
@@ -79,7 +79,7 @@ func calcHashWithTSK(inode string) (string, int64, error) {
 }
 
 func doTest() {
-	reader, err := parser.NewPagedReader(*test_command_file_arg, 1024, 10000)
+	reader, err := parser.NewPagedReader(test_command_file_arg, 1024, 10000)
 	kingpin.FatalIfError(err, "Can not open MFT file")
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
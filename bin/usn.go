@@ -14,9 +14,9 @@ var (
 	usn_command = app.Command(
 		"usn", "inspect the USN journal.")
 
-	usn_command_file_arg = usn_command.Arg(
+	usn_command_file_arg = imageFileArg(usn_command.Arg(
 		"file", "The image file to inspect",
-	).Required().File()
+	).Required())
 
 	usn_command_watch = usn_command.Flag(
 		"watch", "Watch the USN for changes").Bool()
@@ -37,7 +37,7 @@ SourceInfo: %s
 
 func doWatchUSN() {
 	reader, _ := parser.NewPagedReader(
-		getReader(*usn_command_file_arg), 1024, 10000)
+		getReader(usn_command_file_arg), 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
 	kingpin.FatalIfError(err, "Can not open filesystem")
@@ -60,7 +60,7 @@ func doUSN() {
 	}
 
 	reader, _ := parser.NewPagedReader(
-		getReader(*usn_command_file_arg), 1024, 10000)
+		getReader(usn_command_file_arg), 1024, 10000)
 
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
 	kingpin.FatalIfError(err, "Can not open filesystem")
@@ -16,6 +16,12 @@ func GetMFTEntry(ntfs_ctx *parser.NTFSContext, filename string) (*parser.MFT_ENT
 			return nil, err
 		}
 
+		if *follow_reparse_flag {
+			options := *ntfs_ctx.GetOptions()
+			options.FollowReparsePoints = true
+			ntfs_ctx.SetOptions(options)
+		}
+
 		return dir.Open(ntfs_ctx, filename)
 	}
 }
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"time"
 
+	"github.com/olekukonko/tablewriter"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 	"www.velocidex.com/golang/go-ntfs/parser"
 )
@@ -12,14 +15,61 @@ var (
 	vss_command = app.Command(
 		"vss", "Inspect VSS.")
 
-	vss_command_file_arg = vss_command.Arg(
+	vss_command_file_arg = imageFileArg(vss_command.Arg(
 		"file", "The image file to inspect",
-	).Required().OpenFile(os.O_RDONLY, os.FileMode(0666))
+	).Required())
+
+	vss_command_dump = vss_command.Flag(
+		"dump", "Dump the raw VSS headers instead of listing snapshots.",
+	).Bool()
 )
 
 func doVSS() {
-	reader, _ := parser.NewPagedReader(*vss_command_file_arg, 1024, 10000)
+	reader, _ := parser.NewPagedReader(vss_command_file_arg, 1024, 10000)
+
+	if *vss_command_dump {
+		dumpVSS(reader)
+		return
+	}
+
+	snapshots, err := parser.GetVSSSnapshots(reader)
+	kingpin.FatalIfError(err, "Can not read shadow copies")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Ordinal", "Created", "Shadow Copy", "Store", "Size", "Machine",
+	})
+	defer table.Render()
+
+	for _, snapshot := range snapshots {
+		table.Append([]string{
+			fmt.Sprintf("%v", snapshot.Ordinal),
+			fmt.Sprintf("%v", snapshot.CreationTime.In(time.UTC)),
+			snapshot.ShadowCopyGUID,
+			snapshot.StoreGUID,
+			fmt.Sprintf("%v", snapshot.VolumeSize),
+			snapshot.OperatingMachine,
+		})
+	}
+}
+
+// Paths prefixed with a shadow copy id (e.g.
+// {b5946137-7b9f-4925-af80-51abd60b20d5}\Windows) are opened in the
+// snapshot instead of the live volume.
+func openSnapshotPath(ntfs_ctx *parser.NTFSContext,
+	reader io.ReaderAt, path string) (*parser.NTFSContext, string) {
+	_, _, ok := parser.ParseVSSPath(path)
+	if !ok {
+		return ntfs_ctx, path
+	}
+
+	snapshot_ctx, snapshot_path, err := parser.OpenVSSPath(reader, path)
+	kingpin.FatalIfError(err, "Can not open shadow copy")
+
+	return snapshot_ctx, snapshot_path
+}
 
+func dumpVSS(reader io.ReaderAt) {
 	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
 	kingpin.FatalIfError(err, "Can not open filesystem")
 
@@ -0,0 +1,415 @@
+// The attribute type registry.
+//
+// Each volume defines its attribute types in $AttrDef (MFT entry 4):
+// an array of 0xa0 byte entries holding the type name, type code,
+// collation rule, flags and the minimum and maximum size of the
+// attribute content. We start with the definitions of a standard
+// NTFS 3.1 volume and merge in the volume's own $AttrDef the first
+// time the registry of a context is requested with AttributeTypes()
+// or LoadAttrDef(). Resolving attribute names never reads $AttrDef by
+// itself.
+//
+// Decoders for individual attribute types register themselves
+// against DefaultAttributeTypes so an attribute can be decoded by its
+// type code.
+//
+// References:
+// https://github.com/tuxera/ntfs-3g/blob/edge/include/ntfs-3g/layout.h (ATTR_DEF)
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	ATTRDEF_MFT_ID = 4
+
+	ATTRDEF_ENTRY_SIZE = 0xa0
+
+	MAX_ATTRDEF_SIZE = 64 * 1024
+
+	// Decoders see at most this much of the attribute.
+	MAX_DECODE_SIZE = 64 * 1024
+
+	ATTR_DEF_INDEXABLE      = 0x02
+	ATTR_DEF_MULTIPLE       = 0x04
+	ATTR_DEF_NOT_ZERO       = 0x08
+	ATTR_DEF_INDEXED_UNIQUE = 0x10
+	ATTR_DEF_NAMED_UNIQUE   = 0x20
+	ATTR_DEF_RESIDENT       = 0x40
+	ATTR_DEF_ALWAYS_LOG     = 0x80
+)
+
+var (
+	attrDefFlagNames = map[uint32]string{
+		ATTR_DEF_INDEXABLE:      "INDEXABLE",
+		ATTR_DEF_MULTIPLE:       "MULTIPLE",
+		ATTR_DEF_NOT_ZERO:       "NOT_ZERO",
+		ATTR_DEF_INDEXED_UNIQUE: "INDEXED_UNIQUE",
+		ATTR_DEF_NAMED_UNIQUE:   "NAMED_UNIQUE",
+		ATTR_DEF_RESIDENT:       "RESIDENT",
+		ATTR_DEF_ALWAYS_LOG:     "ALWAYS_LOG",
+	}
+
+	attrDefNotFoundError = errors.New("$AttrDef $DATA not found!")
+
+	// The types known to the parser. Each context starts with a copy.
+	DefaultAttributeTypes = newDefaultAttributeTypes()
+)
+
+// Decode the content of an attribute.
+type AttributeDecoder func(data []byte) (interface{}, error)
+
+type AttributeDefinition struct {
+	Type          uint64
+	Name          string
+	CollationRule uint32
+	Flags         []string
+
+	// A MaxSize of -1 means unlimited.
+	MinSize int64
+	MaxSize int64
+
+	// The attribute must always be resident.
+	Resident bool
+
+	// The attribute may be indexed (e.g. $FILE_NAME in $I30).
+	Indexed bool
+
+	decoder AttributeDecoder
+}
+
+func NewAttributeDefinition(attr_type uint64, name string,
+	flags uint32, min_size, max_size int64) *AttributeDefinition {
+	return &AttributeDefinition{
+		Type:     attr_type,
+		Name:     name,
+		Flags:    flagNames(flags, attrDefFlagNames),
+		MinSize:  min_size,
+		MaxSize:  max_size,
+		Resident: flags&ATTR_DEF_RESIDENT != 0,
+		Indexed:  flags&ATTR_DEF_INDEXABLE != 0,
+	}
+}
+
+type AttributeTypeRegistry struct {
+	mu    sync.RWMutex
+	types map[uint64]*AttributeDefinition
+
+	// Reads the volume's definitions on first use. The lock is held
+	// across the load so callers never see an unmerged registry.
+	load_mu sync.Mutex
+	loader  func() ([]*AttributeDefinition, error)
+
+	// Set once the volume's $AttrDef was merged in (or failed to)
+	// so later calls do not need to take load_mu.
+	loaded uint32
+	err    error
+}
+
+func NewAttributeTypeRegistry() *AttributeTypeRegistry {
+	return &AttributeTypeRegistry{
+		types: make(map[uint64]*AttributeDefinition),
+	}
+}
+
+// Add or replace the definition of a type. A registered decoder is
+// kept.
+func (self *AttributeTypeRegistry) Register(definition *AttributeDefinition) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	old, pres := self.types[definition.Type]
+	if pres && definition.decoder == nil {
+		definition.decoder = old.decoder
+	}
+	self.types[definition.Type] = definition
+}
+
+// Register the decoder for a type.
+func (self *AttributeTypeRegistry) RegisterDecoder(
+	attr_type uint64, decoder AttributeDecoder) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	definition, pres := self.types[attr_type]
+	if !pres {
+		definition = NewAttributeDefinition(attr_type,
+			fmt.Sprintf("Unknown_%#x", attr_type), 0, 0, -1)
+		self.types[attr_type] = definition
+	}
+	definition.decoder = decoder
+}
+
+// Merge in the definitions from the loader the first time we are
+// called. Failures are cached.
+func (self *AttributeTypeRegistry) load(
+	loader func() ([]*AttributeDefinition, error)) error {
+	// err is written before loaded is set.
+	if atomic.LoadUint32(&self.loaded) == 1 {
+		return self.err
+	}
+
+	self.load_mu.Lock()
+	defer self.load_mu.Unlock()
+
+	if atomic.LoadUint32(&self.loaded) == 1 {
+		return self.err
+	}
+
+	if loader == nil {
+		loader = self.loader
+	}
+	if loader == nil {
+		return nil
+	}
+
+	definitions, err := loader()
+	if err == nil {
+		for _, definition := range definitions {
+			self.Register(definition)
+		}
+	}
+
+	self.err = err
+	atomic.StoreUint32(&self.loaded, 1)
+	return err
+}
+
+func (self *AttributeTypeRegistry) setLoader(
+	loader func() ([]*AttributeDefinition, error)) {
+	self.load_mu.Lock()
+	defer self.load_mu.Unlock()
+
+	self.loader = loader
+}
+
+func (self *AttributeTypeRegistry) Get(attr_type uint64) (*AttributeDefinition, bool) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	definition, pres := self.types[attr_type]
+	return definition, pres
+}
+
+func (self *AttributeTypeRegistry) Name(attr_type uint64) string {
+	definition, pres := self.Get(attr_type)
+	if !pres {
+		return "Unknown"
+	}
+	return definition.Name
+}
+
+// All the definitions sorted by type code.
+func (self *AttributeTypeRegistry) Definitions() []*AttributeDefinition {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	result := make([]*AttributeDefinition, 0, len(self.types))
+	for _, definition := range self.types {
+		result = append(result, definition)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Type < result[j].Type
+	})
+	return result
+}
+
+func (self *AttributeTypeRegistry) Copy() *AttributeTypeRegistry {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+
+	result := NewAttributeTypeRegistry()
+	for k, v := range self.types {
+		definition := *v
+		result.types[k] = &definition
+	}
+	return result
+}
+
+// Parse the $AttrDef entries. The array ends with an entry of type 0.
+func ParseAttrDef(data []byte) []*AttributeDefinition {
+	result := []*AttributeDefinition{}
+	for offset := 0; offset+ATTRDEF_ENTRY_SIZE <= len(data); offset += ATTRDEF_ENTRY_SIZE {
+		entry := data[offset : offset+ATTRDEF_ENTRY_SIZE]
+		attr_type := binary.LittleEndian.Uint32(entry[0x80:])
+		if attr_type == 0 {
+			break
+		}
+
+		definition := NewAttributeDefinition(uint64(attr_type),
+			UTF16BytesToUTF8(trimUTF16(entry[:0x80]), binary.LittleEndian),
+			binary.LittleEndian.Uint32(entry[0x8c:]),
+			int64(binary.LittleEndian.Uint64(entry[0x90:])),
+			int64(binary.LittleEndian.Uint64(entry[0x98:])))
+		definition.CollationRule = binary.LittleEndian.Uint32(entry[0x88:])
+
+		result = append(result, definition)
+	}
+
+	return result
+}
+
+// The registry of this context. Contexts opened with
+// GetNTFSContext() merge in the volume's $AttrDef the first time
+// this is called. If $AttrDef can not be read the standard
+// definitions are used (see LoadAttrDef() for the error).
+func (self *NTFSContext) AttributeTypes() *AttributeTypeRegistry {
+	if self.attribute_types == nil {
+		return DefaultAttributeTypes
+	}
+	self.attribute_types.load(nil)
+	return self.attribute_types
+}
+
+// Merge in the volume's $AttrDef the first time the registry is
+// requested. Only call this once the context can read MFT entries.
+func (self *NTFSContext) loadAttrDefOnFirstUse() {
+	if self.attribute_types != nil {
+		self.attribute_types.setLoader(self.readAttrDef)
+	}
+}
+
+// Merge the volume's $AttrDef into the registry of this context and
+// return any error from loading it.
+func (self *NTFSContext) LoadAttrDef() (*AttributeTypeRegistry, error) {
+	registry := self.attribute_types
+	if registry == nil {
+		return DefaultAttributeTypes,
+			errors.New("Context has no attribute type registry")
+	}
+
+	return registry, registry.load(self.readAttrDef)
+}
+
+// Read $AttrDef with the standard definitions so reading it does not
+// recurse into the registry being loaded.
+func (self *NTFSContext) readAttrDef() ([]*AttributeDefinition, error) {
+	ntfs := self.Copy()
+	ntfs.attribute_types = DefaultAttributeTypes.Copy()
+	return readAttrDef(ntfs)
+}
+
+func readAttrDef(ntfs *NTFSContext) ([]*AttributeDefinition, error) {
+	attrdef, err := ntfs.GetMFT(ATTRDEF_MFT_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range attrdef.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_DATA && attr.Name() == "" {
+			buf := make([]byte, CapInt64(attr.DataSize(), MAX_ATTRDEF_SIZE))
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseAttrDef(buf[:n]), nil
+		}
+	}
+
+	return nil, attrDefNotFoundError
+}
+
+// Decode the attribute content with the decoder registered for its
+// type.
+func (self *NTFS_ATTRIBUTE) Decode(ntfs *NTFSContext) (interface{}, error) {
+	attr_type := self.Type().Value
+	definition, pres := ntfs.AttributeTypes().Get(attr_type)
+	if !pres || definition.decoder == nil {
+		return nil, fmt.Errorf("No decoder for attribute type %#x", attr_type)
+	}
+
+	buf := make([]byte, CapInt64(self.DataSize(), MAX_DECODE_SIZE))
+	n, _ := self.Data(ntfs).ReadAt(buf, 0)
+	return definition.decoder(buf[:n])
+}
+
+// Check the attributes of the MFT entry against their definitions:
+// unknown types, non-resident attributes which must be resident and
+// sizes outside the defined range.
+func (self *MFT_ENTRY) AttributeAnomalies(ntfs *NTFSContext) []string {
+	result := []string{}
+	registry := ntfs.AttributeTypes()
+
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		// Only check the first VCN of non-resident attributes.
+		if !attr.IsResident() && attr.Runlist_vcn_start() != 0 {
+			continue
+		}
+
+		attr_type := attr.Type().Value
+		description := fmt.Sprintf("%v (%v-%v)", attr.Type().Name,
+			attr_type, attr.Attribute_id())
+
+		definition, pres := registry.Get(attr_type)
+		if !pres {
+			result = append(result, fmt.Sprintf(
+				"%v: Attribute type is not defined", description))
+			continue
+		}
+
+		if definition.Resident && !attr.IsResident() {
+			result = append(result, fmt.Sprintf(
+				"%v: Attribute must be resident", description))
+		}
+
+		size := attr.DataSize()
+		if size < definition.MinSize {
+			result = append(result, fmt.Sprintf(
+				"%v: Size %#x is less than the minimum %#x",
+				description, size, definition.MinSize))
+		}
+
+		if definition.MaxSize >= 0 && size > definition.MaxSize {
+			result = append(result, fmt.Sprintf(
+				"%v: Size %#x is more than the maximum %#x",
+				description, size, definition.MaxSize))
+		}
+	}
+
+	return result
+}
+
+// The $AttrDef of a standard NTFS 3.1 volume.
+func newDefaultAttributeTypes() *AttributeTypeRegistry {
+	result := NewAttributeTypeRegistry()
+	for _, definition := range []*AttributeDefinition{
+		NewAttributeDefinition(ATTR_TYPE_STANDARD_INFORMATION,
+			"$STANDARD_INFORMATION", 0x40, 0x30, 0x48),
+		NewAttributeDefinition(ATTR_TYPE_ATTRIBUTE_LIST,
+			"$ATTRIBUTE_LIST", 0x80, 0, -1),
+		NewAttributeDefinition(ATTR_TYPE_FILE_NAME,
+			"$FILE_NAME", 0x42, 0x44, 0x242),
+		NewAttributeDefinition(ATTR_TYPE_OBJECT_ID,
+			"$OBJECT_ID", 0x40, 0, 0x100),
+		NewAttributeDefinition(ATTR_TYPE_SECURITY_DESCRIPTOR,
+			"$SECURITY_DESCRIPTOR", 0x80, 0, -1),
+		NewAttributeDefinition(ATTR_TYPE_VOLUME_NAME,
+			"$VOLUME_NAME", 0x40, 2, 0x100),
+		NewAttributeDefinition(ATTR_TYPE_VOLUME_INFORMATION,
+			"$VOLUME_INFORMATION", 0x40, 0xc, 0xc),
+		NewAttributeDefinition(ATTR_TYPE_DATA,
+			"$DATA", 0, 0, -1),
+		NewAttributeDefinition(ATTR_TYPE_INDEX_ROOT,
+			"$INDEX_ROOT", 0x40, 0, -1),
+		NewAttributeDefinition(ATTR_TYPE_INDEX_ALLOCATION,
+			"$INDEX_ALLOCATION", 0x80, 0, -1),
+		NewAttributeDefinition(ATTR_TYPE_BITMAP,
+			"$BITMAP", 0x80, 0, -1),
+		NewAttributeDefinition(ATTR_TYPE_REPARSE_POINT,
+			"$REPARSE_POINT", 0x80, 0, 0x4000),
+		NewAttributeDefinition(ATTR_TYPE_EA_INFORMATION,
+			"$EA_INFORMATION", 0x40, 8, 8),
+		NewAttributeDefinition(ATTR_TYPE_EA,
+			"$EA", 0, 0, 0x10000),
+		NewAttributeDefinition(ATTR_TYPE_LOGGED_UTILITY_STREAM,
+			"$LOGGED_UTILITY_STREAM", 0x80, 0, 0x10000),
+	} {
+		result.Register(definition)
+	}
+	return result
+}
@@ -0,0 +1,881 @@
+// Decrypting BitLocker (Full Volume Encryption) volumes.
+//
+// A BitLocker volume replaces the NTFS boot sector with a volume
+// header carrying the "-FVE-FS-" signature. The header points to three
+// copies of the FVE metadata block which holds a list of entries:
+//
+//   - Each Volume Master Key (VMK) entry is one key protector. It holds
+//     the VMK encrypted with AES-CCM under a key derived from the
+//     protector (a recovery password, a startup key file, or a clear
+//     key when protection is suspended).
+//   - The Full Volume Encryption Key (FVEK) entry holds the FVEK
+//     encrypted with AES-CCM under the VMK.
+//
+// Sectors are encrypted with the FVEK using AES-CBC (optionally with
+// the Elephant diffuser used before Windows 8) or AES-XTS (Windows 10).
+//
+// Since Windows 7 the original boot sectors are moved to the location
+// given in the metadata and the metadata regions read as zeros. Vista
+// leaves the start of the volume unencrypted and only patches the
+// boot sector.
+//
+// Reference: libbde - BitLocker Drive Encryption (BDE) format
+
+package parser
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BITLOCKER_SIGNATURE  = "-FVE-FS-"
+	BITLOCKER_IDENTIFIER = "{4967d63b-2e29-4ad8-8399-f6a339e3d001}"
+
+	// Sector encryption methods
+	BITLOCKER_AES_128_CBC_DIFFUSER = 0x8000
+	BITLOCKER_AES_256_CBC_DIFFUSER = 0x8001
+	BITLOCKER_AES_128_CBC          = 0x8002
+	BITLOCKER_AES_256_CBC          = 0x8003
+	BITLOCKER_AES_128_XTS          = 0x8004
+	BITLOCKER_AES_256_XTS          = 0x8005
+
+	// Metadata entry types
+	BITLOCKER_ENTRY_VMK         = 0x0002
+	BITLOCKER_ENTRY_FVEK        = 0x0003
+	BITLOCKER_ENTRY_STARTUP_KEY = 0x0006
+	BITLOCKER_ENTRY_DESCRIPTION = 0x0007
+
+	// Metadata value types
+	BITLOCKER_VALUE_KEY          = 0x0001
+	BITLOCKER_VALUE_UNICODE      = 0x0002
+	BITLOCKER_VALUE_STRETCH_KEY  = 0x0003
+	BITLOCKER_VALUE_AES_CCM      = 0x0005
+	BITLOCKER_VALUE_VMK          = 0x0008
+	BITLOCKER_VALUE_EXTERNAL_KEY = 0x0009
+
+	// VMK protection types
+	BITLOCKER_PROTECTION_CLEAR_KEY         = 0x0000
+	BITLOCKER_PROTECTION_TPM               = 0x0100
+	BITLOCKER_PROTECTION_STARTUP_KEY       = 0x0200
+	BITLOCKER_PROTECTION_TPM_PIN           = 0x0500
+	BITLOCKER_PROTECTION_RECOVERY_PASSWORD = 0x0800
+	BITLOCKER_PROTECTION_PASSWORD          = 0x2000
+
+	BITLOCKER_BLOCK_HEADER_SIZE    = 64
+	BITLOCKER_METADATA_HEADER_SIZE = 48
+
+	// Each metadata block reserves this much of the volume.
+	BITLOCKER_METADATA_REGION_SIZE = 0x10000
+
+	// Vista does not encrypt the start of the volume.
+	BITLOCKER_VISTA_CLEAR_SIZE = 0x2000
+
+	// Key stretching for recovery passwords.
+	BITLOCKER_STRETCH_ITERATIONS = 0x100000
+)
+
+var (
+	notBitLockerError               = errors.New("Not a BitLocker volume")
+	invalidFVEMetadataError         = errors.New("Invalid FVE metadata block")
+	invalidRecoveryPasswordError    = errors.New("Invalid BitLocker recovery password")
+	invalidStartupKeyError          = errors.New("Invalid BitLocker startup key")
+	bitlockerKeyMismatchError       = errors.New("BitLocker key integrity check failed")
+	noBitLockerKeyError             = errors.New("No key protector could be unlocked")
+	unsupportedBitLockerCipherError = errors.New("Unsupported BitLocker encryption method")
+
+	bitlockerProtectionNames = map[uint16]string{
+		BITLOCKER_PROTECTION_CLEAR_KEY:         "Clear Key",
+		BITLOCKER_PROTECTION_TPM:               "TPM",
+		BITLOCKER_PROTECTION_STARTUP_KEY:       "Startup Key",
+		BITLOCKER_PROTECTION_TPM_PIN:           "TPM and PIN",
+		BITLOCKER_PROTECTION_RECOVERY_PASSWORD: "Recovery Password",
+		BITLOCKER_PROTECTION_PASSWORD:          "Password",
+	}
+
+	bitlockerMethodNames = map[uint16]string{
+		BITLOCKER_AES_128_CBC_DIFFUSER: "AES-128-CBC + Elephant Diffuser",
+		BITLOCKER_AES_256_CBC_DIFFUSER: "AES-256-CBC + Elephant Diffuser",
+		BITLOCKER_AES_128_CBC:          "AES-128-CBC",
+		BITLOCKER_AES_256_CBC:          "AES-256-CBC",
+		BITLOCKER_AES_128_XTS:          "AES-128-XTS",
+		BITLOCKER_AES_256_XTS:          "AES-256-XTS",
+	}
+
+	diffuserARotations = [4]int{9, 0, 13, 0}
+	diffuserBRotations = [4]int{0, 10, 0, 25}
+)
+
+// The keys that may unlock a volume. Only one is needed.
+type BitLockerKeys struct {
+	// 48 digits in 8 groups separated by dashes.
+	RecoveryPassword string
+
+	// The content of a .BEK startup key file.
+	StartupKey []byte
+
+	// The raw key data of the FVEK (e.g. recovered from memory). A 66
+	// byte key starts with the 2 byte encryption method.
+	FVEK []byte
+}
+
+type BitLockerProtector struct {
+	GUID         string
+	Type         string
+	LastModified time.Time
+
+	protection uint16
+	entries    []*fveEntry
+}
+
+type BitLockerMetadata struct {
+	Version          int
+	VolumeGUID       string
+	EncryptionMethod string
+	CreationTime     time.Time
+	Description      string `json:"Description,omitempty"`
+
+	SectorSize          int64
+	EncryptedVolumeSize int64 `json:"EncryptedVolumeSize,omitempty"`
+	VolumeHeaderOffset  int64 `json:"VolumeHeaderOffset,omitempty"`
+	VolumeHeaderSize    int64 `json:"VolumeHeaderSize,omitempty"`
+	MetadataOffsets     []int64
+
+	Protectors []*BitLockerProtector
+
+	method             uint16
+	mft_mirror_cluster uint64
+	entries            []*fveEntry
+}
+
+// A metadata entry (sometimes called a datum). The data follows the
+// 8 byte entry header.
+type fveEntry struct {
+	entry_type uint16
+	value_type uint16
+	data       []byte
+}
+
+func parseFVEEntries(data []byte) []*fveEntry {
+	result := []*fveEntry{}
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint16(data))
+		if size < 8 || size > len(data) {
+			break
+		}
+
+		result = append(result, &fveEntry{
+			entry_type: binary.LittleEndian.Uint16(data[2:]),
+			value_type: binary.LittleEndian.Uint16(data[4:]),
+			data:       data[8:size],
+		})
+		data = data[size:]
+	}
+	return result
+}
+
+// Nested entries follow the fixed fields of some value types.
+func (self *fveEntry) nested() []*fveEntry {
+	offset := 0
+	switch self.value_type {
+	case BITLOCKER_VALUE_STRETCH_KEY:
+		offset = 20
+	case BITLOCKER_VALUE_VMK:
+		offset = 28
+	case BITLOCKER_VALUE_EXTERNAL_KEY:
+		offset = 24
+	default:
+		return nil
+	}
+
+	if len(self.data) < offset {
+		return nil
+	}
+	return parseFVEEntries(self.data[offset:])
+}
+
+func findFVEEntry(entries []*fveEntry, entry_type, value_type uint16) *fveEntry {
+	for _, entry := range entries {
+		if entry.entry_type == entry_type && entry.value_type == value_type {
+			return entry
+		}
+	}
+	return nil
+}
+
+func findFVEValue(entries []*fveEntry, value_type uint16) *fveEntry {
+	for _, entry := range entries {
+		if entry.value_type == value_type {
+			return entry
+		}
+	}
+	return nil
+}
+
+// The key data of a key entry.
+func fveKeyData(entry *fveEntry) ([]byte, error) {
+	if entry == nil || entry.value_type != BITLOCKER_VALUE_KEY ||
+		len(entry.data) < 4 {
+		return nil, invalidFVEMetadataError
+	}
+	return entry.data[4:], nil
+}
+
+func IsBitLocker(reader io.ReaderAt) bool {
+	sector, err := readSector(reader, 0, 512)
+	return err == nil && string(sector[3:11]) == BITLOCKER_SIGNATURE
+}
+
+func GetBitLockerMetadata(reader io.ReaderAt) (*BitLockerMetadata, error) {
+	sector, err := readSector(reader, 0, 512)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(sector[3:11]) != BITLOCKER_SIGNATURE {
+		return nil, notBitLockerError
+	}
+
+	sector_size := int64(binary.LittleEndian.Uint16(sector[11:]))
+	if sector_size < 512 || sector_size&(sector_size-1) != 0 {
+		return nil, fmt.Errorf("%w: Invalid sector size %v",
+			invalidFVEMetadataError, sector_size)
+	}
+
+	offsets := []int64{}
+	if guidString(sector[160:176]) == BITLOCKER_IDENTIFIER {
+		for i := 176; i < 200; i += 8 {
+			offsets = append(offsets,
+				int64(binary.LittleEndian.Uint64(sector[i:])))
+		}
+
+	} else {
+		// Vista stores the cluster of the first metadata block in
+		// place of the $MFTMirr cluster.
+		cluster_size := sector_size * int64(sector[13])
+		offsets = append(offsets,
+			int64(binary.LittleEndian.Uint64(sector[0x38:]))*cluster_size)
+	}
+
+	err = invalidFVEMetadataError
+	for _, offset := range offsets {
+		var metadata *BitLockerMetadata
+		metadata, err = parseFVEMetadataBlock(reader, offset, sector_size)
+		if err == nil {
+			return metadata, nil
+		}
+		DebugPrint(DEBUG_NTFS, "FVE metadata block at %#x: %v\n", offset, err)
+	}
+
+	return nil, err
+}
+
+func parseFVEMetadataBlock(reader io.ReaderAt,
+	offset, sector_size int64) (*BitLockerMetadata, error) {
+	header, err := readSector(reader, offset,
+		BITLOCKER_BLOCK_HEADER_SIZE+BITLOCKER_METADATA_HEADER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(header[:8]) != BITLOCKER_SIGNATURE {
+		return nil, invalidFVEMetadataError
+	}
+
+	result := &BitLockerMetadata{
+		Version:    int(binary.LittleEndian.Uint16(header[10:])),
+		SectorSize: sector_size,
+	}
+
+	for i := 32; i < 56; i += 8 {
+		result.MetadataOffsets = append(result.MetadataOffsets,
+			int64(binary.LittleEndian.Uint64(header[i:])))
+	}
+
+	switch result.Version {
+	case 1:
+		result.mft_mirror_cluster = binary.LittleEndian.Uint64(header[56:])
+
+	case 2:
+		result.EncryptedVolumeSize = int64(binary.LittleEndian.Uint64(header[16:]))
+		result.VolumeHeaderSize = int64(
+			binary.LittleEndian.Uint32(header[28:])) * sector_size
+		result.VolumeHeaderOffset = int64(binary.LittleEndian.Uint64(header[56:]))
+
+	default:
+		return nil, fmt.Errorf("%w: Unsupported version %v",
+			invalidFVEMetadataError, result.Version)
+	}
+
+	metadata_header := header[BITLOCKER_BLOCK_HEADER_SIZE:]
+	metadata_size := int64(binary.LittleEndian.Uint32(metadata_header))
+	if metadata_size < BITLOCKER_METADATA_HEADER_SIZE ||
+		metadata_size > BITLOCKER_METADATA_REGION_SIZE {
+		return nil, invalidFVEMetadataError
+	}
+
+	result.VolumeGUID = guidString(metadata_header[16:32])
+	result.method = binary.LittleEndian.Uint16(metadata_header[36:])
+	result.EncryptionMethod = bitlockerMethodName(result.method)
+	result.CreationTime = fveTime(metadata_header[40:])
+
+	data, err := readSector(reader, offset+BITLOCKER_BLOCK_HEADER_SIZE,
+		metadata_size)
+	if err != nil {
+		return nil, err
+	}
+
+	result.entries = parseFVEEntries(data[BITLOCKER_METADATA_HEADER_SIZE:])
+	for _, entry := range result.entries {
+		switch {
+		case entry.entry_type == BITLOCKER_ENTRY_DESCRIPTION &&
+			entry.value_type == BITLOCKER_VALUE_UNICODE:
+			result.Description = UTF16BytesToUTF8(
+				trimUTF16(entry.data), binary.LittleEndian)
+
+		case entry.entry_type == BITLOCKER_ENTRY_VMK &&
+			entry.value_type == BITLOCKER_VALUE_VMK && len(entry.data) >= 28:
+			protection := binary.LittleEndian.Uint16(entry.data[26:])
+			result.Protectors = append(result.Protectors, &BitLockerProtector{
+				GUID:         guidString(entry.data[:16]),
+				Type:         bitlockerProtectionName(protection),
+				LastModified: fveTime(entry.data[16:]),
+				protection:   protection,
+				entries:      entry.nested(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func fveTime(data []byte) time.Time {
+	return time.Unix(0, int64(filetimeToUnixtime(
+		binary.LittleEndian.Uint64(data)))).UTC()
+}
+
+func bitlockerMethodName(method uint16) string {
+	name, pres := bitlockerMethodNames[method]
+	if pres {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%#x)", method)
+}
+
+func bitlockerProtectionName(protection uint16) string {
+	name, pres := bitlockerProtectionNames[protection]
+	if pres {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%#x)", protection)
+}
+
+// Convert the recovery password to its 16 byte binary form. Each
+// group is a multiple of 11 encoding 16 bits.
+func parseRecoveryPassword(password string) ([]byte, error) {
+	groups := strings.Split(strings.TrimSpace(password), "-")
+	if len(groups) != 8 {
+		return nil, invalidRecoveryPasswordError
+	}
+
+	result := make([]byte, 16)
+	for i, group := range groups {
+		value, err := strconv.ParseUint(group, 10, 32)
+		if err != nil || len(group) != 6 || value%11 != 0 || value/11 > 0xffff {
+			return nil, invalidRecoveryPasswordError
+		}
+		binary.LittleEndian.PutUint16(result[i*2:], uint16(value/11))
+	}
+
+	return result, nil
+}
+
+// Derive the key protecting the VMK from the password hash.
+func bitlockerStretchKey(password_hash, salt []byte) []byte {
+	// The last hash, the password hash, the salt and the iteration
+	// count are hashed together on each iteration.
+	buf := make([]byte, 88)
+	copy(buf[32:], password_hash)
+	copy(buf[64:], salt)
+
+	for i := uint64(0); i < BITLOCKER_STRETCH_ITERATIONS; i++ {
+		binary.LittleEndian.PutUint64(buf[80:], i)
+		sum := sha256.Sum256(buf)
+		copy(buf, sum[:])
+	}
+
+	return buf[:32]
+}
+
+func recoveryPasswordKey(password string, protector *BitLockerProtector) ([]byte, error) {
+	binary_password, err := parseRecoveryPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	stretch := findFVEValue(protector.entries, BITLOCKER_VALUE_STRETCH_KEY)
+	if stretch == nil || len(stretch.data) < 20 {
+		return nil, invalidFVEMetadataError
+	}
+
+	password_hash := sha256.Sum256(binary_password)
+	return bitlockerStretchKey(password_hash[:], stretch.data[4:20]), nil
+}
+
+// Get the external key from a .BEK file. The file holds a metadata
+// header followed by metadata entries.
+func parseBitLockerStartupKey(data []byte) ([]byte, error) {
+	if len(data) < BITLOCKER_METADATA_HEADER_SIZE {
+		return nil, invalidStartupKeyError
+	}
+
+	size := int(binary.LittleEndian.Uint32(data))
+	if size < BITLOCKER_METADATA_HEADER_SIZE || size > len(data) {
+		return nil, invalidStartupKeyError
+	}
+
+	external := findFVEEntry(parseFVEEntries(
+		data[BITLOCKER_METADATA_HEADER_SIZE:size]),
+		BITLOCKER_ENTRY_STARTUP_KEY, BITLOCKER_VALUE_EXTERNAL_KEY)
+	if external == nil {
+		return nil, invalidStartupKeyError
+	}
+
+	return fveKeyData(findFVEValue(external.nested(), BITLOCKER_VALUE_KEY))
+}
+
+// Apply the CCM key stream starting at the counter. BitLocker uses a
+// 12 byte nonce leaving a 3 byte counter.
+func bitlockerCCMCrypt(block cipher.Block, nonce []byte,
+	counter uint32, data []byte) {
+	counter_block := make([]byte, aes.BlockSize)
+	key_stream := make([]byte, aes.BlockSize)
+	counter_block[0] = 2
+	copy(counter_block[1:13], nonce)
+
+	for i := 0; i < len(data); i += aes.BlockSize {
+		counter_block[13] = byte(counter >> 16)
+		counter_block[14] = byte(counter >> 8)
+		counter_block[15] = byte(counter)
+		block.Encrypt(key_stream, counter_block)
+		xorBytes(data[i:], data[i:], key_stream)
+		counter++
+	}
+}
+
+// The CBC-MAC of the plain text with a 16 byte tag.
+func bitlockerCCMTag(block cipher.Block, nonce, plain_text []byte) []byte {
+	tag := make([]byte, aes.BlockSize)
+	tag[0] = 0x3a
+	copy(tag[1:13], nonce)
+	tag[13] = byte(len(plain_text) >> 16)
+	tag[14] = byte(len(plain_text) >> 8)
+	tag[15] = byte(len(plain_text))
+	block.Encrypt(tag, tag)
+
+	for i := 0; i < len(plain_text); i += aes.BlockSize {
+		xorBytes(tag, tag, plain_text[i:])
+		block.Encrypt(tag, tag)
+	}
+
+	return tag
+}
+
+// Decrypt an AES-CCM encrypted key entry holding a key entry.
+func decryptFVEKey(key []byte, entry *fveEntry) (uint16, []byte, error) {
+	if entry == nil || entry.value_type != BITLOCKER_VALUE_AES_CCM ||
+		len(entry.data) < 28 {
+		return 0, nil, invalidFVEMetadataError
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	nonce := entry.data[:12]
+	tag := append([]byte{}, entry.data[12:28]...)
+	plain_text := append([]byte{}, entry.data[28:]...)
+
+	bitlockerCCMCrypt(block, nonce, 0, tag)
+	bitlockerCCMCrypt(block, nonce, 1, plain_text)
+
+	if subtle.ConstantTimeCompare(tag,
+		bitlockerCCMTag(block, nonce, plain_text)) != 1 {
+		return 0, nil, bitlockerKeyMismatchError
+	}
+
+	entries := parseFVEEntries(plain_text)
+	if len(entries) == 0 {
+		return 0, nil, invalidFVEMetadataError
+	}
+
+	key_data, err := fveKeyData(entries[0])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return binary.LittleEndian.Uint16(entries[0].data), key_data, nil
+}
+
+// Try each key protector we have a key for.
+func (self *BitLockerMetadata) unlockVMK(keys *BitLockerKeys) ([]byte, error) {
+	var startup_key []byte
+	if len(keys.StartupKey) > 0 {
+		var err error
+		startup_key, err = parseBitLockerStartupKey(keys.StartupKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var last_err error = noBitLockerKeyError
+	for _, protector := range self.Protectors {
+		var key []byte
+		var err error
+
+		switch protector.protection {
+		case BITLOCKER_PROTECTION_CLEAR_KEY:
+			key, err = fveKeyData(findFVEValue(
+				protector.entries, BITLOCKER_VALUE_KEY))
+
+		case BITLOCKER_PROTECTION_RECOVERY_PASSWORD:
+			if keys.RecoveryPassword == "" {
+				continue
+			}
+			key, err = recoveryPasswordKey(keys.RecoveryPassword, protector)
+
+		case BITLOCKER_PROTECTION_STARTUP_KEY:
+			if startup_key == nil {
+				continue
+			}
+			key = startup_key
+
+		default:
+			continue
+		}
+
+		if err == nil {
+			var vmk []byte
+			_, vmk, err = decryptFVEKey(key, findFVEValue(
+				protector.entries, BITLOCKER_VALUE_AES_CCM))
+			if err == nil {
+				return vmk, nil
+			}
+		}
+
+		DebugPrint(DEBUG_NTFS, "Can not unlock protector %v (%v): %v\n",
+			protector.GUID, protector.Type, err)
+		last_err = err
+	}
+
+	return nil, last_err
+}
+
+type bitlockerCipher struct {
+	method uint16
+	block  cipher.Block
+
+	// The Elephant diffuser sector key or the XTS tweak key.
+	tweak cipher.Block
+}
+
+func newBitLockerCipher(method uint16, key []byte) (*bitlockerCipher, error) {
+	key_size := 16
+	switch method {
+	case BITLOCKER_AES_256_CBC_DIFFUSER, BITLOCKER_AES_256_CBC,
+		BITLOCKER_AES_256_XTS:
+		key_size = 32
+
+	case BITLOCKER_AES_128_CBC_DIFFUSER, BITLOCKER_AES_128_CBC,
+		BITLOCKER_AES_128_XTS:
+
+	default:
+		return nil, fmt.Errorf("%w: %#x", unsupportedBitLockerCipherError, method)
+	}
+
+	// The diffuser tweak key is stored at offset 32 but may be given
+	// straight after the FVEK.
+	tweak_offset := -1
+	switch method {
+	case BITLOCKER_AES_128_CBC_DIFFUSER, BITLOCKER_AES_256_CBC_DIFFUSER:
+		tweak_offset = 32
+		if len(key) < 32+key_size {
+			tweak_offset = key_size
+		}
+
+	case BITLOCKER_AES_128_XTS, BITLOCKER_AES_256_XTS:
+		tweak_offset = key_size
+	}
+
+	if len(key) < key_size || len(key) < tweak_offset+key_size {
+		return nil, fmt.Errorf("%w: Key too short", unsupportedBitLockerCipherError)
+	}
+
+	result := &bitlockerCipher{method: method}
+
+	var err error
+	result.block, err = aes.NewCipher(key[:key_size])
+	if err != nil {
+		return nil, err
+	}
+
+	if tweak_offset >= 0 {
+		result.tweak, err = aes.NewCipher(key[tweak_offset : tweak_offset+key_size])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// The CBC initialization vector is the encrypted byte offset.
+func (self *bitlockerCipher) iv(offset int64) []byte {
+	result := make([]byte, aes.BlockSize)
+	binary.LittleEndian.PutUint64(result, uint64(offset))
+	self.block.Encrypt(result, result)
+	return result
+}
+
+// The diffuser sector key is derived from the byte offset with the
+// tweak key.
+func (self *bitlockerCipher) sectorKey(offset int64) []byte {
+	result := make([]byte, 2*aes.BlockSize)
+	binary.LittleEndian.PutUint64(result, uint64(offset))
+	binary.LittleEndian.PutUint64(result[aes.BlockSize:], uint64(offset))
+	result[2*aes.BlockSize-1] = 0x80
+	self.tweak.Encrypt(result, result)
+	self.tweak.Encrypt(result[aes.BlockSize:], result[aes.BlockSize:])
+	return result
+}
+
+// Decrypt the sector in place. CBC modes use the byte offset of the
+// sector and XTS uses the sector number.
+func (self *bitlockerCipher) decryptSector(buf []byte, offset, sector_size int64) {
+	switch self.method {
+	case BITLOCKER_AES_128_XTS, BITLOCKER_AES_256_XTS:
+		tweak := make([]byte, aes.BlockSize)
+		binary.LittleEndian.PutUint64(tweak, uint64(offset/sector_size))
+		self.tweak.Encrypt(tweak, tweak)
+
+		for i := 0; i+aes.BlockSize <= len(buf); i += aes.BlockSize {
+			block := buf[i : i+aes.BlockSize]
+			xorBytes(block, block, tweak)
+			self.block.Decrypt(block, block)
+			xorBytes(block, block, tweak)
+			xtsMultiplyAlpha(tweak)
+		}
+
+	case BITLOCKER_AES_128_CBC_DIFFUSER, BITLOCKER_AES_256_CBC_DIFFUSER:
+		cipher.NewCBCDecrypter(self.block, self.iv(offset)).CryptBlocks(buf, buf)
+
+		words := bytesToWords(buf)
+		diffuserBDecrypt(words)
+		diffuserADecrypt(words)
+		wordsToBytes(words, buf)
+
+		sector_key := self.sectorKey(offset)
+		for i := range buf {
+			buf[i] ^= sector_key[i%len(sector_key)]
+		}
+
+	default:
+		cipher.NewCBCDecrypter(self.block, self.iv(offset)).CryptBlocks(buf, buf)
+	}
+}
+
+// Set dst to a XOR b up to the shortest of the three.
+func xorBytes(dst, a, b []byte) {
+	for i := 0; i < len(dst) && i < len(a) && i < len(b); i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// Multiply the XTS tweak by the primitive element of GF(2^128).
+func xtsMultiplyAlpha(tweak []byte) {
+	carry := tweak[15] >> 7
+	for i := 15; i > 0; i-- {
+		tweak[i] = tweak[i]<<1 | tweak[i-1]>>7
+	}
+	tweak[0] <<= 1
+	if carry != 0 {
+		tweak[0] ^= 0x87
+	}
+}
+
+func bytesToWords(buf []byte) []uint32 {
+	result := make([]uint32, len(buf)/4)
+	for i := range result {
+		result[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	return result
+}
+
+func wordsToBytes(words []uint32, buf []byte) {
+	for i, word := range words {
+		binary.LittleEndian.PutUint32(buf[i*4:], word)
+	}
+}
+
+func diffuserADecrypt(d []uint32) {
+	n := len(d)
+	for cycle := 0; cycle < 5; cycle++ {
+		for i := 0; i < n; i++ {
+			d[i] += d[(i+n-2)%n] ^ bits.RotateLeft32(
+				d[(i+n-5)%n], diffuserARotations[i%4])
+		}
+	}
+}
+
+func diffuserBDecrypt(d []uint32) {
+	n := len(d)
+	for cycle := 0; cycle < 3; cycle++ {
+		for i := 0; i < n; i++ {
+			d[i] += d[(i+2)%n] ^ bits.RotateLeft32(
+				d[(i+5)%n], diffuserBRotations[i%4])
+		}
+	}
+}
+
+// A decrypted view of a BitLocker volume.
+type BitLockerReader struct {
+	reader io.ReaderAt
+	cipher *bitlockerCipher
+
+	Metadata *BitLockerMetadata
+}
+
+func NewBitLockerReader(reader io.ReaderAt, keys *BitLockerKeys) (*BitLockerReader, error) {
+	if keys == nil {
+		keys = &BitLockerKeys{}
+	}
+
+	metadata, err := GetBitLockerMetadata(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	method := metadata.method
+	key_data := keys.FVEK
+
+	switch {
+	case len(key_data) == 66:
+		method = binary.LittleEndian.Uint16(key_data)
+		key_data = key_data[2:]
+
+	case len(key_data) == 0:
+		vmk, err := metadata.unlockVMK(keys)
+		if err != nil {
+			return nil, err
+		}
+
+		var fvek_method uint16
+		fvek_method, key_data, err = decryptFVEKey(vmk, findFVEEntry(
+			metadata.entries, BITLOCKER_ENTRY_FVEK, BITLOCKER_VALUE_AES_CCM))
+		if err != nil {
+			return nil, err
+		}
+
+		_, pres := bitlockerMethodNames[fvek_method]
+		if pres {
+			method = fvek_method
+		}
+	}
+
+	block_cipher, err := newBitLockerCipher(method, key_data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BitLockerReader{
+		reader:   reader,
+		cipher:   block_cipher,
+		Metadata: metadata,
+	}, nil
+}
+
+func (self *BitLockerReader) ReadAt(buf []byte, offset int64) (int, error) {
+	sector_size := self.Metadata.SectorSize
+	start := offset - offset%sector_size
+	end := offset + int64(len(buf))
+	if end%sector_size != 0 {
+		end += sector_size - end%sector_size
+	}
+
+	data := make([]byte, end-start)
+	n, err := self.reader.ReadAt(data, start)
+	n -= n % int(sector_size)
+	if n <= int(offset-start) {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+
+	for i := 0; i < n; i += int(sector_size) {
+		err := self.decryptSector(data[i:i+int(sector_size)], start+int64(i))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result := copy(buf, data[offset-start:n])
+	if result < len(buf) {
+		return result, io.EOF
+	}
+	return result, nil
+}
+
+func (self *BitLockerReader) decryptSector(sector []byte, offset int64) error {
+	metadata := self.Metadata
+
+	for _, metadata_offset := range metadata.MetadataOffsets {
+		if offset >= metadata_offset &&
+			offset < metadata_offset+BITLOCKER_METADATA_REGION_SIZE {
+			for i := range sector {
+				sector[i] = 0
+			}
+			return nil
+		}
+	}
+
+	if metadata.Version == 1 {
+		if offset >= BITLOCKER_VISTA_CLEAR_SIZE {
+			self.cipher.decryptSector(sector, offset, metadata.SectorSize)
+
+		} else if offset == 0 {
+			copy(sector[3:11], "NTFS    ")
+			binary.LittleEndian.PutUint64(sector[0x38:], metadata.mft_mirror_cluster)
+		}
+		return nil
+	}
+
+	// The original boot sectors were moved and are decrypted at
+	// their new location.
+	if offset < metadata.VolumeHeaderSize {
+		offset += metadata.VolumeHeaderOffset
+		_, err := self.reader.ReadAt(sector, offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A volume still being encrypted is only encrypted up to here.
+	if metadata.EncryptedVolumeSize > 0 && offset >= metadata.EncryptedVolumeSize {
+		return nil
+	}
+
+	self.cipher.decryptSector(sector, offset, metadata.SectorSize)
+	return nil
+}
@@ -0,0 +1,342 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"testing"
+)
+
+const (
+	testBitLockerSize         = 0x50000
+	testBitLockerHeaderOffset = 0x40000
+	testBitLockerHeaderSize   = 0x2000
+	testBitLockerVolumeGUID   = "{3a8f51a6-7e4c-4b3d-9a44-0c8d2b6e1f01}"
+)
+
+var testBitLockerMetadataOffsets = []int64{0x10000, 0x20000, 0x30000}
+
+func testUint32(value uint32) []byte {
+	result := make([]byte, 4)
+	binary.LittleEndian.PutUint32(result, value)
+	return result
+}
+
+func testFVEEntry(entry_type, value_type uint16, fields ...[]byte) []byte {
+	data := bytes.Join(fields, nil)
+	result := make([]byte, 8, 8+len(data))
+	binary.LittleEndian.PutUint16(result, uint16(8+len(data)))
+	binary.LittleEndian.PutUint16(result[2:], entry_type)
+	binary.LittleEndian.PutUint16(result[4:], value_type)
+	binary.LittleEndian.PutUint16(result[6:], 1)
+	return append(result, data...)
+}
+
+func testFVEKey(method uint32, key []byte) []byte {
+	return testFVEEntry(0, BITLOCKER_VALUE_KEY, testUint32(method), key)
+}
+
+// AES-CCM encrypt the plain text into an entry.
+func testFVEEncryptedKey(entry_type uint16, key, plain_text []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	nonce := []byte("nonce-123456")
+
+	tag := bitlockerCCMTag(block, nonce, plain_text)
+	bitlockerCCMCrypt(block, nonce, 0, tag)
+
+	cipher_text := append([]byte{}, plain_text...)
+	bitlockerCCMCrypt(block, nonce, 1, cipher_text)
+
+	return testFVEEntry(entry_type, BITLOCKER_VALUE_AES_CCM,
+		nonce, tag, cipher_text)
+}
+
+func testVMKEntry(guid string, protection uint16, nested ...[]byte) []byte {
+	fixed := make([]byte, 28)
+	copy(fixed, testGUIDBytes(guid))
+	binary.LittleEndian.PutUint16(fixed[26:], protection)
+	return testFVEEntry(BITLOCKER_ENTRY_VMK, BITLOCKER_VALUE_VMK,
+		fixed, bytes.Join(nested, nil))
+}
+
+func testDiffuserAEncrypt(d []uint32) {
+	n := len(d)
+	for cycle := 0; cycle < 5; cycle++ {
+		for i := n - 1; i >= 0; i-- {
+			d[i] -= d[(i+n-2)%n] ^ bits.RotateLeft32(
+				d[(i+n-5)%n], diffuserARotations[i%4])
+		}
+	}
+}
+
+func testDiffuserBEncrypt(d []uint32) {
+	n := len(d)
+	for cycle := 0; cycle < 3; cycle++ {
+		for i := n - 1; i >= 0; i-- {
+			d[i] -= d[(i+2)%n] ^ bits.RotateLeft32(
+				d[(i+5)%n], diffuserBRotations[i%4])
+		}
+	}
+}
+
+// The inverse of decryptSector.
+func testEncryptSector(c *bitlockerCipher, buf []byte, offset int64) {
+	switch c.method {
+	case BITLOCKER_AES_128_XTS, BITLOCKER_AES_256_XTS:
+		tweak := make([]byte, aes.BlockSize)
+		binary.LittleEndian.PutUint64(tweak, uint64(offset/512))
+		c.tweak.Encrypt(tweak, tweak)
+
+		for i := 0; i < len(buf); i += aes.BlockSize {
+			block := buf[i : i+aes.BlockSize]
+			xorBytes(block, block, tweak)
+			c.block.Encrypt(block, block)
+			xorBytes(block, block, tweak)
+			xtsMultiplyAlpha(tweak)
+		}
+		return
+
+	case BITLOCKER_AES_128_CBC_DIFFUSER, BITLOCKER_AES_256_CBC_DIFFUSER:
+		sector_key := c.sectorKey(offset)
+		for i := range buf {
+			buf[i] ^= sector_key[i%len(sector_key)]
+		}
+
+		words := bytesToWords(buf)
+		testDiffuserAEncrypt(words)
+		testDiffuserBEncrypt(words)
+		wordsToBytes(words, buf)
+	}
+
+	cipher.NewCBCEncrypter(c.block, c.iv(offset)).CryptBlocks(buf, buf)
+}
+
+// Build an encrypted volume. Returns the expected decrypted volume
+// and the image.
+func buildTestBitLocker(t *testing.T, method uint16,
+	fvek []byte, entries ...[]byte) ([]byte, []byte) {
+	plain := make([]byte, testBitLockerSize)
+	for i := range plain {
+		plain[i] = byte(i*7 + i>>9)
+	}
+
+	// The boot sectors are moved and the metadata regions read as
+	// zeros.
+	copy(plain[testBitLockerHeaderOffset:], plain[:testBitLockerHeaderSize])
+	for _, offset := range testBitLockerMetadataOffsets {
+		copy(plain[offset:], make([]byte, BITLOCKER_METADATA_REGION_SIZE))
+	}
+
+	c, err := newBitLockerCipher(method, fvek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := make([]byte, testBitLockerSize)
+	for offset := int64(testBitLockerHeaderSize); offset < testBitLockerSize; offset += 512 {
+		if offset >= testBitLockerMetadataOffsets[0] &&
+			offset < testBitLockerHeaderOffset {
+			continue
+		}
+
+		sector := image[offset : offset+512]
+		copy(sector, plain[offset:])
+		testEncryptSector(c, sector, offset)
+	}
+
+	header := image[:512]
+	copy(header, "\xeb\x58\x90"+BITLOCKER_SIGNATURE)
+	binary.LittleEndian.PutUint16(header[11:], 512)
+	header[13] = 8
+	copy(header[160:], testGUIDBytes(BITLOCKER_IDENTIFIER))
+	for i, offset := range testBitLockerMetadataOffsets {
+		binary.LittleEndian.PutUint64(header[176+i*8:], uint64(offset))
+	}
+
+	// The first metadata block is damaged.
+	data := bytes.Join(entries, nil)
+	for _, offset := range testBitLockerMetadataOffsets[1:] {
+		block := image[offset:]
+		copy(block, BITLOCKER_SIGNATURE)
+		binary.LittleEndian.PutUint16(block[10:], 2)
+		binary.LittleEndian.PutUint64(block[16:], testBitLockerSize)
+		binary.LittleEndian.PutUint32(block[28:], testBitLockerHeaderSize/512)
+		for i, offset := range testBitLockerMetadataOffsets {
+			binary.LittleEndian.PutUint64(block[32+i*8:], uint64(offset))
+		}
+		binary.LittleEndian.PutUint64(block[56:], testBitLockerHeaderOffset)
+
+		metadata := block[BITLOCKER_BLOCK_HEADER_SIZE:]
+		size := uint32(BITLOCKER_METADATA_HEADER_SIZE + len(data))
+		binary.LittleEndian.PutUint32(metadata, size)
+		binary.LittleEndian.PutUint32(metadata[4:], 1)
+		binary.LittleEndian.PutUint32(metadata[8:], BITLOCKER_METADATA_HEADER_SIZE)
+		binary.LittleEndian.PutUint32(metadata[12:], size)
+		copy(metadata[16:], testGUIDBytes(testBitLockerVolumeGUID))
+		binary.LittleEndian.PutUint16(metadata[36:], method)
+		copy(metadata[BITLOCKER_METADATA_HEADER_SIZE:], data)
+	}
+
+	return plain, image
+}
+
+func testBitLockerDecrypt(t *testing.T, reader *BitLockerReader, plain []byte) {
+	// Read in unaligned chunks.
+	buf := make([]byte, len(plain))
+	for offset := 0; offset < len(buf); offset += 3000 {
+		end := offset + 3000
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		_, err := reader.ReadAt(buf[offset:end], int64(offset))
+		if err != nil {
+			t.Fatalf("Read at %#x: %v", offset, err)
+		}
+	}
+
+	for offset := 0; offset < len(buf); offset += 512 {
+		if !bytes.Equal(buf[offset:offset+512], plain[offset:offset+512]) {
+			t.Fatalf("Sector at %#x decrypted incorrectly", offset)
+		}
+	}
+}
+
+func TestBitLockerMethods(t *testing.T) {
+	fvek := make([]byte, 64)
+	for i := range fvek {
+		fvek[i] = byte(i + 1)
+	}
+
+	for _, method := range []uint16{
+		BITLOCKER_AES_128_CBC_DIFFUSER, BITLOCKER_AES_256_CBC_DIFFUSER,
+		BITLOCKER_AES_128_CBC, BITLOCKER_AES_256_CBC,
+		BITLOCKER_AES_128_XTS, BITLOCKER_AES_256_XTS,
+	} {
+		plain, image := buildTestBitLocker(t, method, fvek)
+
+		reader, err := NewBitLockerReader(bytes.NewReader(image),
+			&BitLockerKeys{FVEK: fvek})
+		if err != nil {
+			t.Fatalf("%v: %v", bitlockerMethodName(method), err)
+		}
+		testBitLockerDecrypt(t, reader, plain)
+	}
+
+	// A key prefixed with its method overrides the volume's method.
+	plain, image := buildTestBitLocker(t, BITLOCKER_AES_256_XTS, fvek)
+	binary.LittleEndian.PutUint16(image[0x20000+BITLOCKER_BLOCK_HEADER_SIZE+36:],
+		BITLOCKER_AES_128_CBC)
+	reader, err := NewBitLockerReader(bytes.NewReader(image), &BitLockerKeys{
+		FVEK: append([]byte{0x05, 0x80}, fvek...),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBitLockerDecrypt(t, reader, plain)
+}
+
+func TestBitLockerProtectors(t *testing.T) {
+	fvek := bytes.Repeat([]byte{0x42}, 32)
+	vmk := bytes.Repeat([]byte{0x24}, 32)
+	external_key := bytes.Repeat([]byte{0x99}, 32)
+	salt := []byte("0123456789abcdef")
+
+	recovery_guid := "{11111111-2222-3333-4444-555555555555}"
+	startup_guid := "{66666666-7777-8888-9999-000000000000}"
+	password := "111111-222222-333333-444444-555555-666666-000011-123420"
+
+	binary_password, err := parseRecoveryPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password_hash := sha256.Sum256(binary_password)
+	recovery_key := bitlockerStretchKey(password_hash[:], salt)
+
+	plain, image := buildTestBitLocker(t, BITLOCKER_AES_128_XTS, fvek,
+		testFVEEntry(BITLOCKER_ENTRY_DESCRIPTION, BITLOCKER_VALUE_UNICODE,
+			utf16LE("TESTPC C: 1/1/2020\x00")),
+		testVMKEntry(recovery_guid, BITLOCKER_PROTECTION_RECOVERY_PASSWORD,
+			testFVEEntry(0, BITLOCKER_VALUE_STRETCH_KEY, testUint32(0x1000), salt),
+			testFVEEncryptedKey(0, recovery_key, testFVEKey(0x2000, vmk))),
+		testVMKEntry(startup_guid, BITLOCKER_PROTECTION_STARTUP_KEY,
+			testFVEEncryptedKey(0, external_key, testFVEKey(0x2000, vmk))),
+		testFVEEncryptedKey(BITLOCKER_ENTRY_FVEK, vmk,
+			testFVEKey(BITLOCKER_AES_128_XTS, fvek)))
+
+	metadata, err := GetBitLockerMetadata(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.VolumeGUID != testBitLockerVolumeGUID ||
+		metadata.Description != "TESTPC C: 1/1/2020" ||
+		metadata.EncryptionMethod != "AES-128-XTS" ||
+		len(metadata.Protectors) != 2 ||
+		metadata.Protectors[0].GUID != recovery_guid ||
+		metadata.Protectors[0].Type != "Recovery Password" ||
+		metadata.Protectors[1].Type != "Startup Key" {
+		t.Fatalf("Unexpected metadata %v", metadata)
+	}
+
+	reader, err := NewBitLockerReader(bytes.NewReader(image),
+		&BitLockerKeys{RecoveryPassword: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBitLockerDecrypt(t, reader, plain)
+
+	// The startup key file holds the external key.
+	external := make([]byte, 24)
+	copy(external, testGUIDBytes(startup_guid))
+	bek_entries := testFVEEntry(BITLOCKER_ENTRY_STARTUP_KEY,
+		BITLOCKER_VALUE_EXTERNAL_KEY, external, testFVEKey(0x2000, external_key))
+	bek := make([]byte, BITLOCKER_METADATA_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(bek, uint32(len(bek)+len(bek_entries)))
+	bek = append(bek, bek_entries...)
+
+	reader, err = NewBitLockerReader(bytes.NewReader(image),
+		&BitLockerKeys{StartupKey: bek})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBitLockerDecrypt(t, reader, plain)
+
+	_, err = NewBitLockerReader(bytes.NewReader(image), &BitLockerKeys{
+		RecoveryPassword: "111111-222222-333333-444444-555555-666666-000011-000022",
+	})
+	if !errors.Is(err, bitlockerKeyMismatchError) {
+		t.Fatalf("Expected key mismatch: %v", err)
+	}
+
+	_, err = NewBitLockerReader(bytes.NewReader(image), &BitLockerKeys{
+		RecoveryPassword: "111111-222222-333333-444444-555555-666666-000011-000023",
+	})
+	if !errors.Is(err, invalidRecoveryPasswordError) {
+		t.Fatalf("Expected invalid password: %v", err)
+	}
+
+	_, err = NewBitLockerReader(bytes.NewReader(image), nil)
+	if !errors.Is(err, noBitLockerKeyError) {
+		t.Fatalf("Expected no key: %v", err)
+	}
+
+	// Suspended protection stores the key in the clear.
+	clear_key := bytes.Repeat([]byte{0x77}, 32)
+	plain, image = buildTestBitLocker(t, BITLOCKER_AES_128_XTS, fvek,
+		testVMKEntry(recovery_guid, BITLOCKER_PROTECTION_CLEAR_KEY,
+			testFVEKey(0x2000, clear_key),
+			testFVEEncryptedKey(0, clear_key, testFVEKey(0x2000, vmk))),
+		testFVEEncryptedKey(BITLOCKER_ENTRY_FVEK, vmk,
+			testFVEKey(BITLOCKER_AES_128_XTS, fvek)))
+
+	reader, err = NewBitLockerReader(bytes.NewReader(image), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBitLockerDecrypt(t, reader, plain)
+}
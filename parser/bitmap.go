@@ -0,0 +1,393 @@
+// Support for the cluster allocation bitmap ($Bitmap, MFT entry 6).
+//
+// The $DATA stream of $Bitmap holds one bit per cluster on the
+// volume: bit n of byte n/8 is set when the cluster is allocated.
+//
+// Knowing which clusters are free allows carving and recovery to
+// limit themselves to unallocated space, and to tell whether the
+// clusters of a deleted file were reused since.
+
+package parser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	noBootSectorError = errors.New("No boot sector known")
+)
+
+const (
+	BITMAP_MFT_ID = 6
+
+	// Read the bitmap in chunks of this size when iterating.
+	BITMAP_CHUNK_SIZE = 0x10000
+)
+
+// A contiguous run of clusters with the same allocation status.
+type ClusterExtent struct {
+	Lcn       int64
+	Count     int64
+	Allocated bool
+}
+
+type ClusterBitmap struct {
+	reader        io.ReaderAt
+	cluster_size  int64
+	cluster_count int64
+}
+
+func NewClusterBitmap(reader io.ReaderAt,
+	cluster_size, cluster_count int64) *ClusterBitmap {
+	return &ClusterBitmap{
+		reader:        reader,
+		cluster_size:  cluster_size,
+		cluster_count: cluster_count,
+	}
+}
+
+// Open the volume's $Bitmap.
+func OpenClusterBitmap(ntfs *NTFSContext) (*ClusterBitmap, error) {
+	// A context over a raw $MFT file does not have a volume.
+	if ntfs.Boot == nil {
+		return nil, noBootSectorError
+	}
+
+	mft_entry, err := ntfs.GetMFT(BITMAP_MFT_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := OpenStream(ntfs, mft_entry, ATTR_TYPE_DATA,
+		WILDCARD_STREAM_ID, WILDCARD_STREAM_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster_size := ntfs.ClusterSize
+	if cluster_size == 0 {
+		cluster_size = 0x1000
+	}
+
+	cluster_count := ntfs.Boot.VolumeSize() *
+		int64(ntfs.Boot.Sector_size()) / cluster_size
+
+	return NewClusterBitmap(stream, cluster_size, cluster_count), nil
+}
+
+func (self *ClusterBitmap) ClusterSize() int64 {
+	return self.cluster_size
+}
+
+func (self *ClusterBitmap) ClusterCount() int64 {
+	return self.cluster_count
+}
+
+func (self *ClusterBitmap) IsClusterAllocated(lcn int64) (bool, error) {
+	if lcn < 0 || lcn >= self.cluster_count {
+		return false, io.EOF
+	}
+
+	buf := make([]byte, 1)
+	n, err := self.reader.ReadAt(buf, lcn/8)
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return false, err
+	}
+
+	return buf[0]&(1<<uint(lcn%8)) != 0, nil
+}
+
+// Count the allocated clusters in the range [lcn, lcn+count).
+func (self *ClusterBitmap) CountAllocated(lcn, count int64) int64 {
+	result := int64(0)
+	for extent := range self.extents(context.Background(), lcn, count) {
+		if extent.Allocated {
+			result += extent.Count
+		}
+	}
+	return result
+}
+
+// Emit all extents of the volume.
+func (self *ClusterBitmap) Extents(ctx context.Context) chan *ClusterExtent {
+	return self.extents(ctx, 0, self.cluster_count)
+}
+
+func (self *ClusterBitmap) AllocatedExtents(
+	ctx context.Context) chan *ClusterExtent {
+	return self.filterExtents(ctx, true)
+}
+
+func (self *ClusterBitmap) UnallocatedExtents(
+	ctx context.Context) chan *ClusterExtent {
+	return self.filterExtents(ctx, false)
+}
+
+func (self *ClusterBitmap) filterExtents(
+	ctx context.Context, allocated bool) chan *ClusterExtent {
+	output := make(chan *ClusterExtent)
+
+	go func() {
+		defer close(output)
+
+		sub_ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		for extent := range self.Extents(sub_ctx) {
+			if extent.Allocated != allocated {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output <- extent:
+			}
+		}
+	}()
+
+	return output
+}
+
+// Emit the extents covering the clusters [start, start+count).
+func (self *ClusterBitmap) extents(ctx context.Context,
+	start, count int64) chan *ClusterExtent {
+	output := make(chan *ClusterExtent)
+
+	go func() {
+		defer close(output)
+
+		end := start + count
+		if end > self.cluster_count {
+			end = self.cluster_count
+		}
+
+		var current *ClusterExtent
+		emit := func() bool {
+			if current == nil {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case output <- current:
+				return true
+			}
+		}
+
+		buffer := make([]byte, BITMAP_CHUNK_SIZE)
+		for lcn := start; lcn < end; {
+			chunk_offset := lcn / 8
+			n, err := self.reader.ReadAt(buffer, chunk_offset)
+			if n == 0 {
+				if err != nil && err != io.EOF {
+					DebugPrint(DEBUG_NTFS, "ClusterBitmap: %v\n", err)
+				}
+				break
+			}
+
+			chunk_end := (chunk_offset + int64(n)) * 8
+			if chunk_end > end {
+				chunk_end = end
+			}
+
+			for ; lcn < chunk_end; lcn++ {
+				b := buffer[lcn/8-chunk_offset]
+
+				// Skip over whole bytes quickly.
+				if lcn%8 == 0 && lcn+8 <= chunk_end &&
+					current != nil && (b == 0 || b == 0xff) &&
+					current.Allocated == (b == 0xff) {
+					current.Count += 8
+					lcn += 7
+					continue
+				}
+
+				allocated := b&(1<<uint(lcn%8)) != 0
+				if current != nil && current.Allocated == allocated {
+					current.Count++
+					continue
+				}
+
+				if !emit() {
+					return
+				}
+				current = &ClusterExtent{
+					Lcn: lcn, Count: 1, Allocated: allocated,
+				}
+			}
+		}
+
+		emit()
+	}()
+
+	return output
+}
+
+// Presents the unallocated clusters of the volume as a single
+// contiguous stream. The Ranges() of the reader are the unallocated
+// extents (in stream offsets) and VtoP() maps a stream offset back to
+// the disk offset.
+type UnallocatedReader struct {
+	disk_reader  io.ReaderAt
+	cluster_size int64
+
+	// Sorted by file offset.
+	runs []*MappedReader
+	size int64
+}
+
+func NewUnallocatedReader(ctx context.Context,
+	ntfs *NTFSContext) (*UnallocatedReader, error) {
+	bitmap, err := ntfs.GetClusterBitmap()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UnallocatedReader{
+		disk_reader:  ntfs.DiskReader,
+		cluster_size: bitmap.ClusterSize(),
+	}
+
+	file_offset := int64(0)
+	for extent := range bitmap.UnallocatedExtents(ctx) {
+		result.runs = append(result.runs, &MappedReader{
+			FileOffset:   file_offset,
+			TargetOffset: extent.Lcn,
+			Length:       extent.Count,
+			ClusterSize:  result.cluster_size,
+			Reader:       ntfs.DiskReader,
+		})
+		file_offset += extent.Count
+	}
+	result.size = file_offset * result.cluster_size
+
+	return result, nil
+}
+
+// The total size of unallocated space.
+func (self *UnallocatedReader) Size() int64 {
+	return self.size
+}
+
+func (self *UnallocatedReader) Ranges() []Range {
+	result := make([]Range, 0, len(self.runs))
+	for _, run := range self.runs {
+		result = append(result, Range{
+			Offset: run.FileOffset * self.cluster_size,
+			Length: run.Length * self.cluster_size,
+		})
+	}
+	return result
+}
+
+// Find the run containing the offset.
+func (self *UnallocatedReader) findRun(offset int64) (*MappedReader, bool) {
+	idx := sort.Search(len(self.runs), func(i int) bool {
+		run := self.runs[i]
+		return (run.FileOffset+run.Length)*self.cluster_size > offset
+	})
+	if idx >= len(self.runs) || offset < 0 {
+		return nil, false
+	}
+	return self.runs[idx], true
+}
+
+// Map the offset in unallocated space to the offset on the disk.
+func (self *UnallocatedReader) VtoP(offset int64) int64 {
+	run, ok := self.findRun(offset)
+	if !ok {
+		return 0
+	}
+
+	disk_offset := offset - run.FileOffset*self.cluster_size +
+		run.TargetOffset*self.cluster_size
+
+	delegate, ok := self.disk_reader.(VtoPer)
+	if ok {
+		return delegate.VtoP(disk_offset)
+	}
+	return disk_offset
+}
+
+func (self *UnallocatedReader) ReadAt(buf []byte, offset int64) (int, error) {
+	buf_idx := 0
+	for buf_idx < len(buf) {
+		run, ok := self.findRun(offset)
+		if !ok {
+			return buf_idx, io.EOF
+		}
+
+		run_offset := offset - run.FileOffset*self.cluster_size
+		to_read := run.Length*self.cluster_size - run_offset
+		if to_read > int64(len(buf)-buf_idx) {
+			to_read = int64(len(buf) - buf_idx)
+		}
+
+		n, err := self.disk_reader.ReadAt(
+			buf[buf_idx:buf_idx+int(to_read)],
+			run.TargetOffset*self.cluster_size+run_offset)
+		buf_idx += n
+		offset += int64(n)
+
+		if err != nil && err != io.EOF {
+			return buf_idx, err
+		}
+		if n == 0 {
+			return buf_idx, io.EOF
+		}
+	}
+
+	return buf_idx, nil
+}
+
+// Cache the $Bitmap per context.
+type bitmapCache struct {
+	mu     sync.Mutex
+	loaded bool
+	bitmap *ClusterBitmap
+	err    error
+}
+
+func (self *bitmapCache) Purge() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.loaded = false
+	self.bitmap = nil
+	self.err = nil
+}
+
+// Get the volume's cluster bitmap.
+func (self *NTFSContext) GetClusterBitmap() (*ClusterBitmap, error) {
+	cache := self.bitmap_cache
+	if cache == nil {
+		return OpenClusterBitmap(self)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if !cache.loaded {
+		cache.bitmap, cache.err = OpenClusterBitmap(self)
+		cache.loaded = true
+	}
+
+	return cache.bitmap, cache.err
+}
+
+// Is the cluster at lcn allocated according to $Bitmap?
+func (self *NTFSContext) IsClusterAllocated(lcn int64) (bool, error) {
+	bitmap, err := self.GetClusterBitmap()
+	if err != nil {
+		return false, err
+	}
+	return bitmap.IsClusterAllocated(lcn)
+}
@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestClusterBitmap(t *testing.T) {
+	// Clusters 0-9 and 28-29 are allocated, 30 clusters in total.
+	bitmap := NewClusterBitmap(bytes.NewReader(
+		[]byte{0xff, 0x03, 0x00, 0x30}), 16, 30)
+
+	for lcn, expected := range map[int64]bool{
+		0: true, 9: true, 10: false, 19: false, 28: true, 29: true,
+	} {
+		allocated, err := bitmap.IsClusterAllocated(lcn)
+		if err != nil || allocated != expected {
+			t.Fatalf("Cluster %v: expected %v got %v (%v)",
+				lcn, expected, allocated, err)
+		}
+	}
+
+	_, err := bitmap.IsClusterAllocated(30)
+	if err == nil {
+		t.Fatalf("Expected an error past the end of the volume")
+	}
+
+	extents := []ClusterExtent{}
+	for extent := range bitmap.Extents(context.Background()) {
+		extents = append(extents, *extent)
+	}
+
+	if !reflect.DeepEqual(extents, []ClusterExtent{
+		{Lcn: 0, Count: 10, Allocated: true},
+		{Lcn: 10, Count: 18, Allocated: false},
+		{Lcn: 28, Count: 2, Allocated: true},
+	}) {
+		t.Fatalf("Unexpected extents %v", extents)
+	}
+
+	unallocated := []ClusterExtent{}
+	for extent := range bitmap.UnallocatedExtents(context.Background()) {
+		unallocated = append(unallocated, *extent)
+	}
+	if len(unallocated) != 1 || unallocated[0].Lcn != 10 {
+		t.Fatalf("Unexpected unallocated extents %v", unallocated)
+	}
+
+	if bitmap.CountAllocated(5, 25) != 7 {
+		t.Fatalf("Unexpected allocated count %v",
+			bitmap.CountAllocated(5, 25))
+	}
+}
+
+func TestUnallocatedReader(t *testing.T) {
+	// 8 clusters of 4 bytes, each filled with its cluster number.
+	disk := []byte{}
+	for i := byte(0); i < 8; i++ {
+		disk = append(disk, i, i, i, i)
+	}
+
+	ntfs := newNTFSContext(bytes.NewReader(disk), "test")
+	ntfs.bitmap_cache.loaded = true
+	ntfs.bitmap_cache.bitmap = NewClusterBitmap(
+		bytes.NewReader([]byte{0x99}), 4, 8)
+
+	allocated, err := ntfs.IsClusterAllocated(3)
+	if err != nil || !allocated {
+		t.Fatalf("Expected cluster 3 to be allocated")
+	}
+
+	reader, err := NewUnallocatedReader(context.Background(), ntfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clusters 1, 2, 5 and 6 are free.
+	if reader.Size() != 16 || len(reader.Ranges()) != 2 {
+		t.Fatalf("Unexpected ranges %v", reader.Ranges())
+	}
+
+	buf := make([]byte, 16)
+	n, err := reader.ReadAt(buf, 0)
+	if err != nil || n != 16 || !bytes.Equal(buf, []byte{
+		1, 1, 1, 1, 2, 2, 2, 2, 5, 5, 5, 5, 6, 6, 6, 6}) {
+		t.Fatalf("Unexpected data %v (%v)", buf[:n], err)
+	}
+
+	if reader.VtoP(9) != 21 {
+		t.Fatalf("Unexpected VtoP %v", reader.VtoP(9))
+	}
+
+	n, _ = reader.ReadAt(buf, 14)
+	if n != 2 {
+		t.Fatalf("Expected short read at the end, got %v", n)
+	}
+}
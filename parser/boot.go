@@ -25,11 +25,20 @@ func (self *NTFS_BOOT_SECTOR) VolumeSize() int64 {
 }
 
 func (self *NTFS_BOOT_SECTOR) RecordSize() int64 {
-	_record_size := int64(self._mft_record_size())
-	if _record_size > 0 {
-		return _record_size * self.ClusterSize()
+	return self.clustersOrBytes(self._mft_record_size())
+}
+
+func (self *NTFS_BOOT_SECTOR) IndexRecordSize() int64 {
+	return self.clustersOrBytes(int8(self.Index_record_size()))
+}
+
+// Sizes in the boot sector are in clusters if positive or a power of
+// 2 in bytes if negative.
+func (self *NTFS_BOOT_SECTOR) clustersOrBytes(value int8) int64 {
+	if value > 0 {
+		return int64(value) * self.ClusterSize()
 	}
-	return 1 << uint32(-_record_size)
+	return 1 << uint32(-value)
 }
 
 // The MFT entry needs to be fixed up. This method extracts the
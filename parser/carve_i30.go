@@ -0,0 +1,248 @@
+// Carve INDX records from arbitrary data.
+//
+// Directory indexes ($I30) are stored in INDX records in the
+// $INDEX_ALLOCATION stream of the directory. When a directory is
+// deleted (or shrinks) its INDX records remain in unallocated
+// clusters until they are overwritten. Each record holds FILE_NAME
+// entries (and slack entries of files removed earlier) which refer
+// to the directory they belong to, so carved entries are evidence of
+// files even when both the file and its directory are long gone.
+
+package parser
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	// INDX records are 4kb in practice but may be larger on
+	// volumes with large clusters.
+	MAX_INDX_RECORD_SIZE = 0x10000
+)
+
+type I30CarvedRecord struct {
+	*FileInfo
+
+	// The disk offset of the index entry.
+	DiskOffset int64
+
+	ParentEntryNumber    uint64
+	ParentSequenceNumber uint16
+
+	// The path of the parent directory if it can still be found in
+	// the MFT.
+	ParentPath string `json:"ParentPath,omitempty"`
+
+	// The parent directory was deleted or its MFT entry reused.
+	ParentDeleted bool `json:"ParentDeleted,omitempty"`
+}
+
+// Check the header of a potential INDX record at offset in the
+// buffer and return its size.
+func testINDXHeader(ntfs *NTFSContext, buffer []byte, offset int) (int, bool) {
+	if offset+0x28 > len(buffer) ||
+		string(buffer[offset:offset+4]) != "INDX" {
+		return 0, false
+	}
+
+	index := ntfs.Profile.STANDARD_INDEX_HEADER(
+		bytes.NewReader(buffer), int64(offset))
+	fixup_offset := int(index.Fixup_offset())
+	fixup_count := int(index.Fixup_count())
+
+	// The fixup array covers every sector of the record.
+	size := (fixup_count - 1) * 512
+	if size < 0x400 || size > MAX_INDX_RECORD_SIZE ||
+		size&(size-1) != 0 || offset+size > len(buffer) {
+		return 0, false
+	}
+
+	node := index.Node()
+	node_offset := int(node.Offset - int64(offset))
+	start := int(node.Offset_to_index_entry())
+	end := int(node.Offset_to_end_index_entry())
+	alloc := int(node.SizeOfEntriesAlloc())
+
+	if fixup_offset < 0x28 || fixup_offset+2*fixup_count > node_offset+start ||
+		start > end || end > alloc || node_offset+alloc > size {
+		return 0, false
+	}
+
+	return size, true
+}
+
+// Find the path of the directory the carved entry belonged to. The
+// directory may have been deleted since, in which case its MFT entry
+// sequence number is one higher.
+func carvedParentPath(ntfs *NTFSContext,
+	parent_id uint64, parent_seq uint16) (string, bool) {
+	if ntfs.MFTReader == nil {
+		return "", false
+	}
+
+	if parent_id == 5 {
+		return "/", false
+	}
+
+	parent, err := ntfs.GetMFT(int64(parent_id))
+	if err != nil {
+		return "", true
+	}
+
+	seq := parent.Sequence_value()
+	if parent.Flags().IsSet("ALLOCATED") {
+		if seq != parent_seq {
+			return "", true
+		}
+		path, _ := RecoverPath(ntfs, parent)
+		return path, false
+	}
+
+	if seq != parent_seq && seq != parent_seq+1 {
+		return "", true
+	}
+	path, _ := RecoverPath(ntfs, parent)
+	return path, true
+}
+
+// Scan the reader for INDX records and emit their live and slack
+// entries. The NTFS context is used to resolve the parent directories
+// of the entries. If it is nil a context without an MFT is used.
+func CarveI30(ctx context.Context,
+	ntfs *NTFSContext,
+	reader io.ReaderAt,
+	size int64) chan *I30CarvedRecord {
+	output := make(chan *I30CarvedRecord)
+
+	if ntfs == nil {
+		ntfs = newNTFSContext(reader, "CarveI30")
+		ntfs.ClusterSize = 0x1000
+		ntfs.RecordSize = 0x400
+	}
+
+	go func() {
+		defer close(output)
+
+		type parent_key struct {
+			id  uint64
+			seq uint16
+		}
+		type parent_info struct {
+			path    string
+			deleted bool
+		}
+		parents := make(map[parent_key]parent_info)
+
+		// Overlap the buffers by the largest record size in case
+		// a record is split.
+		buffer_size := int64(64 * MAX_INDX_RECORD_SIZE)
+		overlap := int64(MAX_INDX_RECORD_SIZE)
+		buffer := make([]byte, buffer_size)
+
+		now := time.Now()
+
+		for i := int64(0); i < size; i += buffer_size - overlap {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			DebugPrint(DEBUG_NTFS, "%v: CarveI30 reading %v at %v in %v\n",
+				time.Now(), len(buffer), i, time.Now().Sub(now))
+			now = time.Now()
+
+			n, err := reader.ReadAt(buffer, i)
+			if err != nil && err != io.EOF {
+				return
+			}
+			if n < 0x400 {
+				return
+			}
+
+			buf_reader := bytes.NewReader(buffer[:n])
+
+			// Skip the overlap except on the last buffer so
+			// records are not reported twice.
+			end := n
+			if int64(n) == buffer_size {
+				end = n - int(overlap)
+			}
+
+			for j := 0; j < end; j += 512 {
+				record_size, ok := testINDXHeader(ntfs, buffer[:n], j)
+				if !ok {
+					continue
+				}
+
+				index, err := DecodeSTANDARD_INDEX_HEADER(
+					ntfs, buf_reader, int64(j), int64(record_size))
+				if err != nil {
+					continue
+				}
+
+				node := index.Node()
+				emit := func(slack bool, record *INDEX_RECORD_ENTRY) bool {
+					if !record.IsValid() {
+						return true
+					}
+
+					fi := new_file_info(record)
+					fi.SequenceNumber = record.Seq_num()
+					fi.IsSlack = slack
+					if slack {
+						fi.SlackOffset = record.Offset
+					}
+
+					filename := record.File()
+					key := parent_key{
+						id:  filename.MftReference(),
+						seq: filename.Seq_num(),
+					}
+					parent, pres := parents[key]
+					if !pres {
+						parent.path, parent.deleted = carvedParentPath(
+							ntfs, key.id, key.seq)
+						parents[key] = parent
+					}
+
+					select {
+					case <-ctx.Done():
+						return false
+
+					case output <- &I30CarvedRecord{
+						FileInfo: fi,
+						DiskOffset: carvedDiskOffset(
+							reader, i+int64(j)+record.Offset),
+						ParentEntryNumber:    key.id,
+						ParentSequenceNumber: key.seq,
+						ParentPath:           parent.path,
+						ParentDeleted:        parent.deleted,
+					}:
+					}
+					return true
+				}
+
+				for _, record := range node.GetRecords(ntfs) {
+					if !emit(false, record) {
+						return
+					}
+				}
+
+				for _, record := range node.ScanSlack(ntfs) {
+					if !emit(true, record) {
+						return
+					}
+				}
+
+				// Records do not overlap.
+				j += record_size - 512
+			}
+		}
+	}()
+
+	return output
+}
@@ -0,0 +1,186 @@
+// Carve MFT entries from arbitrary data.
+//
+// MFT entries start with the FILE signature and are always sector
+// aligned. They are found in unallocated space (e.g. from a previous
+// file system on a reformatted volume or old MFT extents), in
+// pagefiles and in memory images.
+
+package parser
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+type MFTCarvedRecord struct {
+	*MFTHighlight
+	DiskOffset int64
+}
+
+// Check the header of a potential MFT entry at offset in the buffer
+// before we bother with fixups.
+func testMFTEntryHeader(ntfs *NTFSContext, buffer []byte, offset int) bool {
+	if offset+0x30 > len(buffer) ||
+		string(buffer[offset:offset+4]) != "FILE" {
+		return false
+	}
+
+	mft_entry := ntfs.Profile.MFT_ENTRY(bytes.NewReader(buffer), int64(offset))
+	allocated := int(mft_entry.Mft_entry_allocated())
+	used := int(mft_entry.Mft_entry_size())
+	attribute_offset := int(mft_entry.Attribute_offset())
+	fixup_offset := int(mft_entry.Fixup_offset())
+	fixup_count := int(mft_entry.Fixup_count())
+
+	// Entries are 1kb or 4kb in practice.
+	if allocated < 0x400 || allocated > 0x1000 ||
+		allocated&(allocated-1) != 0 ||
+		offset+allocated > len(buffer) {
+		return false
+	}
+
+	if used > allocated || attribute_offset%8 != 0 ||
+		attribute_offset+8 > used {
+		return false
+	}
+
+	// The fixup array covers every sector and precedes the
+	// attributes.
+	if fixup_count != allocated/512+1 || fixup_offset < 0x28 ||
+		fixup_offset+2*fixup_count > attribute_offset {
+		return false
+	}
+
+	return true
+}
+
+// Check that the attributes of the fixed up entry are sane.
+func testMFTEntryAttributes(ntfs *NTFSContext, mft_entry *MFT_ENTRY) bool {
+	attributes := mft_entry.EnumerateAttributes(ntfs)
+	if len(attributes) == 0 {
+		return false
+	}
+
+	for _, attr := range attributes {
+		attr_type := attr.Type().Value
+		if attr_type == 0 || attr_type > ATTR_TYPE_LOGGED_UTILITY_STREAM ||
+			attr_type%0x10 != 0 || attr.Length()%8 != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Resolve the path of a carved entry through its parent in the
+// volume's MFT (if any). The carved entry itself may not be in the
+// MFT any more so we do not look it up.
+func carvedComponents(ntfs *NTFSContext, row *MFTHighlight) []string {
+	name := row.FileName()
+	if ntfs.MFTReader == nil || row.ParentEntryNumber == 5 {
+		return []string{name}
+	}
+
+	links := ntfs.full_path_resolver.GetHardLinks(
+		row.ParentEntryNumber, row.ParentSequenceNumber, 1)
+	if len(links) == 0 {
+		return []string{name}
+	}
+
+	return append(links[0], name)
+}
+
+// Scan the reader for MFT entries. The NTFS context is used to read
+// the non resident attributes of the carved entries and to resolve
+// their parent directories. If it is nil (e.g. there is no valid boot
+// sector) a context without an MFT is used.
+func CarveMFT(ctx context.Context,
+	ntfs *NTFSContext,
+	reader io.ReaderAt,
+	size int64) chan *MFTCarvedRecord {
+	output := make(chan *MFTCarvedRecord)
+
+	if ntfs == nil {
+		ntfs = newNTFSContext(reader, "CarveMFT")
+		ntfs.ClusterSize = 0x1000
+		ntfs.RecordSize = 0x400
+	}
+
+	go func() {
+		defer close(output)
+
+		// Overlap the buffers by the largest entry size in case
+		// an entry is split.
+		buffer_size := int64(1024 * 0x1000)
+		overlap := int64(0x1000)
+		buffer := make([]byte, buffer_size)
+
+		now := time.Now()
+
+		for i := int64(0); i < size; i += buffer_size - overlap {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			DebugPrint(DEBUG_NTFS, "%v: CarveMFT reading %v at %v in %v\n",
+				time.Now(), len(buffer), i, time.Now().Sub(now))
+			now = time.Now()
+
+			n, err := reader.ReadAt(buffer, i)
+			if err != nil && err != io.EOF {
+				return
+			}
+			if n < 0x400 {
+				return
+			}
+
+			buf_reader := bytes.NewReader(buffer[:n])
+
+			// Skip the overlap except on the last buffer so
+			// entries are not reported twice.
+			end := n
+			if int64(n) == buffer_size {
+				end = n - int(overlap)
+			}
+
+			for j := 0; j < end; j += 512 {
+				if !testMFTEntryHeader(ntfs, buffer[:n], j) {
+					continue
+				}
+
+				fixed_reader, err := FixUpDiskMFTEntry(
+					ntfs.Profile.MFT_ENTRY(buf_reader, int64(j)))
+				if err != nil {
+					continue
+				}
+
+				mft_entry := ntfs.Profile.MFT_ENTRY(fixed_reader, 0)
+				if !testMFTEntryAttributes(ntfs, mft_entry) {
+					continue
+				}
+
+				row, _, _ := newMFTHighlight(ntfs, mft_entry)
+				if row == nil {
+					continue
+				}
+				row.components = carvedComponents(ntfs, row)
+
+				select {
+				case <-ctx.Done():
+					return
+
+				case output <- &MFTCarvedRecord{
+					MFTHighlight: row,
+					DiskOffset:   carvedDiskOffset(reader, i+int64(j)),
+				}:
+				}
+			}
+		}
+	}()
+
+	return output
+}
@@ -8,11 +8,23 @@ const (
 	MAX_USN_RECORD_SCAN_SIZE = 1024
 	MAX_ATTR_NAME_LENGTH     = 1024
 	MAX_FILENAME_LENGTH      = 32 * 1024
+	MAX_REPARSE_DATA_SIZE    = 16 * 1024
 
-	ATTR_TYPE_DATA                 = 128
-	ATTR_TYPE_ATTRIBUTE_LIST       = 32
-	ATTR_TYPE_STANDARD_INFORMATION = 16
-	ATTR_TYPE_FILE_NAME            = 48
-	ATTR_TYPE_INDEX_ROOT           = 144
-	ATTR_TYPE_INDEX_ALLOCATION     = 160
+	MAX_SECURITY_DESCRIPTOR_SIZE = 64 * 1024
+
+	ATTR_TYPE_DATA                  = 128
+	ATTR_TYPE_ATTRIBUTE_LIST        = 32
+	ATTR_TYPE_STANDARD_INFORMATION  = 16
+	ATTR_TYPE_FILE_NAME             = 48
+	ATTR_TYPE_OBJECT_ID             = 64
+	ATTR_TYPE_SECURITY_DESCRIPTOR   = 80
+	ATTR_TYPE_VOLUME_NAME           = 96
+	ATTR_TYPE_VOLUME_INFORMATION    = 112
+	ATTR_TYPE_INDEX_ROOT            = 144
+	ATTR_TYPE_INDEX_ALLOCATION      = 160
+	ATTR_TYPE_BITMAP                = 176
+	ATTR_TYPE_REPARSE_POINT         = 192
+	ATTR_TYPE_EA_INFORMATION        = 208
+	ATTR_TYPE_EA                    = 224
+	ATTR_TYPE_LOGGED_UTILITY_STREAM = 256
 )
@@ -33,6 +33,19 @@ type NTFSContext struct {
 	mft_summary_cache *MFTEntryCache
 
 	full_path_resolver *FullPathResolver
+
+	// Cache of the $Secure indexes.
+	secure_cache *secureCache
+
+	// Cache of the $Bitmap.
+	bitmap_cache *bitmapCache
+
+	// Cache of the $Quota indexes.
+	quota_cache *quotaCache
+
+	// The attribute types, merged with the volume's $AttrDef on
+	// first use (see AttributeTypes()).
+	attribute_types *AttributeTypeRegistry
 }
 
 func (self *NTFSContext) Stats() *ordereddict.Dict {
@@ -49,6 +62,11 @@ func newNTFSContext(image io.ReaderAt, name string) *NTFSContext {
 		options:       GetDefaultOptions(),
 		Profile:       NewNTFSProfile(),
 		mft_entry_lru: mft_cache,
+		secure_cache:  &secureCache{},
+		bitmap_cache:  &bitmapCache{},
+		quota_cache:   &quotaCache{},
+
+		attribute_types: DefaultAttributeTypes.Copy(),
 	}
 
 	// Only used for USN path reconstruction.
@@ -82,6 +100,10 @@ func (self *NTFSContext) Copy() *NTFSContext {
 		RecordSize:        self.RecordSize,
 		mft_entry_lru:     self.mft_entry_lru,
 		mft_summary_cache: self.mft_summary_cache,
+		secure_cache:      self.secure_cache,
+		bitmap_cache:      self.bitmap_cache,
+		quota_cache:       self.quota_cache,
+		attribute_types:   self.attribute_types,
 	}
 }
 
@@ -110,6 +132,9 @@ func (self *NTFSContext) Purge() {
 	self.mft_entry_lru.Purge()
 	self.mft_summary_cache.Purge()
 	self.full_path_resolver.Purge()
+	self.secure_cache.Purge()
+	self.bitmap_cache.Purge()
+	self.quota_cache.Purge()
 
 	// Try to flush our reader if possible
 	Flush(self.DiskReader)
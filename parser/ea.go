@@ -0,0 +1,303 @@
+// Decoding of extended attributes.
+//
+// The $EA attribute holds a list of FILE_FULL_EA_INFORMATION entries
+// (name and value pairs) and $EA_INFORMATION holds their total sizes.
+// Extended attributes are rarely used by Windows itself but WSL
+// stores the POSIX metadata of Linux files in them:
+//
+//   $LXUID, $LXGID, $LXMOD  - uid, gid and mode (DrvFs metadata)
+//   $LXDEV                  - device major and minor numbers
+//   LXATTRB                 - mode, uid, gid and timestamps (LxFs)
+//
+// Since EA values are arbitrary bytes they are also a place to hide
+// data so we keep the raw values.
+//
+// References:
+// https://learn.microsoft.com/en-us/windows-hardware/drivers/ddi/wdm/ns-wdm-_file_full_ea_information
+// https://learn.microsoft.com/en-us/windows/wsl/file-permissions
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// The EA is required to interpret the file.
+	FILE_NEED_EA = 0x80
+
+	EA_INFORMATION_SIZE = 8
+
+	// EAs are limited to 64kb.
+	MAX_EA_SIZE = 0x10000
+
+	LXATTRB_SIZE = 56
+
+	WSL_EA_UID     = "$LXUID"
+	WSL_EA_GID     = "$LXGID"
+	WSL_EA_MODE    = "$LXMOD"
+	WSL_EA_DEVICE  = "$LXDEV"
+	WSL_EA_LXATTRB = "LXATTRB"
+)
+
+var (
+	eaTooShortError = errors.New("$EA too short")
+	eaNotFoundError = errors.New("Extended attribute not found")
+)
+
+type EAInformation struct {
+	PackedSize   uint16
+	NeedEACount  uint16
+	UnpackedSize uint32
+}
+
+type ExtendedAttribute struct {
+	Name  string
+	Flags uint8
+
+	// Serialized as base64.
+	Value []byte
+}
+
+// POSIX metadata of files created by WSL. Fields are only set when
+// present.
+type WSLMetadata struct {
+	UID         *uint32    `json:"UID,omitempty"`
+	GID         *uint32    `json:"GID,omitempty"`
+	Mode        *uint32    `json:"Mode,omitempty"`
+	ModeString  string     `json:"ModeString,omitempty"`
+	DeviceMajor *uint32    `json:"DeviceMajor,omitempty"`
+	DeviceMinor *uint32    `json:"DeviceMinor,omitempty"`
+	Rdev        uint32     `json:"Rdev,omitempty"`
+	Atime       *time.Time `json:"Atime,omitempty"`
+	Mtime       *time.Time `json:"Mtime,omitempty"`
+	Ctime       *time.Time `json:"Ctime,omitempty"`
+}
+
+// Parse the content of the $EA_INFORMATION attribute.
+func ParseEAInformation(data []byte) (*EAInformation, error) {
+	if len(data) < EA_INFORMATION_SIZE {
+		return nil, eaTooShortError
+	}
+
+	return &EAInformation{
+		PackedSize:   binary.LittleEndian.Uint16(data[0:]),
+		NeedEACount:  binary.LittleEndian.Uint16(data[2:]),
+		UnpackedSize: binary.LittleEndian.Uint32(data[4:]),
+	}, nil
+}
+
+// Parse the list of FILE_FULL_EA_INFORMATION entries in the $EA
+// attribute. Each entry starts with the offset to the next entry (0
+// for the last one), the flags, the name length and the value
+// length, followed by the NUL terminated name and the value.
+func ParseExtendedAttributes(data []byte) ([]*ExtendedAttribute, error) {
+	result := []*ExtendedAttribute{}
+
+	offset := 0
+	for offset+8 <= len(data) {
+		entry := data[offset:]
+		next_offset := int(binary.LittleEndian.Uint32(entry[0:]))
+		name_length := int(entry[5])
+		value_length := int(binary.LittleEndian.Uint16(entry[6:]))
+
+		value_offset := 8 + name_length + 1
+		if value_offset+value_length > len(entry) {
+			return result, eaTooShortError
+		}
+
+		value := make([]byte, value_length)
+		copy(value, entry[value_offset:])
+
+		result = append(result, &ExtendedAttribute{
+			Name:  string(entry[8 : 8+name_length]),
+			Flags: entry[4],
+			Value: value,
+		})
+
+		if next_offset == 0 {
+			break
+		}
+		offset += next_offset
+	}
+
+	return result, nil
+}
+
+// Extract the $EA_INFORMATION attribute from the MFT.
+func (self *MFT_ENTRY) EAInformation(ntfs *NTFSContext) (*EAInformation, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_EA_INFORMATION {
+			buf := make([]byte, EA_INFORMATION_SIZE)
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseEAInformation(buf[:n])
+		}
+	}
+
+	return nil, errors.New("$EA_INFORMATION not found!")
+}
+
+// Extract the extended attributes from the $EA attribute.
+func (self *MFT_ENTRY) ExtendedAttributes(
+	ntfs *NTFSContext) ([]*ExtendedAttribute, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_EA {
+			buf := make([]byte, CapInt64(attr.DataSize(), MAX_EA_SIZE))
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseExtendedAttributes(buf[:n])
+		}
+	}
+
+	return nil, errors.New("$EA not found!")
+}
+
+// Find an extended attribute by name. EA names are case insensitive.
+func GetExtendedAttribute(ntfs *NTFSContext, mft_entry *MFT_ENTRY,
+	name string) (*ExtendedAttribute, error) {
+	eas, err := mft_entry.ExtendedAttributes(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ea := range eas {
+		if strings.EqualFold(ea.Name, name) {
+			return ea, nil
+		}
+	}
+
+	return nil, eaNotFoundError
+}
+
+func eaUint32(value []byte) *uint32 {
+	if len(value) < 4 {
+		return nil
+	}
+	result := binary.LittleEndian.Uint32(value)
+	return &result
+}
+
+func unixTime(sec uint64, nsec uint32) *time.Time {
+	result := time.Unix(int64(sec), int64(nsec)).UTC()
+	return &result
+}
+
+// Interpret the WSL extended attributes. Returns nil if the file has
+// none.
+func ParseWSLMetadata(eas []*ExtendedAttribute) *WSLMetadata {
+	var result *WSLMetadata
+	get_result := func() *WSLMetadata {
+		if result == nil {
+			result = &WSLMetadata{}
+		}
+		return result
+	}
+
+	for _, ea := range eas {
+		switch strings.ToUpper(ea.Name) {
+		case WSL_EA_UID:
+			get_result().UID = eaUint32(ea.Value)
+
+		case WSL_EA_GID:
+			get_result().GID = eaUint32(ea.Value)
+
+		case WSL_EA_MODE:
+			get_result().Mode = eaUint32(ea.Value)
+
+		case WSL_EA_DEVICE:
+			if len(ea.Value) >= 8 {
+				get_result().DeviceMajor = eaUint32(ea.Value)
+				result.DeviceMinor = eaUint32(ea.Value[4:])
+			}
+
+		case WSL_EA_LXATTRB:
+			// Flags and version (2 bytes each), mode, uid, gid,
+			// rdev and the nanoseconds of atime, mtime and ctime
+			// (4 bytes each) and the seconds of atime, mtime and
+			// ctime (8 bytes each).
+			value := ea.Value
+			if len(value) < LXATTRB_SIZE {
+				continue
+			}
+
+			// $LXUID etc take precedence.
+			lx := get_result()
+			if lx.Mode == nil {
+				lx.Mode = eaUint32(value[4:])
+			}
+			if lx.UID == nil {
+				lx.UID = eaUint32(value[8:])
+			}
+			if lx.GID == nil {
+				lx.GID = eaUint32(value[12:])
+			}
+			lx.Rdev = binary.LittleEndian.Uint32(value[16:])
+			lx.Atime = unixTime(binary.LittleEndian.Uint64(value[32:]),
+				binary.LittleEndian.Uint32(value[20:]))
+			lx.Mtime = unixTime(binary.LittleEndian.Uint64(value[40:]),
+				binary.LittleEndian.Uint32(value[24:]))
+			lx.Ctime = unixTime(binary.LittleEndian.Uint64(value[48:]),
+				binary.LittleEndian.Uint32(value[28:]))
+		}
+	}
+
+	if result != nil && result.Mode != nil {
+		result.ModeString = posixModeString(*result.Mode)
+	}
+
+	return result
+}
+
+// Format a POSIX mode like ls -l (e.g. -rwxr-xr-x).
+func posixModeString(mode uint32) string {
+	file_type := map[uint32]byte{
+		0140000: 's', 0120000: 'l', 0100000: '-', 0060000: 'b',
+		0040000: 'd', 0020000: 'c', 0010000: 'p',
+	}
+
+	result := []byte("?rwxrwxrwx")
+	if t, pres := file_type[mode&0170000]; pres {
+		result[0] = t
+	}
+
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			result[i+1] = '-'
+		}
+	}
+
+	// The setuid, setgid and sticky bits replace the execute bits.
+	special := []struct {
+		bit      uint32
+		index    int
+		set, exe byte
+	}{
+		{04000, 3, 'S', 's'},
+		{02000, 6, 'S', 's'},
+		{01000, 9, 'T', 't'},
+	}
+	for _, s := range special {
+		if mode&s.bit != 0 {
+			if result[s.index] == '-' {
+				result[s.index] = s.set
+			} else {
+				result[s.index] = s.exe
+			}
+		}
+	}
+
+	return string(result)
+}
+
+func init() {
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_EA_INFORMATION,
+		func(data []byte) (interface{}, error) {
+			return ParseEAInformation(data)
+		})
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_EA,
+		func(data []byte) (interface{}, error) {
+			return ParseExtendedAttributes(data)
+		})
+}
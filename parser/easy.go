@@ -42,6 +42,13 @@ type FileInfo struct {
 	// Is it in I30 slack?
 	IsSlack     bool  `json:"IsSlack,omitempty"`
 	SlackOffset int64 `json:"SlackOffset,omitempty"`
+
+	// Set for junctions, symlinks and other reparse points.
+	ReparsePoint *ReparsePoint `json:"ReparsePoint,omitempty"`
+
+	// Extended attributes and the WSL metadata stored in them.
+	ExtendedAttributes []*ExtendedAttribute `json:"ExtendedAttributes,omitempty"`
+	WSL                *WSLMetadata         `json:"WSL,omitempty"`
 }
 
 // Build an NTFS Context from the raw MFT file. NOTE: This approach
@@ -61,6 +68,7 @@ func GetNTFSContextFromRawMFT(reader io.ReaderAt,
 	ntfs.MFTReader = reader
 	ntfs.ClusterSize = cluster_size
 	ntfs.RecordSize = record_size
+	ntfs.loadAttrDefOnFirstUse()
 
 	return ntfs
 }
@@ -85,6 +93,7 @@ func GetNTFSContext(image io.ReaderAt, offset int64) (*NTFSContext, error) {
 	}
 
 	ntfs.MFTReader = mft_reader
+	ntfs.loadAttrDefOnFirstUse()
 
 	return ntfs, nil
 }
@@ -160,6 +169,9 @@ func Stat(ntfs *NTFSContext, node_mft *MFT_ENTRY) []*FileInfo {
 	var data_attributes []*NTFS_ATTRIBUTE
 	var win32_name *FILE_NAME
 	var index_attribute *NTFS_ATTRIBUTE
+	var reparse *ReparsePoint
+	var eas []*ExtendedAttribute
+	var wsl *WSLMetadata
 	var fn_birth_time, fn_mtime time.Time
 
 	mft_id := node_mft.Record_number()
@@ -202,6 +214,13 @@ func Stat(ntfs *NTFSContext, node_mft *MFT_ENTRY) []*FileInfo {
 
 		case ATTR_TYPE_INDEX_ROOT, ATTR_TYPE_INDEX_ALLOCATION:
 			index_attribute = attr
+
+		case ATTR_TYPE_REPARSE_POINT:
+			reparse, _ = node_mft.ReparsePoint(ntfs)
+
+		case ATTR_TYPE_EA:
+			eas, _ = node_mft.ExtendedAttributes(ntfs)
+			wsl = ParseWSLMetadata(eas)
 		}
 	}
 
@@ -247,6 +266,10 @@ func Stat(ntfs *NTFSContext, node_mft *MFT_ENTRY) []*FileInfo {
 			Name:           win32_name.Name(),
 			NameType:       win32_name.NameType().Name,
 			IsDir:          is_dir,
+			ReparsePoint:   reparse,
+
+			ExtendedAttributes: eas,
+			WSL:                wsl,
 		}
 
 		add_extra_names(info, "")
@@ -290,6 +313,10 @@ func Stat(ntfs *NTFSContext, node_mft *MFT_ENTRY) []*FileInfo {
 		// be a directory.
 		if ads != "" {
 			info.IsDir = false
+		} else {
+			info.ReparsePoint = reparse
+			info.ExtendedAttributes = eas
+			info.WSL = wsl
 		}
 
 		result = append(result, info)
@@ -480,9 +507,25 @@ func OpenStream(
 		return nil, os.ErrNotExist
 	}
 
-	// Return a resident reader immediately.
 	attr := vcns[0]
 
+	// Files compressed by the Windows Overlay Filter keep their
+	// real data in a separate stream - return a reader over the
+	// decompressed data instead.
+	if attr_type == ATTR_TYPE_DATA && attr.Name() == "" {
+		wof_reader, err := openWofStream(ntfs, mft_entry, attr.DataSize())
+		if err == nil {
+			return wof_reader, nil
+		}
+
+		// The WofCompressedData stream may be missing if the
+		// file is not really WOF compressed.
+		if err != notWofError && err != os.ErrNotExist {
+			return nil, err
+		}
+	}
+
+	// Return a resident reader immediately.
 	if attr.Resident().Name == "RESIDENT" {
 		buf := make([]byte, CapUint32(attr.Content_size(),
 			MAX_MFT_ENTRY_SIZE))
@@ -0,0 +1,361 @@
+// Parsing of EFS encrypted files.
+//
+// NTFS stores the data of EFS encrypted files encrypted with a File
+// Encryption Key (FEK). The FEK is kept in the $EFS
+// $LOGGED_UTILITY_STREAM, once encrypted for each user who may
+// decrypt the file (the Data Decryption Fields or DDF) and for each
+// recovery agent (the Data Recovery Fields or DRF). Each field
+// identifies the user's key by SID and either a certificate
+// thumbprint or a CryptoAPI key container.
+//
+// We can not decrypt the file without the user's private key, but we
+// can export it in the format of ReadEncryptedFileRaw() so it may be
+// restored with WriteEncryptedFileRaw() and decrypted elsewhere.
+//
+// References:
+// https://github.com/tuxera/ntfs-3g/blob/edge/include/ntfs-3g/layout.h (EFS_ATTR_HEADER)
+// [MS-EFSR] 2.2.3 EFSRPC Raw Data Format
+
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"unicode/utf16"
+)
+
+const (
+	EFS_STREAM_NAME = "$EFS"
+
+	// Credential types in a DDF or DRF entry.
+	EFS_CREDENTIAL_CRYPTOAPI_CONTAINER = 1
+	EFS_CREDENTIAL_CERT_THUMBPRINT     = 3
+
+	EFS_HEADER_SIZE = 0x4c
+
+	MAX_EFS_SIZE = 1024 * 1024
+
+	// The raw export is written in segments of this size.
+	EFS_RAW_SEGMENT_SIZE = 0x10000
+	EFS_RAW_VERSION      = 0x100
+
+	// EFS encrypts 512 byte units.
+	efsDataUnitShift = 9
+	efsChunkShift    = 16
+)
+
+var (
+	efsTooShortError  = errors.New("$EFS stream too short")
+	notEncryptedError = errors.New("File is not EFS encrypted")
+)
+
+type EFSKeyEntry struct {
+	SID              string `json:"SID,omitempty"`
+	CredentialType   uint32
+	Thumbprint       string `json:"Thumbprint,omitempty"`
+	ContainerName    string `json:"ContainerName,omitempty"`
+	ProviderName     string `json:"ProviderName,omitempty"`
+	UserName         string `json:"UserName,omitempty"`
+	EncryptedFEKSize int
+}
+
+type EFSInfo struct {
+	Version uint32
+
+	// Users who may decrypt the file.
+	DDF []*EFSKeyEntry
+
+	// Recovery agents.
+	DRF []*EFSKeyEntry `json:"DRF,omitempty"`
+}
+
+func efsUint32(data []byte, offset int) uint32 {
+	if offset < 0 || offset+4 > len(data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data[offset:])
+}
+
+func efsString(data []byte, offset int) string {
+	if offset <= 0 || offset >= len(data) {
+		return ""
+	}
+	return UTF16BytesToUTF8(trimUTF16(data[offset:]), binary.LittleEndian)
+}
+
+// Parse the content of the $EFS stream.
+func ParseEFS(data []byte) (*EFSInfo, error) {
+	if len(data) < EFS_HEADER_SIZE {
+		return nil, efsTooShortError
+	}
+
+	length := int(efsUint32(data, 0))
+	if length < EFS_HEADER_SIZE || length > len(data) {
+		return nil, efsTooShortError
+	}
+	data = data[:length]
+
+	return &EFSInfo{
+		Version: efsUint32(data, 8),
+		DDF:     parseEFSKeyList(data, int(efsUint32(data, 0x40))),
+		DRF:     parseEFSKeyList(data, int(efsUint32(data, 0x44))),
+	}, nil
+}
+
+func parseEFSKeyList(data []byte, offset int) []*EFSKeyEntry {
+	if offset == 0 {
+		return nil
+	}
+
+	result := []*EFSKeyEntry{}
+	count := int(efsUint32(data, offset))
+	entry_offset := offset + 4
+
+	for i := 0; i < count && entry_offset+20 <= len(data); i++ {
+		entry_length := int(efsUint32(data, entry_offset))
+		if entry_length < 20 || entry_offset+entry_length > len(data) {
+			break
+		}
+
+		entry := data[entry_offset : entry_offset+entry_length]
+		result = append(result, parseEFSKeyEntry(entry))
+		entry_offset += entry_length
+	}
+
+	return result
+}
+
+func parseEFSKeyEntry(entry []byte) *EFSKeyEntry {
+	result := &EFSKeyEntry{
+		EncryptedFEKSize: int(efsUint32(entry, 8)),
+	}
+
+	cred_offset := int(efsUint32(entry, 4))
+	if cred_offset < 20 || cred_offset+28 > len(entry) {
+		return result
+	}
+	cred := entry[cred_offset:]
+
+	sid_offset := int(efsUint32(cred, 4))
+	if sid_offset > 0 && sid_offset < len(cred) {
+		result.SID, _, _ = ParseSID(cred[sid_offset:])
+	}
+
+	result.CredentialType = efsUint32(cred, 8)
+	switch result.CredentialType {
+	case EFS_CREDENTIAL_CRYPTOAPI_CONTAINER:
+		result.ContainerName = efsString(cred, int(efsUint32(cred, 12)))
+		result.ProviderName = efsString(cred, int(efsUint32(cred, 16)))
+
+	case EFS_CREDENTIAL_CERT_THUMBPRINT:
+		header_offset := int(efsUint32(cred, 16))
+		if header_offset <= 0 || header_offset+20 > len(cred) {
+			break
+		}
+		header := cred[header_offset:]
+
+		thumbprint_offset := int(efsUint32(header, 0))
+		thumbprint_size := int(efsUint32(header, 4))
+		if thumbprint_offset > 0 &&
+			thumbprint_offset+thumbprint_size <= len(header) {
+			result.Thumbprint = hex.EncodeToString(
+				header[thumbprint_offset : thumbprint_offset+thumbprint_size])
+		}
+
+		result.ContainerName = efsString(header, int(efsUint32(header, 8)))
+		result.ProviderName = efsString(header, int(efsUint32(header, 12)))
+		result.UserName = efsString(header, int(efsUint32(header, 16)))
+	}
+
+	return result
+}
+
+func (self *MFT_ENTRY) efsAttribute(ntfs *NTFSContext) (*NTFS_ATTRIBUTE, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_LOGGED_UTILITY_STREAM &&
+			attr.Name() == EFS_STREAM_NAME {
+			return attr, nil
+		}
+	}
+	return nil, notEncryptedError
+}
+
+func readEFSStream(ntfs *NTFSContext, mft_entry *MFT_ENTRY) ([]byte, error) {
+	attr, err := mft_entry.efsAttribute(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := OpenStream(ntfs, mft_entry, ATTR_TYPE_LOGGED_UTILITY_STREAM,
+		attr.Attribute_id(), EFS_STREAM_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, CapInt64(attr.DataSize(), MAX_EFS_SIZE))
+	n, err := reader.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Extract the $EFS stream from the MFT.
+func (self *MFT_ENTRY) EFS(ntfs *NTFSContext) (*EFSInfo, error) {
+	data, err := readEFSStream(ntfs, self)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEFS(data)
+}
+
+// Open the raw (encrypted) data of a stream. Unlike OpenStream() the
+// reader is not limited to the initialized size because the last
+// encrypted unit extends past the end of the file.
+func openEncryptedStream(ntfs *NTFSContext, mft_entry *MFT_ENTRY,
+	attr_id uint16, name string) (io.ReaderAt, int64, int64, error) {
+	vcns := GetAllVCNs(ntfs, mft_entry, ATTR_TYPE_DATA, attr_id, name)
+	if len(vcns) == 0 {
+		return nil, 0, 0, os.ErrNotExist
+	}
+
+	attr := vcns[0]
+	if attr.IsResident() {
+		reader, err := OpenStream(ntfs, mft_entry, ATTR_TYPE_DATA, attr_id, name)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return reader, attr.DataSize(), attr.DataSize(), nil
+	}
+
+	runs := joinAllVCNs(ntfs, vcns)
+	if len(runs) == 0 {
+		return nil, 0, 0, os.ErrNotExist
+	}
+
+	return runs[0].Reader, int64(attr.Actual_size()),
+		int64(attr.Initialized_size()), nil
+}
+
+type efsRawWriter struct {
+	out io.Writer
+	err error
+}
+
+func (self *efsRawWriter) write(fields ...interface{}) {
+	for _, field := range fields {
+		if self.err != nil {
+			return
+		}
+
+		switch t := field.(type) {
+		case []byte:
+			_, self.err = self.out.Write(t)
+		default:
+			self.err = binary.Write(self.out, binary.LittleEndian, t)
+		}
+	}
+}
+
+func efsUTF16(value string) []byte {
+	result := []byte{}
+	for _, c := range utf16.Encode([]rune(value)) {
+		result = append(result, byte(c), byte(c>>8))
+	}
+	return result
+}
+
+func (self *efsRawWriter) writeStreamHeader(name string) {
+	name_bytes := efsUTF16(name)
+	self.write(uint32(28+len(name_bytes)), efsUTF16("NTFS"),
+		uint32(0), uint64(0), uint32(len(name_bytes)), name_bytes)
+}
+
+// Write the raw data of a stream in segments. Each segment records
+// how much of its data is within the stream size and the valid data
+// length.
+func (self *efsRawWriter) writeDataSegments(reader io.ReaderAt,
+	size, valid_data_length int64, cluster_size int64) {
+	cluster_shift := uint8(0)
+	for cluster_size > 1 {
+		cluster_size >>= 1
+		cluster_shift++
+	}
+
+	// The encrypted data is padded to whole units.
+	unit_size := int64(1) << efsDataUnitShift
+	raw_size := (size + unit_size - 1) &^ (unit_size - 1)
+
+	within := func(limit, offset, length int64) uint32 {
+		if limit <= offset {
+			return 0
+		}
+		if limit-offset < length {
+			return uint32(limit - offset)
+		}
+		return uint32(length)
+	}
+
+	buf := make([]byte, EFS_RAW_SEGMENT_SIZE)
+	for offset := int64(0); offset < raw_size && self.err == nil; offset += EFS_RAW_SEGMENT_SIZE {
+		length := CapInt64(raw_size-offset, EFS_RAW_SEGMENT_SIZE)
+		data := buf[:length]
+		for i := range data {
+			data[i] = 0
+		}
+
+		// Do not export zeros in place of the ciphertext.
+		_, err := reader.ReadAt(data, offset)
+		if err != nil && err != io.EOF {
+			self.err = err
+			return
+		}
+
+		// The segment header and the 26 byte encryption header.
+		self.write(uint32(20+26+length), efsUTF16("GURE"), uint64(0),
+			uint64(offset), uint32(26),
+			within(size, offset, length),
+			within(valid_data_length, offset, length),
+			uint16(0), uint8(efsDataUnitShift), uint8(efsChunkShift),
+			cluster_shift, uint8(0), data)
+	}
+}
+
+// Export the file in the format of ReadEncryptedFileRaw(): the $EFS
+// stream followed by the raw encrypted data streams.
+func ExportEncryptedFileRaw(ntfs *NTFSContext,
+	mft_entry *MFT_ENTRY, out io.Writer) error {
+	efs, err := readEFSStream(ntfs, mft_entry)
+	if err != nil {
+		return err
+	}
+
+	writer := &efsRawWriter{out: out}
+	writer.write(uint32(EFS_RAW_VERSION), efsUTF16("ROBS"), uint64(0))
+
+	writer.writeStreamHeader("::" + EFS_STREAM_NAME)
+	writer.write(uint32(20+len(efs)), efsUTF16("GURE"), uint64(0), efs)
+
+	for _, attr := range mft_entry.EnumerateAttributes(ntfs) {
+		if attr.Type().Value != ATTR_TYPE_DATA ||
+			(!attr.IsResident() && attr.Runlist_vcn_start() != 0) {
+			continue
+		}
+
+		name := attr.Name()
+		reader, size, valid_data_length, err := openEncryptedStream(
+			ntfs, mft_entry, attr.Attribute_id(), name)
+		if err != nil {
+			return err
+		}
+
+		writer.writeStreamHeader(":" + name + ":$DATA")
+		writer.writeDataSegments(reader, size, valid_data_length,
+			ntfs.ClusterSize)
+	}
+
+	return writer.err
+}
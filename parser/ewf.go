@@ -0,0 +1,877 @@
+// A reader for the Expert Witness Format (EWF) used by EnCase (E01)
+// and FTK Imager.
+//
+// An EWF image is split into segment files (image.E01, image.E02
+// ...). Each segment starts with a file header followed by a chain of
+// sections. Each section starts with a descriptor holding its type
+// and the offset of the next section:
+//
+//   - header/header2: zlib compressed case information.
+//   - volume/disk: The media geometry (chunk and sector sizes).
+//   - sectors: The chunks of media data.
+//   - table: The offsets of the chunks in the preceding sectors
+//     section. The high bit of each entry marks a zlib compressed
+//     chunk. Images written by EnCase 1-5 have no sectors section
+//     and store the chunks in the table section after the offsets.
+//   - hash/digest: The MD5/SHA1 of the media stored at acquisition.
+//   - next/done: The end of the segment or of the image.
+//
+// EWF2 (Ex01) segments have numeric section types and store the
+// section descriptor after the section data, so the sections are
+// found by walking backwards from the end of the segment. The sector
+// tables of EWF2 hold the size and flags of each chunk, and chunks
+// may be zlib or bzip2 compressed or filled with an 8 byte pattern.
+//
+// Reference: https://github.com/libyal/libewf/blob/main/documentation/
+// Expert%20Witness%20Compression%20Format%20(EWF).asciidoc
+
+package parser
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	EWF_SIGNATURE  = "EVF\x09\x0d\x0a\xff\x00"
+	EWF2_SIGNATURE = "EVF2\x0d\x0a\x81\x00"
+
+	EWF_FILE_HEADER_SIZE        = 13
+	EWF_SECTION_DESCRIPTOR_SIZE = 76
+	EWF_TABLE_HEADER_SIZE       = 24
+
+	EWF2_FILE_HEADER_SIZE        = 32
+	EWF2_SECTION_DESCRIPTOR_SIZE = 64
+	EWF2_TABLE_HEADER_SIZE       = 32
+	EWF2_TABLE_ENTRY_SIZE        = 16
+
+	// EWF2 section types.
+	EWF2_SECTION_DEVICE_INFORMATION = 0x01
+	EWF2_SECTION_CASE_DATA          = 0x02
+	EWF2_SECTION_SECTOR_DATA        = 0x03
+	EWF2_SECTION_SECTOR_TABLE       = 0x04
+	EWF2_SECTION_MD5_HASH           = 0x08
+	EWF2_SECTION_SHA1_HASH          = 0x09
+	EWF2_SECTION_NEXT               = 0x0d
+	EWF2_SECTION_DONE               = 0x0f
+
+	// EWF2 section data flags.
+	EWF2_SECTION_ENCRYPTED = 0x02
+
+	// EWF2 chunk flags.
+	EWF2_CHUNK_COMPRESSED   = 0x01
+	EWF2_CHUNK_CHECKSUM     = 0x02
+	EWF2_CHUNK_PATTERN_FILL = 0x04
+
+	// EWF2 compression methods.
+	EWF2_COMPRESSION_BZIP2 = 2
+
+	// The number of decompressed chunks to cache.
+	EWF_CHUNK_CACHE_SIZE = 64
+
+	// Limit the size of sections we read into memory.
+	MAX_EWF_SECTION_SIZE = 64 * 1024 * 1024
+)
+
+var (
+	notEWFError      = errors.New("Not an EWF file")
+	noEWFVolumeError = errors.New("EWF image has no volume section")
+)
+
+// The names of the header values.
+var ewfHeaderNames = map[string]string{
+	"a":  "Description",
+	"c":  "CaseNumber",
+	"n":  "EvidenceNumber",
+	"e":  "Examiner",
+	"t":  "Notes",
+	"av": "Version",
+	"ov": "Platform",
+	"m":  "AcquisitionDate",
+	"u":  "SystemDate",
+	"p":  "Password",
+	"r":  "Compression",
+	"md": "Model",
+	"sn": "SerialNumber",
+}
+
+// The names of the EWF2 device information and case data values.
+var ewf2HeaderNames = map[string]string{
+	"nm": "Description",
+	"cn": "CaseNumber",
+	"en": "EvidenceNumber",
+	"ex": "Examiner",
+	"nt": "Notes",
+	"av": "Version",
+	"os": "Platform",
+	"at": "AcquisitionDate",
+	"md": "Model",
+	"sn": "SerialNumber",
+	"lb": "Label",
+}
+
+type ewfChunk struct {
+	segment    int
+	offset     int64
+	size       int64
+	compressed bool
+
+	// Uncompressed chunks are followed by an Adler32 checksum.
+	checksum bool
+
+	// EWF2 chunks may be bzip2 compressed or filled with a pattern.
+	bzip2   bool
+	pattern []byte
+}
+
+type EWFReader struct {
+	mu sync.Mutex
+
+	segments []io.ReaderAt
+	closers  []io.Closer
+
+	chunks []*ewfChunk
+	cache  *LRU
+
+	ChunkSize   int64
+	SectorSize  int64
+	SectorCount int64
+	size        int64
+
+	// Case information from the header sections.
+	Header map[string]string
+
+	// Hashes of the media stored in the image (hex encoded).
+	StoredMD5  string
+	StoredSHA1 string
+}
+
+// Does the reader start with an EWF signature?
+func IsEWF(reader io.ReaderAt) bool {
+	signature := make([]byte, 8)
+	n, _ := reader.ReadAt(signature, 0)
+	return n == 8 && (string(signature) == EWF_SIGNATURE ||
+		string(signature) == EWF2_SIGNATURE)
+}
+
+// The extension of the nth segment: E01-E99 followed by EAA-EZZ,
+// FAA...
+func ewfSegmentExtension(first byte, n int) string {
+	if n < 100 {
+		return fmt.Sprintf("%c%02d", first, n)
+	}
+
+	base := byte('A')
+	if first >= 'a' && first <= 'z' {
+		base = 'a'
+	}
+
+	n -= 100
+	third := base + byte(n%26)
+	n /= 26
+	second := base + byte(n%26)
+	n /= 26
+	return string([]byte{first + byte(n), second, third})
+}
+
+// The extension of the nth EWF2 segment: Ex01-Ex99 followed by
+// ExAA-ExZZ.
+func ewf2SegmentExtension(prefix string, n int) string {
+	if n < 100 {
+		return fmt.Sprintf("%s%02d", prefix, n)
+	}
+
+	base := byte('A')
+	if prefix[0] >= 'a' && prefix[0] <= 'z' {
+		base = 'a'
+	}
+
+	n -= 100
+	return prefix + string([]byte{base + byte(n/26%26), base + byte(n%26)})
+}
+
+// Open the image from the path of its first segment. The following
+// segments are found next to it.
+func OpenEWF(path string) (*EWFReader, error) {
+	ext := filepath.Ext(path)
+
+	var segment_extension func(n int) string
+	switch len(ext) {
+	case 4:
+		segment_extension = func(n int) string {
+			return ewfSegmentExtension(ext[1], n)
+		}
+	case 5:
+		segment_extension = func(n int) string {
+			return ewf2SegmentExtension(ext[1:3], n)
+		}
+	default:
+		return nil, fmt.Errorf("%w: Unexpected extension %v", notEWFError, ext)
+	}
+	base := strings.TrimSuffix(path, ext)
+
+	segments := []io.ReaderAt{}
+	closers := []io.Closer{}
+	for i := 1; ; i++ {
+		fd, err := os.Open(base + "." + segment_extension(i))
+		if err != nil {
+			if i == 1 {
+				return nil, err
+			}
+			break
+		}
+		segments = append(segments, fd)
+		closers = append(closers, fd)
+	}
+
+	result, err := NewEWFReader(segments)
+	if err != nil {
+		for _, closer := range closers {
+			closer.Close()
+		}
+		return nil, err
+	}
+	result.closers = closers
+
+	return result, nil
+}
+
+// Build a reader from the segments in order.
+func NewEWFReader(segments []io.ReaderAt) (*EWFReader, error) {
+	cache, err := NewLRU(EWF_CHUNK_CACHE_SIZE, nil, "EWFChunks")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EWFReader{
+		segments: segments,
+		cache:    cache,
+		Header:   make(map[string]string),
+	}
+
+	for idx, segment := range segments {
+		done, err := result.parseSegment(idx, segment)
+		if err != nil {
+			return nil, fmt.Errorf("EWF segment %v: %w", idx+1, err)
+		}
+		if done {
+			break
+		}
+	}
+
+	if result.ChunkSize == 0 {
+		return nil, noEWFVolumeError
+	}
+
+	return result, nil
+}
+
+func (self *EWFReader) Close() {
+	for _, closer := range self.closers {
+		closer.Close()
+	}
+	self.closers = nil
+}
+
+func (self *EWFReader) Size() int64 {
+	return self.size
+}
+
+func readEWFSection(reader io.ReaderAt, offset, size int64) ([]byte, error) {
+	if size < 0 || size > MAX_EWF_SECTION_SIZE {
+		return nil, fmt.Errorf("Invalid EWF section size %v", size)
+	}
+	return readSector(reader, offset, size)
+}
+
+// Parse the sections of a segment file. Returns true when the last
+// segment was found.
+func (self *EWFReader) parseSegment(idx int, reader io.ReaderAt) (bool, error) {
+	header, err := readSector(reader, 0, EWF_FILE_HEADER_SIZE)
+	if err != nil {
+		return false, err
+	}
+
+	switch string(header[:8]) {
+	case EWF_SIGNATURE:
+	case EWF2_SIGNATURE:
+		return self.parseSegmentV2(idx, reader)
+	default:
+		return false, notEWFError
+	}
+
+	// The end of the last sectors section. The last chunk of a
+	// table extends to here.
+	sectors_end := int64(0)
+
+	offset := int64(EWF_FILE_HEADER_SIZE)
+	for {
+		descriptor, err := readSector(reader, offset, EWF_SECTION_DESCRIPTOR_SIZE)
+		if err != nil {
+			return false, err
+		}
+
+		if adler32.Checksum(descriptor[:72]) !=
+			binary.LittleEndian.Uint32(descriptor[72:]) {
+			return false, fmt.Errorf("Section checksum mismatch at %#x", offset)
+		}
+
+		section_type := string(bytes.TrimRight(descriptor[:16], "\x00"))
+		next := int64(binary.LittleEndian.Uint64(descriptor[16:]))
+		size := int64(binary.LittleEndian.Uint64(descriptor[24:]))
+		data_offset := offset + EWF_SECTION_DESCRIPTOR_SIZE
+		data_size := size - EWF_SECTION_DESCRIPTOR_SIZE
+
+		DebugPrint(DEBUG_NTFS, "EWF segment %v: Section %v at %#x (%v bytes)\n",
+			idx+1, section_type, offset, size)
+
+		switch section_type {
+		case "done":
+			return true, nil
+
+		case "next":
+			return false, nil
+
+		case "header", "header2":
+			data, err := readEWFSection(reader, data_offset, data_size)
+			if err != nil {
+				return false, err
+			}
+			self.parseHeader(data)
+
+		case "volume", "disk":
+			data, err := readEWFSection(reader, data_offset, data_size)
+			if err != nil {
+				return false, err
+			}
+			err = self.parseVolume(data)
+			if err != nil {
+				return false, err
+			}
+
+		case "sectors":
+			sectors_end = offset + size
+
+		case "table":
+			data, err := readEWFSection(reader, data_offset, data_size)
+			if err != nil {
+				return false, err
+			}
+
+			// EnCase 1-5 images have no sectors section - the
+			// chunks follow the offsets inside the table section.
+			end := sectors_end
+			if end <= 0 {
+				end = offset + size
+			}
+			err = self.parseTable(idx, data, end)
+			if err != nil {
+				return false, err
+			}
+
+		case "hash":
+			data, err := readEWFSection(reader, data_offset, data_size)
+			if err == nil && len(data) >= 16 {
+				self.StoredMD5 = hex.EncodeToString(data[:16])
+			}
+
+		case "digest":
+			data, err := readEWFSection(reader, data_offset, data_size)
+			if err == nil && len(data) >= 36 {
+				self.StoredMD5 = hex.EncodeToString(data[:16])
+				self.StoredSHA1 = hex.EncodeToString(data[16:36])
+			}
+		}
+
+		// The last section may point to itself.
+		if next <= offset {
+			return false, nil
+		}
+		offset = next
+	}
+}
+
+// The header is a zlib compressed table of tab separated values. The
+// header2 section is the same in UTF16.
+func (self *EWFReader) parseHeader(data []byte) {
+	self.addHeaderValues(parseEWFValues(data), ewfHeaderNames)
+}
+
+func (self *EWFReader) addHeaderValues(
+	values map[string]string, names map[string]string) {
+	for key, value := range values {
+		name, pres := names[key]
+		if !pres {
+			name = key
+		}
+
+		// Prefer the values of the first (header2) section.
+		_, pres = self.Header[name]
+		if !pres {
+			self.Header[name] = value
+		}
+	}
+}
+
+// Decompress the table of values and return them by key.
+func parseEWFValues(data []byte) map[string]string {
+	result := make(map[string]string)
+
+	zlib_reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return result
+	}
+	defer zlib_reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(zlib_reader, MAX_EWF_SECTION_SIZE))
+	if err != nil && len(decompressed) == 0 {
+		return result
+	}
+
+	// UTF16 text starts with a little endian byte order mark.
+	text := string(decompressed)
+	if len(decompressed) >= 2 &&
+		decompressed[0] == 0xff && decompressed[1] == 0xfe {
+		text = UTF16BytesToUTF8(decompressed[2:], binary.LittleEndian)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+	for i := 0; i+2 < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "main" {
+			continue
+		}
+
+		keys := strings.Split(lines[i+1], "\t")
+		values := strings.Split(lines[i+2], "\t")
+		for j, key := range keys {
+			if j >= len(values) || key == "" {
+				continue
+			}
+			result[key] = values[j]
+		}
+		break
+	}
+
+	return result
+}
+
+func (self *EWFReader) parseVolume(data []byte) error {
+	if len(data) < 20 {
+		return noEWFVolumeError
+	}
+
+	sectors_per_chunk := int64(binary.LittleEndian.Uint32(data[8:]))
+	self.SectorSize = int64(binary.LittleEndian.Uint32(data[12:]))
+
+	// The SMART (s01) volume section is 94 bytes and only has a 32
+	// bit sector count.
+	if len(data) > 94 {
+		self.SectorCount = int64(binary.LittleEndian.Uint64(data[16:]))
+	} else {
+		self.SectorCount = int64(binary.LittleEndian.Uint32(data[16:]))
+	}
+
+	return self.setGeometry(sectors_per_chunk)
+}
+
+func (self *EWFReader) setGeometry(sectors_per_chunk int64) error {
+	self.ChunkSize = sectors_per_chunk * self.SectorSize
+	if self.ChunkSize <= 0 || self.ChunkSize > MAX_EWF_SECTION_SIZE {
+		return fmt.Errorf("Invalid EWF chunk size %v", self.ChunkSize)
+	}
+
+	self.size = self.SectorCount * self.SectorSize
+	return nil
+}
+
+// Parse the chunk offsets of a table. Each chunk extends to the next
+// one while the last one extends to the end of the sectors section
+// (or of the table section when there is no sectors section).
+func (self *EWFReader) parseTable(segment int, data []byte, end int64) error {
+	if len(data) < EWF_TABLE_HEADER_SIZE {
+		return errors.New("EWF table too short")
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[0:]))
+	base_offset := int64(binary.LittleEndian.Uint64(data[8:]))
+	entries := data[EWF_TABLE_HEADER_SIZE:]
+	if count*4 > len(entries) {
+		return fmt.Errorf("EWF table too short for %v entries", count)
+	}
+
+	chunks := make([]*ewfChunk, 0, count)
+	for i := 0; i < count; i++ {
+		entry := binary.LittleEndian.Uint32(entries[4*i:])
+		compressed := entry&0x80000000 != 0
+		chunks = append(chunks, &ewfChunk{
+			segment:    segment,
+			offset:     base_offset + int64(entry&0x7fffffff),
+			compressed: compressed,
+			checksum:   !compressed,
+		})
+	}
+
+	for i, chunk := range chunks {
+		chunk_end := end
+		if i+1 < len(chunks) {
+			chunk_end = chunks[i+1].offset
+		}
+		chunk.size = chunk_end - chunk.offset
+		if chunk.size <= 0 || chunk.size > 2*self.ChunkSize+4 {
+			return fmt.Errorf("Invalid EWF chunk size %v at %#x",
+				chunk.size, chunk.offset)
+		}
+	}
+
+	self.chunks = append(self.chunks, chunks...)
+	return nil
+}
+
+// The size of the segment. EWF2 sections are found from the end of
+// the segment.
+func ewfSegmentSize(reader io.ReaderAt) (int64, error) {
+	switch t := reader.(type) {
+	case interface{ Size() int64 }:
+		return t.Size(), nil
+
+	case interface{ Stat() (os.FileInfo, error) }:
+		stat, err := t.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	}
+
+	return 0, errors.New("Unable to determine the size of the EWF2 segment")
+}
+
+type ewf2Section struct {
+	section_type uint32
+	data_offset  int64
+	data_size    int64
+}
+
+// Parse the sections of an EWF2 segment file. The descriptor of each
+// section follows its data and points to the descriptor of the
+// previous section, so we walk the chain backwards from the end of
+// the segment and then process the sections in order.
+func (self *EWFReader) parseSegmentV2(idx int, reader io.ReaderAt) (bool, error) {
+	header, err := readSector(reader, 0, EWF2_FILE_HEADER_SIZE)
+	if err != nil {
+		return false, err
+	}
+	compression_method := binary.LittleEndian.Uint16(header[10:])
+
+	segment_size, err := ewfSegmentSize(reader)
+	if err != nil {
+		return false, err
+	}
+
+	sections := []*ewf2Section{}
+	offset := segment_size - EWF2_SECTION_DESCRIPTOR_SIZE
+	for offset >= EWF2_FILE_HEADER_SIZE {
+		descriptor, err := readSector(reader, offset, EWF2_SECTION_DESCRIPTOR_SIZE)
+		if err != nil {
+			return false, err
+		}
+
+		if adler32.Checksum(descriptor[:60]) !=
+			binary.LittleEndian.Uint32(descriptor[60:]) {
+			return false, fmt.Errorf("Section checksum mismatch at %#x", offset)
+		}
+
+		section_type := binary.LittleEndian.Uint32(descriptor[0:])
+		flags := binary.LittleEndian.Uint32(descriptor[4:])
+		previous := int64(binary.LittleEndian.Uint64(descriptor[8:]))
+		data_size := int64(binary.LittleEndian.Uint64(descriptor[16:]))
+		padding_size := int64(binary.LittleEndian.Uint32(descriptor[28:]))
+
+		DebugPrint(DEBUG_NTFS, "EWF segment %v: Section %#x at %#x (%v bytes)\n",
+			idx+1, section_type, offset, data_size)
+
+		if flags&EWF2_SECTION_ENCRYPTED != 0 {
+			return false, fmt.Errorf("Encrypted EWF2 section at %#x", offset)
+		}
+
+		if data_size < padding_size || data_size > offset-EWF2_FILE_HEADER_SIZE {
+			return false, fmt.Errorf("Invalid EWF2 section size %v at %#x",
+				data_size, offset)
+		}
+
+		sections = append(sections, &ewf2Section{
+			section_type: section_type,
+			data_offset:  offset - data_size,
+			data_size:    data_size - padding_size,
+		})
+
+		// The first section has no previous section.
+		if previous >= offset || previous < EWF2_FILE_HEADER_SIZE {
+			break
+		}
+		offset = previous
+	}
+
+	// The values of the device information and case data sections.
+	values := make(map[string]string)
+	done := false
+
+	for i := len(sections) - 1; i >= 0; i-- {
+		section := sections[i]
+		switch section.section_type {
+		case EWF2_SECTION_DEVICE_INFORMATION, EWF2_SECTION_CASE_DATA:
+			data, err := readEWFSection(reader, section.data_offset, section.data_size)
+			if err != nil {
+				return false, err
+			}
+			for k, v := range parseEWFValues(data) {
+				values[k] = v
+			}
+
+		case EWF2_SECTION_SECTOR_TABLE:
+			data, err := readEWFSection(reader, section.data_offset, section.data_size)
+			if err != nil {
+				return false, err
+			}
+			err = self.parseTableV2(idx, data, compression_method)
+			if err != nil {
+				return false, err
+			}
+
+		case EWF2_SECTION_MD5_HASH:
+			data, err := readEWFSection(reader, section.data_offset, section.data_size)
+			if err == nil && len(data) >= 16 {
+				self.StoredMD5 = hex.EncodeToString(data[:16])
+			}
+
+		case EWF2_SECTION_SHA1_HASH:
+			data, err := readEWFSection(reader, section.data_offset, section.data_size)
+			if err == nil && len(data) >= 20 {
+				self.StoredSHA1 = hex.EncodeToString(data[:20])
+			}
+
+		case EWF2_SECTION_DONE:
+			done = true
+		}
+	}
+
+	// Only the first segment carries the device information.
+	if len(values) > 0 {
+		self.addHeaderValues(values, ewf2HeaderNames)
+
+		sectors_per_chunk := ewfValueInt(values, "sb", 64)
+		self.SectorSize = ewfValueInt(values, "bp", 512)
+		self.SectorCount = ewfValueInt(values, "ts",
+			ewfValueInt(values, "tb", 0)*sectors_per_chunk)
+		err := self.setGeometry(sectors_per_chunk)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return done, nil
+}
+
+func ewfValueInt(values map[string]string, key string, default_value int64) int64 {
+	value, err := strconv.ParseInt(strings.TrimSpace(values[key]), 10, 64)
+	if err != nil || value <= 0 {
+		return default_value
+	}
+	return value
+}
+
+// Parse an EWF2 sector table. Unlike EWF1 each entry holds the
+// size and flags of its chunk. Pattern filled chunks store the
+// pattern in place of the offset.
+func (self *EWFReader) parseTableV2(
+	segment int, data []byte, compression_method uint16) error {
+	if len(data) < EWF2_TABLE_HEADER_SIZE {
+		return errors.New("EWF2 table too short")
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[8:]))
+	entries := data[EWF2_TABLE_HEADER_SIZE:]
+	if count < 0 || count > len(entries)/EWF2_TABLE_ENTRY_SIZE {
+		return fmt.Errorf("EWF2 table too short for %v entries", count)
+	}
+
+	for i := 0; i < count; i++ {
+		entry := entries[i*EWF2_TABLE_ENTRY_SIZE:]
+		flags := binary.LittleEndian.Uint32(entry[12:])
+		chunk := &ewfChunk{
+			segment:    segment,
+			offset:     int64(binary.LittleEndian.Uint64(entry[0:])),
+			size:       int64(binary.LittleEndian.Uint32(entry[8:])),
+			compressed: flags&EWF2_CHUNK_COMPRESSED != 0,
+			checksum:   flags&EWF2_CHUNK_CHECKSUM != 0,
+			bzip2:      compression_method == EWF2_COMPRESSION_BZIP2,
+		}
+
+		if flags&EWF2_CHUNK_PATTERN_FILL != 0 {
+			chunk.pattern = append([]byte{}, entry[0:8]...)
+
+		} else if chunk.size <= 0 || chunk.size > MAX_EWF_SECTION_SIZE {
+			return fmt.Errorf("Invalid EWF chunk size %v at %#x",
+				chunk.size, chunk.offset)
+		}
+
+		self.chunks = append(self.chunks, chunk)
+	}
+
+	return nil
+}
+
+// Get the decompressed data of the chunk.
+func (self *EWFReader) getChunk(idx int) ([]byte, error) {
+	cached, pres := self.cache.Get(idx)
+	if pres {
+		return cached.([]byte), nil
+	}
+
+	chunk := self.chunks[idx]
+	if chunk.pattern != nil {
+		result := bytes.Repeat(chunk.pattern,
+			int(self.ChunkSize)/len(chunk.pattern))
+		self.cache.Add(idx, result)
+		return result, nil
+	}
+
+	data, err := readSector(self.segments[chunk.segment], chunk.offset, chunk.size)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []byte
+	switch {
+	case chunk.compressed && chunk.bzip2:
+		result, err = io.ReadAll(io.LimitReader(
+			bzip2.NewReader(bytes.NewReader(data)), self.ChunkSize))
+		if err != nil {
+			return nil, err
+		}
+
+	case chunk.compressed:
+		zlib_reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		result, err = io.ReadAll(io.LimitReader(zlib_reader, self.ChunkSize))
+		zlib_reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+	case chunk.checksum:
+		if len(data) <= 4 {
+			return nil, fmt.Errorf("EWF chunk %v too short", idx)
+		}
+		result = data[:len(data)-4]
+
+	default:
+		result = data
+	}
+
+	self.cache.Add(idx, result)
+	return result, nil
+}
+
+func (self *EWFReader) ReadAt(buf []byte, offset int64) (int, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	buf_idx := 0
+	for buf_idx < len(buf) {
+		if offset < 0 || offset >= self.size {
+			return buf_idx, io.EOF
+		}
+
+		chunk_idx := int(offset / self.ChunkSize)
+		if chunk_idx >= len(self.chunks) {
+			return buf_idx, io.EOF
+		}
+
+		data, err := self.getChunk(chunk_idx)
+		if err != nil {
+			return buf_idx, err
+		}
+
+		chunk_offset := offset % self.ChunkSize
+		if chunk_offset >= int64(len(data)) {
+			return buf_idx, io.EOF
+		}
+
+		available := data[chunk_offset:]
+		if int64(len(available)) > self.size-offset {
+			available = available[:self.size-offset]
+		}
+
+		n := copy(buf[buf_idx:], available)
+		buf_idx += n
+		offset += int64(n)
+	}
+
+	return buf_idx, nil
+}
+
+type EWFVerification struct {
+	StoredMD5    string
+	ComputedMD5  string
+	StoredSHA1   string
+	ComputedSHA1 string
+
+	// Set when all stored hashes match the media.
+	Verified bool
+}
+
+// Hash the media and compare with the hashes stored in the image.
+func (self *EWFReader) Verify(ctx context.Context) (*EWFVerification, error) {
+	md5_hash := md5.New()
+	sha1_hash := sha1.New()
+
+	buffer := make([]byte, 16*self.ChunkSize)
+	for offset := int64(0); offset < self.size; {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		n, err := self.ReadAt(buffer, offset)
+		if n == 0 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		md5_hash.Write(buffer[:n])
+		sha1_hash.Write(buffer[:n])
+		offset += int64(n)
+	}
+
+	result := &EWFVerification{
+		StoredMD5:    self.StoredMD5,
+		ComputedMD5:  hex.EncodeToString(md5_hash.Sum(nil)),
+		StoredSHA1:   self.StoredSHA1,
+		ComputedSHA1: hex.EncodeToString(sha1_hash.Sum(nil)),
+	}
+
+	result.Verified = (result.StoredMD5 != "" || result.StoredSHA1 != "") &&
+		(result.StoredMD5 == "" || result.StoredMD5 == result.ComputedMD5) &&
+		(result.StoredSHA1 == "" || result.StoredSHA1 == result.ComputedSHA1)
+
+	return result, nil
+}
@@ -0,0 +1,392 @@
+package parser
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"testing"
+	"unicode/utf16"
+)
+
+type testEWFSegment struct {
+	buf []byte
+}
+
+func newTestEWFSegment(number uint16) *testEWFSegment {
+	header := append([]byte(EWF_SIGNATURE), 1, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(header[9:], number)
+	return &testEWFSegment{buf: header}
+}
+
+// The offset of the data of the next section.
+func (self *testEWFSegment) DataOffset() int64 {
+	return int64(len(self.buf) + EWF_SECTION_DESCRIPTOR_SIZE)
+}
+
+func (self *testEWFSegment) AddSection(section_type string, data []byte) {
+	offset := len(self.buf)
+	size := EWF_SECTION_DESCRIPTOR_SIZE + len(data)
+	next := offset + size
+	if section_type == "next" || section_type == "done" {
+		next = offset
+	}
+
+	descriptor := make([]byte, EWF_SECTION_DESCRIPTOR_SIZE)
+	copy(descriptor, section_type)
+	binary.LittleEndian.PutUint64(descriptor[16:], uint64(next))
+	binary.LittleEndian.PutUint64(descriptor[24:], uint64(size))
+	binary.LittleEndian.PutUint32(descriptor[72:],
+		adler32.Checksum(descriptor[:72]))
+
+	self.buf = append(self.buf, descriptor...)
+	self.buf = append(self.buf, data...)
+}
+
+// Add a sectors section with the chunks followed by their table.
+func (self *testEWFSegment) AddChunks(chunks [][]byte, compressed bool) {
+	base := self.DataOffset()
+	table := make([]byte, EWF_TABLE_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(table, uint32(len(chunks)))
+
+	sectors := []byte{}
+	for _, chunk := range chunks {
+		entry := uint32(base + int64(len(sectors)))
+		if compressed {
+			entry |= 0x80000000
+			b := &bytes.Buffer{}
+			writer := zlib.NewWriter(b)
+			writer.Write(chunk)
+			writer.Close()
+			sectors = append(sectors, b.Bytes()...)
+		} else {
+			checksum := make([]byte, 4)
+			binary.LittleEndian.PutUint32(checksum, adler32.Checksum(chunk))
+			sectors = append(sectors, chunk...)
+			sectors = append(sectors, checksum...)
+		}
+		entry_bytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(entry_bytes, entry)
+		table = append(table, entry_bytes...)
+	}
+
+	self.AddSection("sectors", sectors)
+	self.AddSection("table", append(table, 0, 0, 0, 0))
+}
+
+// Add a table section with the chunks stored after the offsets, as
+// written by EnCase 1-5.
+func (self *testEWFSegment) AddTableWithChunks(chunks [][]byte) {
+	table := make([]byte, EWF_TABLE_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(table, uint32(len(chunks)))
+
+	// The chunk data starts after the entries and their checksum.
+	base := self.DataOffset() + int64(len(table)+4*len(chunks)+4)
+	sectors := []byte{}
+	for _, chunk := range chunks {
+		entry_bytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(entry_bytes,
+			uint32(base+int64(len(sectors)))|0x80000000)
+		table = append(table, entry_bytes...)
+
+		b := &bytes.Buffer{}
+		writer := zlib.NewWriter(b)
+		writer.Write(chunk)
+		writer.Close()
+		sectors = append(sectors, b.Bytes()...)
+	}
+
+	table = append(table, 0, 0, 0, 0)
+	self.AddSection("table", append(table, sectors...))
+}
+
+func testEWFImage(media []byte) [][]byte {
+	chunk_size := 1024
+
+	header := &bytes.Buffer{}
+	writer := zlib.NewWriter(header)
+	writer.Write([]byte("1\nmain\nc\tn\ta\te\n42\t1\tTest disk\tBob\n\n"))
+	writer.Close()
+
+	volume := make([]byte, 1052)
+	binary.LittleEndian.PutUint32(volume[4:], 3)
+	binary.LittleEndian.PutUint32(volume[8:], 2)
+	binary.LittleEndian.PutUint32(volume[12:], 512)
+	binary.LittleEndian.PutUint64(volume[16:], uint64(len(media)/512))
+
+	first := newTestEWFSegment(1)
+	first.AddSection("header", header.Bytes())
+	first.AddSection("volume", volume)
+	first.AddChunks([][]byte{media[:chunk_size]}, true)
+	first.AddChunks([][]byte{media[chunk_size : 2*chunk_size]}, false)
+	first.AddSection("next", nil)
+
+	md5_sum := md5.Sum(media)
+	sha1_sum := sha1.Sum(media)
+	digest := append(append(md5_sum[:], sha1_sum[:]...), make([]byte, 44)...)
+
+	second := newTestEWFSegment(2)
+	second.AddChunks([][]byte{media[2*chunk_size:]}, true)
+	second.AddSection("digest", digest)
+	second.AddSection("done", nil)
+
+	return [][]byte{first.buf, second.buf}
+}
+
+func TestEWFReader(t *testing.T) {
+	media := make([]byte, 5*512)
+	for i := range media {
+		media[i] = byte(i * 7)
+	}
+
+	segments := []io.ReaderAt{}
+	for _, segment := range testEWFImage(media) {
+		segments = append(segments, bytes.NewReader(segment))
+	}
+
+	if !IsEWF(segments[0]) {
+		t.Fatalf("Expected an EWF signature")
+	}
+
+	reader, err := NewEWFReader(segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Size() != int64(len(media)) || reader.ChunkSize != 1024 {
+		t.Fatalf("Unexpected geometry %v %v", reader.Size(), reader.ChunkSize)
+	}
+
+	if reader.Header["CaseNumber"] != "42" || reader.Header["Examiner"] != "Bob" {
+		t.Fatalf("Unexpected header %v", reader.Header)
+	}
+
+	// Read across all three chunks.
+	buf := make([]byte, len(media)-100)
+	n, err := reader.ReadAt(buf, 50)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, media[50:len(media)-50]) {
+		t.Fatalf("Unexpected read %v %v", n, err)
+	}
+
+	// Reading past the end
+	n, err = reader.ReadAt(buf, int64(len(media)-10))
+	if n != 10 || err != io.EOF {
+		t.Fatalf("Unexpected read %v %v", n, err)
+	}
+
+	verification, err := reader.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Verified {
+		t.Fatalf("Verification failed %+v", verification)
+	}
+}
+
+func TestEWFReaderTableChunks(t *testing.T) {
+	media := make([]byte, 4*512)
+	for i := range media {
+		media[i] = byte(i * 13)
+	}
+
+	volume := make([]byte, 1052)
+	binary.LittleEndian.PutUint32(volume[8:], 2)
+	binary.LittleEndian.PutUint32(volume[12:], 512)
+	binary.LittleEndian.PutUint64(volume[16:], uint64(len(media)/512))
+
+	segment := newTestEWFSegment(1)
+	segment.AddSection("volume", volume)
+	segment.AddTableWithChunks([][]byte{media[:1024], media[1024:]})
+	segment.AddSection("done", nil)
+
+	reader, err := NewEWFReader([]io.ReaderAt{bytes.NewReader(segment.buf)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(media))
+	n, err := reader.ReadAt(buf, 0)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, media) {
+		t.Fatalf("Unexpected read %v %v", n, err)
+	}
+}
+
+type testEWF2Segment struct {
+	buf      []byte
+	previous int
+}
+
+func newTestEWF2Segment(number uint32) *testEWF2Segment {
+	header := make([]byte, EWF2_FILE_HEADER_SIZE)
+	copy(header, EWF2_SIGNATURE)
+	header[8] = 2
+	header[9] = 1
+	binary.LittleEndian.PutUint16(header[10:], 1)
+	binary.LittleEndian.PutUint32(header[12:], number)
+	return &testEWF2Segment{buf: header}
+}
+
+// EWF2 section data is followed by its descriptor.
+func (self *testEWF2Segment) AddSection(section_type uint32, data []byte) {
+	padding := (16 - len(data)%16) % 16
+	data_size := len(data) + padding
+
+	self.buf = append(self.buf, data...)
+	self.buf = append(self.buf, make([]byte, padding)...)
+
+	offset := len(self.buf)
+	descriptor := make([]byte, EWF2_SECTION_DESCRIPTOR_SIZE)
+	binary.LittleEndian.PutUint32(descriptor[0:], section_type)
+	binary.LittleEndian.PutUint64(descriptor[8:], uint64(self.previous))
+	binary.LittleEndian.PutUint64(descriptor[16:], uint64(data_size))
+	binary.LittleEndian.PutUint32(descriptor[24:], EWF2_SECTION_DESCRIPTOR_SIZE)
+	binary.LittleEndian.PutUint32(descriptor[28:], uint32(padding))
+	binary.LittleEndian.PutUint32(descriptor[60:],
+		adler32.Checksum(descriptor[:60]))
+
+	self.buf = append(self.buf, descriptor...)
+	self.previous = offset
+}
+
+// Add a sector data section followed by its table. A nil chunk is
+// stored as a pattern fill of zeros.
+func (self *testEWF2Segment) AddChunks(first_chunk uint64, chunks [][]byte) {
+	table := make([]byte, EWF2_TABLE_HEADER_SIZE)
+	binary.LittleEndian.PutUint64(table, first_chunk)
+	binary.LittleEndian.PutUint32(table[8:], uint32(len(chunks)))
+
+	base := len(self.buf)
+	sectors := []byte{}
+	for i, chunk := range chunks {
+		entry := make([]byte, EWF2_TABLE_ENTRY_SIZE)
+		switch {
+		case chunk == nil:
+			binary.LittleEndian.PutUint32(entry[12:], EWF2_CHUNK_PATTERN_FILL)
+
+		// Alternate between compressed and checksummed chunks.
+		case i%2 == 0:
+			b := &bytes.Buffer{}
+			writer := zlib.NewWriter(b)
+			writer.Write(chunk)
+			writer.Close()
+
+			binary.LittleEndian.PutUint64(entry, uint64(base+len(sectors)))
+			binary.LittleEndian.PutUint32(entry[8:], uint32(b.Len()))
+			binary.LittleEndian.PutUint32(entry[12:], EWF2_CHUNK_COMPRESSED)
+			sectors = append(sectors, b.Bytes()...)
+
+		default:
+			checksum := make([]byte, 4)
+			binary.LittleEndian.PutUint32(checksum, adler32.Checksum(chunk))
+
+			binary.LittleEndian.PutUint64(entry, uint64(base+len(sectors)))
+			binary.LittleEndian.PutUint32(entry[8:], uint32(len(chunk)+4))
+			binary.LittleEndian.PutUint32(entry[12:], EWF2_CHUNK_CHECKSUM)
+			sectors = append(sectors, chunk...)
+			sectors = append(sectors, checksum...)
+		}
+		table = append(table, entry...)
+	}
+
+	self.AddSection(EWF2_SECTION_SECTOR_DATA, sectors)
+	self.AddSection(EWF2_SECTION_SECTOR_TABLE, table)
+}
+
+// EWF2 values are zlib compressed UTF16 text.
+func testEWF2Values(text string) []byte {
+	encoded := []byte{0xff, 0xfe}
+	for _, c := range utf16.Encode([]rune(text)) {
+		encoded = append(encoded, byte(c), byte(c>>8))
+	}
+
+	b := &bytes.Buffer{}
+	writer := zlib.NewWriter(b)
+	writer.Write(encoded)
+	writer.Close()
+	return b.Bytes()
+}
+
+func TestEWF2Reader(t *testing.T) {
+	media := make([]byte, 8*512)
+	for i := range media[:6*512] {
+		media[i] = byte(i * 11)
+	}
+
+	first := newTestEWF2Segment(1)
+	first.AddSection(EWF2_SECTION_DEVICE_INFORMATION, testEWF2Values(
+		"1\nmain\nsn\tmd\tts\tbp\n1234\tTest Model\t8\t512\n\n"))
+	first.AddSection(EWF2_SECTION_CASE_DATA, testEWF2Values(
+		"1\nmain\ncn\tex\tsb\n42\tBob\t2\n\n"))
+	first.AddChunks(0, [][]byte{media[:1024], media[1024:2048]})
+	first.AddSection(EWF2_SECTION_NEXT, nil)
+
+	md5_sum := md5.Sum(media)
+	sha1_sum := sha1.Sum(media)
+
+	second := newTestEWF2Segment(2)
+	second.AddChunks(2, [][]byte{media[2048:3072], nil})
+	second.AddSection(EWF2_SECTION_MD5_HASH, md5_sum[:])
+	second.AddSection(EWF2_SECTION_SHA1_HASH, sha1_sum[:])
+	second.AddSection(EWF2_SECTION_DONE, nil)
+
+	segments := []io.ReaderAt{
+		bytes.NewReader(first.buf), bytes.NewReader(second.buf)}
+
+	if !IsEWF(segments[0]) {
+		t.Fatalf("Expected an EWF signature")
+	}
+
+	reader, err := NewEWFReader(segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Size() != int64(len(media)) || reader.ChunkSize != 1024 {
+		t.Fatalf("Unexpected geometry %v %v", reader.Size(), reader.ChunkSize)
+	}
+
+	if reader.Header["CaseNumber"] != "42" || reader.Header["Examiner"] != "Bob" ||
+		reader.Header["SerialNumber"] != "1234" {
+		t.Fatalf("Unexpected header %v", reader.Header)
+	}
+
+	buf := make([]byte, len(media))
+	n, err := reader.ReadAt(buf, 0)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, media) {
+		t.Fatalf("Unexpected read %v %v", n, err)
+	}
+
+	verification, err := reader.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Verified {
+		t.Fatalf("Verification failed %+v", verification)
+	}
+}
+
+func TestEWFSegmentExtension(t *testing.T) {
+	for n, expected := range map[int]string{
+		1: "E01", 99: "E99", 100: "EAA", 126: "EBA", 776: "FAA"} {
+		if ewfSegmentExtension('E', n) != expected {
+			t.Fatalf("Segment %v: expected %v got %v",
+				n, expected, ewfSegmentExtension('E', n))
+		}
+	}
+
+	if ewfSegmentExtension('e', 101) != "eab" {
+		t.Fatalf("Unexpected extension %v", ewfSegmentExtension('e', 101))
+	}
+
+	for n, expected := range map[int]string{
+		1: "Ex01", 99: "Ex99", 100: "ExAA", 127: "ExBB"} {
+		if ewf2SegmentExtension("Ex", n) != expected {
+			t.Fatalf("Segment %v: expected %v got %v",
+				n, expected, ewf2SegmentExtension("Ex", n))
+		}
+	}
+}
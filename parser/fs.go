@@ -0,0 +1,321 @@
+// An io/fs adapter over the NTFS context. This allows the NTFS
+// filesystem to be passed to any code that consumes the standard
+// library's fs.FS interfaces (e.g. fs.WalkDir, fs.Glob, http.FS).
+
+package parser
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NTFSFS implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS.
+//
+// Paths follow the io/fs conventions (slash separated, unrooted, "."
+// is the root directory). Alternate data streams may be accessed
+// using the same "path:stream" syntax that GetDataForPath() accepts.
+type NTFSFS struct {
+	ntfs *NTFSContext
+}
+
+func NewNTFSFS(ntfs *NTFSContext) *NTFSFS {
+	return &NTFSFS{ntfs: ntfs}
+}
+
+// Resolve the name to an MFT entry and the requested stream name.
+func (self *NTFSFS) resolve(op, name string) (*MFT_ENTRY, string, error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	root, err := self.ntfs.GetMFT(5)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if name == "." {
+		return root, "", nil
+	}
+
+	stream_name := ""
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) > 1 {
+		stream_name = parts[1]
+	}
+
+	mft_entry, err := root.Open(self.ntfs, parts[0])
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return mft_entry, stream_name, nil
+}
+
+// Find the FileInfo which describes the name in the MFT entry. An
+// MFT entry may be known by multiple names and have multiple
+// streams, so we pick the one that best matches the requested name.
+func (self *NTFSFS) stat(op, name string) (*ntfsFileInfo, *MFT_ENTRY, error) {
+	mft_entry, stream_name, err := self.resolve(op, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	infos := Stat(self.ntfs, mft_entry)
+	if len(infos) == 0 {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	base := path.Base(name)
+	var selected *FileInfo
+
+	for _, info := range infos {
+		// Skip infos for other streams.
+		if streamOfName(info.Name) != stream_name {
+			continue
+		}
+
+		if selected == nil {
+			selected = info
+		}
+
+		if strings.EqualFold(info.Name, base) {
+			selected = info
+			break
+		}
+	}
+
+	if selected == nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &ntfsFileInfo{info: selected, name: base}, mft_entry, nil
+}
+
+func streamOfName(name string) string {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) > 1 {
+		return parts[1]
+	}
+	return ""
+}
+
+func (self *NTFSFS) Open(name string) (fs.File, error) {
+	info, mft_entry, err := self.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &ntfsDir{fs: self, name: name, info: info,
+			mft_entry: mft_entry}, nil
+	}
+
+	stream_name := streamOfName(name)
+	if stream_name == "" {
+		stream_name = WILDCARD_STREAM_NAME
+	}
+
+	reader, err := OpenStream(self.ntfs, mft_entry,
+		ATTR_TYPE_DATA, WILDCARD_STREAM_ID, stream_name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &ntfsFile{
+		SectionReader: io.NewSectionReader(reader, 0, info.Size()),
+		info:          info,
+		reader:        reader,
+	}, nil
+}
+
+func (self *NTFSFS) Stat(name string) (fs.FileInfo, error) {
+	info, _, err := self.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (self *NTFSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	mft_entry, stream_name, err := self.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream_name != "" || !mft_entry.IsDir(self.ntfs) {
+		return nil, &fs.PathError{Op: "readdir", Path: name,
+			Err: errors.New("not a directory")}
+	}
+
+	return self.readDir(mft_entry), nil
+}
+
+func (self *NTFSFS) readDir(mft_entry *MFT_ENTRY) []fs.DirEntry {
+	seen := make(map[string]bool)
+	result := []fs.DirEntry{}
+
+	for _, info := range ListDir(self.ntfs, mft_entry) {
+		// Alternate data streams are not directory entries in
+		// their own right - they are accessible via the
+		// path:stream syntax.
+		if info.Name == "." || info.Name == "" ||
+			strings.Contains(info.Name, ":") {
+			continue
+		}
+
+		key := strings.ToLower(info.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		result = append(result, fs.FileInfoToDirEntry(
+			&ntfsFileInfo{info: info, name: info.Name}))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+
+	return result
+}
+
+func (self *NTFSFS) ReadFile(name string) ([]byte, error) {
+	fd, err := self.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	file, ok := fd.(*ntfsFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name,
+			Err: errors.New("is a directory")}
+	}
+
+	return io.ReadAll(file)
+}
+
+// An fs.FileInfo that wraps the FileInfo model.
+type ntfsFileInfo struct {
+	info *FileInfo
+	name string
+}
+
+func (self *ntfsFileInfo) Name() string {
+	return self.name
+}
+
+func (self *ntfsFileInfo) Size() int64 {
+	if self.info.IsDir {
+		return 0
+	}
+	return self.info.Size
+}
+
+func (self *ntfsFileInfo) Mode() fs.FileMode {
+	if self.info.IsDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (self *ntfsFileInfo) ModTime() time.Time {
+	return self.info.Mtime
+}
+
+func (self *ntfsFileInfo) IsDir() bool {
+	return self.info.IsDir
+}
+
+// Sys returns the underlying *FileInfo. The MFTId field may be used
+// with ParseMFTId() and ModelMFTEntry() to get the full model.
+func (self *ntfsFileInfo) Sys() interface{} {
+	return self.info
+}
+
+type ntfsFile struct {
+	*io.SectionReader
+	info   *ntfsFileInfo
+	reader RangeReaderAt
+}
+
+// The underlying RangeReaderAt returns io.EOF for a zero length
+// read, which breaks the io.Reader contract.
+func (self *ntfsFile) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	return self.SectionReader.Read(buf)
+}
+
+func (self *ntfsFile) ReadAt(buf []byte, offset int64) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	return self.SectionReader.ReadAt(buf, offset)
+}
+
+func (self *ntfsFile) Stat() (fs.FileInfo, error) {
+	return self.info, nil
+}
+
+func (self *ntfsFile) Close() error {
+	return nil
+}
+
+// Ranges exposes the sparse runs of the underlying stream.
+func (self *ntfsFile) Ranges() []Range {
+	return self.reader.Ranges()
+}
+
+type ntfsDir struct {
+	fs        *NTFSFS
+	name      string
+	info      *ntfsFileInfo
+	mft_entry *MFT_ENTRY
+
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (self *ntfsDir) Stat() (fs.FileInfo, error) {
+	return self.info, nil
+}
+
+func (self *ntfsDir) Read(buf []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: self.name,
+		Err: errors.New("is a directory")}
+}
+
+func (self *ntfsDir) Close() error {
+	return nil
+}
+
+func (self *ntfsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if self.entries == nil {
+		self.entries = self.fs.readDir(self.mft_entry)
+	}
+
+	remaining := self.entries[self.offset:]
+	if count <= 0 {
+		self.offset = len(self.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	self.offset += count
+
+	return remaining[:count], nil
+}
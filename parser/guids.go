@@ -1,6 +1,9 @@
 package parser
 
-import "fmt"
+import (
+	"encoding/binary"
+	"fmt"
+)
 
 func (self GUID) AsString() string {
 	data4 := self.Data4()
@@ -10,3 +13,18 @@ func (self GUID) AsString() string {
 		data4[0], data4[1], data4[2], data4[3],
 		data4[4], data4[5], data4[6], data4[7])
 }
+
+// Format a 16 byte GUID in the same way as GUID.AsString().
+func guidString(data []byte) string {
+	if len(data) < 16 {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"{%08x-%04x-%04x-%02x%02x-%02x%02x%02x%02x%02x%02x}",
+		binary.LittleEndian.Uint32(data[0:]),
+		binary.LittleEndian.Uint16(data[4:]),
+		binary.LittleEndian.Uint16(data[6:]),
+		data[8], data[9], data[10], data[11],
+		data[12], data[13], data[14], data[15])
+}
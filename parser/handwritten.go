@@ -14,6 +14,8 @@ type NTFS_ATTRIBUTE struct {
 	Reader  io.ReaderAt
 	Offset  int64
 	Profile *NTFSProfile
+
+	types *AttributeTypeRegistry
 }
 
 func NewNTFS_ATTRIBUTE(Reader io.ReaderAt,
@@ -35,57 +37,16 @@ func (self *NTFS_ATTRIBUTE) Size() int {
 	return 64
 }
 
+// Type names resolve through the attribute type registry of the
+// context the attribute was read from.
 func (self *NTFS_ATTRIBUTE) Type() *Enumeration {
-	value := binary.LittleEndian.Uint32(self.b[0:4])
-	name := "Unknown"
-	switch value {
-
-	case 16:
-		name = "$STANDARD_INFORMATION"
-
-	case 32:
-		name = "$ATTRIBUTE_LIST"
-
-	case 48:
-		name = "$FILE_NAME"
-
-	case 64:
-		name = "$OBJECT_ID"
-
-	case 80:
-		name = "$SECURITY_DESCRIPTOR"
-
-	case 96:
-		name = "$VOLUME_NAME"
+	value := uint64(binary.LittleEndian.Uint32(self.b[0:4]))
 
-	case 112:
-		name = "$VOLUME_INFORMATION"
-
-	case 128:
-		name = "$DATA"
-
-	case 144:
-		name = "$INDEX_ROOT"
-
-	case 160:
-		name = "$INDEX_ALLOCATION"
-
-	case 176:
-		name = "$BITMAP"
-
-	case 192:
-		name = "$REPARSE_POINT"
-
-	case 208:
-		name = "$EA_INFORMATION"
-
-	case 224:
-		name = "$EA"
-
-	case 256:
-		name = "$LOGGED_UTILITY_STREAM"
+	types := self.types
+	if types == nil {
+		types = DefaultAttributeTypes
 	}
-	return &Enumeration{Value: uint64(value), Name: name}
+	return &Enumeration{Value: value, Name: types.Name(value)}
 }
 
 func (self *NTFS_ATTRIBUTE) Length() uint32 {
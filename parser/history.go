@@ -0,0 +1,415 @@
+// Compare files across Volume Shadow Copies and the live volume.
+//
+// A file's history shows each version of the volume (every snapshot
+// followed by the live volume) with the file's metadata and content
+// hashes as they were at that time.
+//
+// A volume diff compares the $MFT of two versions. Entries are keyed
+// on their MFT id and sequence number, so a reused MFT entry shows up
+// as a deleted file and an added file.
+
+package parser
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	DIFF_ADDED    = "added"
+	DIFF_DELETED  = "deleted"
+	DIFF_MODIFIED = "modified"
+	DIFF_RENAMED  = "renamed"
+)
+
+// A version of the volume - either the live volume or a snapshot.
+type VolumeVersion struct {
+	// "Live" or the shadow copy id.
+	Source          string
+	SnapshotOrdinal int
+	SnapshotTime    time.Time
+	NTFS            *NTFSContext `json:"-"`
+}
+
+// Get the snapshots of the volume (oldest first) followed by the live
+// volume.
+func GetVolumeVersions(volume io.ReaderAt) ([]*VolumeVersion, error) {
+	live, err := GetNTFSContext(volume, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*VolumeVersion{}
+	snapshots, err := GetVSSSnapshots(volume)
+	if err != nil && !errors.Is(err, noVSSError) {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		ntfs, err := snapshot.NTFSContext()
+		if err != nil {
+			DebugPrint(DEBUG_NTFS, "Can not open shadow copy %v: %v\n",
+				snapshot.Ordinal, err)
+			continue
+		}
+
+		result = append(result, &VolumeVersion{
+			Source:          snapshot.ShadowCopyGUID,
+			SnapshotOrdinal: snapshot.Ordinal,
+			SnapshotTime:    snapshot.CreationTime,
+			NTFS:            ntfs,
+		})
+	}
+
+	return append(result, &VolumeVersion{
+		Source: "Live",
+		NTFS:   live,
+	}), nil
+}
+
+type FileStreamVersion struct {
+	Name   string `json:"Name,omitempty"`
+	Size   int64
+	MD5    string `json:"MD5,omitempty"`
+	SHA256 string `json:"SHA256,omitempty"`
+	Error  string `json:"Error,omitempty"`
+}
+
+// The file as it existed in one version of the volume.
+type FileVersion struct {
+	Source          string
+	SnapshotOrdinal int       `json:"SnapshotOrdinal,omitempty"`
+	SnapshotTime    time.Time `json:"SnapshotTime,omitempty"`
+
+	// Set when the file could not be found in this version.
+	Error string `json:"Error,omitempty"`
+
+	MFTID          int64           `json:"MFTID,omitempty"`
+	SequenceNumber uint16          `json:"SequenceNumber,omitempty"`
+	FullPath       string          `json:"FullPath,omitempty"`
+	Allocated      bool            `json:"Allocated,omitempty"`
+	IsDir          bool            `json:"IsDir,omitempty"`
+	Size           int64           `json:"Size,omitempty"`
+	SI_Times       *TimeStamps     `json:"SI_Times,omitempty"`
+	Filenames      []*FilenameInfo `json:"Filenames,omitempty"`
+
+	// The default $DATA stream and any alternate data streams.
+	Streams []*FileStreamVersion `json:"Streams,omitempty"`
+}
+
+// Find the file by path or MFT id (e.g. 1234 or 1234-128-0).
+func openPathOrMFTId(ntfs *NTFSContext, path string) (*MFT_ENTRY, error) {
+	mft_idx, _, _, _, err := ParseMFTId(path)
+	if err == nil {
+		return ntfs.GetMFT(mft_idx)
+	}
+
+	root, err := ntfs.GetMFT(5)
+	if err != nil {
+		return nil, err
+	}
+	return root.Open(ntfs, path)
+}
+
+// Show the file in each version of the volume.
+func GetFileHistory(ctx context.Context,
+	versions []*VolumeVersion, path string) []*FileVersion {
+	result := []*FileVersion{}
+	for _, version := range versions {
+		select {
+		case <-ctx.Done():
+			return result
+		default:
+		}
+
+		file_version := &FileVersion{
+			Source:          version.Source,
+			SnapshotOrdinal: version.SnapshotOrdinal,
+			SnapshotTime:    version.SnapshotTime,
+		}
+		result = append(result, file_version)
+
+		mft_entry, err := openPathOrMFTId(version.NTFS, path)
+		if err != nil {
+			file_version.Error = err.Error()
+			continue
+		}
+
+		info, err := ModelMFTEntry(version.NTFS, mft_entry)
+		if err != nil {
+			file_version.Error = err.Error()
+			continue
+		}
+
+		file_version.MFTID = info.MFTID
+		file_version.SequenceNumber = info.SequenceNumber
+		file_version.FullPath = info.FullPath
+		file_version.Allocated = info.Allocated
+		file_version.IsDir = info.IsDir
+		file_version.Size = info.Size
+		file_version.SI_Times = info.SI_Times
+		file_version.Filenames = info.Filenames
+
+		for _, attr := range info.Attributes {
+			if attr.TypeId != ATTR_TYPE_DATA {
+				continue
+			}
+
+			stream := &FileStreamVersion{
+				Name: attr.Name,
+				Size: attr.Size,
+			}
+			file_version.Streams = append(file_version.Streams, stream)
+
+			reader, err := OpenStream(version.NTFS, mft_entry,
+				ATTR_TYPE_DATA, uint16(attr.Id), attr.Name)
+			if err != nil {
+				stream.Error = err.Error()
+				continue
+			}
+
+			stream.MD5, stream.SHA256, err = hashStream(reader, attr.Size)
+			if err != nil {
+				stream.Error = err.Error()
+			}
+		}
+	}
+
+	return result
+}
+
+func hashStream(reader io.ReaderAt, size int64) (string, string, error) {
+	md5_hash := md5.New()
+	sha256_hash := sha256.New()
+
+	_, err := io.Copy(io.MultiWriter(md5_hash, sha256_hash),
+		io.NewSectionReader(reader, 0, size))
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(md5_hash.Sum(nil)),
+		hex.EncodeToString(sha256_hash.Sum(nil)), nil
+}
+
+// A raw $MFT and the geometry needed to parse it.
+type MFTSource struct {
+	Reader      io.ReaderAt
+	Size        int64
+	ClusterSize int64
+	RecordSize  int64
+}
+
+// Get the $MFT of the volume.
+func NewMFTSource(ntfs *NTFSContext) (*MFTSource, error) {
+	mft_entry, err := ntfs.GetMFT(0)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := OpenStream(ntfs, mft_entry,
+		ATTR_TYPE_DATA, WILDCARD_STREAM_ID, WILDCARD_STREAM_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFTSource{
+		Reader:      reader,
+		Size:        RangeSize(reader),
+		ClusterSize: ntfs.ClusterSize,
+		RecordSize:  ntfs.RecordSize,
+	}, nil
+}
+
+type VolumeDiffEntry struct {
+	// One of added, deleted, modified or renamed.
+	Change         string
+	EntryNumber    int64
+	SequenceNumber uint16
+	IsDir          bool
+	OldPath        string `json:"OldPath,omitempty"`
+	NewPath        string `json:"NewPath,omitempty"`
+	OldSize        int64
+	NewSize        int64
+
+	// The fields which changed for modified and renamed entries.
+	Changes []string `json:"Changes,omitempty"`
+}
+
+type mftDiffState struct {
+	path          string
+	is_dir        bool
+	size          int64
+	created       time.Time
+	modified      time.Time
+	record_change time.Time
+	ads           map[string]int64
+}
+
+func collectMFTState(ctx context.Context, source *MFTSource,
+	options Options) map[uint64]*mftDiffState {
+	result := make(map[uint64]*mftDiffState)
+
+	for row := range ParseMFTFileWithOptions(ctx, source.Reader,
+		source.Size, source.ClusterSize, source.RecordSize, 0, options) {
+		if !row.InUse {
+			continue
+		}
+
+		key := uint64(row.EntryNumber)<<16 | uint64(row.SequenceNumber)
+
+		// Alternate data streams follow the row of their entry.
+		if row.ads_name != "" {
+			state, pres := result[key]
+			if pres {
+				state.ads[row.ads_name] = row.FileSize
+			}
+			continue
+		}
+
+		result[key] = &mftDiffState{
+			path:          row.FullPath(),
+			is_dir:        row.IsDir,
+			size:          row.FileSize,
+			created:       row.Created0x10,
+			modified:      row.LastModified0x10,
+			record_change: row.LastRecordChange0x10,
+			ads:           make(map[string]int64),
+		}
+	}
+
+	return result
+}
+
+// Compare the $MFT of two versions of a volume.
+func DiffMFT(ctx context.Context, old_source, new_source *MFTSource,
+	options Options) chan *VolumeDiffEntry {
+	output := make(chan *VolumeDiffEntry)
+
+	go func() {
+		defer close(output)
+
+		old_state := collectMFTState(ctx, old_source, options)
+		new_state := collectMFTState(ctx, new_source, options)
+
+		keys := make([]uint64, 0, len(old_state)+len(new_state))
+		for key := range old_state {
+			keys = append(keys, key)
+		}
+		for key := range new_state {
+			_, pres := old_state[key]
+			if !pres {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		for _, key := range keys {
+			entry := diffMFTEntry(key, old_state[key], new_state[key])
+			if entry == nil {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output <- entry:
+			}
+		}
+	}()
+
+	return output
+}
+
+func diffMFTEntry(key uint64, old, new *mftDiffState) *VolumeDiffEntry {
+	result := &VolumeDiffEntry{
+		EntryNumber:    int64(key >> 16),
+		SequenceNumber: uint16(key),
+	}
+
+	if old != nil {
+		result.OldPath = old.path
+		result.OldSize = old.size
+		result.IsDir = old.is_dir
+	}
+
+	if new != nil {
+		result.NewPath = new.path
+		result.NewSize = new.size
+		result.IsDir = new.is_dir
+	}
+
+	switch {
+	case old == nil:
+		result.Change = DIFF_ADDED
+		return result
+
+	case new == nil:
+		result.Change = DIFF_DELETED
+		return result
+	}
+
+	if old.size != new.size {
+		result.Changes = append(result.Changes, "Size")
+	}
+	if !old.created.Equal(new.created) {
+		result.Changes = append(result.Changes, "Created0x10")
+	}
+	if !old.modified.Equal(new.modified) {
+		result.Changes = append(result.Changes, "LastModified0x10")
+	}
+	if !old.record_change.Equal(new.record_change) {
+		result.Changes = append(result.Changes, "LastRecordChange0x10")
+	}
+
+	ads_names := []string{}
+	for name, size := range old.ads {
+		new_size, pres := new.ads[name]
+		if !pres || new_size != size {
+			ads_names = append(ads_names, name)
+		}
+	}
+	for name := range new.ads {
+		_, pres := old.ads[name]
+		if !pres {
+			ads_names = append(ads_names, name)
+		}
+	}
+	sort.Strings(ads_names)
+	for _, name := range ads_names {
+		result.Changes = append(result.Changes, "ADS:"+name)
+	}
+
+	switch {
+	case old.path != new.path:
+		result.Change = DIFF_RENAMED
+	case len(result.Changes) > 0:
+		result.Change = DIFF_MODIFIED
+	default:
+		return nil
+	}
+
+	return result
+}
+
+// Compare two versions of a volume.
+func DiffVolumes(ctx context.Context,
+	old_ntfs, new_ntfs *NTFSContext) (chan *VolumeDiffEntry, error) {
+	old_source, err := NewMFTSource(old_ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	new_source, err := NewMFTSource(new_ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	return DiffMFT(ctx, old_source, new_source, *new_ntfs.GetOptions()), nil
+}
@@ -0,0 +1,167 @@
+// Generic (view) indexes.
+//
+// Directories are indexed by $FILE_NAME but NTFS also maintains
+// indexes keyed by other values (e.g. $Secure:$SII is keyed by
+// security id, $ObjId:$O by object id). These view indexes share the
+// same node layout ($INDEX_ROOT and INDX records in
+// $INDEX_ALLOCATION) but their entries hold an arbitrary key and data
+// instead of a $FILE_NAME.
+
+package parser
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	INDEX_ENTRY_FLAG_LAST = 2
+)
+
+// An entry in a view index.
+type IndexEntry struct {
+	Key  []byte
+	Data []byte
+}
+
+// Find all the nodes of the named index in the MFT entry. INDX
+// records which are not marked in use in the $BITMAP attribute of the
+// same name are skipped because they may still hold stale entries.
+func (self *MFT_ENTRY) IndexNodes(
+	ntfs *NTFSContext, name string) []*INDEX_NODE_HEADER {
+	result := []*INDEX_NODE_HEADER{}
+	index_record_size := int64(0x1000)
+
+	// The $BITMAP follows the $INDEX_ALLOCATION attribute so collect
+	// the attributes first.
+	var allocations []*NTFS_ATTRIBUTE
+	var bitmap []byte
+
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Name() != name {
+			continue
+		}
+
+		switch attr.Type().Value {
+		case ATTR_TYPE_INDEX_ROOT:
+			index_root := self.Profile.INDEX_ROOT(attr.Data(ntfs), 0)
+			size := int64(index_root.Idxalloc_size_b())
+			if size > 0 && size <= MAX_IDX_SIZE {
+				index_record_size = size
+			}
+			result = append(result, index_root.Node())
+
+		case ATTR_TYPE_INDEX_ALLOCATION:
+			allocations = append(allocations, attr)
+
+		case ATTR_TYPE_BITMAP:
+			bitmap = readIndexBitmap(ntfs, attr)
+		}
+	}
+
+	for _, attr := range allocations {
+		attr_reader := attr.Data(ntfs)
+		for i := int64(0); i < attr.DataSize(); i += index_record_size {
+			if !isIndexRecordInUse(bitmap, i/index_record_size) {
+				continue
+			}
+
+			index_header, err := DecodeSTANDARD_INDEX_HEADER(
+				ntfs, attr_reader, i, index_record_size)
+			if err == nil && index_header.MagicNumber().IsValid() {
+				result = append(result, index_header.Node())
+			}
+		}
+	}
+
+	return result
+}
+
+func readIndexBitmap(ntfs *NTFSContext, attr *NTFS_ATTRIBUTE) []byte {
+	size := attr.DataSize()
+	if size <= 0 || size > MAX_IDX_SIZE {
+		return nil
+	}
+
+	buffer := make([]byte, size)
+	n, err := attr.Data(ntfs).ReadAt(buffer, 0)
+	if err != nil && err != io.EOF {
+		return nil
+	}
+	return buffer[:n]
+}
+
+// Without a readable $BITMAP all the records are considered in use.
+func isIndexRecordInUse(bitmap []byte, record int64) bool {
+	if bitmap == nil {
+		return true
+	}
+
+	if record/8 >= int64(len(bitmap)) {
+		return false
+	}
+	return bitmap[record/8]&(1<<uint(record%8)) != 0
+}
+
+// Return all the entries of the named view index.
+func (self *MFT_ENTRY) IndexEntries(
+	ntfs *NTFSContext, name string) []*IndexEntry {
+	result := []*IndexEntry{}
+	for _, node := range self.IndexNodes(ntfs, name) {
+		result = append(result, node.GetIndexEntries()...)
+	}
+	return result
+}
+
+// Parse the entries in the node as view index entries. Each entry
+// has a 16 byte header:
+//
+//	0  DataOffset (relative to the entry)
+//	2  DataLength
+//	8  EntrySize
+//	10 KeySize
+//	12 Flags
+//	16 Key
+func (self *INDEX_NODE_HEADER) GetIndexEntries() []*IndexEntry {
+	result := []*IndexEntry{}
+
+	start := int64(self.Offset_to_index_entry()) + self.Offset
+	end := int64(self.Offset_to_end_index_entry()) + self.Offset
+	if end <= start || end-start > MAX_IDX_SIZE {
+		return result
+	}
+
+	buffer := make([]byte, end-start)
+	n, err := self.Reader.ReadAt(buffer, start)
+	if err != nil && err != io.EOF {
+		return result
+	}
+	buffer = buffer[:n]
+
+	for offset := 0; offset+16 <= len(buffer); {
+		entry := buffer[offset:]
+		data_offset := int(binary.LittleEndian.Uint16(entry[0:]))
+		data_length := int(binary.LittleEndian.Uint16(entry[2:]))
+		entry_size := int(binary.LittleEndian.Uint16(entry[8:]))
+		key_size := int(binary.LittleEndian.Uint16(entry[10:]))
+		flags := binary.LittleEndian.Uint16(entry[12:])
+
+		if flags&INDEX_ENTRY_FLAG_LAST != 0 || entry_size < 16 ||
+			entry_size > len(entry) {
+			break
+		}
+
+		index_entry := &IndexEntry{}
+		if 16+key_size <= entry_size {
+			index_entry.Key = entry[16 : 16+key_size]
+		}
+		if data_offset+data_length <= entry_size {
+			index_entry.Data = entry[data_offset : data_offset+data_length]
+		}
+		result = append(result, index_entry)
+
+		offset += entry_size
+	}
+
+	return result
+}
@@ -0,0 +1,588 @@
+// Parser for the NTFS $LogFile (MFT entry 2).
+//
+// The $LogFile is maintained by the Log File Service (LFS). It starts
+// with two restart pages (RSTR) followed by record pages (RCRD). Each
+// record page holds one or more log records, and a record may span
+// several pages. Each page is protected by an update sequence array
+// (fixups) just like MFT entries.
+//
+// The record pages start with copies of the tail of the log (2 pages
+// in LFS 1.x, 32 in LFS 2.x). NTFS writes a partially filled page
+// there before it is written to its real location, so the copy may
+// be newer than the page it copies. The copies are not walked in
+// place - instead each one replaces the page it copies when it is
+// newer.
+//
+// Log records are addressed by their LSN which encodes the file
+// offset of the record (in units of 8 bytes) along with a sequence
+// number which is incremented each time the log wraps. This allows
+// us to validate each record by checking that its LSN matches where
+// we found it.
+//
+// NTFS log records carry the redo and undo operations which describe
+// the change to the metadata (MFT entries, indexes, bitmaps etc).
+//
+// References:
+// https://github.com/libyal/libfsntfs/blob/main/documentation/New%20Technologies%20File%20System%20(NTFS).asciidoc
+// https://flatcap.github.io/linux-ntfs/ntfs/files/logfile.html
+
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	LOGFILE_MFT_ID = 2
+
+	LOG_RECORD_HEADER_SIZE = 0x30
+
+	// The NTFS client data header before the LCN list.
+	NTFS_LOG_RECORD_HEADER_SIZE = 0x20
+
+	LOG_RECORD_TYPE_CLIENT  = 1
+	LOG_RECORD_TYPE_RESTART = 2
+
+	LOG_RECORD_MULTI_PAGE = 1
+
+	// Log records should not be larger than this.
+	MAX_LOG_RECORD_SIZE = 1024 * 1024
+
+	// The number of tail copy pages after the restart pages.
+	LOG_TAIL_PAGES_V1 = 2
+	LOG_TAIL_PAGES_V2 = 32
+)
+
+var (
+	notLogFileError = errors.New("Not a $LogFile: No valid restart page")
+
+	logOperationNames = map[uint16]string{
+		0x00: "Noop",
+		0x01: "CompensationLogRecord",
+		0x02: "InitializeFileRecordSegment",
+		0x03: "DeallocateFileRecordSegment",
+		0x04: "WriteEndOfFileRecordSegment",
+		0x05: "CreateAttribute",
+		0x06: "DeleteAttribute",
+		0x07: "UpdateResidentValue",
+		0x08: "UpdateNonresidentValue",
+		0x09: "UpdateMappingPairs",
+		0x0A: "DeleteDirtyClusters",
+		0x0B: "SetNewAttributeSizes",
+		0x0C: "AddIndexEntryRoot",
+		0x0D: "DeleteIndexEntryRoot",
+		0x0E: "AddIndexEntryAllocation",
+		0x0F: "DeleteIndexEntryAllocation",
+		0x10: "WriteEndOfIndexBuffer",
+		0x11: "SetIndexEntryVcnRoot",
+		0x12: "SetIndexEntryVcnAllocation",
+		0x13: "UpdateFileNameRoot",
+		0x14: "UpdateFileNameAllocation",
+		0x15: "SetBitsInNonresidentBitMap",
+		0x16: "ClearBitsInNonresidentBitMap",
+		0x17: "HotFix",
+		0x18: "EndTopLevelAction",
+		0x19: "PrepareTransaction",
+		0x1A: "CommitTransaction",
+		0x1B: "ForgetTransaction",
+		0x1C: "OpenNonresidentAttribute",
+		0x1D: "OpenAttributeTableDump",
+		0x1E: "AttributeNamesDump",
+		0x1F: "DirtyPageTableDump",
+		0x20: "TransactionTableDump",
+		0x21: "UpdateRecordDataRoot",
+		0x22: "UpdateRecordDataAllocation",
+		0x23: "UpdateRelativeDataInIndex",
+		0x24: "UpdateRelativeDataInIndex2",
+		0x25: "ZeroEndOfFileRecord",
+	}
+)
+
+func LogOperationName(op uint16) string {
+	name, pres := logOperationNames[op]
+	if pres {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%#x)", op)
+}
+
+// A client of the log file service (NTFS is normally the only one).
+type LogClient struct {
+	OldestLsn        uint64
+	ClientRestartLsn uint64
+	Name             string
+}
+
+// A restart page (RSTR) together with its restart area.
+type RestartPage struct {
+	Offset         int64
+	ChkDskLsn      uint64
+	SystemPageSize uint32
+	LogPageSize    uint32
+	MajorVersion   int16
+	MinorVersion   int16
+
+	CurrentLsn         uint64
+	Flags              uint16
+	SeqNumberBits      uint32
+	FileSize           uint64
+	RecordHeaderLength uint16
+	LogPageDataOffset  uint16
+	RestartOpenCount   uint32
+
+	Clients []*LogClient
+}
+
+// A single log record.
+type LogRecord struct {
+	// The offset in the $LogFile of the record header.
+	Offset int64
+
+	Lsn              uint64
+	PreviousLsn      uint64
+	UndoNextLsn      uint64
+	ClientDataLength uint32
+	ClientIndex      uint16
+	RecordType       uint32
+	TransactionId    uint32
+	Flags            uint16
+
+	// The NTFS client data. Only valid for client records.
+	RedoOperation      string
+	UndoOperation      string
+	RedoOp             uint16
+	UndoOp             uint16
+	TargetAttribute    uint16
+	RecordOffset       uint16
+	AttributeOffset    uint16
+	ClusterBlockOffset uint16
+	TargetVcn          uint64
+	TargetLcns         []uint64
+
+	RedoData []byte
+	UndoData []byte
+}
+
+// Only meaningful when the record targets the $MFT's $DATA
+// attribute: the MFT entry that was changed. This may be compared to
+// MFT_ENTRY.Logfile_sequence_number() to match MFT entries to their
+// most recent log record.
+func (self *LogRecord) MFTEntryId(cluster_size, record_size int64) int64 {
+	if record_size == 0 {
+		return 0
+	}
+	return (int64(self.TargetVcn)*cluster_size +
+		int64(self.ClusterBlockOffset)*512) / record_size
+}
+
+func parseLogRecord(offset int64, data []byte) *LogRecord {
+	result := &LogRecord{
+		Offset:           offset,
+		Lsn:              binary.LittleEndian.Uint64(data[0:]),
+		PreviousLsn:      binary.LittleEndian.Uint64(data[8:]),
+		UndoNextLsn:      binary.LittleEndian.Uint64(data[16:]),
+		ClientDataLength: binary.LittleEndian.Uint32(data[24:]),
+		ClientIndex:      binary.LittleEndian.Uint16(data[30:]),
+		RecordType:       binary.LittleEndian.Uint32(data[32:]),
+		TransactionId:    binary.LittleEndian.Uint32(data[36:]),
+		Flags:            binary.LittleEndian.Uint16(data[40:]),
+	}
+
+	client_data := data[LOG_RECORD_HEADER_SIZE:]
+	if result.RecordType != LOG_RECORD_TYPE_CLIENT ||
+		len(client_data) < NTFS_LOG_RECORD_HEADER_SIZE {
+		return result
+	}
+
+	result.RedoOp = binary.LittleEndian.Uint16(client_data[0:])
+	result.UndoOp = binary.LittleEndian.Uint16(client_data[2:])
+	result.RedoOperation = LogOperationName(result.RedoOp)
+	result.UndoOperation = LogOperationName(result.UndoOp)
+	result.TargetAttribute = binary.LittleEndian.Uint16(client_data[12:])
+	result.RecordOffset = binary.LittleEndian.Uint16(client_data[16:])
+	result.AttributeOffset = binary.LittleEndian.Uint16(client_data[18:])
+	result.ClusterBlockOffset = binary.LittleEndian.Uint16(client_data[20:])
+	result.TargetVcn = binary.LittleEndian.Uint64(client_data[24:])
+
+	lcns_to_follow := int(binary.LittleEndian.Uint16(client_data[14:]))
+	for i := 0; i < lcns_to_follow; i++ {
+		lcn_offset := NTFS_LOG_RECORD_HEADER_SIZE + 8*i
+		if lcn_offset+8 > len(client_data) {
+			break
+		}
+		result.TargetLcns = append(result.TargetLcns,
+			binary.LittleEndian.Uint64(client_data[lcn_offset:]))
+	}
+
+	get_data := func(offset_at, length_at int) []byte {
+		offset := int(binary.LittleEndian.Uint16(client_data[offset_at:]))
+		length := int(binary.LittleEndian.Uint16(client_data[length_at:]))
+		if length == 0 || offset+length > len(client_data) {
+			return nil
+		}
+		return client_data[offset : offset+length]
+	}
+
+	result.RedoData = get_data(4, 6)
+	result.UndoData = get_data(8, 10)
+
+	return result
+}
+
+// Apply the update sequence array to a page. Sectors whose trailing
+// bytes do not match the update sequence number (e.g. because of a
+// torn write) are left alone.
+func applyLogFixups(page []byte, usa_offset, usa_count int) {
+	if usa_count < 2 || usa_offset+usa_count*2 > len(page) {
+		return
+	}
+
+	magic := page[usa_offset : usa_offset+2]
+	for i := 1; i < usa_count; i++ {
+		sector_end := i*512 - 2
+		if sector_end+2 > len(page) {
+			break
+		}
+		if !bytes.Equal(page[sector_end:sector_end+2], magic) {
+			DebugPrint(DEBUG_NTFS, "LogFile: Fixup mismatch in sector %v\n", i)
+			continue
+		}
+		copy(page[sector_end:sector_end+2],
+			page[usa_offset+2*i:usa_offset+2*i+2])
+	}
+}
+
+func readLogPage(reader io.ReaderAt, offset, page_size int64) ([]byte, error) {
+	page := make([]byte, page_size)
+	n, err := reader.ReadAt(page, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if int64(n) < page_size {
+		return nil, io.EOF
+	}
+
+	applyLogFixups(page,
+		int(binary.LittleEndian.Uint16(page[4:])),
+		int(binary.LittleEndian.Uint16(page[6:])))
+
+	return page, nil
+}
+
+func parseRestartPage(reader io.ReaderAt, offset int64) (*RestartPage, error) {
+	header := make([]byte, 32)
+	n, err := reader.ReadAt(header, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n < 32 || string(header[:4]) != "RSTR" {
+		return nil, notLogFileError
+	}
+
+	system_page_size := binary.LittleEndian.Uint32(header[16:])
+	if system_page_size < 512 || system_page_size > 0x10000 ||
+		system_page_size%512 != 0 {
+		return nil, notLogFileError
+	}
+
+	page, err := readLogPage(reader, offset, int64(system_page_size))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestartPage{
+		Offset:         offset,
+		ChkDskLsn:      binary.LittleEndian.Uint64(page[8:]),
+		SystemPageSize: system_page_size,
+		LogPageSize:    binary.LittleEndian.Uint32(page[20:]),
+		MinorVersion:   int16(binary.LittleEndian.Uint16(page[26:])),
+		MajorVersion:   int16(binary.LittleEndian.Uint16(page[28:])),
+	}
+
+	restart_offset := int(binary.LittleEndian.Uint16(page[24:]))
+	if restart_offset+48 > len(page) {
+		return nil, notLogFileError
+	}
+
+	area := page[restart_offset:]
+	result.CurrentLsn = binary.LittleEndian.Uint64(area[0:])
+	log_clients := int(binary.LittleEndian.Uint16(area[8:]))
+	result.Flags = binary.LittleEndian.Uint16(area[14:])
+	result.SeqNumberBits = binary.LittleEndian.Uint32(area[16:])
+	client_array_offset := int(binary.LittleEndian.Uint16(area[22:]))
+	result.FileSize = binary.LittleEndian.Uint64(area[24:])
+	result.RecordHeaderLength = binary.LittleEndian.Uint16(area[36:])
+	result.LogPageDataOffset = binary.LittleEndian.Uint16(area[38:])
+	result.RestartOpenCount = binary.LittleEndian.Uint32(area[40:])
+
+	if result.LogPageSize < 512 || result.LogPageSize > 0x10000 ||
+		result.SeqNumberBits < 3 || result.SeqNumberBits > 64 {
+		return nil, notLogFileError
+	}
+
+	// Each client record is 0xa0 bytes long.
+	for i := 0; i < log_clients; i++ {
+		client_offset := client_array_offset + i*0xa0
+		if client_offset+0xa0 > len(area) {
+			break
+		}
+		client := area[client_offset:]
+		name_length := CapInt64(int64(
+			binary.LittleEndian.Uint32(client[28:])), 128)
+		result.Clients = append(result.Clients, &LogClient{
+			OldestLsn:        binary.LittleEndian.Uint64(client[0:]),
+			ClientRestartLsn: binary.LittleEndian.Uint64(client[8:]),
+			Name: UTF16BytesToUTF8(
+				client[32:32+name_length], binary.LittleEndian),
+		})
+	}
+
+	return result, nil
+}
+
+type LogFile struct {
+	reader io.ReaderAt
+	size   int64
+
+	// The most recent restart page.
+	Restart *RestartPage
+
+	// Both restart pages.
+	RestartPages []*RestartPage
+
+	page_size   int64
+	data_offset int64
+	seq_bits    uint32
+	tail_pages  int64
+}
+
+func NewLogFile(reader io.ReaderAt, size int64) (*LogFile, error) {
+	result := &LogFile{reader: reader, size: size}
+
+	first, err := parseRestartPage(reader, 0)
+	if err == nil {
+		result.RestartPages = append(result.RestartPages, first)
+	}
+
+	// The second restart page follows the first one. If the
+	// first is damaged, assume the usual 4kb page size.
+	second_offset := int64(0x1000)
+	if first != nil {
+		second_offset = int64(first.SystemPageSize)
+	}
+
+	second, err := parseRestartPage(reader, second_offset)
+	if err == nil {
+		result.RestartPages = append(result.RestartPages, second)
+	}
+
+	for _, restart := range result.RestartPages {
+		if result.Restart == nil ||
+			restart.CurrentLsn > result.Restart.CurrentLsn {
+			result.Restart = restart
+		}
+	}
+
+	if result.Restart == nil {
+		return nil, notLogFileError
+	}
+
+	result.page_size = int64(result.Restart.LogPageSize)
+	result.data_offset = int64(result.Restart.LogPageDataOffset)
+	result.seq_bits = result.Restart.SeqNumberBits
+
+	result.tail_pages = LOG_TAIL_PAGES_V1
+	if result.Restart.MajorVersion >= 2 {
+		result.tail_pages = LOG_TAIL_PAGES_V2
+	}
+
+	if result.data_offset < 0x28 || result.data_offset >= result.page_size {
+		return nil, fmt.Errorf("LogFile: Invalid page data offset %#x",
+			result.data_offset)
+	}
+
+	return result, nil
+}
+
+// Convert an LSN to the offset in the log file.
+func (self *LogFile) LsnToOffset(lsn uint64) int64 {
+	return int64((lsn << self.seq_bits) >> (self.seq_bits - 3))
+}
+
+// The LSN of the last record ending on the record page.
+func logPageLastEndLsn(page []byte) uint64 {
+	if string(page[:4]) != "RCRD" {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(page[0x20:])
+}
+
+// Read the tail copy pages and return the newest copy of each page
+// by the offset of the page it copies. Tail copies store that offset
+// in place of the last LSN.
+func (self *LogFile) readTailCopies(first_page, logging_start int64) map[int64][]byte {
+	result := make(map[int64][]byte)
+
+	for page_offset := first_page; page_offset < logging_start &&
+		page_offset+self.page_size <= self.size; page_offset += self.page_size {
+		page, err := readLogPage(self.reader, page_offset, self.page_size)
+		if err != nil || string(page[:4]) != "RCRD" {
+			continue
+		}
+
+		copy_offset := int64(binary.LittleEndian.Uint64(page[8:]))
+		if copy_offset < logging_start || copy_offset%self.page_size != 0 ||
+			copy_offset+self.page_size > self.size {
+			continue
+		}
+
+		existing, pres := result[copy_offset]
+		if !pres || logPageLastEndLsn(page) > logPageLastEndLsn(existing) {
+			result[copy_offset] = page
+		}
+	}
+
+	return result
+}
+
+// Walk all the record pages and emit the log records found on
+// them. Records are emitted in file order (not LSN order).
+func (self *LogFile) Records(ctx context.Context) chan *LogRecord {
+	output := make(chan *LogRecord)
+
+	go func() {
+		defer close(output)
+
+		// A record which started on a previous page.
+		var pending []byte
+		var pending_offset int64
+		var pending_length int
+
+		emit := func(offset int64, data []byte) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case output <- parseLogRecord(offset, data):
+				return true
+			}
+		}
+
+		// The log pages start after the two restart pages and
+		// the tail copies.
+		first_page := 2 * int64(self.Restart.SystemPageSize)
+		logging_start := first_page + self.tail_pages*self.page_size
+		tail_copies := self.readTailCopies(first_page, logging_start)
+
+		for page_offset := logging_start; page_offset+self.page_size <= self.size; page_offset += self.page_size {
+			page, err := readLogPage(self.reader, page_offset, self.page_size)
+			if err != nil {
+				return
+			}
+
+			tail_copy, pres := tail_copies[page_offset]
+			if pres && logPageLastEndLsn(tail_copy) > logPageLastEndLsn(page) {
+				page = tail_copy
+			}
+
+			if string(page[:4]) != "RCRD" {
+				pending = nil
+				continue
+			}
+
+			pos := self.data_offset
+
+			// Complete the record from the previous page.
+			if pending != nil {
+				to_copy := CapInt64(int64(pending_length-len(pending)),
+					self.page_size-pos)
+				pending = append(pending, page[pos:pos+to_copy]...)
+				pos += to_copy
+
+				if len(pending) == pending_length {
+					if !emit(pending_offset, pending) {
+						return
+					}
+					pending = nil
+					pos = (pos + 7) &^ 7
+				}
+			}
+
+			for pending == nil && pos+LOG_RECORD_HEADER_SIZE <= self.page_size {
+				header := page[pos:]
+				lsn := binary.LittleEndian.Uint64(header)
+
+				// Only accept records whose LSN refers to
+				// this location - otherwise scan ahead.
+				if lsn == 0 || self.LsnToOffset(lsn) != page_offset+pos {
+					pos += 8
+					continue
+				}
+
+				length := LOG_RECORD_HEADER_SIZE + int(
+					binary.LittleEndian.Uint32(header[24:]))
+				if length > MAX_LOG_RECORD_SIZE {
+					pos += 8
+					continue
+				}
+
+				if pos+int64(length) > self.page_size {
+					pending = append([]byte{}, page[pos:]...)
+					pending_offset = page_offset + pos
+					pending_length = length
+					break
+				}
+
+				if !emit(page_offset+pos, page[pos:pos+int64(length)]) {
+					return
+				}
+				pos = (pos + int64(length) + 7) &^ 7
+			}
+		}
+	}()
+
+	return output
+}
+
+// Open the $LogFile stream.
+func OpenLogFileStream(ntfs *NTFSContext) (RangeReaderAt, error) {
+	mft_entry, err := ntfs.GetMFT(LOGFILE_MFT_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenStream(ntfs, mft_entry, ATTR_TYPE_DATA,
+		WILDCARD_STREAM_ID, WILDCARD_STREAM_NAME)
+}
+
+// Open and parse the restart pages of the volume's $LogFile.
+func OpenLogFile(ntfs *NTFSContext) (*LogFile, error) {
+	stream, err := OpenLogFileStream(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(0)
+	for _, rng := range stream.Ranges() {
+		if rng.Offset+rng.Length > size {
+			size = rng.Offset + rng.Length
+		}
+	}
+
+	return NewLogFile(stream, size)
+}
+
+// Returns a channel which will send $LogFile records on.
+func ParseLogFile(ctx context.Context,
+	reader io.ReaderAt, size int64) (chan *LogRecord, error) {
+	log_file, err := NewLogFile(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return log_file.Records(ctx), nil
+}
@@ -0,0 +1,658 @@
+// Replay $LogFile records into higher level events.
+//
+// Each NTFS log record describes a low level change to a metadata
+// structure (e.g. overwrite some bytes in an MFT entry, insert an
+// index entry into a directory). By replaying the records in LSN
+// order we can follow the state of MFT entries through time and
+// recognize the common file system operations:
+//
+// - Created: An MFT entry is initialized (and usually a directory
+//   entry is added for it).
+// - Deleted: An MFT entry is deallocated.
+// - Renamed: A directory entry for an MFT entry is removed and a new
+//   one with a different name or parent is added in the same
+//   transaction.
+// - TimestampsChanged: The timestamps in $STANDARD_INFORMATION (or a
+//   $FILE_NAME) are overwritten. Since the undo data holds the
+//   previous timestamps, this reveals timestomping even when the
+//   $STANDARD_INFORMATION times are later than the $FILE_NAME times.
+//   The updated attribute is only known for entries initialized
+//   within the log, while the $FILE_NAME copies in index entries
+//   are always reported.
+// - Resized: The size of a non resident $DATA attribute is changed.
+//   Since the attribute type is only known from the entry's layout,
+//   this is only reported for entries initialized within the log.
+//
+// The log typically only covers the last few minutes to hours of
+// activity, so most MFT entries will have been created before the
+// log starts. We only know the layout of entries which were
+// initialized within the log, so changes to the attributes of other
+// entries are not reported.
+//
+// The records of concurrent transactions are interleaved in the log.
+// Records are collected per transaction id and a transaction's events
+// are emitted once it is committed (or forgotten).
+
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// NTFS log operations we interpret.
+const (
+	LOG_OP_INITIALIZE_FILE_RECORD_SEGMENT   = 0x02
+	LOG_OP_DEALLOCATE_FILE_RECORD_SEGMENT   = 0x03
+	LOG_OP_WRITE_END_OF_FILE_RECORD_SEGMENT = 0x04
+	LOG_OP_CREATE_ATTRIBUTE                 = 0x05
+	LOG_OP_DELETE_ATTRIBUTE                 = 0x06
+	LOG_OP_UPDATE_RESIDENT_VALUE            = 0x07
+	LOG_OP_UPDATE_MAPPING_PAIRS             = 0x09
+	LOG_OP_SET_NEW_ATTRIBUTE_SIZES          = 0x0B
+	LOG_OP_ADD_INDEX_ENTRY_ROOT             = 0x0C
+	LOG_OP_DELETE_INDEX_ENTRY_ROOT          = 0x0D
+	LOG_OP_ADD_INDEX_ENTRY_ALLOCATION       = 0x0E
+	LOG_OP_DELETE_INDEX_ENTRY_ALLOCATION    = 0x0F
+	LOG_OP_UPDATE_FILE_NAME_ROOT            = 0x13
+	LOG_OP_UPDATE_FILE_NAME_ALLOCATION      = 0x14
+	LOG_OP_COMMIT_TRANSACTION               = 0x1A
+	LOG_OP_FORGET_TRANSACTION               = 0x1B
+
+	// Timestamps are the first 32 bytes of both
+	// $STANDARD_INFORMATION and the duplicated information in
+	// index entries.
+	LOG_TIMESTAMPS_SIZE = 32
+)
+
+// A high level event recovered from the $LogFile.
+type LogFileEvent struct {
+	Lsn           uint64
+	TransactionId uint32
+	Event         string
+	Operation     string
+	MFTId         int64
+
+	SI_Before *TimeStamps   `json:"SI_Before,omitempty"`
+	SI_After  *TimeStamps   `json:"SI_After,omitempty"`
+	FN_Before *FilenameInfo `json:"FN_Before,omitempty"`
+	FN_After  *FilenameInfo `json:"FN_After,omitempty"`
+
+	SizeBefore int64 `json:"SizeBefore,omitempty"`
+	SizeAfter  int64 `json:"SizeAfter,omitempty"`
+
+	// Set when any timestamp was moved backwards - a strong
+	// indicator of timestomping.
+	Backdated bool `json:"Backdated,omitempty"`
+}
+
+type logIndexChange struct {
+	lsn       uint64
+	operation string
+	filename  *FilenameInfo
+}
+
+// The records of a single transaction.
+type logTransaction struct {
+	id          uint32
+	events      []*LogFileEvent
+	created     map[int64]*LogFileEvent
+	deallocated map[int64]*LogFileEvent
+	added       map[int64]*logIndexChange
+	removed     map[int64]*logIndexChange
+}
+
+func newLogTransaction(id uint32) *logTransaction {
+	return &logTransaction{
+		id:          id,
+		created:     make(map[int64]*LogFileEvent),
+		deallocated: make(map[int64]*LogFileEvent),
+		added:       make(map[int64]*logIndexChange),
+		removed:     make(map[int64]*logIndexChange),
+	}
+}
+
+// Combine the collected changes into events.
+func (self *logTransaction) Events() []*LogFileEvent {
+	result := append([]*LogFileEvent{}, self.events...)
+
+	for mft_id, event := range self.created {
+		added, pres := self.added[mft_id]
+		if event.FN_After == nil && pres {
+			event.FN_After = added.filename
+		}
+		result = append(result, event)
+	}
+
+	for mft_id, event := range self.deallocated {
+		removed, pres := self.removed[mft_id]
+		if pres {
+			event.FN_Before = removed.filename
+		}
+		result = append(result, event)
+	}
+
+	for mft_id, removed := range self.removed {
+		_, created := self.created[mft_id]
+		_, deallocated := self.deallocated[mft_id]
+		added, pres := self.added[mft_id]
+		if created || deallocated || !pres {
+			continue
+		}
+
+		// Index entries are also moved around when index
+		// nodes are split - this is not a rename.
+		if added.filename.Name == removed.filename.Name &&
+			added.filename.ParentEntryNumber == removed.filename.ParentEntryNumber {
+			continue
+		}
+
+		result = append(result, &LogFileEvent{
+			Lsn:           added.lsn,
+			TransactionId: self.id,
+			Event:         "Renamed",
+			Operation:     added.operation,
+			MFTId:         mft_id,
+			FN_Before:     removed.filename,
+			FN_After:      added.filename,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Lsn < result[j].Lsn
+	})
+
+	return result
+}
+
+// Replays log records (in LSN order) and tracks the state of MFT
+// entries.
+type LogFileReplayer struct {
+	cluster_size int64
+	record_size  int64
+
+	profile *NTFSProfile
+
+	// The reconstructed MFT entries (without fixups), for entries
+	// initialized within the log.
+	records map[int64][]byte
+
+	// The open transactions by transaction id.
+	transactions map[uint32]*logTransaction
+
+	// The transaction of the record being replayed.
+	transaction *logTransaction
+}
+
+func NewLogFileReplayer(cluster_size, record_size int64) *LogFileReplayer {
+	return &LogFileReplayer{
+		cluster_size: cluster_size,
+		record_size:  record_size,
+		profile:      NewNTFSProfile(),
+		records:      make(map[int64][]byte),
+		transactions: make(map[uint32]*logTransaction),
+	}
+}
+
+// The reconstructed MFT entry as of the last replayed record, or nil
+// if the entry was not initialized within the log.
+func (self *LogFileReplayer) RecordState(mft_id int64) []byte {
+	return self.records[mft_id]
+}
+
+// Flush the transactions which were not committed within the log and
+// return their events.
+func (self *LogFileReplayer) Flush() []*LogFileEvent {
+	result := []*LogFileEvent{}
+	for id, transaction := range self.transactions {
+		result = append(result, transaction.Events()...)
+		delete(self.transactions, id)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Lsn < result[j].Lsn
+	})
+	return result
+}
+
+// Replay the record and return the events of the transaction if the
+// record completes it.
+func (self *LogFileReplayer) Replay(record *LogRecord) []*LogFileEvent {
+	if record.RecordType != LOG_RECORD_TYPE_CLIENT {
+		return nil
+	}
+
+	transaction, pres := self.transactions[record.TransactionId]
+	if !pres {
+		transaction = newLogTransaction(record.TransactionId)
+		self.transactions[record.TransactionId] = transaction
+	}
+	self.transaction = transaction
+
+	switch record.RedoOp {
+	case LOG_OP_COMMIT_TRANSACTION, LOG_OP_FORGET_TRANSACTION:
+		delete(self.transactions, record.TransactionId)
+		return transaction.Events()
+
+	case LOG_OP_INITIALIZE_FILE_RECORD_SEGMENT:
+		self.replayInitialize(record)
+
+	case LOG_OP_DEALLOCATE_FILE_RECORD_SEGMENT:
+		self.replayDeallocate(record)
+
+	case LOG_OP_CREATE_ATTRIBUTE:
+		self.replayCreateAttribute(record)
+
+	case LOG_OP_DELETE_ATTRIBUTE:
+		self.replayDeleteAttribute(record)
+
+	case LOG_OP_UPDATE_RESIDENT_VALUE:
+		self.replayUpdateResidentValue(record)
+
+	case LOG_OP_WRITE_END_OF_FILE_RECORD_SEGMENT,
+		LOG_OP_UPDATE_MAPPING_PAIRS:
+		state := self.records[self.mftId(record)]
+		overwrite(state, int(record.RecordOffset)+
+			int(record.AttributeOffset), record.RedoData)
+
+	case LOG_OP_SET_NEW_ATTRIBUTE_SIZES:
+		self.replaySetNewAttributeSizes(record)
+
+	case LOG_OP_ADD_INDEX_ENTRY_ROOT, LOG_OP_ADD_INDEX_ENTRY_ALLOCATION:
+		self.replayIndexEntry(record, self.transaction.added)
+
+	case LOG_OP_DELETE_INDEX_ENTRY_ROOT, LOG_OP_DELETE_INDEX_ENTRY_ALLOCATION:
+		self.replayIndexEntry(record, self.transaction.removed)
+
+	case LOG_OP_UPDATE_FILE_NAME_ROOT, LOG_OP_UPDATE_FILE_NAME_ALLOCATION:
+		self.replayUpdateFileName(record)
+	}
+
+	return nil
+}
+
+func (self *LogFileReplayer) mftId(record *LogRecord) int64 {
+	return record.MFTEntryId(self.cluster_size, self.record_size)
+}
+
+func (self *LogFileReplayer) newEvent(
+	record *LogRecord, event string) *LogFileEvent {
+	return &LogFileEvent{
+		Lsn:           record.Lsn,
+		TransactionId: record.TransactionId,
+		Event:         event,
+		Operation:     record.RedoOperation,
+		MFTId:         self.mftId(record),
+	}
+}
+
+func (self *LogFileReplayer) replayInitialize(record *LogRecord) {
+	mft_id := self.mftId(record)
+	state := make([]byte, self.record_size)
+	overwrite(state, int(record.RecordOffset)+
+		int(record.AttributeOffset), record.RedoData)
+	self.records[mft_id] = state
+
+	event := self.newEvent(record, "Created")
+	event.SI_After, event.FN_After = self.recordNames(state)
+	self.transaction.created[mft_id] = event
+}
+
+func (self *LogFileReplayer) replayDeallocate(record *LogRecord) {
+	mft_id := self.mftId(record)
+	event := self.newEvent(record, "Deleted")
+
+	state, pres := self.records[mft_id]
+	if pres {
+		event.SI_Before, event.FN_Before = self.recordNames(state)
+		delete(self.records, mft_id)
+	}
+	self.transaction.deallocated[mft_id] = event
+}
+
+func (self *LogFileReplayer) replayCreateAttribute(record *LogRecord) {
+	mft_id := self.mftId(record)
+	state, pres := self.records[mft_id]
+	offset := int(record.RecordOffset)
+	if !pres || offset > len(state) {
+		return
+	}
+
+	// Make room for the new attribute.
+	updated := append([]byte{}, state[:offset]...)
+	updated = append(updated, record.RedoData...)
+	updated = append(updated, state[offset:]...)
+	self.records[mft_id] = updated[:len(state)]
+}
+
+func (self *LogFileReplayer) replayDeleteAttribute(record *LogRecord) {
+	mft_id := self.mftId(record)
+	state, pres := self.records[mft_id]
+	offset := int(record.RecordOffset)
+	if !pres || offset+8 > len(state) {
+		return
+	}
+
+	length := int(binary.LittleEndian.Uint32(state[offset+4:]))
+	if length == 0 || offset+length > len(state) {
+		return
+	}
+
+	updated := append([]byte{}, state[:offset]...)
+	updated = append(updated, state[offset+length:]...)
+	self.records[mft_id] = append(updated, make([]byte, length)...)
+}
+
+func (self *LogFileReplayer) replayUpdateResidentValue(record *LogRecord) {
+	mft_id := self.mftId(record)
+	state, pres := self.records[mft_id]
+
+	// Without the entry's layout we can not tell which attribute
+	// is updated.
+	if !pres {
+		return
+	}
+	attr_type, content_offset := attributeAt(state, int(record.RecordOffset))
+
+	// The offset of the change within the attribute content.
+	offset := int(record.AttributeOffset) - content_offset
+
+	record_offset := int(record.RecordOffset)
+	content := record_offset + content_offset
+
+	switch attr_type {
+	case ATTR_TYPE_STANDARD_INFORMATION:
+		if offset < LOG_TIMESTAMPS_SIZE && offset+len(record.RedoData) > 0 {
+			before := make([]byte, LOG_TIMESTAMPS_SIZE)
+			if content < len(state) {
+				copy(before, state[content:])
+			}
+			after := append([]byte{}, before...)
+			overwrite(before, offset, record.UndoData)
+			overwrite(after, offset, record.RedoData)
+
+			event := self.newEvent(record, "TimestampsChanged")
+			event.SI_Before = logTimeStamps(before)
+			event.SI_After = logTimeStamps(after)
+			self.addTimestampEvent(event, event.SI_Before, event.SI_After)
+		}
+
+	case ATTR_TYPE_FILE_NAME:
+		if offset >= 8 && offset < 8+LOG_TIMESTAMPS_SIZE &&
+			content+self.fileNameSize() <= len(state) {
+			before := append([]byte{}, state[content:]...)
+			after := append([]byte{}, before...)
+			overwrite(before, offset, record.UndoData)
+			overwrite(after, offset, record.RedoData)
+
+			event := self.newEvent(record, "TimestampsChanged")
+			event.FN_Before = self.parseFileName(before, 0)
+			event.FN_After = self.parseFileName(after, 0)
+			self.addTimestampEvent(event,
+				&event.FN_Before.Times, &event.FN_After.Times)
+		}
+	}
+
+	overwrite(state, record_offset+int(record.AttributeOffset),
+		record.RedoData)
+}
+
+// Record the event if the timestamps actually changed.
+func (self *LogFileReplayer) addTimestampEvent(
+	event *LogFileEvent, before, after *TimeStamps) {
+	if *before == *after {
+		return
+	}
+
+	event.Backdated = isBackdated(before, after)
+	self.transaction.events = append(self.transaction.events, event)
+}
+
+func (self *LogFileReplayer) replaySetNewAttributeSizes(record *LogRecord) {
+	mft_id := self.mftId(record)
+	state, pres := self.records[mft_id]
+	offset := int(record.RecordOffset)
+
+	// Without the entry's layout we can not tell a $DATA stream
+	// from an $INDEX_ALLOCATION or $BITMAP attribute.
+	if !pres {
+		return
+	}
+
+	// Only report the size of $DATA streams.
+	attr_type, _ := attributeAt(state, offset)
+	overwrite(state, offset+0x28, record.RedoData)
+	if attr_type != ATTR_TYPE_DATA {
+		return
+	}
+
+	// The sizes are allocated size, data size and initialized
+	// size.
+	if len(record.RedoData) < 16 || len(record.UndoData) < 16 {
+		return
+	}
+
+	event := self.newEvent(record, "Resized")
+	event.SizeBefore = int64(binary.LittleEndian.Uint64(record.UndoData[8:]))
+	event.SizeAfter = int64(binary.LittleEndian.Uint64(record.RedoData[8:]))
+	if event.SizeBefore != event.SizeAfter {
+		self.transaction.events = append(self.transaction.events, event)
+	}
+}
+
+func (self *LogFileReplayer) replayIndexEntry(
+	record *LogRecord, changes map[int64]*logIndexChange) {
+	// The index entry is in the redo data for additions and in
+	// the undo data for deletions.
+	data := record.RedoData
+	if len(data) == 0 {
+		data = record.UndoData
+	}
+
+	// An index entry has a 16 byte header before the $FILE_NAME.
+	if len(data) < 16+self.fileNameSize() {
+		return
+	}
+
+	mft_id := int64(binary.LittleEndian.Uint64(data) & 0xffffffffffff)
+	changes[mft_id] = &logIndexChange{
+		lsn:       record.Lsn,
+		operation: record.RedoOperation,
+		filename:  self.parseFileName(data, 16),
+	}
+}
+
+func (self *LogFileReplayer) replayUpdateFileName(record *LogRecord) {
+	// The data is the duplicated information in the index entry,
+	// starting with the timestamps. The affected MFT entry is not
+	// recorded (MFTId is -1) so we report the index entry's
+	// directory (for $INDEX_ROOT) instead.
+	if len(record.RedoData) < LOG_TIMESTAMPS_SIZE ||
+		len(record.UndoData) < LOG_TIMESTAMPS_SIZE {
+		return
+	}
+
+	event := self.newEvent(record, "TimestampsChanged")
+	event.MFTId = -1
+	event.FN_Before = &FilenameInfo{Times: *logTimeStamps(record.UndoData)}
+	event.FN_After = &FilenameInfo{Times: *logTimeStamps(record.RedoData)}
+
+	if record.RedoOp == LOG_OP_UPDATE_FILE_NAME_ROOT {
+		directory := uint64(self.mftId(record))
+		event.FN_Before.ParentEntryNumber = directory
+		event.FN_After.ParentEntryNumber = directory
+	}
+
+	self.addTimestampEvent(event,
+		&event.FN_Before.Times, &event.FN_After.Times)
+}
+
+// The size of a $FILE_NAME up to the name.
+func (self *LogFileReplayer) fileNameSize() int {
+	return int(self.profile.Off_FILE_NAME_name)
+}
+
+// Get the $STANDARD_INFORMATION times and the first $FILE_NAME of a
+// reconstructed MFT entry.
+func (self *LogFileReplayer) recordNames(state []byte) (
+	*TimeStamps, *FilenameInfo) {
+	var si *TimeStamps
+	var fn *FilenameInfo
+
+	walkAttributes(state, func(attr_type uint32, content []byte) {
+		switch attr_type {
+		case ATTR_TYPE_STANDARD_INFORMATION:
+			if si == nil {
+				si = logTimeStamps(content)
+			}
+		case ATTR_TYPE_FILE_NAME:
+			if fn == nil || fn.Type == "DOS" {
+				fn = self.parseFileName(content, 0)
+			}
+		}
+	})
+
+	return si, fn
+}
+
+func (self *LogFileReplayer) parseFileName(data []byte, offset int64) *FilenameInfo {
+	if int(offset)+self.fileNameSize() > len(data) {
+		return &FilenameInfo{}
+	}
+
+	filename := self.profile.FILE_NAME(bytes.NewReader(data), offset)
+	return &FilenameInfo{
+		Times:                *logTimeStamps(data[offset+8:]),
+		Type:                 filename.NameType().Name,
+		Name:                 filename.Name(),
+		ParentEntryNumber:    filename.MftReference(),
+		ParentSequenceNumber: filename.Seq_num(),
+	}
+}
+
+// Call cb with the type and content of each resident attribute in
+// the MFT entry.
+func walkAttributes(state []byte, cb func(attr_type uint32, content []byte)) {
+	if len(state) < 24 {
+		return
+	}
+
+	offset := int(binary.LittleEndian.Uint16(state[20:]))
+	for offset+24 <= len(state) {
+		attr_type := binary.LittleEndian.Uint32(state[offset:])
+		length := int(binary.LittleEndian.Uint32(state[offset+4:]))
+		if attr_type == 0xffffffff || length < 24 ||
+			offset+length > len(state) {
+			return
+		}
+
+		if state[offset+8] == 0 {
+			content_size := int(binary.LittleEndian.Uint32(state[offset+16:]))
+			content_offset := int(binary.LittleEndian.Uint16(state[offset+20:]))
+			if content_offset+content_size <= length {
+				cb(attr_type, state[offset+content_offset:offset+content_offset+content_size])
+			}
+		}
+
+		offset += length
+	}
+}
+
+// The type and content offset of the attribute at offset.
+func attributeAt(state []byte, offset int) (uint32, int) {
+	if offset+24 > len(state) {
+		return 0, 0
+	}
+	return binary.LittleEndian.Uint32(state[offset:]),
+		int(binary.LittleEndian.Uint16(state[offset+20:]))
+}
+
+// Overwrite the buffer at offset with data, ignoring out of bound
+// writes.
+func overwrite(buffer []byte, offset int, data []byte) {
+	if offset < 0 || offset >= len(buffer) {
+		return
+	}
+	copy(buffer[offset:], data)
+}
+
+func logTime(data []byte) time.Time {
+	filetime := binary.LittleEndian.Uint64(data)
+	if filetime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(filetimeToUnixtime(filetime))).UTC()
+}
+
+// Decode the four timestamps at the start of data.
+func logTimeStamps(data []byte) *TimeStamps {
+	if len(data) < LOG_TIMESTAMPS_SIZE {
+		return &TimeStamps{}
+	}
+	return &TimeStamps{
+		CreateTime:       logTime(data[0:]),
+		FileModifiedTime: logTime(data[8:]),
+		MFTModifiedTime:  logTime(data[16:]),
+		AccessedTime:     logTime(data[24:]),
+	}
+}
+
+func isBackdated(before, after *TimeStamps) bool {
+	pairs := [][2]time.Time{
+		{before.CreateTime, after.CreateTime},
+		{before.FileModifiedTime, after.FileModifiedTime},
+		{before.MFTModifiedTime, after.MFTModifiedTime},
+		{before.AccessedTime, after.AccessedTime},
+	}
+	for _, pair := range pairs {
+		if !pair[0].IsZero() && !pair[1].IsZero() && pair[1].Before(pair[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replay the whole log and send the events on the channel. Records
+// are replayed in LSN order, since the log is circular.
+func ParseLogFileEvents(ctx context.Context, log_file *LogFile,
+	cluster_size, record_size int64) chan *LogFileEvent {
+	output := make(chan *LogFileEvent)
+
+	go func() {
+		defer close(output)
+
+		records := []*LogRecord{}
+		for record := range log_file.Records(ctx) {
+			records = append(records, record)
+		}
+
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].Lsn < records[j].Lsn
+		})
+
+		replayer := NewLogFileReplayer(cluster_size, record_size)
+
+		send := func(events []*LogFileEvent) bool {
+			for _, event := range events {
+				select {
+				case <-ctx.Done():
+					return false
+				case output <- event:
+				}
+			}
+			return true
+		}
+
+		for _, record := range records {
+			if !send(replayer.Replay(record)) {
+				return
+			}
+		}
+		send(replayer.Flush())
+	}()
+
+	return output
+}
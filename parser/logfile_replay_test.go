@@ -0,0 +1,262 @@
+package parser
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const (
+	// Filetimes for 2020-01-01 and 2010-01-01
+	testFiletime2020 = 132223104000000000
+	testFiletime2010 = 129067776000000000
+
+	// The layout of the MFT entries built by testMFTRecord()
+	testFirstAttributeOffset  = 0x38
+	testResidentContentOffset = 0x18
+)
+
+func testTimestampBytes(filetime uint64) []byte {
+	result := make([]byte, LOG_TIMESTAMPS_SIZE)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(result[8*i:], filetime)
+	}
+	return result
+}
+
+func testResidentAttribute(attr_type uint32, content []byte) []byte {
+	length := (0x18 + len(content) + 7) &^ 7
+	result := make([]byte, length)
+	binary.LittleEndian.PutUint32(result[0:], attr_type)
+	binary.LittleEndian.PutUint32(result[4:], uint32(length))
+	binary.LittleEndian.PutUint32(result[16:], uint32(len(content)))
+	binary.LittleEndian.PutUint16(result[20:], 0x18)
+	copy(result[0x18:], content)
+	return result
+}
+
+func testNonResidentAttribute(attr_type uint32) []byte {
+	result := make([]byte, 0x48)
+	binary.LittleEndian.PutUint32(result[0:], attr_type)
+	binary.LittleEndian.PutUint32(result[4:], 0x48)
+	result[8] = 1
+	binary.LittleEndian.PutUint16(result[20:], 0x40)
+	return result
+}
+
+func testFileName(parent uint64, name string, filetime uint64) []byte {
+	result := make([]byte, 66)
+	binary.LittleEndian.PutUint64(result[0:], parent)
+	copy(result[8:], testTimestampBytes(filetime))
+	result[64] = byte(len(name))
+	result[65] = 1
+	return append(result, utf16LE(name)...)
+}
+
+func testMFTRecord(parent uint64, name string, filetime uint64) []byte {
+	record := make([]byte, testFirstAttributeOffset)
+	copy(record, "FILE")
+	binary.LittleEndian.PutUint16(record[20:], testFirstAttributeOffset)
+
+	si := append(testTimestampBytes(filetime), make([]byte, 0x28)...)
+	record = append(record, testResidentAttribute(
+		ATTR_TYPE_STANDARD_INFORMATION, si)...)
+	record = append(record, testResidentAttribute(
+		ATTR_TYPE_FILE_NAME, testFileName(parent, name, filetime))...)
+	record = append(record, testNonResidentAttribute(ATTR_TYPE_DATA)...)
+	return append(record, 0xff, 0xff, 0xff, 0xff)
+}
+
+// The offset of the $DATA attribute in testMFTRecord()
+func testDataAttributeOffset(name string) uint16 {
+	return uint16(len(testMFTRecord(5, name, testFiletime2020)) - 0x48 - 4)
+}
+
+func testIndexEntry(mft_id, parent uint64, name string) []byte {
+	result := make([]byte, 16)
+	binary.LittleEndian.PutUint64(result, mft_id)
+	return append(result, testFileName(parent, name, testFiletime2020)...)
+}
+
+func TestLogFileReplay(t *testing.T) {
+	replayer := NewLogFileReplayer(4096, 1024)
+
+	// MFT entry 41 lives in the second half of VCN 10.
+	records := []*LogRecord{{
+		Lsn: 1, TransactionId: 0x18, TargetVcn: 10, ClusterBlockOffset: 2,
+		RedoOp:   LOG_OP_INITIALIZE_FILE_RECORD_SEGMENT,
+		RedoData: testMFTRecord(5, "new.txt", testFiletime2020),
+	}, {
+		Lsn: 2, TransactionId: 0x18, TargetVcn: 1,
+		RedoOp:   LOG_OP_ADD_INDEX_ENTRY_ROOT,
+		RedoData: testIndexEntry(41, 5, "new.txt"),
+	}, {
+		// Rename in a new transaction.
+		Lsn: 3, TransactionId: 0x40, TargetVcn: 1,
+		RedoOp:   LOG_OP_DELETE_INDEX_ENTRY_ROOT,
+		UndoData: testIndexEntry(41, 5, "new.txt"),
+	}, {
+		Lsn: 4, TransactionId: 0x40, TargetVcn: 1,
+		RedoOp:   LOG_OP_ADD_INDEX_ENTRY_ROOT,
+		RedoData: testIndexEntry(41, 5, "renamed.txt"),
+	}, {
+		// Timestomp the entry's $STANDARD_INFORMATION
+		Lsn: 5, TransactionId: 0x18, TargetVcn: 10, ClusterBlockOffset: 2,
+		RedoOp:          LOG_OP_UPDATE_RESIDENT_VALUE,
+		RecordOffset:    testFirstAttributeOffset,
+		AttributeOffset: testResidentContentOffset,
+		RedoData:        testTimestampBytes(testFiletime2010),
+		UndoData:        testTimestampBytes(testFiletime2020),
+	}, {
+		// Entry 42 was not initialized within the log so the
+		// updated attribute is not known.
+		Lsn: 6, TransactionId: 0x20, TargetVcn: 10, ClusterBlockOffset: 4,
+		RedoOp:          LOG_OP_UPDATE_RESIDENT_VALUE,
+		RecordOffset:    testFirstAttributeOffset,
+		AttributeOffset: testResidentContentOffset + 8,
+		RedoData:        testTimestampBytes(testFiletime2020)[:8],
+		UndoData:        testTimestampBytes(testFiletime2010)[:8],
+	}, {
+		// The attribute type of entry 42 is not known so the
+		// resize is not reported.
+		Lsn: 7, TransactionId: 0x28, TargetVcn: 10, ClusterBlockOffset: 4,
+		RedoOp:   LOG_OP_SET_NEW_ATTRIBUTE_SIZES,
+		RedoData: make([]byte, 24),
+		UndoData: []byte{0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0x10, 0, 0, 0, 0, 0, 0},
+	}, {
+		// Resizing an attribute other than $DATA is not reported.
+		Lsn: 8, TransactionId: 0x28, TargetVcn: 10, ClusterBlockOffset: 2,
+		RedoOp:       LOG_OP_SET_NEW_ATTRIBUTE_SIZES,
+		RecordOffset: testFirstAttributeOffset,
+		RedoData:     make([]byte, 24),
+		UndoData:     []byte{0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0x10, 0, 0, 0, 0, 0, 0},
+	}, {
+		Lsn: 9, TransactionId: 0x28, TargetVcn: 10, ClusterBlockOffset: 2,
+		RedoOp:       LOG_OP_SET_NEW_ATTRIBUTE_SIZES,
+		RecordOffset: testDataAttributeOffset("new.txt"),
+		RedoData:     make([]byte, 24),
+		UndoData:     []byte{0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0x10, 0, 0, 0, 0, 0, 0},
+	}, {
+		Lsn: 10, TransactionId: 0x30, TargetVcn: 1,
+		RedoOp:   LOG_OP_DELETE_INDEX_ENTRY_ROOT,
+		UndoData: testIndexEntry(41, 5, "renamed.txt"),
+	}, {
+		Lsn: 11, TransactionId: 0x30, TargetVcn: 10, ClusterBlockOffset: 2,
+		RedoOp: LOG_OP_DEALLOCATE_FILE_RECORD_SEGMENT,
+	}}
+
+	events := []*LogFileEvent{}
+	for _, record := range records {
+		record.RecordType = LOG_RECORD_TYPE_CLIENT
+		record.RedoOperation = LogOperationName(record.RedoOp)
+		events = append(events, replayer.Replay(record)...)
+	}
+	events = append(events, replayer.Flush()...)
+
+	expected := []string{"Created", "Renamed", "TimestampsChanged",
+		"Resized", "Deleted"}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v events, got %v", len(expected), len(events))
+	}
+
+	for i, event := range events {
+		if event.Event != expected[i] {
+			t.Fatalf("Event %v: expected %v, got %+v", i, expected[i], event)
+		}
+	}
+
+	created := events[0]
+	if created.MFTId != 41 || created.FN_After.Name != "new.txt" ||
+		created.FN_After.ParentEntryNumber != 5 ||
+		created.SI_After.CreateTime.Year() != 2020 {
+		t.Fatalf("Unexpected created event %+v", created)
+	}
+
+	renamed := events[1]
+	if renamed.MFTId != 41 || renamed.FN_Before.Name != "new.txt" ||
+		renamed.FN_After.Name != "renamed.txt" {
+		t.Fatalf("Unexpected renamed event %+v", renamed)
+	}
+
+	stomped := events[2]
+	if stomped.MFTId != 41 || !stomped.Backdated ||
+		stomped.SI_Before.CreateTime.Year() != 2020 ||
+		stomped.SI_After.CreateTime.Year() != 2010 {
+		t.Fatalf("Unexpected timestamp event %+v", stomped)
+	}
+
+	resized := events[3]
+	if resized.MFTId != 41 || resized.SizeBefore != 0x1000 ||
+		resized.SizeAfter != 0 {
+		t.Fatalf("Unexpected resize event %+v", resized)
+	}
+
+	deleted := events[4]
+	if deleted.MFTId != 41 || deleted.FN_Before.Name != "renamed.txt" ||
+		deleted.SI_Before.CreateTime.Year() != 2010 {
+		t.Fatalf("Unexpected deleted event %+v", deleted)
+	}
+
+	if replayer.RecordState(41) != nil {
+		t.Fatalf("Deallocated entry should have no state")
+	}
+}
+
+func TestLogFileReplayInterleavedTransactions(t *testing.T) {
+	replayer := NewLogFileReplayer(4096, 1024)
+
+	// Transaction 0x10 creates entry 41 while transaction 0x20
+	// renames entry 50.
+	records := []*LogRecord{{
+		Lsn: 1, TransactionId: 0x10, TargetVcn: 10, ClusterBlockOffset: 2,
+		RedoOp:   LOG_OP_INITIALIZE_FILE_RECORD_SEGMENT,
+		RedoData: testMFTRecord(5, "new.txt", testFiletime2020),
+	}, {
+		Lsn: 2, TransactionId: 0x20, TargetVcn: 1,
+		RedoOp:   LOG_OP_DELETE_INDEX_ENTRY_ROOT,
+		UndoData: testIndexEntry(50, 5, "a.txt"),
+	}, {
+		Lsn: 3, TransactionId: 0x10, TargetVcn: 1,
+		RedoOp:   LOG_OP_ADD_INDEX_ENTRY_ROOT,
+		RedoData: testIndexEntry(41, 5, "new.txt"),
+	}, {
+		Lsn: 4, TransactionId: 0x20, TargetVcn: 1,
+		RedoOp:   LOG_OP_ADD_INDEX_ENTRY_ROOT,
+		RedoData: testIndexEntry(50, 5, "b.txt"),
+	}, {
+		Lsn: 5, TransactionId: 0x10,
+		RedoOp: LOG_OP_FORGET_TRANSACTION,
+	}, {
+		Lsn: 6, TransactionId: 0x20,
+		RedoOp: LOG_OP_COMMIT_TRANSACTION,
+	}}
+
+	events := [][]*LogFileEvent{}
+	for _, record := range records {
+		record.RecordType = LOG_RECORD_TYPE_CLIENT
+		record.RedoOperation = LogOperationName(record.RedoOp)
+		events = append(events, replayer.Replay(record))
+	}
+
+	// Events are only emitted when the transaction completes.
+	for i := 0; i < 4; i++ {
+		if len(events[i]) != 0 {
+			t.Fatalf("Record %v: unexpected events %+v", i, events[i])
+		}
+	}
+
+	if len(events[4]) != 1 || events[4][0].Event != "Created" ||
+		events[4][0].MFTId != 41 {
+		t.Fatalf("Unexpected events %+v", events[4])
+	}
+
+	if len(events[5]) != 1 || events[5][0].Event != "Renamed" ||
+		events[5][0].MFTId != 50 ||
+		events[5][0].FN_Before.Name != "a.txt" ||
+		events[5][0].FN_After.Name != "b.txt" {
+		t.Fatalf("Unexpected events %+v", events[5])
+	}
+
+	if flushed := replayer.Flush(); len(flushed) != 0 {
+		t.Fatalf("Unexpected events %+v", flushed)
+	}
+}
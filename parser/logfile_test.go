@@ -0,0 +1,208 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+const (
+	testLogPageSize = 0x1000
+	testLogSeqBits  = 51
+)
+
+// Protect the page with an update sequence array at usa_offset.
+func protectLogPage(page []byte, usa_offset int) {
+	usa_count := len(page)/512 + 1
+	binary.LittleEndian.PutUint16(page[4:], uint16(usa_offset))
+	binary.LittleEndian.PutUint16(page[6:], uint16(usa_count))
+	binary.LittleEndian.PutUint16(page[usa_offset:], 0x4242)
+
+	for i := 1; i < usa_count; i++ {
+		sector_end := i*512 - 2
+		copy(page[usa_offset+2*i:], page[sector_end:sector_end+2])
+		binary.LittleEndian.PutUint16(page[sector_end:], 0x4242)
+	}
+}
+
+func testLsn(seq, offset uint64) uint64 {
+	return seq<<(64-testLogSeqBits) | offset>>3
+}
+
+func buildTestRestartPage(current_lsn uint64, size int) []byte {
+	page := make([]byte, testLogPageSize)
+	copy(page, "RSTR")
+	binary.LittleEndian.PutUint32(page[16:], testLogPageSize)
+	binary.LittleEndian.PutUint32(page[20:], testLogPageSize)
+	binary.LittleEndian.PutUint16(page[24:], 0x40)
+	binary.LittleEndian.PutUint16(page[26:], 1)
+	binary.LittleEndian.PutUint16(page[28:], 1)
+
+	area := page[0x40:]
+	binary.LittleEndian.PutUint64(area[0:], current_lsn)
+	binary.LittleEndian.PutUint16(area[8:], 1)
+	binary.LittleEndian.PutUint32(area[16:], testLogSeqBits)
+	binary.LittleEndian.PutUint16(area[22:], 0x30)
+	binary.LittleEndian.PutUint64(area[24:], uint64(size))
+	binary.LittleEndian.PutUint16(area[36:], LOG_RECORD_HEADER_SIZE)
+	binary.LittleEndian.PutUint16(area[38:], 0x40)
+
+	client := area[0x30:]
+	binary.LittleEndian.PutUint32(client[28:], 8)
+	copy(client[32:], utf16LE("NTFS"))
+
+	protectLogPage(page, 0x1e)
+	return page
+}
+
+func utf16LE(s string) []byte {
+	result := []byte{}
+	for _, c := range s {
+		result = append(result, byte(c), byte(c>>8))
+	}
+	return result
+}
+
+func buildTestLogRecord(lsn uint64, redo_op, undo_op uint16,
+	redo_data []byte, vcn uint64, lcns ...uint64) []byte {
+	client := make([]byte, NTFS_LOG_RECORD_HEADER_SIZE+8*len(lcns))
+	binary.LittleEndian.PutUint16(client[0:], redo_op)
+	binary.LittleEndian.PutUint16(client[2:], undo_op)
+	binary.LittleEndian.PutUint16(client[4:], uint16(len(client)))
+	binary.LittleEndian.PutUint16(client[6:], uint16(len(redo_data)))
+	binary.LittleEndian.PutUint16(client[12:], 0x18)
+	binary.LittleEndian.PutUint16(client[14:], uint16(len(lcns)))
+	binary.LittleEndian.PutUint16(client[20:], 2)
+	binary.LittleEndian.PutUint64(client[24:], vcn)
+	for i, lcn := range lcns {
+		binary.LittleEndian.PutUint64(
+			client[NTFS_LOG_RECORD_HEADER_SIZE+8*i:], lcn)
+	}
+	client = append(client, redo_data...)
+
+	header := make([]byte, LOG_RECORD_HEADER_SIZE)
+	binary.LittleEndian.PutUint64(header[0:], lsn)
+	binary.LittleEndian.PutUint32(header[24:], uint32(len(client)))
+	binary.LittleEndian.PutUint32(header[32:], LOG_RECORD_TYPE_CLIENT)
+	binary.LittleEndian.PutUint32(header[36:], 0x18)
+	return append(header, client...)
+}
+
+// Mark the page as a record page whose last record ends at
+// last_end_lsn. Tail copies record the offset of the page they copy.
+func setTestLogPage(page []byte, copy_offset, last_end_lsn uint64) {
+	copy(page, "RCRD")
+	binary.LittleEndian.PutUint64(page[8:], copy_offset)
+	binary.LittleEndian.PutUint64(page[0x20:], last_end_lsn)
+}
+
+func buildTestLogFile() []byte {
+	size := 8 * testLogPageSize
+	data := make([]byte, size)
+	copy(data, buildTestRestartPage(testLsn(1, 0x4040), size))
+	copy(data[testLogPageSize:],
+		buildTestRestartPage(testLsn(1, 0x4000), size))
+
+	// A small record at the start of the first log page (after the
+	// two tail copies).
+	copy(data[0x4040:], buildTestLogRecord(testLsn(1, 0x4040),
+		0x02, 0x03, []byte("FILE0"), 4, 0x100))
+
+	// A record spanning into the next page continues after the
+	// next page's header.
+	offset := uint64(0x5000 - 0x48)
+	record := buildTestLogRecord(testLsn(1, offset),
+		0x07, 0x07, bytes.Repeat([]byte{0xaa}, 0x100), 8)
+	copy(data[offset:0x5000], record)
+	copy(data[0x5040:], record[0x48:])
+
+	setTestLogPage(data[0x4000:], testLsn(1, 0x4040), testLsn(1, 0x4040))
+	setTestLogPage(data[0x5000:], testLsn(1, offset), testLsn(1, offset))
+
+	// A record from a previous wrap of the log file, which is
+	// still valid.
+	copy(data[0x6040:], buildTestLogRecord(testLsn(0, 0x6040),
+		0x1a, 0x00, nil, 0))
+
+	// A record whose LSN does not refer to its location is
+	// ignored.
+	copy(data[0x6100:], buildTestLogRecord(testLsn(1, 0x4040),
+		0x00, 0x00, nil, 0))
+	setTestLogPage(data[0x6000:], testLsn(0, 0x6040), testLsn(0, 0x6040))
+	setTestLogPage(data[0x7000:], 0, 0)
+
+	// The first tail copy is newer than the page it copies and
+	// replaces it. The second copy is the same as the first log
+	// page and must not produce duplicate records.
+	copy(data[0x2040:], buildTestLogRecord(testLsn(1, 0x6040),
+		0x1b, 0x00, nil, 0))
+	setTestLogPage(data[0x2000:], 0x6000, testLsn(1, 0x6040))
+
+	copy(data[0x3000:0x4000], data[0x4000:0x5000])
+	setTestLogPage(data[0x3000:], 0x4000, testLsn(1, 0x4040))
+
+	for page := 2 * testLogPageSize; page < size; page += testLogPageSize {
+		protectLogPage(data[page:page+testLogPageSize], 0x28)
+	}
+
+	return data
+}
+
+func TestLogFile(t *testing.T) {
+	data := buildTestLogFile()
+	log_file, err := NewLogFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log_file.RestartPages) != 2 ||
+		log_file.Restart.CurrentLsn != testLsn(1, 0x4040) ||
+		len(log_file.Restart.Clients) != 1 ||
+		log_file.Restart.Clients[0].Name != "NTFS" {
+		t.Fatalf("Unexpected restart page %+v", log_file.Restart)
+	}
+
+	records := []*LogRecord{}
+	for record := range log_file.Records(context.Background()) {
+		records = append(records, record)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %v", len(records))
+	}
+
+	first := records[0]
+	if first.Offset != 0x4040 ||
+		first.RedoOperation != "InitializeFileRecordSegment" ||
+		first.UndoOperation != "DeallocateFileRecordSegment" ||
+		string(first.RedoData) != "FILE0" ||
+		len(first.TargetLcns) != 1 || first.TargetLcns[0] != 0x100 ||
+		first.MFTEntryId(4096, 1024) != 17 {
+		t.Fatalf("Unexpected record %+v", first)
+	}
+
+	// The spanning record must be reassembled across the page
+	// (and the fixups restored).
+	second := records[1]
+	if second.Offset != 0x5000-0x48 ||
+		second.RedoOperation != "UpdateResidentValue" ||
+		!bytes.Equal(second.RedoData, bytes.Repeat([]byte{0xaa}, 0x100)) {
+		t.Fatalf("Unexpected record %+v", second)
+	}
+
+	// The newer tail copy replaces the record from the previous
+	// wrap.
+	if records[2].Offset != 0x6040 || records[2].Lsn != testLsn(1, 0x6040) ||
+		records[2].RedoOperation != "ForgetTransaction" {
+		t.Fatalf("Unexpected record %+v", records[2])
+	}
+}
+
+func TestLogFileInvalid(t *testing.T) {
+	data := make([]byte, 4*testLogPageSize)
+	_, err := NewLogFile(bytes.NewReader(data), int64(len(data)))
+	if err != notLogFileError {
+		t.Fatalf("Expected error, got %v", err)
+	}
+}
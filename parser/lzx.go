@@ -0,0 +1,452 @@
+/*
+Decompression support for the LZX compression algorithm as used by WIM
+images and the Windows Overlay Filter (WOF) "LZX" system compression.
+
+Reference:
+https://learn.microsoft.com/en-us/openspecs/exchange_server_protocols/ms-patch/
+(LZX DELTA Compression and Decompression)
+
+https://wimlib.net/ (src/lzx_decompress.c)
+
+Each chunk is compressed independently with a 32kb window, so the
+Huffman code lengths and the recent offsets are reset at the start of
+every chunk.
+
+The chunk is a sequence of blocks. Each block starts with a 3 bit
+block type (1 = verbatim, 2 = aligned offset, 3 = uncompressed)
+followed by a single bit which, when set, means the block is the
+default size of 32768 bytes. Otherwise the block size follows in the
+next 16 bits.
+
+The bitstream consists of LE16 words read most-significant bit first.
+
+Aligned offset blocks start with 8 3-bit aligned offset code lengths.
+Verbatim and aligned blocks then contain the main code lengths (in two
+parts: the 256 literals and the match headers) followed by the length
+code lengths. Each group of code lengths is delta coded against the
+previous lengths using a 20 symbol pretree which is itself stored as 20
+4-bit lengths.
+
+Main symbols below 256 are literals. Larger symbols encode a match
+header: the low 3 bits are the length header (7 meaning an extra
+symbol from the length code follows) and the rest is the offset
+slot. Slots 0-2 refer to the three recent offsets.
+
+Uncompressed blocks are aligned to the next 16 bit boundary (16 bits
+are skipped if the stream is already aligned), followed by the three
+recent offsets as LE32 values, the raw data and a pad byte if the
+block size is odd.
+
+Finally the x86 call instruction (E8) translation performed by the
+compressor is undone.
+*/
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	lzxCorruptStreamError = errors.New("LZX: corrupt stream")
+	lzxInvalidCodeError   = errors.New("LZX: invalid Huffman code")
+)
+
+const (
+	lzxBlockTypeVerbatim     = 1
+	lzxBlockTypeAligned      = 2
+	lzxBlockTypeUncompressed = 3
+
+	lzxDefaultBlockSize = 32768
+	lzxNumChars         = 256
+	lzxNumLenHeaders    = 8
+	lzxMinMatchLen      = 2
+	lzxLenCodeSymbols   = 249
+	lzxPreCodeSymbols   = 20
+	lzxAlignedSymbols   = 8
+	lzxMaxCodeLen       = 16
+
+	// WOF and WIM use a 32kb window which needs 30 offset slots.
+	lzxNumOffsetSlots  = 30
+	lzxMainCodeSymbols = lzxNumChars + lzxNumOffsetSlots*lzxNumLenHeaders
+
+	// The file size the compressor assumes for E8 translation.
+	lzxE8FileSize = 12000000
+)
+
+var (
+	lzxExtraOffsetBits = [lzxNumOffsetSlots]uint32{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13,
+	}
+
+	lzxOffsetSlotBase = [lzxNumOffsetSlots]uint32{
+		0, 1, 2, 3, 4, 6, 8, 12, 16, 24, 32, 48, 64, 96, 128, 192,
+		256, 384, 512, 768, 1024, 1536, 2048, 3072, 4096, 6144,
+		8192, 12288, 16384, 24576,
+	}
+)
+
+// Reads LE16 words most-significant bit first. Reading past the end
+// of the input produces zero bits - the output size bounds decoding.
+type lzxBitReader struct {
+	in []byte
+
+	// Offset of the next word to load.
+	pos int
+
+	// The currently loaded word and the number of bits left in it.
+	word      uint32
+	word_bits uint32
+}
+
+func (self *lzxBitReader) readBit() uint32 {
+	if self.word_bits == 0 {
+		self.word = 0
+		if self.pos+1 < len(self.in) {
+			self.word = uint32(binary.LittleEndian.Uint16(self.in[self.pos:]))
+		}
+		self.pos += 2
+		self.word_bits = 16
+	}
+	self.word_bits--
+	return (self.word >> self.word_bits) & 1
+}
+
+func (self *lzxBitReader) readBits(count uint32) uint32 {
+	result := uint32(0)
+	for i := uint32(0); i < count; i++ {
+		result = result<<1 | self.readBit()
+	}
+	return result
+}
+
+// Skip to the next 16 bit boundary. If we are already aligned the
+// next word is skipped.
+func (self *lzxBitReader) align() {
+	if self.word_bits == 0 {
+		self.pos += 2
+	}
+	self.word_bits = 0
+}
+
+func (self *lzxBitReader) readUint32() (uint32, error) {
+	if self.pos+4 > len(self.in) {
+		return 0, lzxCorruptStreamError
+	}
+	result := binary.LittleEndian.Uint32(self.in[self.pos:])
+	self.pos += 4
+	return result, nil
+}
+
+// A canonical Huffman decoder. Codes are assigned in (length,
+// symbol) order so we only need to count the codes of each length.
+type lzxHuffman struct {
+	counts  [lzxMaxCodeLen + 1]uint32
+	symbols []uint16
+}
+
+func newLZXHuffman(lens []uint8) (*lzxHuffman, error) {
+	result := &lzxHuffman{}
+	for _, l := range lens {
+		if l > lzxMaxCodeLen {
+			return nil, lzxInvalidCodeError
+		}
+		result.counts[l]++
+	}
+	result.counts[0] = 0
+
+	// Make sure the code is not oversubscribed.
+	left := 1
+	for l := 1; l <= lzxMaxCodeLen; l++ {
+		left <<= 1
+		left -= int(result.counts[l])
+		if left < 0 {
+			return nil, lzxInvalidCodeError
+		}
+	}
+
+	for l := 1; l <= lzxMaxCodeLen; l++ {
+		for s, sym_len := range lens {
+			if int(sym_len) == l {
+				result.symbols = append(result.symbols, uint16(s))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (self *lzxHuffman) decode(reader *lzxBitReader) (uint16, error) {
+	code := uint32(0)
+	first := uint32(0)
+	index := uint32(0)
+
+	for l := 1; l <= lzxMaxCodeLen; l++ {
+		code |= reader.readBit()
+		count := self.counts[l]
+		if code-first < count {
+			return self.symbols[index+code-first], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+
+	return 0, lzxInvalidCodeError
+}
+
+// Read a group of delta coded lengths using the pretree.
+func lzxReadCodeLens(reader *lzxBitReader, lens []uint8) error {
+	pre_lens := make([]uint8, lzxPreCodeSymbols)
+	for i := range pre_lens {
+		pre_lens[i] = uint8(reader.readBits(4))
+	}
+
+	pretree, err := newLZXHuffman(pre_lens)
+	if err != nil {
+		return err
+	}
+
+	delta := func(prev uint8, presym uint16) uint8 {
+		return uint8((int(prev) - int(presym) + 17) % 17)
+	}
+
+	for i := 0; i < len(lens); {
+		presym, err := pretree.decode(reader)
+		if err != nil {
+			return err
+		}
+
+		run_len := 1
+		value := uint8(0)
+
+		switch presym {
+		case 17:
+			run_len = 4 + int(reader.readBits(4))
+		case 18:
+			run_len = 20 + int(reader.readBits(5))
+		case 19:
+			run_len = 4 + int(reader.readBits(1))
+			presym, err = pretree.decode(reader)
+			if err != nil {
+				return err
+			}
+			if presym > 16 {
+				return lzxCorruptStreamError
+			}
+			value = delta(lens[i], presym)
+		default:
+			value = delta(lens[i], presym)
+		}
+
+		for ; run_len > 0 && i < len(lens); run_len-- {
+			lens[i] = value
+			i++
+		}
+	}
+
+	return nil
+}
+
+// Undo the x86 call translation the compressor performed.
+func lzxUndoE8Translation(out []byte) {
+	if len(out) <= 10 {
+		return
+	}
+
+	for i := 0; i < len(out)-10; {
+		if out[i] != 0xE8 {
+			i++
+			continue
+		}
+
+		abs_offset := int32(binary.LittleEndian.Uint32(out[i+1:]))
+		if abs_offset >= 0 {
+			if abs_offset < lzxE8FileSize {
+				binary.LittleEndian.PutUint32(out[i+1:],
+					uint32(abs_offset-int32(i)))
+			}
+		} else if abs_offset >= -int32(i) {
+			binary.LittleEndian.PutUint32(out[i+1:],
+				uint32(abs_offset+lzxE8FileSize))
+		}
+		i += 5
+	}
+}
+
+// LZXDecompress decompresses a single LZX chunk with a 32kb window.
+// The uncompressed size must be known in advance.
+func LZXDecompress(in []byte, decompressed_size int) ([]byte, error) {
+	if decompressed_size > lzxDefaultBlockSize {
+		return nil, compressionTooLarge
+	}
+
+	reader := &lzxBitReader{in: in}
+	out := make([]byte, 0, decompressed_size)
+
+	main_lens := make([]uint8, lzxMainCodeSymbols)
+	len_lens := make([]uint8, lzxLenCodeSymbols)
+	aligned_lens := make([]uint8, lzxAlignedSymbols)
+	recent_offsets := [3]uint32{1, 1, 1}
+
+	for len(out) < decompressed_size {
+		block_type := reader.readBits(3)
+		block_size := lzxDefaultBlockSize
+		if reader.readBit() == 0 {
+			block_size = int(reader.readBits(16))
+		}
+
+		if block_size == 0 {
+			return nil, lzxCorruptStreamError
+		}
+
+		// The last block may be larger than the output.
+		if block_size > decompressed_size-len(out) {
+			block_size = decompressed_size - len(out)
+		}
+
+		switch block_type {
+		case lzxBlockTypeUncompressed:
+			reader.align()
+			for i := range recent_offsets {
+				value, err := reader.readUint32()
+				if err != nil {
+					return nil, err
+				}
+				recent_offsets[i] = value
+			}
+
+			if reader.pos+block_size > len(in) {
+				return nil, lzxCorruptStreamError
+			}
+			out = append(out, in[reader.pos:reader.pos+block_size]...)
+			reader.pos += block_size
+
+			// Uncompressed blocks are padded to 16 bits.
+			if block_size%2 == 1 {
+				reader.pos++
+			}
+			continue
+
+		case lzxBlockTypeAligned:
+			for i := range aligned_lens {
+				aligned_lens[i] = uint8(reader.readBits(3))
+			}
+			fallthrough
+
+		case lzxBlockTypeVerbatim:
+			err := lzxReadCodeLens(reader, main_lens[:lzxNumChars])
+			if err != nil {
+				return nil, err
+			}
+
+			err = lzxReadCodeLens(reader, main_lens[lzxNumChars:])
+			if err != nil {
+				return nil, err
+			}
+
+			err = lzxReadCodeLens(reader, len_lens)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, lzxCorruptStreamError
+		}
+
+		main_code, err := newLZXHuffman(main_lens)
+		if err != nil {
+			return nil, err
+		}
+
+		len_code, err := newLZXHuffman(len_lens)
+		if err != nil {
+			return nil, err
+		}
+
+		var aligned_code *lzxHuffman
+		if block_type == lzxBlockTypeAligned {
+			aligned_code, err = newLZXHuffman(aligned_lens)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		block_end := len(out) + block_size
+		for len(out) < block_end {
+			main_sym, err := main_code.decode(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			if main_sym < lzxNumChars {
+				out = append(out, byte(main_sym))
+				continue
+			}
+
+			main_sym -= lzxNumChars
+			match_len := uint32(main_sym % lzxNumLenHeaders)
+			offset_slot := uint32(main_sym / lzxNumLenHeaders)
+
+			if match_len == lzxNumLenHeaders-1 {
+				len_sym, err := len_code.decode(reader)
+				if err != nil {
+					return nil, err
+				}
+				match_len += uint32(len_sym)
+			}
+			match_len += lzxMinMatchLen
+
+			var match_offset uint32
+			switch offset_slot {
+			case 0:
+				match_offset = recent_offsets[0]
+
+			case 1, 2:
+				match_offset = recent_offsets[offset_slot]
+				recent_offsets[offset_slot] = recent_offsets[0]
+				recent_offsets[0] = match_offset
+
+			default:
+				extra_bits := lzxExtraOffsetBits[offset_slot]
+				match_offset = lzxOffsetSlotBase[offset_slot]
+
+				if aligned_code != nil && extra_bits >= 3 {
+					match_offset += reader.readBits(extra_bits-3) << 3
+					aligned_sym, err := aligned_code.decode(reader)
+					if err != nil {
+						return nil, err
+					}
+					match_offset += uint32(aligned_sym)
+
+				} else {
+					match_offset += reader.readBits(extra_bits)
+				}
+
+				// Formatted offsets are biased by 2.
+				match_offset -= 2
+
+				recent_offsets[2] = recent_offsets[1]
+				recent_offsets[1] = recent_offsets[0]
+				recent_offsets[0] = match_offset
+			}
+
+			if match_offset == 0 || int(match_offset) > len(out) ||
+				len(out)+int(match_len) > block_end {
+				return nil, lzxCorruptStreamError
+			}
+
+			start := len(out) - int(match_offset)
+			for j := 0; j < int(match_len); j++ {
+				out = append(out, out[start+j])
+			}
+		}
+	}
+
+	lzxUndoE8Translation(out)
+
+	return out, nil
+}
@@ -0,0 +1,409 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// A minimal LZX encoder used to produce test vectors. It does not
+// build Huffman codes from the symbol frequencies - every code is a
+// complete code where all symbols have (nearly) the same length.
+type lzxBitWriter struct {
+	out   []byte
+	word  uint16
+	nbits uint
+}
+
+func (self *lzxBitWriter) writeBits(value uint32, count uint) {
+	for i := int(count) - 1; i >= 0; i-- {
+		self.word = self.word<<1 | uint16((value>>uint(i))&1)
+		self.nbits++
+		if self.nbits == 16 {
+			self.out = append(self.out, byte(self.word), byte(self.word>>8))
+			self.word = 0
+			self.nbits = 0
+		}
+	}
+}
+
+// Pad to the next 16 bit boundary (16 bits if already aligned).
+func (self *lzxBitWriter) align() {
+	if self.nbits == 0 {
+		self.writeBits(0, 16)
+		return
+	}
+	self.writeBits(0, 16-self.nbits)
+}
+
+func (self *lzxBitWriter) flush() []byte {
+	if self.nbits > 0 {
+		self.writeBits(0, 16-self.nbits)
+	}
+	return self.out
+}
+
+type lzxTestCode struct {
+	codes []uint32
+	lens  []uint8
+}
+
+func newLZXTestCode(lens []uint8) *lzxTestCode {
+	result := &lzxTestCode{codes: make([]uint32, len(lens)), lens: lens}
+	code := uint32(0)
+	for l := uint8(1); l <= lzxMaxCodeLen; l++ {
+		for s, sym_len := range lens {
+			if sym_len == l {
+				result.codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return result
+}
+
+func (self *lzxTestCode) write(w *lzxBitWriter, sym int) {
+	w.writeBits(self.codes[sym], uint(self.lens[sym]))
+}
+
+// Code lengths of a complete code over count symbols: with
+// 2^(length-1) < count <= 2^length, the first 2^length - count
+// symbols are one bit shorter.
+func completeLens(count int) []uint8 {
+	length := uint8(0)
+	for 1<<length < count {
+		length++
+	}
+
+	result := make([]uint8, count)
+	for i := range result {
+		result[i] = length
+		if i < 1<<length-count {
+			result[i] = length - 1
+		}
+	}
+	return result
+}
+
+func writeLZXLens(w *lzxBitWriter, lens []uint8) {
+	pretree := newLZXTestCode(completeLens(lzxPreCodeSymbols))
+	for _, l := range pretree.lens {
+		w.writeBits(uint32(l), 4)
+	}
+
+	// Previous lengths are all zero in the first block.
+	for _, l := range lens {
+		pretree.write(w, (17-int(l))%17)
+	}
+}
+
+// An operation is either a literal (length 0) or a match.
+type lzxTestOp struct {
+	literal byte
+	length  int
+	offset  int
+}
+
+func encodeLZXBlock(w *lzxBitWriter, block_type int, ops []lzxTestOp) []byte {
+	expected := []byte{}
+	for _, op := range ops {
+		if op.length == 0 {
+			expected = append(expected, op.literal)
+			continue
+		}
+		start := len(expected) - op.offset
+		for i := 0; i < op.length; i++ {
+			expected = append(expected, expected[start+i])
+		}
+	}
+
+	w.writeBits(uint32(block_type), 3)
+	w.writeBits(0, 1)
+	w.writeBits(uint32(len(expected)), 16)
+
+	main_code := newLZXTestCode(completeLens(lzxMainCodeSymbols))
+	len_code := newLZXTestCode(completeLens(lzxLenCodeSymbols))
+	aligned_code := newLZXTestCode(completeLens(lzxAlignedSymbols))
+
+	if block_type == lzxBlockTypeAligned {
+		for _, l := range aligned_code.lens {
+			w.writeBits(uint32(l), 3)
+		}
+	}
+
+	writeLZXLens(w, main_code.lens[:lzxNumChars])
+	writeLZXLens(w, main_code.lens[lzxNumChars:])
+	writeLZXLens(w, len_code.lens)
+
+	recent_offset := 1
+	for _, op := range ops {
+		if op.length == 0 {
+			main_code.write(w, int(op.literal))
+			continue
+		}
+
+		len_header := op.length - lzxMinMatchLen
+		if len_header > 7 {
+			len_header = 7
+		}
+
+		// Use the repeated offset slot where possible.
+		slot := 0
+		formatted := op.offset + 2
+		if op.offset != recent_offset {
+			for slot = 3; slot < lzxNumOffsetSlots-1; slot++ {
+				if int(lzxOffsetSlotBase[slot+1]) > formatted {
+					break
+				}
+			}
+			recent_offset = op.offset
+		}
+
+		main_code.write(w, lzxNumChars+slot*lzxNumLenHeaders+len_header)
+		if len_header == 7 {
+			len_code.write(w, op.length-lzxMinMatchLen-7)
+		}
+
+		if slot == 0 {
+			continue
+		}
+
+		extra_bits := uint(lzxExtraOffsetBits[slot])
+		extra := uint32(formatted) - lzxOffsetSlotBase[slot]
+		if block_type == lzxBlockTypeAligned && extra_bits >= 3 {
+			w.writeBits(extra>>3, extra_bits-3)
+			aligned_code.write(w, int(extra&7))
+		} else {
+			w.writeBits(extra, extra_bits)
+		}
+	}
+
+	return expected
+}
+
+func literals(data string) []lzxTestOp {
+	result := []lzxTestOp{}
+	for _, c := range []byte(data) {
+		result = append(result, lzxTestOp{literal: c})
+	}
+	return result
+}
+
+func testLZXOps() []lzxTestOp {
+	ops := literals("abcdefgh")
+	ops = append(ops, lzxTestOp{length: 16, offset: 8})
+	ops = append(ops, literals("X")...)
+	ops = append(ops, lzxTestOp{length: 10, offset: 8})
+	ops = append(ops, literals("0123456789012345678901234567890123456789")...)
+	ops = append(ops, lzxTestOp{length: 40, offset: 35})
+	ops = append(ops, lzxTestOp{length: 3, offset: 1})
+	return ops
+}
+
+func TestLZXVerbatimAndAligned(t *testing.T) {
+	for _, block_type := range []int{
+		lzxBlockTypeVerbatim, lzxBlockTypeAligned} {
+		w := &lzxBitWriter{}
+		expected := encodeLZXBlock(w, block_type, testLZXOps())
+
+		out, err := LZXDecompress(w.flush(), len(expected))
+		if err != nil {
+			t.Fatalf("block type %d: %v", block_type, err)
+		}
+		if !bytes.Equal(out, expected) {
+			t.Fatalf("block type %d: mismatched output %q", block_type, out)
+		}
+	}
+}
+
+// An aligned offset block with matches, repeated offsets and a
+// translated E8 call. The expected output was produced by an
+// independent decoder (github.com/Microsoft/go-winio/wim/lzx, which
+// reads WIM resources) rather than by the encoder above, so a mistake
+// shared by our encoder and decoder is caught. The vector uses
+// complete Huffman codes since real decoders reject anything else.
+var lzxAlignedVector = "" +
+	"0a40db06b46d4444444445445555595599999999999998998888888888888888" +
+	"8888888888888888888888888888888888888888888888888888888888888888" +
+	"8888888888888888888888888888888888888888888888888888888888888888" +
+	"8888888888888888888888888888888888888888888888888888888888888888" +
+	"8888888888888888888888888888848844444444454455555855888888888888" +
+	"8888888888888888888888888888888888888888888888888888888888888888" +
+	"8888888888888888888888888888888888888888888888888888888888888888" +
+	"8888888888888888888888888888888888888888888888888888888888888888" +
+	"88888888888888888888888888888888848844444444454455555a55aaaa99aa" +
+	"9999999999999999999999999999999999999999999999999999999999999999" +
+	"9999999999999999999999999999999999999999999999999999999999999999" +
+	"9999999999999999999999999999999999999999999999999999999999999999" +
+	"9999999999999999999999999999999999999999999999999c38678ea943eed8" +
+	"a3784687b888011284042243481172c424414180102184c83152901c20494810" +
+	"32441421478c122404481112880c2345c9119b0432394eca1367f0e1f8600100" +
+	"00002118070ff05300c13a71100c1407101a84601d3c07468ac3080d20300690" +
+	"2407104a83ea"
+
+var lzxAlignedVectorExpected = "abcdefghabcdefghabcdefghXbcdefghXbc" +
+	"0123456789012345678901234567890123456789" +
+	"5678901234567890123456789012345678956789999" +
+	"call \xe8\x85\x00\x00\x00 then pad past the last 10 bytes"
+
+func TestLZXIndependentVector(t *testing.T) {
+	in, err := hex.DecodeString(lzxAlignedVector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := LZXDecompress(in, len(lzxAlignedVectorExpected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != lzxAlignedVectorExpected {
+		t.Fatalf("mismatched output %q", out)
+	}
+}
+
+func TestLZXUncompressedBlock(t *testing.T) {
+	w := &lzxBitWriter{}
+	expected := encodeLZXBlock(w, lzxBlockTypeVerbatim, literals("hello "))
+
+	raw := []byte("uncompressed world")
+	w.writeBits(lzxBlockTypeUncompressed, 3)
+	w.writeBits(0, 1)
+	w.writeBits(uint32(len(raw)), 16)
+	w.align()
+
+	// R0, R1, R2 follow the block header.
+	in := w.flush()
+	for i := 0; i < 3; i++ {
+		in = append(in, 1, 0, 0, 0)
+	}
+	in = append(in, raw...)
+	expected = append(expected, raw...)
+
+	out, err := LZXDecompress(in, len(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, expected) {
+		t.Fatalf("mismatched output %q", out)
+	}
+}
+
+func TestLZXE8Translation(t *testing.T) {
+	// A call instruction at position 16 to relative target 0x100 is
+	// stored by the compressor as the absolute target 0x110.
+	data := []byte("0123456789abcdef\xe8\x10\x01\x00\x00padding_padding")
+
+	w := &lzxBitWriter{}
+	encodeLZXBlock(w, lzxBlockTypeVerbatim, literals(string(data)))
+
+	out, err := LZXDecompress(w.flush(), len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if binary.LittleEndian.Uint32(out[17:]) != 0x100 {
+		t.Fatalf("E8 translation not undone: %x", out[16:21])
+	}
+}
+
+func TestLZXCorrupt(t *testing.T) {
+	w := &lzxBitWriter{}
+
+	// A match before any data was produced.
+	expected := encodeLZXBlock(w, lzxBlockTypeVerbatim, literals("ab"))
+	in := w.flush()
+	_, err := LZXDecompress(in, len(expected)+10)
+	if err == nil {
+		t.Fatal("expected error on truncated stream")
+	}
+
+	// Invalid block type.
+	w = &lzxBitWriter{}
+	w.writeBits(7, 3)
+	_, err = LZXDecompress(w.flush(), 10)
+	if err == nil {
+		t.Fatal("expected error on invalid block type")
+	}
+}
+
+func TestWofReader(t *testing.T) {
+	// First chunk is stored uncompressed, the second is LZX
+	// compressed.
+	first := bytes.Repeat([]byte("A"), 32768)
+
+	w := &lzxBitWriter{}
+	second := encodeLZXBlock(w, lzxBlockTypeVerbatim, testLZXOps())
+	compressed_second := w.flush()
+
+	stream := make([]byte, 4, 4+len(first)+len(compressed_second))
+	binary.LittleEndian.PutUint32(stream, uint32(len(first)))
+	stream = append(stream, first...)
+	stream = append(stream, compressed_second...)
+
+	size := int64(len(first) + len(second))
+	reader, err := NewWofReader(bytes.NewReader(stream),
+		int64(len(stream)), size, WOF_COMPRESSION_LZX)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read across the chunk boundary.
+	buf := make([]byte, 20)
+	n, err := reader.ReadAt(buf, 32768-10)
+	if err != nil || n != 20 {
+		t.Fatalf("ReadAt: %v %v", n, err)
+	}
+
+	expected := append(bytes.Repeat([]byte("A"), 10), second[:10]...)
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("mismatched output %q", buf)
+	}
+
+	// Reading past the end gives a short read.
+	n, _ = reader.ReadAt(buf, size-5)
+	if n != 5 || !bytes.Equal(buf[:5], second[len(second)-5:]) {
+		t.Fatalf("short read at end: %v %q", n, buf[:n])
+	}
+}
+
+func TestWofReaderXpress(t *testing.T) {
+	var huff *xpressVector
+	for i := range xpressVectors {
+		if xpressVectors[i].name == "Huff2" {
+			huff = &xpressVectors[i]
+		}
+	}
+
+	for _, algorithm := range []uint32{WOF_COMPRESSION_XPRESS4K,
+		WOF_COMPRESSION_XPRESS8K, WOF_COMPRESSION_XPRESS16K} {
+		chunk_size, _ := wofChunkSize(algorithm)
+
+		// First chunk is stored uncompressed, the second is
+		// XPRESS Huffman compressed.
+		first := bytes.Repeat([]byte("B"), int(chunk_size))
+		stream := make([]byte, 4, 4+len(first)+len(huff.data))
+		binary.LittleEndian.PutUint32(stream, uint32(len(first)))
+		stream = append(stream, first...)
+		stream = append(stream, huff.data...)
+
+		size := int64(len(first) + len(huff.expected))
+		reader, err := NewWofReader(bytes.NewReader(stream),
+			int64(len(stream)), size, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, size)
+		n, err := reader.ReadAt(buf, 0)
+		if err != nil || int64(n) != size {
+			t.Fatalf("%v: ReadAt: %v %v", WofAlgorithmName(algorithm), n, err)
+		}
+
+		expected := append(first, huff.expected...)
+		if !bytes.Equal(buf, expected) {
+			t.Fatalf("%v: mismatched output", WofAlgorithmName(algorithm))
+		}
+	}
+}
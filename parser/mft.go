@@ -23,6 +23,7 @@ func (self *MFT_ENTRY) EnumerateAttributes(ntfs *NTFSContext) []*NTFS_ATTRIBUTE
 		// Instantiate the attribute over the fixed up address space.
 		attribute := self.Profile.NTFS_ATTRIBUTE(
 			self.Reader, offset)
+		attribute.types = ntfs.attribute_types
 
 		// Reached the end of the MFT entry.
 		mft_size := int64(self.Mft_entry_size())
@@ -34,7 +35,7 @@ func (self *MFT_ENTRY) EnumerateAttributes(ntfs *NTFSContext) []*NTFS_ATTRIBUTE
 
 		// This is an $ATTRIBUTE_LIST attribute - append its
 		// own attributes to this one.
-		if attribute.Type().Name == "$ATTRIBUTE_LIST" {
+		if attribute.Type().Value == ATTR_TYPE_ATTRIBUTE_LIST {
 			attr_list := self.Profile.ATTRIBUTE_LIST_ENTRY(
 				attribute.Data(ntfs), 0)
 
@@ -80,6 +81,7 @@ func (self *MFT_ENTRY) GetDirectAttribute(
 	for {
 		// Instantiate the attribute over the fixed up address space.
 		attribute := self.Profile.NTFS_ATTRIBUTE(self.Reader, offset)
+		attribute.types = ntfs.attribute_types
 
 		// Reached the end of the MFT entry.
 		mft_size := int64(self.Mft_entry_size())
@@ -102,27 +104,18 @@ func (self *MFT_ENTRY) GetDirectAttribute(
 
 // Open the MFT entry specified by a path name. Walks all directory
 // indexes in the path to find the right MFT entry.
+//
+// If the FollowReparsePoints option is set, junctions and symlinks
+// encountered along the path are followed (see openFollowingReparsePoints).
 func (self *MFT_ENTRY) Open(ntfs *NTFSContext, filename string) (*MFT_ENTRY, error) {
 	filename = strings.Replace(filename, "\\", "/", -1)
 	filename = strings.Split(filename, ":")[0] // remove ADS if any as not needed
 	components := strings.Split(path.Clean(filename), "/")
 
-	get_path_in_dir := func(component string, dir *MFT_ENTRY) (
-		*MFT_ENTRY, error) {
-
-		// NTFS is usually case insensitive.
-		component = strings.ToLower(component)
-
-		for _, idx_record := range dir.Dir(ntfs) {
-			item_name := strings.ToLower(idx_record.File().Name())
-
-			if item_name == component {
-				return ntfs.GetMFT(int64(
-					idx_record.MftReference()))
-			}
-		}
-
-		return nil, errors.New("Not found")
+	options := ntfs.GetOptions()
+	if options.FollowReparsePoints {
+		return self.openFollowingReparsePoints(ntfs, components,
+			options.MaxReparseHops, options.VolumeDriveLetter)
 	}
 
 	directory := self
@@ -130,7 +123,7 @@ func (self *MFT_ENTRY) Open(ntfs *NTFSContext, filename string) (*MFT_ENTRY, err
 		if component == "" {
 			continue
 		}
-		next, err := get_path_in_dir(component, directory)
+		next, err := directory.getPathInDir(ntfs, component)
 		if err != nil {
 			return nil, err
 		}
@@ -140,6 +133,24 @@ func (self *MFT_ENTRY) Open(ntfs *NTFSContext, filename string) (*MFT_ENTRY, err
 	return directory, nil
 }
 
+func (self *MFT_ENTRY) getPathInDir(
+	ntfs *NTFSContext, component string) (*MFT_ENTRY, error) {
+
+	// NTFS is usually case insensitive.
+	component = strings.ToLower(component)
+
+	for _, idx_record := range self.Dir(ntfs) {
+		item_name := strings.ToLower(idx_record.File().Name())
+
+		if item_name == component {
+			return ntfs.GetMFT(int64(
+				idx_record.MftReference()))
+		}
+	}
+
+	return nil, errors.New("Not found")
+}
+
 func (self *MFT_ENTRY) Display(ntfs *NTFSContext) string {
 	result := []string{self.DebugString()}
 
@@ -165,6 +176,21 @@ func (self *MFT_ENTRY) StandardInformation(ntfs *NTFSContext) (
 	return nil, errors.New("$STANDARD_INFORMATION not found!")
 }
 
+// Extract the $REPARSE_POINT attribute from the MFT.
+func (self *MFT_ENTRY) ReparsePoint(ntfs *NTFSContext) (
+	*ReparsePoint, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_REPARSE_POINT {
+			buf := make([]byte, CapInt64(
+				attr.DataSize(), MAX_REPARSE_DATA_SIZE))
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseReparsePoint(buf[:n])
+		}
+	}
+
+	return nil, errors.New("$REPARSE_POINT not found!")
+}
+
 // Extract the $FILE_NAME attribute from the MFT.
 func (self *MFT_ENTRY) FileName(ntfs *NTFSContext) []*FILE_NAME {
 	result := []*FILE_NAME{}
@@ -326,6 +352,11 @@ type MFTHighlight struct {
 
 	LogFileSeqNum uint64
 
+	// Set for junctions, symlinks and other reparse points.
+	ReparseTag            string
+	ReparseSubstituteName string
+	ReparsePrintName      string
+
 	// Hold on to these for delayed lazy evaluation.
 	mu         sync.Mutex
 	ntfs_ctx   *NTFSContext
@@ -365,6 +396,10 @@ func (self *MFTHighlight) Copy() *MFTHighlight {
 		LastAccess0x30:       self.LastAccess0x30,
 		LogFileSeqNum:        self.LogFileSeqNum,
 
+		ReparseTag:            self.ReparseTag,
+		ReparseSubstituteName: self.ReparseSubstituteName,
+		ReparsePrintName:      self.ReparsePrintName,
+
 		ntfs_ctx:  self.ntfs_ctx,
 		mft_entry: self.mft_entry,
 		ads_name:  self.ads_name,
@@ -482,85 +517,10 @@ func ParseMFTFileWithOptions(
 				continue
 			}
 
-			var file_names []*FILE_NAME
-			var file_name_types []string
-			var file_name_strings []string
-
-			var si *STANDARD_INFORMATION
-			var size int64
-			ads := []string{}
-			ads_sizes := []int64{}
-			si_flags := ""
-
-			for _, attr := range mft_entry.EnumerateAttributes(ntfs) {
-				attr_type := attr.Type()
-				switch attr_type.Value {
-				case ATTR_TYPE_DATA:
-					if size == 0 {
-						size = attr.DataSize()
-					}
-
-					// Check if the stream has ADS
-					attr_name := attr.Name()
-					if attr_name != "" {
-						ads = append(ads, attr_name)
-						ads_sizes = append(ads_sizes, int64(attr.Size()))
-					}
-
-				case ATTR_TYPE_FILE_NAME:
-					res := ntfs.Profile.FILE_NAME(attr.Data(ntfs), 0)
-					file_names = append(file_names, res)
-					file_name_types = append(file_name_types, res.NameType().Name)
-					fn := res.Name()
-					file_name_strings = append(file_name_strings, fn)
-
-				case ATTR_TYPE_STANDARD_INFORMATION:
-					si = ntfs.Profile.STANDARD_INFORMATION(
-						attr.Data(ntfs), 0)
-					si_flags = si.Flags().DebugString()
-				}
-			}
-			if len(file_names) == 0 {
+			row, ads, ads_sizes := newMFTHighlight(ntfs, mft_entry)
+			if row == nil {
 				continue
 			}
-			if si == nil {
-				continue
-			}
-
-			mft_id := mft_entry.Record_number()
-			row := &MFTHighlight{
-				EntryNumber:          int64(mft_id),
-				Inode:                fmt.Sprintf("%d", mft_id),
-				SequenceNumber:       mft_entry.Sequence_value(),
-				InUse:                mft_entry.Flags().IsSet("ALLOCATED"),
-				ParentEntryNumber:    file_names[0].MftReference(),
-				ParentSequenceNumber: file_names[0].Seq_num(),
-				FileNames:            file_name_strings,
-				_FileNameTypes:       file_name_types,
-				FileSize:             size,
-				ReferenceCount:       int64(mft_entry.Link_count()),
-				IsDir:                mft_entry.Flags().IsSet("DIRECTORY"),
-				HasADS:               len(ads) > 0,
-				SIFlags:              si_flags,
-				Created0x10:          si.Create_time().Time,
-				Created0x30:          file_names[0].Created().Time,
-				LastModified0x10:     si.File_altered_time().Time,
-				LastModified0x30:     file_names[0].File_modified().Time,
-				LastRecordChange0x10: si.Mft_altered_time().Time,
-				LastRecordChange0x30: file_names[0].Mft_modified().Time,
-				LastAccess0x10:       si.File_accessed_time().Time,
-				LastAccess0x30:       file_names[0].File_accessed().Time,
-				LogFileSeqNum:        mft_entry.Logfile_sequence_number(),
-
-				ntfs_ctx:  ntfs,
-				mft_entry: mft_entry,
-			}
-
-			row.SI_Lt_FN = row.Created0x10.Before(row.Created0x30)
-			row.USecZeros = row.Created0x10.Unix()*1000000000 ==
-				row.Created0x10.UnixNano() ||
-				row.LastModified0x10.Unix()*1000000000 == row.LastModified0x10.UnixNano()
-			row.Copied = row.Created0x10.After(row.LastModified0x10)
 
 			// Check for cancellations.
 			select {
@@ -599,3 +559,98 @@ func ParseMFTFileWithOptions(
 
 	return output
 }
+
+// Build the MFTHighlight row for the MFT entry. Also returns the
+// names and sizes of any alternate data streams. Entries without a
+// $FILE_NAME or $STANDARD_INFORMATION return a nil row.
+func newMFTHighlight(ntfs *NTFSContext, mft_entry *MFT_ENTRY) (
+	*MFTHighlight, []string, []int64) {
+	var file_names []*FILE_NAME
+	var file_name_types []string
+	var file_name_strings []string
+
+	var si *STANDARD_INFORMATION
+	var size int64
+	ads := []string{}
+	ads_sizes := []int64{}
+	si_flags := ""
+	var reparse *ReparsePoint
+
+	for _, attr := range mft_entry.EnumerateAttributes(ntfs) {
+		attr_type := attr.Type()
+		switch attr_type.Value {
+		case ATTR_TYPE_DATA:
+			if size == 0 {
+				size = attr.DataSize()
+			}
+
+			// Check if the stream has ADS
+			attr_name := attr.Name()
+			if attr_name != "" {
+				ads = append(ads, attr_name)
+				ads_sizes = append(ads_sizes, int64(attr.Size()))
+			}
+
+		case ATTR_TYPE_FILE_NAME:
+			res := ntfs.Profile.FILE_NAME(attr.Data(ntfs), 0)
+			file_names = append(file_names, res)
+			file_name_types = append(file_name_types, res.NameType().Name)
+			fn := res.Name()
+			file_name_strings = append(file_name_strings, fn)
+
+		case ATTR_TYPE_STANDARD_INFORMATION:
+			si = ntfs.Profile.STANDARD_INFORMATION(
+				attr.Data(ntfs), 0)
+			si_flags = si.Flags().DebugString()
+
+		case ATTR_TYPE_REPARSE_POINT:
+			reparse, _ = mft_entry.ReparsePoint(ntfs)
+		}
+	}
+	if len(file_names) == 0 || si == nil {
+		return nil, nil, nil
+	}
+
+	mft_id := mft_entry.Record_number()
+	row := &MFTHighlight{
+		EntryNumber:          int64(mft_id),
+		Inode:                fmt.Sprintf("%d", mft_id),
+		SequenceNumber:       mft_entry.Sequence_value(),
+		InUse:                mft_entry.Flags().IsSet("ALLOCATED"),
+		ParentEntryNumber:    file_names[0].MftReference(),
+		ParentSequenceNumber: file_names[0].Seq_num(),
+		FileNames:            file_name_strings,
+		_FileNameTypes:       file_name_types,
+		FileSize:             size,
+		ReferenceCount:       int64(mft_entry.Link_count()),
+		IsDir:                mft_entry.Flags().IsSet("DIRECTORY"),
+		HasADS:               len(ads) > 0,
+		SIFlags:              si_flags,
+		Created0x10:          si.Create_time().Time,
+		Created0x30:          file_names[0].Created().Time,
+		LastModified0x10:     si.File_altered_time().Time,
+		LastModified0x30:     file_names[0].File_modified().Time,
+		LastRecordChange0x10: si.Mft_altered_time().Time,
+		LastRecordChange0x30: file_names[0].Mft_modified().Time,
+		LastAccess0x10:       si.File_accessed_time().Time,
+		LastAccess0x30:       file_names[0].File_accessed().Time,
+		LogFileSeqNum:        mft_entry.Logfile_sequence_number(),
+
+		ntfs_ctx:  ntfs,
+		mft_entry: mft_entry,
+	}
+
+	if reparse != nil {
+		row.ReparseTag = reparse.TagName
+		row.ReparseSubstituteName = reparse.SubstituteName
+		row.ReparsePrintName = reparse.PrintName
+	}
+
+	row.SI_Lt_FN = row.Created0x10.Before(row.Created0x30)
+	row.USecZeros = row.Created0x10.Unix()*1000000000 ==
+		row.Created0x10.UnixNano() ||
+		row.LastModified0x10.Unix()*1000000000 == row.LastModified0x10.UnixNano()
+	row.Copied = row.Created0x10.After(row.LastModified0x10)
+
+	return row, ads, ads_sizes
+}
@@ -48,6 +48,34 @@ type NTFSFileInformation struct {
 	Attributes []*Attribute
 
 	Hardlinks []string
+
+	// Set for junctions, symlinks and other reparse points.
+	ReparsePoint *ReparsePoint `json:"ReparsePoint,omitempty"`
+
+	// The security descriptor resolved through $Secure (or the
+	// legacy $SECURITY_DESCRIPTOR attribute).
+	SecurityId uint32 `json:"SecurityId,omitempty"`
+	OwnerSID   string `json:"OwnerSID,omitempty"`
+	ACL        *ACL   `json:"ACL,omitempty"`
+
+	// The quota owner and the bytes charged to them for this file.
+	OwnerId       uint32 `json:"OwnerId,omitempty"`
+	QuotaCharged  uint64 `json:"QuotaCharged,omitempty"`
+	QuotaOwnerSID string `json:"QuotaOwnerSID,omitempty"`
+
+	// Distributed Link Tracking ids.
+	ObjectId *ObjectId `json:"ObjectId,omitempty"`
+
+	// The users and recovery agents of EFS encrypted files.
+	EFS *EFSInfo `json:"EFS,omitempty"`
+
+	// Extended attributes and the WSL metadata stored in them.
+	EAInformation      *EAInformation       `json:"EAInformation,omitempty"`
+	ExtendedAttributes []*ExtendedAttribute `json:"ExtendedAttributes,omitempty"`
+	WSL                *WSLMetadata         `json:"WSL,omitempty"`
+
+	// Attributes which violate their $AttrDef definition.
+	Anomalies []string `json:"Anomalies,omitempty"`
 }
 
 func ModelMFTEntry(ntfs *NTFSContext, mft_entry *MFT_ENTRY) (*NTFSFileInformation, error) {
@@ -70,6 +98,15 @@ func ModelMFTEntry(ntfs *NTFSContext, mft_entry *MFT_ENTRY) (*NTFSFileInformatio
 			MFTModifiedTime:  si.Mft_altered_time().Time,
 			AccessedTime:     si.File_accessed_time().Time,
 		}
+		result.SecurityId = si.Sid()
+		result.OwnerId = si.Owner_id()
+		result.QuotaCharged = si.Quota()
+	}
+
+	sd, err := mft_entry.SecurityDescriptor(ntfs)
+	if err == nil {
+		result.OwnerSID = sd.Owner
+		result.ACL = sd.DACL
 	}
 
 	for _, filename := range mft_entry.FileName(ntfs) {
@@ -87,6 +124,44 @@ func ModelMFTEntry(ntfs *NTFSContext, mft_entry *MFT_ENTRY) (*NTFSFileInformatio
 		})
 	}
 
+	if result.OwnerId != 0 {
+		quota_entry, err := GetQuotaEntry(ntfs, result.OwnerId)
+		if err == nil {
+			result.QuotaOwnerSID = quota_entry.SID
+		}
+	}
+
+	reparse, err := mft_entry.ReparsePoint(ntfs)
+	if err == nil {
+		result.ReparsePoint = reparse
+	}
+
+	object_id, err := mft_entry.ObjectId(ntfs)
+	if err == nil {
+		result.ObjectId = object_id
+	}
+
+	efs, err := mft_entry.EFS(ntfs)
+	if err == nil {
+		result.EFS = efs
+	}
+
+	ea_information, err := mft_entry.EAInformation(ntfs)
+	if err == nil {
+		result.EAInformation = ea_information
+	}
+
+	eas, err := mft_entry.ExtendedAttributes(ntfs)
+	if err == nil && len(eas) > 0 {
+		result.ExtendedAttributes = eas
+		result.WSL = ParseWSLMetadata(eas)
+	}
+
+	anomalies := mft_entry.AttributeAnomalies(ntfs)
+	if len(anomalies) > 0 {
+		result.Anomalies = anomalies
+	}
+
 	inode_formatter := InodeFormatter{}
 
 	for _, attr := range mft_entry.EnumerateAttributes(ntfs) {
@@ -0,0 +1,218 @@
+// Support for object ids used by Distributed Link Tracking.
+//
+// A file's $OBJECT_ID attribute holds its object id, optionally
+// followed by the birth volume id, birth object id and domain id. The
+// birth ids record where the file was first created so the link
+// tracking service can find it after it moved. LNK files and jump
+// lists store the same ids for their targets.
+//
+// The $O index of $Extend\$ObjId maps each object id to the MFT
+// reference of its file (and the birth ids).
+//
+// Object ids are usually version 1 GUIDs which encode their creation
+// time and the MAC address of the machine that created them.
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	OBJECT_ID_SIZE = 16
+
+	// The $O index data: an MFT reference and the three birth ids.
+	OBJID_INDEX_DATA_SIZE = 8 + 3*OBJECT_ID_SIZE
+
+	// 100ns intervals between the GUID epoch (1582-10-15) and the
+	// unix epoch.
+	guidEpochOffset = 0x01B21DD213814000
+)
+
+var (
+	objectIdTooShortError = errors.New("Object id too short")
+	objectIdNotFoundError = errors.New("Object id not found")
+)
+
+type ObjectGUID struct {
+	GUID    string
+	Version int
+
+	// Decoded from version 1 GUIDs.
+	Timestamp     *time.Time `json:"Timestamp,omitempty"`
+	ClockSequence uint16     `json:"ClockSequence,omitempty"`
+	MAC           string     `json:"MAC,omitempty"`
+}
+
+type ObjectId struct {
+	ObjectId      *ObjectGUID
+	BirthVolumeId *ObjectGUID `json:"BirthVolumeId,omitempty"`
+	BirthObjectId *ObjectGUID `json:"BirthObjectId,omitempty"`
+	DomainId      *ObjectGUID `json:"DomainId,omitempty"`
+
+	// The file with this object id (from the $O index).
+	MFTID          int64  `json:"MFTID,omitempty"`
+	SequenceNumber uint16 `json:"SequenceNumber,omitempty"`
+}
+
+// Decode a 16 byte GUID.
+func ParseObjectGUID(data []byte) *ObjectGUID {
+	if len(data) < OBJECT_ID_SIZE {
+		return nil
+	}
+
+	time_low := uint64(binary.LittleEndian.Uint32(data[0:]))
+	time_mid := uint64(binary.LittleEndian.Uint16(data[4:]))
+	time_hi := uint64(binary.LittleEndian.Uint16(data[6:]))
+
+	result := &ObjectGUID{
+		GUID:    guidString(data[:OBJECT_ID_SIZE]),
+		Version: int(time_hi >> 12),
+	}
+
+	// Version 1 GUIDs are only meaningful with the RFC 4122 variant.
+	if result.Version == 1 && data[8]&0xc0 == 0x80 {
+		timestamp := (time_hi&0x0fff)<<48 | time_mid<<32 | time_low
+		ts := time.Unix(0, 0).Add(
+			time.Duration(int64(timestamp)-guidEpochOffset) * 100).UTC()
+
+		result.Timestamp = &ts
+		result.ClockSequence = uint16(data[8]&0x3f)<<8 | uint16(data[9])
+		result.MAC = fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x",
+			data[10], data[11], data[12], data[13], data[14], data[15])
+	}
+
+	return result
+}
+
+func isNullGUID(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse the birth volume id, birth object id and domain id. Missing
+// or null ids are left unset.
+func (self *ObjectId) parseBirthIds(data []byte) {
+	fields := []**ObjectGUID{
+		&self.BirthVolumeId, &self.BirthObjectId, &self.DomainId}
+	for i, field := range fields {
+		offset := i * OBJECT_ID_SIZE
+		if offset+OBJECT_ID_SIZE > len(data) {
+			break
+		}
+
+		guid := data[offset : offset+OBJECT_ID_SIZE]
+		if !isNullGUID(guid) {
+			*field = ParseObjectGUID(guid)
+		}
+	}
+}
+
+// Parse the content of the $OBJECT_ID attribute.
+func ParseObjectId(data []byte) (*ObjectId, error) {
+	if len(data) < OBJECT_ID_SIZE {
+		return nil, objectIdTooShortError
+	}
+
+	result := &ObjectId{ObjectId: ParseObjectGUID(data)}
+	result.parseBirthIds(data[OBJECT_ID_SIZE:])
+	return result, nil
+}
+
+// Extract the $OBJECT_ID attribute from the MFT.
+func (self *MFT_ENTRY) ObjectId(ntfs *NTFSContext) (*ObjectId, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_OBJECT_ID {
+			buf := make([]byte, CapInt64(attr.DataSize(), 4*OBJECT_ID_SIZE))
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseObjectId(buf[:n])
+		}
+	}
+
+	return nil, errors.New("$OBJECT_ID not found!")
+}
+
+func parseObjIdIndexEntry(entry *IndexEntry) (*ObjectId, error) {
+	if len(entry.Key) < OBJECT_ID_SIZE ||
+		len(entry.Data) < OBJID_INDEX_DATA_SIZE {
+		return nil, objectIdTooShortError
+	}
+
+	mft_reference := binary.LittleEndian.Uint64(entry.Data)
+	result := &ObjectId{
+		ObjectId:       ParseObjectGUID(entry.Key),
+		MFTID:          int64(mft_reference & 0xffffffffffff),
+		SequenceNumber: uint16(mft_reference >> 48),
+	}
+	result.parseBirthIds(entry.Data[8:])
+
+	return result, nil
+}
+
+func openObjIdIndex(ntfs *NTFSContext) (*MFT_ENTRY, error) {
+	root, err := ntfs.GetMFT(5)
+	if err != nil {
+		return nil, err
+	}
+
+	return root.Open(ntfs, "$Extend\\$ObjId")
+}
+
+// Parse all the entries in the $ObjId:$O index.
+func ParseObjIdIndex(ntfs *NTFSContext) ([]*ObjectId, error) {
+	objid, err := openObjIdIndex(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*ObjectId{}
+	for _, entry := range objid.IndexEntries(ntfs, "$O") {
+		object_id, err := parseObjIdIndexEntry(entry)
+		if err == nil {
+			result = append(result, object_id)
+		}
+	}
+
+	return result, nil
+}
+
+// Normalize a GUID string to the form {xxxxxxxx-...} in lower case.
+func normalizeGUID(guid string) string {
+	return "{" + strings.ToLower(strings.Trim(guid, "{} ")) + "}"
+}
+
+// Find the file with the object id (e.g. from a LNK file's tracker
+// data block) by walking the $O index.
+func LookupObjectId(ntfs *NTFSContext, guid string) (*ObjectId, error) {
+	objid, err := openObjIdIndex(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	guid = normalizeGUID(guid)
+	for _, entry := range objid.IndexEntries(ntfs, "$O") {
+		if len(entry.Key) < OBJECT_ID_SIZE ||
+			guidString(entry.Key[:OBJECT_ID_SIZE]) != guid {
+			continue
+		}
+
+		return parseObjIdIndexEntry(entry)
+	}
+
+	return nil, objectIdNotFoundError
+}
+
+func init() {
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_OBJECT_ID,
+		func(data []byte) (interface{}, error) {
+			return ParseObjectId(data)
+		})
+}
@@ -2,6 +2,9 @@ package parser
 
 const (
 	DefaultMaxLinks = 0
+
+	// Windows gives up after 63 reparse points.
+	DefaultMaxReparseHops = 63
 )
 
 type Options struct {
@@ -21,6 +24,19 @@ type Options struct {
 	// Disable resolution of USN paths through the MFT. This is useful
 	// when there is no MFT to look at.
 	DisableFullPathResolution bool
+
+	// Follow junctions and symlinks when opening paths with
+	// MFT_ENTRY.Open()
+	FollowReparsePoints bool
+
+	// Maximum number of reparse points to follow while opening a
+	// single path.
+	MaxReparseHops int
+
+	// The drive letter the volume was mounted on (e.g. "C"). Absolute
+	// reparse targets on other drives are reported as off volume. If
+	// not set, all drive letters are assumed to refer to this volume.
+	VolumeDriveLetter string
 }
 
 func GetDefaultOptions() Options {
@@ -28,5 +44,6 @@ func GetDefaultOptions() Options {
 		IncludeShortNames: false,
 		MaxLinks:          20,
 		MaxDirectoryDepth: 20,
+		MaxReparseHops:    DefaultMaxReparseHops,
 	}
 }
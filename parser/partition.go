@@ -0,0 +1,437 @@
+// Discover the partitions of a disk image.
+//
+// Disk images usually hold a partition table rather than a bare NTFS
+// volume. We support the legacy MBR partition table (including the
+// chain of extended boot records holding the logical partitions) and
+// GPT. GPT headers are protected by a CRC32 and there is a backup
+// header in the last sector of the disk which we use when the
+// primary header is damaged.
+
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	PARTITION_SCHEME_MBR = "MBR"
+	PARTITION_SCHEME_GPT = "GPT"
+
+	MBR_SECTOR_SIZE = 512
+
+	// Limit the length of extended partition chains and the size of
+	// the GPT partition array.
+	MAX_LOGICAL_PARTITIONS = 128
+	MAX_GPT_ENTRIES        = 1024
+	MAX_GPT_ENTRY_SIZE     = 4096
+)
+
+var (
+	noPartitionTableError  = errors.New("No partition table found")
+	partitionNotFoundError = errors.New("Partition not found")
+
+	mbrPartitionTypes = map[byte]string{
+		0x01: "FAT12",
+		0x04: "FAT16",
+		0x05: "Extended",
+		0x06: "FAT16",
+		0x07: "NTFS/exFAT",
+		0x0b: "FAT32",
+		0x0c: "FAT32 (LBA)",
+		0x0e: "FAT16 (LBA)",
+		0x0f: "Extended (LBA)",
+		0x17: "Hidden NTFS",
+		0x27: "Windows Recovery",
+		0x42: "Windows Dynamic Disk",
+		0x82: "Linux Swap",
+		0x83: "Linux",
+		0x85: "Linux Extended",
+		0x8e: "Linux LVM",
+		0xee: "GPT Protective",
+		0xef: "EFI System",
+	}
+
+	gptPartitionTypes = map[string]string{
+		"{ebd0a0a2-b9e5-4433-87c0-68b6b72699c7}": "Microsoft Basic Data",
+		"{e3c9e316-0b5c-4db8-817d-f92df00215ae}": "Microsoft Reserved",
+		"{de94bba4-06d1-4d40-a16a-bfd50179d6ac}": "Windows Recovery",
+		"{5808c8aa-7e8f-42e0-85d2-e1e90434cfb3}": "LDM Metadata",
+		"{af9b60a0-1431-4f62-bc68-3311714a69ad}": "LDM Data",
+		"{e75caf8f-f680-4cee-afa3-b001e56efc2d}": "Storage Spaces",
+		"{c12a7328-f81f-11d2-ba4b-00a0c93ec93b}": "EFI System",
+		"{0fc63daf-8483-4772-8e79-3d69d8477de4}": "Linux Filesystem",
+		"{0657fd6d-a4ab-43c4-84e5-0933c84b4f4f}": "Linux Swap",
+		"{e6d6d379-f507-44c2-a23c-238f2a3df928}": "Linux LVM",
+	}
+)
+
+type Partition struct {
+	// Partition numbers start at 1. Logical partitions in the
+	// extended partition are numbered from 5 like on Linux.
+	Index  int
+	Scheme string
+
+	// The MBR partition type (e.g. 0x07) or the GPT type GUID.
+	Type     string
+	TypeName string `json:"TypeName,omitempty"`
+
+	// GPT partitions have a name and a unique GUID.
+	Name string `json:"Name,omitempty"`
+	GUID string `json:"GUID,omitempty"`
+
+	// In bytes from the start of the disk.
+	Offset int64
+	Size   int64
+
+	Bootable bool `json:"Bootable,omitempty"`
+
+	// Set when the partition holds a valid NTFS boot sector.
+	IsNTFS bool
+}
+
+// A reader over the partition's content.
+func (self *Partition) Reader(disk io.ReaderAt) *OffsetReader {
+	return &OffsetReader{Offset: self.Offset, Reader: disk}
+}
+
+func isMBRExtended(partition_type byte) bool {
+	switch partition_type {
+	case 0x05, 0x0f, 0x85:
+		return true
+	}
+	return false
+}
+
+// Does the reader hold an NTFS boot sector at offset?
+func IsNTFSVolume(reader io.ReaderAt, offset int64) bool {
+	boot := &NTFS_BOOT_SECTOR{
+		Reader: reader, Profile: NewNTFSProfile(), Offset: offset}
+	return strings.HasPrefix(boot.Oemname(), "NTFS") && boot.IsValid() == nil
+}
+
+func readSector(reader io.ReaderAt, offset, size int64) ([]byte, error) {
+	buffer := make([]byte, size)
+	n, err := reader.ReadAt(buffer, offset)
+	if int64(n) < size {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buffer, nil
+}
+
+type mbrEntry struct {
+	bootable       bool
+	partition_type byte
+	start_lba      int64
+	sector_count   int64
+}
+
+// Parse the 4 entries of a MBR or EBR sector.
+func parseMBREntries(sector []byte) ([]*mbrEntry, error) {
+	if len(sector) < MBR_SECTOR_SIZE ||
+		binary.LittleEndian.Uint16(sector[510:]) != 0xaa55 {
+		return nil, noPartitionTableError
+	}
+
+	result := []*mbrEntry{}
+	for i := 0; i < 4; i++ {
+		entry := sector[446+16*i : 446+16*(i+1)]
+		result = append(result, &mbrEntry{
+			bootable:       entry[0] == 0x80,
+			partition_type: entry[4],
+			start_lba:      int64(binary.LittleEndian.Uint32(entry[8:])),
+			sector_count:   int64(binary.LittleEndian.Uint32(entry[12:])),
+		})
+	}
+	return result, nil
+}
+
+func newMBRPartition(index int, entry *mbrEntry, offset int64) *Partition {
+	return &Partition{
+		Index:    index,
+		Scheme:   PARTITION_SCHEME_MBR,
+		Type:     fmt.Sprintf("0x%02x", entry.partition_type),
+		TypeName: mbrPartitionTypes[entry.partition_type],
+		Offset:   offset,
+		Size:     entry.sector_count * MBR_SECTOR_SIZE,
+		Bootable: entry.bootable,
+	}
+}
+
+// Follow the chain of extended boot records. Logical partitions are
+// relative to their EBR while the link to the next EBR is relative
+// to the start of the extended partition.
+func parseExtendedPartitions(reader io.ReaderAt, extended_lba int64) []*Partition {
+	result := []*Partition{}
+	seen := make(map[int64]bool)
+
+	ebr_lba := extended_lba
+	for len(result) < MAX_LOGICAL_PARTITIONS && !seen[ebr_lba] {
+		seen[ebr_lba] = true
+
+		sector, err := readSector(reader,
+			ebr_lba*MBR_SECTOR_SIZE, MBR_SECTOR_SIZE)
+		if err != nil {
+			break
+		}
+
+		entries, err := parseMBREntries(sector)
+		if err != nil {
+			break
+		}
+
+		if entries[0].partition_type != 0 && entries[0].sector_count > 0 {
+			result = append(result, newMBRPartition(5+len(result),
+				entries[0], (ebr_lba+entries[0].start_lba)*MBR_SECTOR_SIZE))
+		}
+
+		if !isMBRExtended(entries[1].partition_type) ||
+			entries[1].start_lba == 0 {
+			break
+		}
+		ebr_lba = extended_lba + entries[1].start_lba
+	}
+
+	return result
+}
+
+func parseMBR(reader io.ReaderAt, entries []*mbrEntry) []*Partition {
+	result := []*Partition{}
+	for i, entry := range entries {
+		if entry.partition_type == 0 || entry.sector_count == 0 {
+			continue
+		}
+
+		if isMBRExtended(entry.partition_type) {
+			result = append(result,
+				parseExtendedPartitions(reader, entry.start_lba)...)
+			continue
+		}
+
+		result = append(result, newMBRPartition(
+			i+1, entry, entry.start_lba*MBR_SECTOR_SIZE))
+	}
+	return result
+}
+
+// Parse and verify the GPT header at lba and its partition array.
+func parseGPTHeader(reader io.ReaderAt,
+	lba, sector_size int64) ([]*Partition, error) {
+	header, err := readSector(reader, lba*sector_size, sector_size)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(header[:8]) != "EFI PART" {
+		return nil, errors.New("Invalid GPT signature")
+	}
+
+	header_size := int64(binary.LittleEndian.Uint32(header[12:]))
+	if header_size < 92 || header_size > sector_size {
+		return nil, errors.New("Invalid GPT header size")
+	}
+
+	// The CRC is calculated with the CRC field zeroed.
+	header_crc := binary.LittleEndian.Uint32(header[16:])
+	crc_data := append([]byte{}, header[:header_size]...)
+	binary.LittleEndian.PutUint32(crc_data[16:], 0)
+	if crc32.ChecksumIEEE(crc_data) != header_crc {
+		return nil, errors.New("GPT header CRC mismatch")
+	}
+
+	entries_lba := int64(binary.LittleEndian.Uint64(header[72:]))
+	entry_count := int64(binary.LittleEndian.Uint32(header[80:]))
+	entry_size := int64(binary.LittleEndian.Uint32(header[84:]))
+	entries_crc := binary.LittleEndian.Uint32(header[88:])
+
+	if entry_count > MAX_GPT_ENTRIES || entry_size < 128 ||
+		entry_size > MAX_GPT_ENTRY_SIZE || entry_size%8 != 0 {
+		return nil, errors.New("Invalid GPT partition array")
+	}
+
+	entries, err := readSector(reader,
+		entries_lba*sector_size, entry_count*entry_size)
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(entries) != entries_crc {
+		return nil, errors.New("GPT partition array CRC mismatch")
+	}
+
+	profile := NewNTFSProfile()
+	result := []*Partition{}
+	for i := int64(0); i < entry_count; i++ {
+		entry := entries[i*entry_size : (i+1)*entry_size]
+		first_lba := int64(binary.LittleEndian.Uint64(entry[32:]))
+		last_lba := int64(binary.LittleEndian.Uint64(entry[40:]))
+
+		// Unused entries have a zero type GUID.
+		if bytes.Equal(entry[:16], make([]byte, 16)) ||
+			last_lba < first_lba {
+			continue
+		}
+
+		type_guid := profile.GUID(bytes.NewReader(entry), 0).AsString()
+		result = append(result, &Partition{
+			Index:    int(i + 1),
+			Scheme:   PARTITION_SCHEME_GPT,
+			Type:     type_guid,
+			TypeName: gptPartitionTypes[type_guid],
+			Name:     UTF16BytesToUTF8(trimUTF16(entry[56:128]), binary.LittleEndian),
+			GUID:     profile.GUID(bytes.NewReader(entry), 16).AsString(),
+			Offset:   first_lba * sector_size,
+			Size:     (last_lba - first_lba + 1) * sector_size,
+		})
+	}
+
+	return result, nil
+}
+
+// Truncate a UTF16 buffer at the first null character.
+func trimUTF16(data []byte) []byte {
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			return data[:i]
+		}
+	}
+	return data
+}
+
+// Find a GPT with either 512 or 4096 byte sectors. When the primary
+// header is damaged we fall back to the backup header at the end of
+// the disk (if the disk size is known).
+func parseGPT(reader io.ReaderAt, disk_size int64) ([]*Partition, error) {
+	var last_err error = noPartitionTableError
+	for _, sector_size := range []int64{512, 4096} {
+		result, err := parseGPTHeader(reader, 1, sector_size)
+		if err == nil {
+			return result, nil
+		}
+		DebugPrint(DEBUG_NTFS, "Primary GPT header (%v byte sectors): %v\n",
+			sector_size, err)
+		last_err = err
+
+		if disk_size >= 2*sector_size {
+			result, err := parseGPTHeader(reader,
+				disk_size/sector_size-1, sector_size)
+			if err == nil {
+				return result, nil
+			}
+			DebugPrint(DEBUG_NTFS, "Backup GPT header (%v byte sectors): %v\n",
+				sector_size, err)
+		}
+	}
+	return nil, last_err
+}
+
+// Enumerate the partitions on the disk. The disk size is used to
+// find the backup GPT header and may be 0 if it is not known.
+func GetPartitions(reader io.ReaderAt, disk_size int64) ([]*Partition, error) {
+	sector, err := readSector(reader, 0, MBR_SECTOR_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	// A bare NTFS volume also ends with 0xaa55 but has no
+	// partition table.
+	if IsNTFSVolume(reader, 0) {
+		return nil, noPartitionTableError
+	}
+
+	entries, err := parseMBREntries(sector)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Partition
+
+	is_gpt := false
+	for _, entry := range entries {
+		if entry.partition_type == 0xee {
+			is_gpt = true
+		}
+	}
+
+	if is_gpt {
+		result, err = parseGPT(reader, disk_size)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		result = parseMBR(reader, entries)
+	}
+
+	for _, partition := range result {
+		partition.IsNTFS = IsNTFSVolume(reader, partition.Offset)
+	}
+
+	return result, nil
+}
+
+// Find the partition with the given number.
+func GetPartition(reader io.ReaderAt,
+	disk_size int64, index int) (*Partition, error) {
+	partitions, err := GetPartitions(reader, disk_size)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partition := range partitions {
+		if partition.Index == index {
+			return partition, nil
+		}
+	}
+	return nil, partitionNotFoundError
+}
+
+type NTFSVolume struct {
+	// Nil when the image is a bare volume.
+	Partition *Partition
+	NTFS      *NTFSContext
+}
+
+// Open every NTFS volume on the disk. A disk image without a
+// partition table is treated as a single volume.
+func GetNTFSVolumes(reader io.ReaderAt, disk_size int64) ([]*NTFSVolume, error) {
+	partitions, err := GetPartitions(reader, disk_size)
+	if err == noPartitionTableError && IsNTFSVolume(reader, 0) {
+		ntfs, err := GetNTFSContext(reader, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []*NTFSVolume{{NTFS: ntfs}}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*NTFSVolume{}
+	for _, partition := range partitions {
+		if !partition.IsNTFS {
+			continue
+		}
+
+		ntfs, err := GetNTFSContext(partition.Reader(reader), 0)
+		if err != nil {
+			DebugPrint(DEBUG_NTFS, "Partition %v: %v\n", partition.Index, err)
+			continue
+		}
+
+		result = append(result, &NTFSVolume{
+			Partition: partition,
+			NTFS:      ntfs,
+		})
+	}
+
+	return result, nil
+}
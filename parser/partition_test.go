@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func testMBREntry(sector []byte, slot int, partition_type byte,
+	start_lba, sector_count uint32) {
+	entry := sector[446+16*slot:]
+	entry[4] = partition_type
+	binary.LittleEndian.PutUint32(entry[8:], start_lba)
+	binary.LittleEndian.PutUint32(entry[12:], sector_count)
+	binary.LittleEndian.PutUint16(sector[510:], 0xaa55)
+}
+
+func testNTFSBootSector(disk []byte, offset int) {
+	boot := disk[offset:]
+	copy(boot[3:], "NTFS    ")
+	binary.LittleEndian.PutUint16(boot[11:], 512)
+	boot[13] = 8
+	binary.LittleEndian.PutUint64(boot[40:], 100000)
+	binary.LittleEndian.PutUint16(boot[510:], 0xaa55)
+}
+
+func TestMBRPartitions(t *testing.T) {
+	disk := make([]byte, 5000*512)
+
+	testMBREntry(disk, 0, 0x07, 2048, 100)
+	testMBREntry(disk, 1, 0x0f, 4096, 900)
+	testNTFSBootSector(disk, 2048*512)
+
+	// Two logical partitions in the extended partition.
+	testMBREntry(disk[4096*512:], 0, 0x07, 63, 10)
+	testMBREntry(disk[4096*512:], 1, 0x05, 200, 100)
+	testMBREntry(disk[4296*512:], 0, 0x83, 63, 10)
+	testNTFSBootSector(disk, (4096+63)*512)
+
+	partitions, err := GetPartitions(bytes.NewReader(disk), int64(len(disk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		index  int
+		offset int64
+		ntfs   bool
+	}{{1, 2048 * 512, true}, {5, (4096 + 63) * 512, true},
+		{6, (4296 + 63) * 512, false}}
+
+	if len(partitions) != len(expected) {
+		t.Fatalf("Expected %v partitions, got %v", len(expected), len(partitions))
+	}
+
+	for i, partition := range partitions {
+		if partition.Index != expected[i].index ||
+			partition.Offset != expected[i].offset ||
+			partition.IsNTFS != expected[i].ntfs ||
+			partition.Scheme != PARTITION_SCHEME_MBR {
+			t.Fatalf("Unexpected partition %+v", partition)
+		}
+	}
+
+	if partitions[2].Type != "0x83" || partitions[2].TypeName != "Linux" {
+		t.Fatalf("Unexpected partition type %+v", partitions[2])
+	}
+
+	// A bare volume has no partition table.
+	_, err = GetPartitions(bytes.NewReader(disk[2048*512:]), 0)
+	if err != noPartitionTableError {
+		t.Fatalf("Expected no partition table, got %v", err)
+	}
+}
+
+func testGPTHeader(disk []byte, lba, entries_lba int64, entries []byte) {
+	header := disk[lba*512:]
+	copy(header, "EFI PART")
+	binary.LittleEndian.PutUint32(header[12:], 92)
+	binary.LittleEndian.PutUint64(header[24:], uint64(lba))
+	binary.LittleEndian.PutUint64(header[72:], uint64(entries_lba))
+	binary.LittleEndian.PutUint32(header[80:], 4)
+	binary.LittleEndian.PutUint32(header[84:], 128)
+	binary.LittleEndian.PutUint32(header[88:], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(header[:92]))
+	copy(disk[entries_lba*512:], entries)
+}
+
+func TestGPTPartitions(t *testing.T) {
+	disk := make([]byte, 4096*512)
+	testMBREntry(disk, 0, 0xee, 1, 4095)
+
+	// Basic data partition GUID {ebd0a0a2-b9e5-4433-87c0-68b6b72699c7}
+	entries := make([]byte, 4*128)
+	copy(entries, []byte{0xa2, 0xa0, 0xd0, 0xeb, 0xe5, 0xb9, 0x33, 0x44,
+		0x87, 0xc0, 0x68, 0xb6, 0xb7, 0x26, 0x99, 0xc7})
+	entries[16] = 1
+	binary.LittleEndian.PutUint64(entries[32:], 2048)
+	binary.LittleEndian.PutUint64(entries[40:], 3071)
+	copy(entries[56:], utf16LE("Data"))
+	testNTFSBootSector(disk, 2048*512)
+
+	testGPTHeader(disk, 4095, 4063, entries)
+	testGPTHeader(disk, 1, 2, entries)
+
+	check := func() {
+		partitions, err := GetPartitions(bytes.NewReader(disk), int64(len(disk)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(partitions) != 1 {
+			t.Fatalf("Expected 1 partition, got %v", len(partitions))
+		}
+
+		partition := partitions[0]
+		if partition.Index != 1 || partition.Scheme != PARTITION_SCHEME_GPT ||
+			partition.TypeName != "Microsoft Basic Data" ||
+			partition.Name != "Data" || !partition.IsNTFS ||
+			partition.Offset != 2048*512 || partition.Size != 1024*512 {
+			t.Fatalf("Unexpected partition %+v", partition)
+		}
+	}
+	check()
+
+	// Corrupt the primary header so the backup is used.
+	disk[512+40] ^= 0xff
+	check()
+
+	// A huge entry size is rejected before reading the array even
+	// when the header CRC is valid.
+	header := disk[512:]
+	binary.LittleEndian.PutUint32(header[84:], 0xfffffff8)
+	binary.LittleEndian.PutUint32(header[16:], 0)
+	binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(header[:92]))
+
+	_, err := parseGPTHeader(bytes.NewReader(disk), 1, 512)
+	if err == nil || err.Error() != "Invalid GPT partition array" {
+		t.Fatalf("Expected an invalid partition array got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+// Support for disk quota tracking in $Extend\$Quota.
+//
+// When quotas are tracked each file's $STANDARD_INFORMATION holds the
+// owner id of its owner and the number of bytes charged to them. The
+// owner ids are resolved through two view indexes of $Quota:
+//
+// - $O is keyed by the owner's SID and holds the owner id.
+// - $Q is keyed by the owner id and holds the quota control entry:
+//   the warning and hard limits, the bytes used, the time they
+//   changed and the owner's SID.
+//
+// Owner id 1 holds the default limits for the volume and real owners
+// start at 0x100. Unlike $Secure this gives the owner of each file
+// without decoding any security descriptors.
+//
+// References:
+// https://github.com/tuxera/ntfs-3g/blob/edge/include/ntfs-3g/layout.h (QUOTA_CONTROL_ENTRY)
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	QUOTA_CONTROL_ENTRY_SIZE = 0x30
+
+	// The entry holding the default limits.
+	QUOTA_DEFAULTS_ID = 1
+
+	QUOTA_FLAG_DEFAULT_LIMITS      = 0x0001
+	QUOTA_FLAG_LIMIT_REACHED       = 0x0002
+	QUOTA_FLAG_ID_DELETED          = 0x0004
+	QUOTA_FLAG_TRACKING_ENABLED    = 0x0010
+	QUOTA_FLAG_ENFORCEMENT_ENABLED = 0x0020
+	QUOTA_FLAG_TRACKING_REQUESTED  = 0x0040
+	QUOTA_FLAG_LOG_THRESHOLD       = 0x0080
+	QUOTA_FLAG_LOG_LIMIT           = 0x0100
+	QUOTA_FLAG_OUT_OF_DATE         = 0x0200
+	QUOTA_FLAG_CORRUPT             = 0x0400
+	QUOTA_FLAG_PENDING_DELETES     = 0x0800
+)
+
+var (
+	quotaEntryTooShortError = errors.New("Quota entry too short")
+	ownerIdNotFoundError    = errors.New("Owner id not found")
+
+	quotaFlagNames = map[uint32]string{
+		QUOTA_FLAG_DEFAULT_LIMITS:      "DEFAULT_LIMITS",
+		QUOTA_FLAG_LIMIT_REACHED:       "LIMIT_REACHED",
+		QUOTA_FLAG_ID_DELETED:          "ID_DELETED",
+		QUOTA_FLAG_TRACKING_ENABLED:    "TRACKING_ENABLED",
+		QUOTA_FLAG_ENFORCEMENT_ENABLED: "ENFORCEMENT_ENABLED",
+		QUOTA_FLAG_TRACKING_REQUESTED:  "TRACKING_REQUESTED",
+		QUOTA_FLAG_LOG_THRESHOLD:       "LOG_THRESHOLD",
+		QUOTA_FLAG_LOG_LIMIT:           "LOG_LIMIT",
+		QUOTA_FLAG_OUT_OF_DATE:         "OUT_OF_DATE",
+		QUOTA_FLAG_CORRUPT:             "CORRUPT",
+		QUOTA_FLAG_PENDING_DELETES:     "PENDING_DELETES",
+	}
+)
+
+// A decoded $Q entry.
+type QuotaEntry struct {
+	OwnerId uint32
+	SID     string `json:"SID,omitempty"`
+	Version uint32
+	Flags   []string
+
+	BytesUsed  uint64
+	ChangeTime time.Time
+
+	// -1 means no limit.
+	WarningLimit int64
+	HardLimit    int64
+
+	// When the warning limit was exceeded.
+	ExceededTime *time.Time `json:"ExceededTime,omitempty"`
+}
+
+// Parse a QUOTA_CONTROL_ENTRY from the $Q index.
+func ParseQuotaControlEntry(owner_id uint32, data []byte) (*QuotaEntry, error) {
+	if len(data) < QUOTA_CONTROL_ENTRY_SIZE {
+		return nil, quotaEntryTooShortError
+	}
+
+	result := &QuotaEntry{
+		OwnerId:      owner_id,
+		Version:      binary.LittleEndian.Uint32(data[0:]),
+		Flags:        flagNames(binary.LittleEndian.Uint32(data[4:]), quotaFlagNames),
+		BytesUsed:    binary.LittleEndian.Uint64(data[8:]),
+		ChangeTime:   logTime(data[16:]),
+		WarningLimit: int64(binary.LittleEndian.Uint64(data[24:])),
+		HardLimit:    int64(binary.LittleEndian.Uint64(data[32:])),
+	}
+
+	exceeded_time := logTime(data[40:])
+	if !exceeded_time.IsZero() {
+		result.ExceededTime = &exceeded_time
+	}
+
+	// The default limits entry has no SID.
+	if len(data) > QUOTA_CONTROL_ENTRY_SIZE {
+		result.SID, _, _ = ParseSID(data[QUOTA_CONTROL_ENTRY_SIZE:])
+	}
+
+	return result, nil
+}
+
+func openQuota(ntfs *NTFSContext) (*MFT_ENTRY, error) {
+	root, err := ntfs.GetMFT(5)
+	if err != nil {
+		return nil, err
+	}
+
+	return root.Open(ntfs, "$Extend\\$Quota")
+}
+
+// Parse the $O index of $Quota: a map of owner id to SID.
+func ParseQuotaOwners(ntfs *NTFSContext) (map[uint32]string, error) {
+	quota, err := openQuota(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseQuotaOwners(ntfs, quota), nil
+}
+
+func parseQuotaOwners(ntfs *NTFSContext, quota *MFT_ENTRY) map[uint32]string {
+	result := make(map[uint32]string)
+	for _, entry := range quota.IndexEntries(ntfs, "$O") {
+		if len(entry.Data) < 4 {
+			continue
+		}
+
+		sid, _, err := ParseSID(entry.Key)
+		if err == nil {
+			result[binary.LittleEndian.Uint32(entry.Data)] = sid
+		}
+	}
+	return result
+}
+
+// Parse the $Q index of $Quota, sorted by owner id. SIDs missing from
+// the quota entries are filled in from $O.
+func ParseQuotaIndex(ntfs *NTFSContext) ([]*QuotaEntry, error) {
+	quota, err := openQuota(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseQuotaIndex(ntfs, quota), nil
+}
+
+func parseQuotaIndex(ntfs *NTFSContext, quota *MFT_ENTRY) []*QuotaEntry {
+	owners := parseQuotaOwners(ntfs, quota)
+
+	result := []*QuotaEntry{}
+	for _, entry := range quota.IndexEntries(ntfs, "$Q") {
+		if len(entry.Key) < 4 {
+			continue
+		}
+
+		owner_id := binary.LittleEndian.Uint32(entry.Key)
+		quota_entry, err := ParseQuotaControlEntry(owner_id, entry.Data)
+		if err != nil {
+			continue
+		}
+
+		if quota_entry.SID == "" {
+			quota_entry.SID = owners[owner_id]
+		}
+		result = append(result, quota_entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].OwnerId < result[j].OwnerId
+	})
+
+	return result
+}
+
+// Cache the $Q index per context.
+type quotaCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	err     error
+	entries map[uint32]*QuotaEntry
+}
+
+func (self *quotaCache) Purge() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.loaded = false
+	self.err = nil
+	self.entries = nil
+}
+
+func (self *quotaCache) load(ntfs *NTFSContext) error {
+	if self.loaded {
+		return self.err
+	}
+	self.loaded = true
+
+	quota, err := openQuota(ntfs)
+	if err != nil {
+		self.err = err
+		return err
+	}
+
+	self.entries = make(map[uint32]*QuotaEntry)
+	for _, entry := range parseQuotaIndex(ntfs, quota) {
+		self.entries[entry.OwnerId] = entry
+	}
+
+	return nil
+}
+
+// Resolve an owner id (from $STANDARD_INFORMATION) to its quota
+// entry.
+func GetQuotaEntry(ntfs *NTFSContext, owner_id uint32) (*QuotaEntry, error) {
+	cache := ntfs.quota_cache
+	if cache == nil {
+		return nil, ownerIdNotFoundError
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	err := cache.load(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, pres := cache.entries[owner_id]
+	if !pres {
+		return nil, ownerIdNotFoundError
+	}
+
+	return entry, nil
+}
+
+// The bytes charged to each owner according to the files on the
+// volume.
+type QuotaUsage struct {
+	OwnerId uint32
+	SID     string `json:"SID,omitempty"`
+	Files   int
+
+	// The sum of the quota charged in $STANDARD_INFORMATION.
+	ChargedBytes uint64
+
+	// The usage recorded in $Q.
+	BytesUsed uint64
+}
+
+// Count the files and bytes charged to each owner by walking the
+// MFT. Owners which are not in $Q (e.g. when $Quota can not be read)
+// are still counted but have no SID.
+func QuotaCensus(ntfs *NTFSContext) ([]*QuotaUsage, error) {
+	usage := make(map[uint32]*QuotaUsage)
+
+	count := mftEntryCount(ntfs)
+	for id := int64(0); id < count; id++ {
+		mft_entry, err := ntfs.GetMFT(id)
+		if err != nil ||
+			!mft_entry.Flags().IsSet("ALLOCATED") ||
+			mft_entry.Base_record_reference() != 0 {
+			continue
+		}
+
+		si, err := mft_entry.StandardInformation(ntfs)
+		if err != nil {
+			continue
+		}
+
+		// NTFS 1.2 $STANDARD_INFORMATION has no owner id.
+		owner_id := si.Owner_id()
+		if owner_id == 0 {
+			continue
+		}
+
+		owner, pres := usage[owner_id]
+		if !pres {
+			owner = &QuotaUsage{OwnerId: owner_id}
+			usage[owner_id] = owner
+		}
+		owner.Files++
+		owner.ChargedBytes += si.Quota()
+	}
+
+	result := make([]*QuotaUsage, 0, len(usage))
+	for owner_id, owner := range usage {
+		entry, err := GetQuotaEntry(ntfs, owner_id)
+		if err == nil {
+			owner.SID = entry.SID
+			owner.BytesUsed = entry.BytesUsed
+		}
+		result = append(result, owner)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].OwnerId < result[j].OwnerId
+	})
+
+	return result, nil
+}
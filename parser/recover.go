@@ -0,0 +1,300 @@
+// Recovery of deleted files.
+//
+// When a file is deleted NTFS clears the ALLOCATED flag of its MFT
+// entry (and increments the sequence number) and frees its clusters
+// in $Bitmap, but the entry itself is left largely intact. As long
+// as the MFT entry was not reused we can still read the runlist of
+// the $DATA attribute (or the resident data) and recover the
+// content.
+//
+// The clusters may have since been reallocated to other files
+// though. We score each run against $Bitmap: A run whose clusters
+// are all still free is likely intact, while a run whose clusters
+// are allocated again was probably overwritten.
+
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+)
+
+const (
+	RECOVERY_INTACT                = "Intact"
+	RECOVERY_PARTIALLY_REALLOCATED = "PartiallyReallocated"
+	RECOVERY_OVERWRITTEN           = "Overwritten"
+	RECOVERY_RESIDENT              = "Resident"
+	RECOVERY_SPARSE                = "Sparse"
+	RECOVERY_UNKNOWN               = "Unknown"
+)
+
+var (
+	notDeletedError = errors.New("MFT entry is not deleted")
+)
+
+// A run of a deleted file's $DATA attribute.
+type RecoverableRun struct {
+	// In clusters
+	Lcn    int64
+	Length int64
+
+	// How many of the clusters are allocated to another file now.
+	ReallocatedClusters int64
+	Status              string
+}
+
+type DeletedFile struct {
+	MFTId          int64
+	SequenceNumber uint16
+	FullPath       string
+
+	// Set when every parent directory of the path was found
+	// (possibly also deleted).
+	PathComplete bool
+
+	Name                 string
+	ParentEntryNumber    uint64
+	ParentSequenceNumber uint16
+	IsDir                bool
+	Size                 int64
+	SI_Times             *TimeStamps
+
+	Resident bool
+	Runs     []*RecoverableRun
+
+	// The fraction of the file's clusters which are still
+	// unallocated (1 for resident files).
+	Score  float64
+	Status string
+}
+
+func classifyRecovery(total, reallocated int64) string {
+	switch {
+	case reallocated == 0:
+		return RECOVERY_INTACT
+	case reallocated >= total:
+		return RECOVERY_OVERWRITTEN
+	default:
+		return RECOVERY_PARTIALLY_REALLOCATED
+	}
+}
+
+// Prefer the long file name of the entry.
+func preferredFileName(ntfs *NTFSContext, mft_entry *MFT_ENTRY) *FILE_NAME {
+	var result *FILE_NAME
+	for _, fn := range mft_entry.FileName(ntfs) {
+		switch fn.NameType().Name {
+		case "Win32", "DOS+Win32", "POSIX":
+			return fn
+		}
+		if result == nil {
+			result = fn
+		}
+	}
+	return result
+}
+
+// Resolve the path of a deleted MFT entry from its $FILE_NAME parent
+// references. Unlike GetFullPath we accept parents which were
+// themselves deleted after the file (the sequence number of a deleted
+// entry is one more than the one the children refer to). When the
+// parent was reused the path is rooted at an <Orphan> component.
+func RecoverPath(ntfs *NTFSContext, mft_entry *MFT_ENTRY) (string, bool) {
+	components := []string{}
+	options := ntfs.GetOptions()
+
+	fn := preferredFileName(ntfs, mft_entry)
+	for depth := 0; fn != nil && depth < options.MaxDirectoryDepth; depth++ {
+		components = append([]string{fn.Name()}, components...)
+
+		parent_id := fn.MftReference()
+		parent_seq := fn.Seq_num()
+		if parent_id == 5 {
+			return "/" + path.Join(components...), true
+		}
+
+		parent, err := ntfs.GetMFT(int64(parent_id))
+		if err != nil {
+			break
+		}
+
+		seq := parent.Sequence_value()
+		if seq != parent_seq &&
+			(parent.Flags().IsSet("ALLOCATED") || seq != parent_seq+1) {
+			return "/" + path.Join(append([]string{fmt.Sprintf(
+				"<Orphan %d-%d>", parent_id, parent_seq)},
+				components...)...), false
+		}
+
+		fn = preferredFileName(ntfs, parent)
+	}
+
+	return "/" + path.Join(append([]string{"<Orphan>"},
+		components...)...), false
+}
+
+// Analyze how recoverable the deleted MFT entry is.
+func AnalyzeDeletedFile(ntfs *NTFSContext,
+	mft_entry *MFT_ENTRY) (*DeletedFile, error) {
+	if mft_entry.Flags().IsSet("ALLOCATED") {
+		return nil, notDeletedError
+	}
+
+	fn := preferredFileName(ntfs, mft_entry)
+	if fn == nil {
+		return nil, fmt.Errorf("MFT entry %v has no filename",
+			mft_entry.Record_number())
+	}
+
+	full_path, complete := RecoverPath(ntfs, mft_entry)
+	result := &DeletedFile{
+		MFTId:                int64(mft_entry.Record_number()),
+		SequenceNumber:       mft_entry.Sequence_value(),
+		FullPath:             full_path,
+		PathComplete:         complete,
+		Name:                 fn.Name(),
+		ParentEntryNumber:    fn.MftReference(),
+		ParentSequenceNumber: fn.Seq_num(),
+		IsDir:                mft_entry.Flags().IsSet("DIRECTORY"),
+		Status:               RECOVERY_UNKNOWN,
+	}
+
+	si, err := mft_entry.StandardInformation(ntfs)
+	if err == nil {
+		result.SI_Times = &TimeStamps{
+			CreateTime:       si.Create_time().Time,
+			FileModifiedTime: si.File_altered_time().Time,
+			MFTModifiedTime:  si.Mft_altered_time().Time,
+			AccessedTime:     si.File_accessed_time().Time,
+		}
+	}
+
+	vcns := GetAllVCNs(ntfs, mft_entry, ATTR_TYPE_DATA, WILDCARD_STREAM_ID, "")
+	if len(vcns) == 0 {
+		return result, nil
+	}
+
+	result.Size = vcns[0].DataSize()
+	if vcns[0].IsResident() {
+		result.Resident = true
+		result.Score = 1
+		result.Status = RECOVERY_RESIDENT
+		return result, nil
+	}
+
+	bitmap, err := ntfs.GetClusterBitmap()
+	if err != nil {
+		DebugPrint(DEBUG_NTFS, "AnalyzeDeletedFile: %v\n", err)
+	}
+
+	var total, reallocated int64
+	for _, attr := range vcns {
+		for _, run := range attr.RunList() {
+			recoverable := &RecoverableRun{
+				Lcn:    run.Offset,
+				Length: run.Length,
+				Status: RECOVERY_UNKNOWN,
+			}
+			result.Runs = append(result.Runs, recoverable)
+
+			if run.RelativeUrnOffset == 0 {
+				recoverable.Status = RECOVERY_SPARSE
+				continue
+			}
+
+			if bitmap == nil {
+				continue
+			}
+
+			recoverable.ReallocatedClusters = bitmap.CountAllocated(
+				run.Offset, run.Length)
+			recoverable.Status = classifyRecovery(
+				run.Length, recoverable.ReallocatedClusters)
+
+			total += run.Length
+			reallocated += recoverable.ReallocatedClusters
+		}
+	}
+
+	if bitmap != nil {
+		result.Status = classifyRecovery(total, reallocated)
+		result.Score = 1
+		if total > 0 {
+			result.Score = float64(total-reallocated) / float64(total)
+		}
+	}
+
+	return result, nil
+}
+
+// Open the content of the deleted entry's $DATA stream.
+func OpenDeletedFile(ntfs *NTFSContext,
+	mft_entry *MFT_ENTRY) (RangeReaderAt, error) {
+	if mft_entry.Flags().IsSet("ALLOCATED") {
+		return nil, notDeletedError
+	}
+
+	stream, err := OpenStream(ntfs, mft_entry, ATTR_TYPE_DATA,
+		WILDCARD_STREAM_ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	vcns := GetAllVCNs(ntfs, mft_entry, ATTR_TYPE_DATA, WILDCARD_STREAM_ID, "")
+	if len(vcns) == 0 {
+		return stream, nil
+	}
+
+	return LimitedReader{RangeReaderAt: stream, N: vcns[0].DataSize()}, nil
+}
+
+// Find all the deleted files in the MFT.
+func ScanDeletedFiles(ctx context.Context,
+	ntfs *NTFSContext) chan *DeletedFile {
+	output := make(chan *DeletedFile)
+
+	go func() {
+		defer close(output)
+
+		count := mftEntryCount(ntfs)
+		for id := int64(0); id < count; id++ {
+			mft_entry, err := ntfs.GetMFT(id)
+			if err != nil || mft_entry.Flags().IsSet("ALLOCATED") {
+				continue
+			}
+
+			deleted, err := AnalyzeDeletedFile(ntfs, mft_entry)
+			if err != nil {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output <- deleted:
+			}
+		}
+	}()
+
+	return output
+}
+
+// The number of entries in the MFT, from the size of $MFT itself or
+// of the raw $MFT file.
+func mftEntryCount(ntfs *NTFSContext) int64 {
+	mft, err := ntfs.GetMFT(0)
+	if err == nil {
+		vcns := GetAllVCNs(ntfs, mft, ATTR_TYPE_DATA, WILDCARD_STREAM_ID, "")
+		if len(vcns) > 0 {
+			return vcns[0].DataSize() / ntfs.GetRecordSize()
+		}
+	}
+
+	sizer, ok := ntfs.MFTReader.(interface{ Size() int64 })
+	if ok {
+		return sizer.Size() / ntfs.GetRecordSize()
+	}
+	return 0
+}
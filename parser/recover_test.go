@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestAnalyzeDeletedFileRuns(t *testing.T) {
+	record := testMFTRecord(5, "gone.bin", testFiletime2020)
+	record = record[:len(record)-4]
+
+	// A non resident $DATA attribute with runs at clusters 2-3
+	// and 6-7.
+	data := make([]byte, 0x48)
+	binary.LittleEndian.PutUint32(data[0:], ATTR_TYPE_DATA)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)))
+	data[8] = 1
+	binary.LittleEndian.PutUint16(data[10:], 0x40)
+	binary.LittleEndian.PutUint64(data[0x18:], 3)
+	binary.LittleEndian.PutUint16(data[0x20:], 0x40)
+	binary.LittleEndian.PutUint64(data[0x28:], 4*4096)
+	binary.LittleEndian.PutUint64(data[0x30:], 4*4096-100)
+	binary.LittleEndian.PutUint64(data[0x38:], 4*4096-100)
+	copy(data[0x40:], []byte{0x11, 0x02, 0x02, 0x11, 0x02, 0x04, 0x00})
+
+	record = append(record, data...)
+	record = append(record, 0xff, 0xff, 0xff, 0xff)
+	record = append(record, make([]byte, 1024-len(record))...)
+	binary.LittleEndian.PutUint16(record[4:], 0x30)
+	binary.LittleEndian.PutUint32(record[24:], 1024)
+	binary.LittleEndian.PutUint32(record[28:], 1024)
+
+	ntfs := GetNTFSContextFromRawMFT(bytes.NewReader(record), 4096, 1024)
+
+	// Only cluster 7 was reallocated.
+	ntfs.bitmap_cache.loaded = true
+	ntfs.bitmap_cache.bitmap = NewClusterBitmap(
+		bytes.NewReader([]byte{0x80, 0x00}), 4096, 16)
+
+	mft_entry, err := ntfs.GetMFT(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := AnalyzeDeletedFile(ntfs, mft_entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted.Name != "gone.bin" || deleted.FullPath != "/gone.bin" ||
+		deleted.Size != 4*4096-100 || len(deleted.Runs) != 2 {
+		t.Fatalf("Unexpected deleted file %+v", deleted)
+	}
+
+	if deleted.Runs[0].Lcn != 2 ||
+		deleted.Runs[0].Status != RECOVERY_INTACT ||
+		deleted.Runs[1].Lcn != 6 ||
+		deleted.Runs[1].ReallocatedClusters != 1 ||
+		deleted.Runs[1].Status != RECOVERY_PARTIALLY_REALLOCATED {
+		t.Fatalf("Unexpected runs %+v %+v", deleted.Runs[0], deleted.Runs[1])
+	}
+
+	if deleted.Status != RECOVERY_PARTIALLY_REALLOCATED ||
+		deleted.Score != 0.75 {
+		t.Fatalf("Unexpected score %v (%v)", deleted.Score, deleted.Status)
+	}
+}
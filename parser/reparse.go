@@ -0,0 +1,450 @@
+// Decoding of the $REPARSE_POINT attribute.
+//
+// The attribute contains a REPARSE_DATA_BUFFER: a 4 byte tag, a 2
+// byte data length and 2 reserved bytes, followed by tag specific
+// data. Tags without the Microsoft bit set have a 16 byte GUID before
+// the data.
+//
+// References:
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-fscc/c8e77b37-3909-4fe6-a4ea-2b9d423b1ee4
+// https://learn.microsoft.com/en-us/windows-hardware/drivers/ddi/ntifs/ns-ntifs-_reparse_data_buffer
+
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	IO_REPARSE_TAG_MOUNT_POINT = 0xA0000003
+	IO_REPARSE_TAG_HSM         = 0xC0000004
+	IO_REPARSE_TAG_HSM2        = 0x80000006
+	IO_REPARSE_TAG_SIS         = 0x80000007
+	IO_REPARSE_TAG_WIM         = 0x80000008
+	IO_REPARSE_TAG_CSV         = 0x80000009
+	IO_REPARSE_TAG_DFS         = 0x8000000A
+	IO_REPARSE_TAG_SYMLINK     = 0xA000000C
+	IO_REPARSE_TAG_DFSR        = 0x80000012
+	IO_REPARSE_TAG_DEDUP       = 0x80000013
+	IO_REPARSE_TAG_NFS         = 0x80000014
+	IO_REPARSE_TAG_WOF         = 0x80000017
+	IO_REPARSE_TAG_WCI         = 0x80000018
+	IO_REPARSE_TAG_CLOUD       = 0x9000001A
+	IO_REPARSE_TAG_APPEXECLINK = 0x8000001B
+	IO_REPARSE_TAG_PROJFS      = 0x9000001C
+	IO_REPARSE_TAG_LX_SYMLINK  = 0xA000001D
+	IO_REPARSE_TAG_AF_UNIX     = 0x80000023
+	IO_REPARSE_TAG_LX_FIFO     = 0x80000024
+	IO_REPARSE_TAG_LX_CHR      = 0x80000025
+	IO_REPARSE_TAG_LX_BLK      = 0x80000026
+
+	// Cloud file tags carry the provider specific sub type in
+	// bits 12-15.
+	IO_REPARSE_TAG_CLOUD_MASK = 0x0000F000
+
+	// The Microsoft bit is set for all tags owned by Microsoft.
+	IO_REPARSE_TAG_MICROSOFT = 0x80000000
+
+	SYMLINK_FLAG_RELATIVE = 1
+)
+
+var (
+	reparseTagNames = map[uint32]string{
+		IO_REPARSE_TAG_MOUNT_POINT: "MOUNT_POINT",
+		IO_REPARSE_TAG_HSM:         "HSM",
+		IO_REPARSE_TAG_HSM2:        "HSM2",
+		IO_REPARSE_TAG_SIS:         "SIS",
+		IO_REPARSE_TAG_WIM:         "WIM",
+		IO_REPARSE_TAG_CSV:         "CSV",
+		IO_REPARSE_TAG_DFS:         "DFS",
+		IO_REPARSE_TAG_SYMLINK:     "SYMLINK",
+		IO_REPARSE_TAG_DFSR:        "DFSR",
+		IO_REPARSE_TAG_DEDUP:       "DEDUP",
+		IO_REPARSE_TAG_NFS:         "NFS",
+		IO_REPARSE_TAG_WOF:         "WOF",
+		IO_REPARSE_TAG_WCI:         "WCI",
+		IO_REPARSE_TAG_CLOUD:       "CLOUD",
+		IO_REPARSE_TAG_APPEXECLINK: "APPEXECLINK",
+		IO_REPARSE_TAG_PROJFS:      "PROJFS",
+		IO_REPARSE_TAG_LX_SYMLINK:  "LX_SYMLINK",
+		IO_REPARSE_TAG_AF_UNIX:     "AF_UNIX",
+		IO_REPARSE_TAG_LX_FIFO:     "LX_FIFO",
+		IO_REPARSE_TAG_LX_CHR:      "LX_CHR",
+		IO_REPARSE_TAG_LX_BLK:      "LX_BLK",
+	}
+
+	reparseTooShortError = errors.New("Reparse buffer too short")
+)
+
+// A decoded reparse point. Only the fields relevant to the tag are
+// filled in.
+type ReparsePoint struct {
+	Tag     uint32
+	TagName string
+
+	// For non-Microsoft tags.
+	GUID string `json:"GUID,omitempty"`
+
+	// The target of symlinks, junctions, LX symlinks and
+	// AppExecLinks. For symlinks and junctions the substitute name
+	// is the NT path (e.g. \??\C:\Users) while the print name is
+	// the path shown to the user.
+	SubstituteName string `json:"SubstituteName,omitempty"`
+	PrintName      string `json:"PrintName,omitempty"`
+
+	// Symlinks may be relative to the directory containing them.
+	Relative bool `json:"Relative,omitempty"`
+
+	// AppExecLink (e.g. WindowsApps execution aliases)
+	PackageID      string `json:"PackageID,omitempty"`
+	AppUserModelID string `json:"AppUserModelID,omitempty"`
+
+	// WOF compressed files.
+	WofProvider  uint32 `json:"WofProvider,omitempty"`
+	WofAlgorithm string `json:"WofAlgorithm,omitempty"`
+
+	// Size of the tag specific data. Cloud files and dedup
+	// reparse points hold opaque provider data which is not
+	// decoded further.
+	DataLength uint16
+
+	wof_algorithm uint32
+}
+
+// Is the reparse point a name surrogate - i.e. does it point at
+// another file or directory (junctions and symlinks)?
+func (self *ReparsePoint) IsNameSurrogate() bool {
+	return self.Tag&0x20000000 != 0
+}
+
+func ReparseTagName(tag uint32) string {
+	name, pres := reparseTagNames[tag]
+	if pres {
+		return name
+	}
+
+	if tag&^IO_REPARSE_TAG_CLOUD_MASK == IO_REPARSE_TAG_CLOUD {
+		return fmt.Sprintf("CLOUD_%X", (tag&IO_REPARSE_TAG_CLOUD_MASK)>>12)
+	}
+
+	return fmt.Sprintf("%#08x", tag)
+}
+
+// Parse the content of the $REPARSE_POINT attribute.
+func ParseReparsePoint(data []byte) (*ReparsePoint, error) {
+	if len(data) < 8 {
+		return nil, reparseTooShortError
+	}
+
+	tag := binary.LittleEndian.Uint32(data)
+	data_length := binary.LittleEndian.Uint16(data[4:])
+	result := &ReparsePoint{
+		Tag:        tag,
+		TagName:    ReparseTagName(tag),
+		DataLength: data_length,
+	}
+
+	buffer := data[8:]
+	if tag&IO_REPARSE_TAG_MICROSOFT == 0 {
+		if len(buffer) < 16 {
+			return nil, reparseTooShortError
+		}
+		result.GUID = NewNTFSProfile().GUID(
+			bytes.NewReader(buffer[:16]), 0).AsString()
+		buffer = buffer[16:]
+	}
+
+	if int(data_length) < len(buffer) {
+		buffer = buffer[:data_length]
+	}
+
+	switch tag {
+	case IO_REPARSE_TAG_MOUNT_POINT:
+		return result, parseReparseNames(result, buffer, 8)
+
+	case IO_REPARSE_TAG_SYMLINK:
+		if len(buffer) < 12 {
+			return nil, reparseTooShortError
+		}
+		result.Relative = binary.LittleEndian.Uint32(
+			buffer[8:])&SYMLINK_FLAG_RELATIVE != 0
+		return result, parseReparseNames(result, buffer, 12)
+
+	case IO_REPARSE_TAG_APPEXECLINK:
+		// A version followed by a list of null terminated
+		// strings.
+		if len(buffer) < 4 {
+			return nil, reparseTooShortError
+		}
+		names := splitUTF16Strings(buffer[4:])
+		if len(names) > 0 {
+			result.PackageID = names[0]
+		}
+		if len(names) > 1 {
+			result.AppUserModelID = names[1]
+		}
+		if len(names) > 2 {
+			result.SubstituteName = names[2]
+			result.PrintName = names[2]
+		}
+
+	case IO_REPARSE_TAG_LX_SYMLINK:
+		// A version followed by the UTF8 target.
+		if len(buffer) < 4 {
+			return nil, reparseTooShortError
+		}
+		result.SubstituteName = string(buffer[4:])
+		result.PrintName = result.SubstituteName
+
+	case IO_REPARSE_TAG_WOF:
+		// WOF_EXTERNAL_INFO (version, provider) followed by the
+		// provider's data. For the file provider this is
+		// FILE_PROVIDER_EXTERNAL_INFO_V1 (version, algorithm).
+		if len(buffer) < 8 {
+			return nil, reparseTooShortError
+		}
+		result.WofProvider = binary.LittleEndian.Uint32(buffer[4:])
+		if result.WofProvider == WOF_PROVIDER_FILE && len(buffer) >= 16 {
+			result.wof_algorithm = binary.LittleEndian.Uint32(buffer[12:])
+			result.WofAlgorithm = WofAlgorithmName(result.wof_algorithm)
+		}
+	}
+
+	return result, nil
+}
+
+// Junctions and symlinks store the names in a path buffer which
+// starts after the header. The offsets are relative to the path
+// buffer.
+func parseReparseNames(
+	result *ReparsePoint, buffer []byte, path_buffer_offset int) error {
+	if len(buffer) < path_buffer_offset {
+		return reparseTooShortError
+	}
+
+	path_buffer := buffer[path_buffer_offset:]
+	get_name := func(offset, length uint16) string {
+		end := int(offset) + int(length)
+		if end > len(path_buffer) {
+			return ""
+		}
+		return UTF16BytesToUTF8(path_buffer[offset:end], binary.LittleEndian)
+	}
+
+	result.SubstituteName = get_name(
+		binary.LittleEndian.Uint16(buffer[0:]),
+		binary.LittleEndian.Uint16(buffer[2:]))
+	result.PrintName = get_name(
+		binary.LittleEndian.Uint16(buffer[4:]),
+		binary.LittleEndian.Uint16(buffer[6:]))
+
+	return nil
+}
+
+// Split a buffer of null terminated UTF16 strings.
+func splitUTF16Strings(buffer []byte) []string {
+	result := []string{}
+	current := []uint16{}
+
+	for i := 0; i+1 < len(buffer); i += 2 {
+		c := binary.LittleEndian.Uint16(buffer[i:])
+		if c == 0 {
+			result = append(result, string(utf16.Decode(current)))
+			current = nil
+			continue
+		}
+		current = append(current, c)
+	}
+
+	if len(current) > 0 {
+		result = append(result, string(utf16.Decode(current)))
+	}
+
+	return result
+}
+
+// Returned when a reparse point refers to a path which is not on this
+// volume (e.g. another drive, a volume GUID path or a network share).
+type ReparseOffVolumeError struct {
+	// The path of the reparse point.
+	Path string
+
+	// The target it points to.
+	Target string
+}
+
+func (self *ReparseOffVolumeError) Error() string {
+	return fmt.Sprintf("Reparse point %v points off volume to %v",
+		self.Path, self.Target)
+}
+
+var (
+	reparseLoopError = errors.New("Reparse point loop detected")
+)
+
+// Convert the target of a junction or symlink into path components
+// on this volume. Absolute targets are relative to the root
+// directory, while relative targets are relative to the directory
+// containing the reparse point.
+func reparseTargetComponents(reparse *ReparsePoint, drive_letter string) (
+	components []string, absolute bool, ok bool) {
+	target := reparse.SubstituteName
+
+	switch reparse.Tag {
+	case IO_REPARSE_TAG_LX_SYMLINK:
+		// Absolute WSL paths are relative to the distribution's
+		// root which we do not know.
+		if strings.HasPrefix(target, "/") {
+			return nil, false, false
+		}
+		return strings.Split(target, "/"), false, true
+
+	case IO_REPARSE_TAG_SYMLINK:
+		if reparse.Relative {
+			// A relative target starting with \ is relative to
+			// the root of the volume (e.g. \Users\Public).
+			return strings.Split(target, "\\"),
+				strings.HasPrefix(target, "\\"), true
+		}
+
+	case IO_REPARSE_TAG_MOUNT_POINT:
+	default:
+		return nil, false, false
+	}
+
+	// Absolute targets are NT paths like \??\C:\Users. Volume GUID
+	// paths (\??\Volume{...}) and UNC paths (\??\UNC\server) are
+	// not on this volume.
+	target = strings.TrimPrefix(target, `\??\`)
+	if len(target) < 2 || target[1] != ':' {
+		return nil, false, false
+	}
+
+	if drive_letter != "" &&
+		!strings.EqualFold(target[:1], drive_letter[:1]) {
+		return nil, false, false
+	}
+
+	return strings.Split(target[2:], "\\"), true, true
+}
+
+// Is this reparse point something that redirects path resolution?
+func isFollowableReparsePoint(reparse *ReparsePoint) bool {
+	switch reparse.Tag {
+	case IO_REPARSE_TAG_MOUNT_POINT, IO_REPARSE_TAG_SYMLINK,
+		IO_REPARSE_TAG_LX_SYMLINK:
+		return true
+	}
+	return false
+}
+
+// Walk the path components while following junctions and
+// symlinks. We keep the stack of directories we walked through so
+// ".." components in relative targets can be resolved.
+func (self *MFT_ENTRY) openFollowingReparsePoints(
+	ntfs *NTFSContext, components []string,
+	max_hops int, drive_letter string) (*MFT_ENTRY, error) {
+	if max_hops <= 0 {
+		max_hops = DefaultMaxReparseHops
+	}
+
+	type walked struct {
+		mft_entry *MFT_ENTRY
+		name      string
+	}
+
+	stack := []walked{{mft_entry: self}}
+	get_path := func() string {
+		names := []string{}
+		for _, item := range stack[1:] {
+			names = append(names, item.name)
+		}
+		return strings.Join(names, "\\")
+	}
+
+	// Remember the reparse points we followed along with the
+	// remaining path. Seeing the same combination again means we
+	// are going around in circles.
+	seen := make(map[string]bool)
+	hops := 0
+
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		next, err := stack[len(stack)-1].mft_entry.getPathInDir(
+			ntfs, component)
+		if err != nil {
+			return nil, err
+		}
+
+		stack = append(stack, walked{mft_entry: next, name: component})
+
+		reparse, err := next.ReparsePoint(ntfs)
+		if err != nil || !isFollowableReparsePoint(reparse) {
+			continue
+		}
+
+		key := fmt.Sprintf("%d:%s", next.Record_number(),
+			strings.Join(components, "/"))
+		if seen[key] {
+			return nil, reparseLoopError
+		}
+		seen[key] = true
+
+		hops++
+		if hops > max_hops {
+			return nil, fmt.Errorf(
+				"Too many reparse points (%d) while opening %v",
+				hops, get_path())
+		}
+
+		target, absolute, ok := reparseTargetComponents(reparse, drive_letter)
+		if !ok {
+			return nil, &ReparseOffVolumeError{
+				Path:   get_path(),
+				Target: reparse.SubstituteName,
+			}
+		}
+
+		DebugPrint(DEBUG_NTFS, "Following reparse point %v -> %v\n",
+			get_path(), reparse.SubstituteName)
+
+		// Relative targets are resolved from the directory
+		// containing the reparse point.
+		stack = stack[:len(stack)-1]
+		if absolute {
+			root, err := ntfs.GetMFT(5)
+			if err != nil {
+				return nil, err
+			}
+			stack = []walked{{mft_entry: root}}
+		}
+
+		components = append(target, components...)
+	}
+
+	return stack[len(stack)-1].mft_entry, nil
+}
+
+func init() {
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_REPARSE_POINT,
+		func(data []byte) (interface{}, error) {
+			return ParseReparsePoint(data)
+		})
+}
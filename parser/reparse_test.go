@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+func utf16Bytes(s string) []byte {
+	result := []byte{}
+	for _, c := range utf16.Encode([]rune(s)) {
+		result = append(result, byte(c), byte(c>>8))
+	}
+	return result
+}
+
+func reparseBuffer(tag uint32, data []byte) []byte {
+	result := make([]byte, 8)
+	binary.LittleEndian.PutUint32(result, tag)
+	binary.LittleEndian.PutUint16(result[4:], uint16(len(data)))
+	return append(result, data...)
+}
+
+// Build the data of a junction or a symlink. Symlinks have an extra
+// flags field before the path buffer.
+func reparseNamesData(substitute, print string, flags []byte) []byte {
+	sub := utf16Bytes(substitute)
+	prn := utf16Bytes(print)
+
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint16(header[0:], 0)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(sub)))
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(sub)+2))
+	binary.LittleEndian.PutUint16(header[6:], uint16(len(prn)))
+
+	result := append(header, flags...)
+	result = append(result, sub...)
+	result = append(result, 0, 0)
+	result = append(result, prn...)
+	return append(result, 0, 0)
+}
+
+func TestReparseJunction(t *testing.T) {
+	data := reparseBuffer(IO_REPARSE_TAG_MOUNT_POINT,
+		reparseNamesData(`\??\C:\Users\Public\Documents`,
+			`C:\Users\Public\Documents`, nil))
+
+	reparse, err := ParseReparsePoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reparse.TagName != "MOUNT_POINT" ||
+		reparse.SubstituteName != `\??\C:\Users\Public\Documents` ||
+		reparse.PrintName != `C:\Users\Public\Documents` ||
+		!reparse.IsNameSurrogate() {
+		t.Fatalf("Unexpected junction %+v", reparse)
+	}
+}
+
+func TestReparseSymlink(t *testing.T) {
+	data := reparseBuffer(IO_REPARSE_TAG_SYMLINK,
+		reparseNamesData(`..\target.txt`, `..\target.txt`,
+			[]byte{SYMLINK_FLAG_RELATIVE, 0, 0, 0}))
+
+	reparse, err := ParseReparsePoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reparse.TagName != "SYMLINK" || !reparse.Relative ||
+		reparse.SubstituteName != `..\target.txt` {
+		t.Fatalf("Unexpected symlink %+v", reparse)
+	}
+}
+
+func TestReparseAppExecLink(t *testing.T) {
+	data := []byte{3, 0, 0, 0}
+	for _, s := range []string{
+		"Microsoft.WindowsTerminal_8wekyb3d8bbwe",
+		"Microsoft.WindowsTerminal_8wekyb3d8bbwe!App",
+		`C:\Program Files\WindowsApps\wt.exe`, "0"} {
+		data = append(data, utf16Bytes(s)...)
+		data = append(data, 0, 0)
+	}
+
+	reparse, err := ParseReparsePoint(
+		reparseBuffer(IO_REPARSE_TAG_APPEXECLINK, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reparse.PackageID != "Microsoft.WindowsTerminal_8wekyb3d8bbwe" ||
+		reparse.AppUserModelID != "Microsoft.WindowsTerminal_8wekyb3d8bbwe!App" ||
+		reparse.SubstituteName != `C:\Program Files\WindowsApps\wt.exe` {
+		t.Fatalf("Unexpected AppExecLink %+v", reparse)
+	}
+}
+
+func TestReparseLxSymlinkAndWof(t *testing.T) {
+	reparse, err := ParseReparsePoint(reparseBuffer(
+		IO_REPARSE_TAG_LX_SYMLINK, append([]byte{2, 0, 0, 0}, "/etc/hosts"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparse.TagName != "LX_SYMLINK" || reparse.SubstituteName != "/etc/hosts" {
+		t.Fatalf("Unexpected LX symlink %+v", reparse)
+	}
+
+	wof := []byte{1, 0, 0, 0, WOF_PROVIDER_FILE, 0, 0, 0,
+		1, 0, 0, 0, WOF_COMPRESSION_XPRESS16K, 0, 0, 0}
+	reparse, err = ParseReparsePoint(reparseBuffer(IO_REPARSE_TAG_WOF, wof))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparse.WofAlgorithm != "XPRESS16K" ||
+		reparse.wof_algorithm != WOF_COMPRESSION_XPRESS16K {
+		t.Fatalf("Unexpected WOF %+v", reparse)
+	}
+}
+
+func TestReparseTagNames(t *testing.T) {
+	for tag, expected := range map[uint32]string{
+		IO_REPARSE_TAG_DEDUP: "DEDUP",
+		IO_REPARSE_TAG_CLOUD: "CLOUD",
+		0x9000601A:           "CLOUD_6",
+		0x00001234:           "0x00001234",
+	} {
+		if ReparseTagName(tag) != expected {
+			t.Fatalf("Tag %#x: got %v expected %v",
+				tag, ReparseTagName(tag), expected)
+		}
+	}
+
+	// Non-Microsoft tags have a GUID.
+	data := reparseBuffer(0x00001234, make([]byte, 16))
+	reparse, err := ParseReparsePoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparse.GUID != "{00000000-0000-0000-0000-000000000000}" {
+		t.Fatalf("Unexpected GUID %v", reparse.GUID)
+	}
+
+	_, err = ParseReparsePoint([]byte{1, 2})
+	if err == nil {
+		t.Fatal("Expected error for short buffer")
+	}
+}
@@ -0,0 +1,278 @@
+// Support for the $Secure metadata file (MFT entry 9).
+//
+// Since NTFS 3.0 security descriptors are stored once in the $SDS
+// stream of $Secure and files refer to them by the security id in
+// their $STANDARD_INFORMATION. Two indexes refer into $SDS:
+//
+// - $SII is keyed by security id.
+// - $SDH is keyed by the hash of the descriptor (and security id).
+//
+// Both index entries hold a copy of the $SDS entry header: the hash,
+// security id, offset of the entry in $SDS and its length (including
+// the 20 byte header).
+//
+// Older volumes store the descriptor in each file's
+// $SECURITY_DESCRIPTOR attribute instead.
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	SECURE_MFT_ID = 9
+
+	SDS_ENTRY_HEADER_SIZE = 20
+
+	// $SDS is written in 256kb blocks, each followed by a mirror
+	// copy.
+	SDS_BLOCK_SIZE = 0x40000
+)
+
+var (
+	securityIdNotFoundError = errors.New("Security id not found")
+)
+
+// The header of an $SDS entry, as found in $SDS and the $SII/$SDH
+// indexes.
+type SecurityIndexEntry struct {
+	Hash       uint32
+	SecurityId uint32
+	Offset     int64
+	Length     uint32
+}
+
+func parseSecurityIndexEntry(data []byte) (*SecurityIndexEntry, error) {
+	if len(data) < SDS_ENTRY_HEADER_SIZE {
+		return nil, securityDescriptorTooShortError
+	}
+
+	return &SecurityIndexEntry{
+		Hash:       binary.LittleEndian.Uint32(data[0:]),
+		SecurityId: binary.LittleEndian.Uint32(data[4:]),
+		Offset:     int64(binary.LittleEndian.Uint64(data[8:])),
+		Length:     binary.LittleEndian.Uint32(data[16:]),
+	}, nil
+}
+
+// A decoded $SDS entry.
+type SecurityDescriptorEntry struct {
+	SecurityIndexEntry
+	Descriptor *SecurityDescriptor
+}
+
+// Cache the $SII index and the $SDS stream per context.
+type secureCache struct {
+	mu     sync.Mutex
+	loaded bool
+	err    error
+	index  map[uint32]*SecurityIndexEntry
+	sds    io.ReaderAt
+}
+
+func (self *secureCache) Purge() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.loaded = false
+	self.err = nil
+	self.index = nil
+	self.sds = nil
+}
+
+func (self *secureCache) load(ntfs *NTFSContext) error {
+	if self.loaded {
+		return self.err
+	}
+	self.loaded = true
+
+	secure, err := ntfs.GetMFT(SECURE_MFT_ID)
+	if err != nil {
+		self.err = err
+		return err
+	}
+
+	self.sds, err = OpenStream(ntfs, secure, ATTR_TYPE_DATA,
+		WILDCARD_STREAM_ID, "$SDS")
+	if err != nil {
+		self.err = err
+		return err
+	}
+
+	self.index = make(map[uint32]*SecurityIndexEntry)
+	for _, entry := range parseSecurityIndex(ntfs, secure, "$SII") {
+		self.index[entry.SecurityId] = entry
+	}
+
+	return nil
+}
+
+func parseSecurityIndex(ntfs *NTFSContext,
+	secure *MFT_ENTRY, name string) []*SecurityIndexEntry {
+	result := []*SecurityIndexEntry{}
+	for _, entry := range secure.IndexEntries(ntfs, name) {
+		sds_entry, err := parseSecurityIndexEntry(entry.Data)
+		if err == nil {
+			result = append(result, sds_entry)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SecurityId < result[j].SecurityId
+	})
+
+	return result
+}
+
+// Parse the $SII index of $Secure, sorted by security id.
+func ParseSII(ntfs *NTFSContext) ([]*SecurityIndexEntry, error) {
+	secure, err := ntfs.GetMFT(SECURE_MFT_ID)
+	if err != nil {
+		return nil, err
+	}
+	return parseSecurityIndex(ntfs, secure, "$SII"), nil
+}
+
+// Parse the $SDH index of $Secure, sorted by security id.
+func ParseSDH(ntfs *NTFSContext) ([]*SecurityIndexEntry, error) {
+	secure, err := ntfs.GetMFT(SECURE_MFT_ID)
+	if err != nil {
+		return nil, err
+	}
+	return parseSecurityIndex(ntfs, secure, "$SDH"), nil
+}
+
+// Read the $SDS entry at the offset.
+func ReadSDSEntry(sds io.ReaderAt, offset int64) (
+	*SecurityDescriptorEntry, error) {
+	header := make([]byte, SDS_ENTRY_HEADER_SIZE)
+	n, err := sds.ReadAt(header, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	entry, err := parseSecurityIndexEntry(header[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Offset != offset || entry.Length < SDS_ENTRY_HEADER_SIZE ||
+		entry.Length > SDS_BLOCK_SIZE {
+		return nil, fmt.Errorf("Invalid $SDS entry at %#x", offset)
+	}
+
+	data := make([]byte, entry.Length-SDS_ENTRY_HEADER_SIZE)
+	n, err = sds.ReadAt(data, offset+SDS_ENTRY_HEADER_SIZE)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	descriptor, err := ParseSecurityDescriptor(data[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecurityDescriptorEntry{
+		SecurityIndexEntry: *entry,
+		Descriptor:         descriptor,
+	}, nil
+}
+
+// Resolve a security id (from $STANDARD_INFORMATION) to its
+// descriptor.
+func GetSecurityDescriptor(ntfs *NTFSContext,
+	security_id uint32) (*SecurityDescriptor, error) {
+	cache := ntfs.secure_cache
+	if cache == nil {
+		return nil, securityIdNotFoundError
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	err := cache.load(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	index_entry, pres := cache.index[security_id]
+	if !pres {
+		return nil, securityIdNotFoundError
+	}
+
+	entry, err := ReadSDSEntry(cache.sds, index_entry.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Descriptor, nil
+}
+
+// Get the security descriptor of the MFT entry. This is either the
+// legacy $SECURITY_DESCRIPTOR attribute or the descriptor in $Secure
+// referred to by $STANDARD_INFORMATION.
+func (self *MFT_ENTRY) SecurityDescriptor(
+	ntfs *NTFSContext) (*SecurityDescriptor, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_SECURITY_DESCRIPTOR {
+			buf := make([]byte, CapInt64(
+				attr.DataSize(), MAX_SECURITY_DESCRIPTOR_SIZE))
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseSecurityDescriptor(buf[:n])
+		}
+	}
+
+	si, err := self.StandardInformation(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	// NTFS 1.2 $STANDARD_INFORMATION does not have a security id.
+	security_id := si.Sid()
+	if security_id == 0 {
+		return nil, securityIdNotFoundError
+	}
+
+	return GetSecurityDescriptor(ntfs, security_id)
+}
+
+// Decode all the descriptors referred to by $SII, sorted by security
+// id.
+func ParseSecureDescriptors(ntfs *NTFSContext) (
+	[]*SecurityDescriptorEntry, error) {
+	cache := ntfs.secure_cache
+	if cache == nil {
+		return nil, securityIdNotFoundError
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	err := cache.load(ntfs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*SecurityDescriptorEntry{}
+	for _, index_entry := range cache.index {
+		entry, err := ReadSDSEntry(cache.sds, index_entry.Offset)
+		if err != nil {
+			DebugPrint(DEBUG_NTFS, "ParseSecureDescriptors: %v: %v\n",
+				index_entry.SecurityId, err)
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SecurityId < result[j].SecurityId
+	})
+
+	return result, nil
+}
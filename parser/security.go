@@ -0,0 +1,358 @@
+// Decoding of self relative security descriptors, SIDs and ACLs.
+//
+// References:
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-dtyp/7d4dac05-9cef-4563-a058-f108abecce1d
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-dtyp/628ebb1d-c509-4ea0-a10f-77ef97ca4586
+
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	securityDescriptorTooShortError = errors.New("Security descriptor too short")
+	sidTooShortError                = errors.New("SID too short")
+
+	securityDescriptorControlNames = map[uint32]string{
+		0x0001: "OWNER_DEFAULTED",
+		0x0002: "GROUP_DEFAULTED",
+		0x0004: "DACL_PRESENT",
+		0x0008: "DACL_DEFAULTED",
+		0x0010: "SACL_PRESENT",
+		0x0020: "SACL_DEFAULTED",
+		0x0100: "DACL_AUTO_INHERIT_REQ",
+		0x0200: "SACL_AUTO_INHERIT_REQ",
+		0x0400: "DACL_AUTO_INHERITED",
+		0x0800: "SACL_AUTO_INHERITED",
+		0x1000: "DACL_PROTECTED",
+		0x2000: "SACL_PROTECTED",
+		0x4000: "RM_CONTROL_VALID",
+		0x8000: "SELF_RELATIVE",
+	}
+
+	aceTypeNames = map[uint8]string{
+		0x00: "ACCESS_ALLOWED",
+		0x01: "ACCESS_DENIED",
+		0x02: "SYSTEM_AUDIT",
+		0x03: "SYSTEM_ALARM",
+		0x04: "ACCESS_ALLOWED_COMPOUND",
+		0x05: "ACCESS_ALLOWED_OBJECT",
+		0x06: "ACCESS_DENIED_OBJECT",
+		0x07: "SYSTEM_AUDIT_OBJECT",
+		0x08: "SYSTEM_ALARM_OBJECT",
+		0x09: "ACCESS_ALLOWED_CALLBACK",
+		0x0A: "ACCESS_DENIED_CALLBACK",
+		0x0B: "ACCESS_ALLOWED_CALLBACK_OBJECT",
+		0x0C: "ACCESS_DENIED_CALLBACK_OBJECT",
+		0x0D: "SYSTEM_AUDIT_CALLBACK",
+		0x0E: "SYSTEM_ALARM_CALLBACK",
+		0x0F: "SYSTEM_AUDIT_CALLBACK_OBJECT",
+		0x10: "SYSTEM_ALARM_CALLBACK_OBJECT",
+		0x11: "SYSTEM_MANDATORY_LABEL",
+		0x12: "SYSTEM_RESOURCE_ATTRIBUTE",
+		0x13: "SYSTEM_SCOPED_POLICY_ID",
+	}
+
+	aceFlagNames = map[uint32]string{
+		0x01: "OBJECT_INHERIT",
+		0x02: "CONTAINER_INHERIT",
+		0x04: "NO_PROPAGATE_INHERIT",
+		0x08: "INHERIT_ONLY",
+		0x10: "INHERITED",
+		0x40: "SUCCESSFUL_ACCESS",
+		0x80: "FAILED_ACCESS",
+	}
+
+	accessMaskNames = map[uint32]string{
+		0x00000001: "FILE_READ_DATA",
+		0x00000002: "FILE_WRITE_DATA",
+		0x00000004: "FILE_APPEND_DATA",
+		0x00000008: "FILE_READ_EA",
+		0x00000010: "FILE_WRITE_EA",
+		0x00000020: "FILE_EXECUTE",
+		0x00000040: "FILE_DELETE_CHILD",
+		0x00000080: "FILE_READ_ATTRIBUTES",
+		0x00000100: "FILE_WRITE_ATTRIBUTES",
+		0x00010000: "DELETE",
+		0x00020000: "READ_CONTROL",
+		0x00040000: "WRITE_DAC",
+		0x00080000: "WRITE_OWNER",
+		0x00100000: "SYNCHRONIZE",
+		0x01000000: "ACCESS_SYSTEM_SECURITY",
+		0x02000000: "MAXIMUM_ALLOWED",
+		0x10000000: "GENERIC_ALL",
+		0x20000000: "GENERIC_EXECUTE",
+		0x40000000: "GENERIC_WRITE",
+		0x80000000: "GENERIC_READ",
+	}
+
+	wellKnownSIDs = map[string]string{
+		"S-1-0-0":      "Nobody",
+		"S-1-1-0":      "Everyone",
+		"S-1-2-0":      "Local",
+		"S-1-3-0":      "Creator Owner",
+		"S-1-3-1":      "Creator Group",
+		"S-1-3-4":      "Owner Rights",
+		"S-1-5-2":      "Network",
+		"S-1-5-4":      "Interactive",
+		"S-1-5-6":      "Service",
+		"S-1-5-7":      "Anonymous",
+		"S-1-5-11":     "Authenticated Users",
+		"S-1-5-18":     "SYSTEM",
+		"S-1-5-19":     "LOCAL SERVICE",
+		"S-1-5-20":     "NETWORK SERVICE",
+		"S-1-5-32-544": "Administrators",
+		"S-1-5-32-545": "Users",
+		"S-1-5-32-546": "Guests",
+		"S-1-5-32-547": "Power Users",
+		"S-1-5-32-551": "Backup Operators",
+		"S-1-15-2-1":   "ALL APPLICATION PACKAGES",
+		"S-1-15-2-2":   "ALL RESTRICTED APPLICATION PACKAGES",
+		"S-1-16-4096":  "Low Mandatory Level",
+		"S-1-16-8192":  "Medium Mandatory Level",
+		"S-1-16-12288": "High Mandatory Level",
+		"S-1-16-16384": "System Mandatory Level",
+
+		"S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464": "TrustedInstaller",
+	}
+)
+
+const (
+	FILE_ALL_ACCESS = 0x1F01FF
+
+	SE_DACL_PRESENT = 0x0004
+	SE_SACL_PRESENT = 0x0010
+)
+
+type SecurityDescriptor struct {
+	Control []string
+	Owner   string
+	Group   string
+
+	// Only present when the DACL_PRESENT/SACL_PRESENT control
+	// flags are set.
+	DACL *ACL `json:"DACL,omitempty"`
+	SACL *ACL `json:"SACL,omitempty"`
+}
+
+type ACL struct {
+	Revision uint8
+	ACEs     []*ACE
+}
+
+type ACE struct {
+	Type   string
+	Flags  []string `json:"Flags,omitempty"`
+	Mask   uint32
+	Access []string `json:"Access,omitempty"`
+	SID    string   `json:"SID,omitempty"`
+
+	// A friendly name for well known SIDs.
+	Name string `json:"Name,omitempty"`
+
+	// Only present in object ACEs.
+	ObjectType          string `json:"ObjectType,omitempty"`
+	InheritedObjectType string `json:"InheritedObjectType,omitempty"`
+}
+
+// Return a friendly name for well known SIDs or an empty string.
+func WellKnownSIDName(sid string) string {
+	return wellKnownSIDs[sid]
+}
+
+// Parse a binary SID into its string form (e.g. S-1-5-18). Returns the
+// string and the length of the SID in bytes.
+func ParseSID(data []byte) (string, int, error) {
+	if len(data) < 8 {
+		return "", 0, sidTooShortError
+	}
+
+	revision := data[0]
+	count := int(data[1])
+	length := 8 + 4*count
+	if len(data) < length {
+		return "", 0, sidTooShortError
+	}
+
+	// The identifier authority is a 48 bit big endian number.
+	authority := uint64(0)
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+
+	result := fmt.Sprintf("S-%d-%d", revision, authority)
+	for i := 0; i < count; i++ {
+		result += fmt.Sprintf("-%d",
+			binary.LittleEndian.Uint32(data[8+4*i:]))
+	}
+
+	return result, length, nil
+}
+
+// Parse a self relative SECURITY_DESCRIPTOR.
+func ParseSecurityDescriptor(data []byte) (*SecurityDescriptor, error) {
+	if len(data) < 20 {
+		return nil, securityDescriptorTooShortError
+	}
+
+	control := binary.LittleEndian.Uint16(data[2:])
+	owner_offset := binary.LittleEndian.Uint32(data[4:])
+	group_offset := binary.LittleEndian.Uint32(data[8:])
+	sacl_offset := binary.LittleEndian.Uint32(data[12:])
+	dacl_offset := binary.LittleEndian.Uint32(data[16:])
+
+	result := &SecurityDescriptor{
+		Control: flagNames(uint32(control), securityDescriptorControlNames),
+	}
+
+	if owner_offset != 0 && int(owner_offset) < len(data) {
+		result.Owner, _, _ = ParseSID(data[owner_offset:])
+	}
+
+	if group_offset != 0 && int(group_offset) < len(data) {
+		result.Group, _, _ = ParseSID(data[group_offset:])
+	}
+
+	var err error
+	if control&SE_DACL_PRESENT != 0 && dacl_offset != 0 &&
+		int(dacl_offset) < len(data) {
+		result.DACL, err = ParseACL(data[dacl_offset:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if control&SE_SACL_PRESENT != 0 && sacl_offset != 0 &&
+		int(sacl_offset) < len(data) {
+		result.SACL, err = ParseACL(data[sacl_offset:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func ParseACL(data []byte) (*ACL, error) {
+	if len(data) < 8 {
+		return nil, securityDescriptorTooShortError
+	}
+
+	acl_size := int(binary.LittleEndian.Uint16(data[2:]))
+	ace_count := int(binary.LittleEndian.Uint16(data[4:]))
+	if acl_size >= 8 && acl_size < len(data) {
+		data = data[:acl_size]
+	}
+
+	result := &ACL{
+		Revision: data[0],
+		ACEs:     []*ACE{},
+	}
+
+	offset := 8
+	for i := 0; i < ace_count && offset+4 <= len(data); i++ {
+		ace_size := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		if ace_size < 4 || offset+ace_size > len(data) {
+			break
+		}
+
+		result.ACEs = append(result.ACEs,
+			parseACE(data[offset:offset+ace_size]))
+		offset += ace_size
+	}
+
+	return result, nil
+}
+
+func parseACE(data []byte) *ACE {
+	ace_type := data[0]
+	result := &ACE{
+		Type:  aceTypeNames[ace_type],
+		Flags: flagNames(uint32(data[1]), aceFlagNames),
+	}
+
+	if result.Type == "" {
+		result.Type = fmt.Sprintf("%#x", ace_type)
+	}
+
+	if len(data) < 8 {
+		return result
+	}
+
+	result.Mask = binary.LittleEndian.Uint32(data[4:])
+	result.Access = AccessMaskNames(result.Mask)
+
+	sid_offset := 8
+	switch ace_type {
+	// Object ACEs have flags and optional GUIDs before the SID.
+	case 0x05, 0x06, 0x07, 0x08, 0x0B, 0x0C, 0x0F, 0x10:
+		if len(data) < 12 {
+			return result
+		}
+		object_flags := binary.LittleEndian.Uint32(data[8:])
+		sid_offset = 12
+
+		profile := NewNTFSProfile()
+		if object_flags&1 != 0 && len(data) >= sid_offset+16 {
+			result.ObjectType = profile.GUID(
+				bytes.NewReader(data[sid_offset:sid_offset+16]), 0).AsString()
+			sid_offset += 16
+		}
+		if object_flags&2 != 0 && len(data) >= sid_offset+16 {
+			result.InheritedObjectType = profile.GUID(
+				bytes.NewReader(data[sid_offset:sid_offset+16]), 0).AsString()
+			sid_offset += 16
+		}
+	}
+
+	if sid_offset < len(data) {
+		result.SID, _, _ = ParseSID(data[sid_offset:])
+		result.Name = WellKnownSIDName(result.SID)
+	}
+
+	return result
+}
+
+func AccessMaskNames(mask uint32) []string {
+	if mask&FILE_ALL_ACCESS == FILE_ALL_ACCESS {
+		return append([]string{"FILE_ALL_ACCESS"},
+			flagNames(mask&^FILE_ALL_ACCESS, accessMaskNames)...)
+	}
+	return flagNames(mask, accessMaskNames)
+}
+
+func flagNames(value uint32, names map[uint32]string) []string {
+	result := []string{}
+	for bit, name := range names {
+		if value&bit != 0 {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (self *SecurityDescriptor) String() string {
+	result := []string{fmt.Sprintf("Owner: %v Group: %v", self.Owner, self.Group)}
+	if self.DACL != nil {
+		for _, ace := range self.DACL.ACEs {
+			result = append(result, fmt.Sprintf("  %v %v %v (%v)",
+				ace.Type, ace.SID, strings.Join(ace.Access, "|"),
+				strings.Join(ace.Flags, "|")))
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+func init() {
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_SECURITY_DESCRIPTOR,
+		func(data []byte) (interface{}, error) {
+			return ParseSecurityDescriptor(data)
+		})
+}
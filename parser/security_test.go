@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func sidBytes(authority byte, sub_authorities ...uint32) []byte {
+	result := []byte{1, byte(len(sub_authorities)), 0, 0, 0, 0, 0, authority}
+	for _, sub := range sub_authorities {
+		result = append(result, byte(sub), byte(sub>>8),
+			byte(sub>>16), byte(sub>>24))
+	}
+	return result
+}
+
+func aceBytes(ace_type, flags byte, mask uint32, sid []byte) []byte {
+	result := make([]byte, 8)
+	result[0] = ace_type
+	result[1] = flags
+	binary.LittleEndian.PutUint16(result[2:], uint16(8+len(sid)))
+	binary.LittleEndian.PutUint32(result[4:], mask)
+	return append(result, sid...)
+}
+
+func TestParseSID(t *testing.T) {
+	sid, length, err := ParseSID(sidBytes(5, 21, 1, 2, 3, 1001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != "S-1-5-21-1-2-3-1001" || length != 28 {
+		t.Fatalf("Unexpected SID %v (%v)", sid, length)
+	}
+
+	_, _, err = ParseSID([]byte{1, 5, 0, 0, 0, 0, 0, 5})
+	if err == nil {
+		t.Fatal("Expected error for truncated SID")
+	}
+}
+
+func TestParseSecurityDescriptor(t *testing.T) {
+	owner := sidBytes(5, 32, 544)
+	group := sidBytes(5, 18)
+
+	aces := append(
+		aceBytes(0, 0x10, FILE_ALL_ACCESS, sidBytes(5, 18)),
+		aceBytes(1, 0x03, 0x00010000, sidBytes(1, 0))...)
+	acl := make([]byte, 8)
+	acl[0] = 2
+	binary.LittleEndian.PutUint16(acl[2:], uint16(8+len(aces)))
+	binary.LittleEndian.PutUint16(acl[4:], 2)
+	acl = append(acl, aces...)
+
+	sd := make([]byte, 20)
+	sd[0] = 1
+	binary.LittleEndian.PutUint16(sd[2:], 0x8004) // SELF_RELATIVE|DACL_PRESENT
+	binary.LittleEndian.PutUint32(sd[4:], 20)
+	binary.LittleEndian.PutUint32(sd[8:], uint32(20+len(owner)))
+	binary.LittleEndian.PutUint32(sd[16:], uint32(20+len(owner)+len(group)))
+	sd = append(sd, owner...)
+	sd = append(sd, group...)
+	sd = append(sd, acl...)
+
+	result, err := ParseSecurityDescriptor(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Owner != "S-1-5-32-544" || result.Group != "S-1-5-18" ||
+		result.SACL != nil || result.DACL == nil ||
+		len(result.DACL.ACEs) != 2 {
+		t.Fatalf("Unexpected descriptor %+v", result)
+	}
+
+	if !reflect.DeepEqual(result.Control,
+		[]string{"DACL_PRESENT", "SELF_RELATIVE"}) {
+		t.Fatalf("Unexpected control %v", result.Control)
+	}
+
+	allowed := result.DACL.ACEs[0]
+	if allowed.Type != "ACCESS_ALLOWED" || allowed.Name != "SYSTEM" ||
+		!reflect.DeepEqual(allowed.Access, []string{"FILE_ALL_ACCESS"}) ||
+		!reflect.DeepEqual(allowed.Flags, []string{"INHERITED"}) {
+		t.Fatalf("Unexpected ACE %+v", allowed)
+	}
+
+	denied := result.DACL.ACEs[1]
+	if denied.Type != "ACCESS_DENIED" || denied.SID != "S-1-1-0" ||
+		!reflect.DeepEqual(denied.Access, []string{"DELETE"}) ||
+		!reflect.DeepEqual(denied.Flags,
+			[]string{"CONTAINER_INHERIT", "OBJECT_INHERIT"}) {
+		t.Fatalf("Unexpected ACE %+v", denied)
+	}
+}
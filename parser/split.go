@@ -0,0 +1,221 @@
+// A reader for raw images split into segment files.
+//
+// Acquisition tools commonly split raw images into fixed size
+// segments named image.001, image.002 ... (FTK Imager, dc3dd) or
+// image.aa, image.ab ... (split). All segments except the last must
+// have the same size.
+
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	noSegmentsError           = errors.New("No image segments")
+	inconsistentSegmentsError = errors.New("Inconsistent segment sizes")
+)
+
+type splitSegment struct {
+	reader io.ReaderAt
+	offset int64
+	size   int64
+}
+
+type SplitReader struct {
+	segments []*splitSegment
+	closers  []io.Closer
+	size     int64
+}
+
+// Build a reader from segments in order. The sizes of the segments
+// must be given since they can not be determined from an io.ReaderAt.
+func NewSplitReader(segments []io.ReaderAt, sizes []int64) (*SplitReader, error) {
+	if len(segments) == 0 || len(segments) != len(sizes) {
+		return nil, noSegmentsError
+	}
+
+	result := &SplitReader{}
+	for idx, reader := range segments {
+		size := sizes[idx]
+
+		// All but the last segment must be the size of the first
+		// and the last one may not be larger.
+		if size <= 0 || size > sizes[0] ||
+			(idx < len(segments)-1 && size != sizes[0]) {
+			return nil, fmt.Errorf("%w: segment %v is %v bytes (expected %v)",
+				inconsistentSegmentsError, idx+1, size, sizes[0])
+		}
+
+		result.segments = append(result.segments, &splitSegment{
+			reader: reader,
+			offset: result.size,
+			size:   size,
+		})
+		result.size += size
+	}
+
+	return result, nil
+}
+
+// Find the segments of a split image from the path of the first
+// segment. Returns just the path if it does not look like a split
+// image.
+func SplitSegmentPaths(path string) []string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	if len(ext) < 2 {
+		return []string{path}
+	}
+	ext = ext[1:]
+
+	var name func(n int) string
+
+	number, err := strconv.Atoi(ext)
+	if err == nil && (number == 0 || number == 1) {
+		// Numbered segments .001, .002 keep their width.
+		name = func(n int) string {
+			return fmt.Sprintf("%s.%0*d", base, len(ext), number+n)
+		}
+
+	} else if strings.Trim(ext, "a") == "" || strings.Trim(ext, "A") == "" {
+		// Lettered segments .aa, .ab ... .az, .ba
+		name = func(n int) string {
+			suffix := []byte(ext)
+			for i := len(suffix) - 1; i >= 0 && n > 0; i-- {
+				suffix[i] += byte(n % 26)
+				n /= 26
+			}
+			if n > 0 {
+				return ""
+			}
+			return base + "." + string(suffix)
+		}
+
+	} else {
+		return []string{path}
+	}
+
+	result := []string{path}
+	for n := 1; ; n++ {
+		candidate := name(n)
+		if candidate == "" {
+			break
+		}
+
+		_, err := os.Stat(candidate)
+		if err != nil {
+			break
+		}
+		result = append(result, candidate)
+	}
+
+	return result
+}
+
+// Open a split image from the paths of its segments. If only the
+// first path is given the other segments are found by name.
+func OpenSplitImage(paths ...string) (*SplitReader, error) {
+	if len(paths) == 0 {
+		return nil, noSegmentsError
+	}
+
+	if len(paths) == 1 {
+		paths = SplitSegmentPaths(paths[0])
+	}
+
+	segments := []io.ReaderAt{}
+	sizes := []int64{}
+	closers := []io.Closer{}
+	close_all := func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}
+
+	for _, path := range paths {
+		fd, err := os.Open(path)
+		if err != nil {
+			close_all()
+			return nil, err
+		}
+		closers = append(closers, fd)
+
+		stat, err := fd.Stat()
+		if err != nil {
+			close_all()
+			return nil, err
+		}
+
+		segments = append(segments, fd)
+		sizes = append(sizes, stat.Size())
+	}
+
+	result, err := NewSplitReader(segments, sizes)
+	if err != nil {
+		close_all()
+		return nil, err
+	}
+	result.closers = closers
+
+	return result, nil
+}
+
+func (self *SplitReader) Close() {
+	for _, closer := range self.closers {
+		closer.Close()
+	}
+	self.closers = nil
+}
+
+func (self *SplitReader) Size() int64 {
+	return self.size
+}
+
+func (self *SplitReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= self.size {
+		return 0, io.EOF
+	}
+
+	// Find the first segment containing the offset.
+	idx := sort.Search(len(self.segments), func(i int) bool {
+		segment := self.segments[i]
+		return segment.offset+segment.size > offset
+	})
+
+	buf_idx := 0
+	for ; idx < len(self.segments) && buf_idx < len(buf); idx++ {
+		segment := self.segments[idx]
+		segment_offset := offset - segment.offset
+
+		to_read := segment.size - segment_offset
+		if to_read > int64(len(buf)-buf_idx) {
+			to_read = int64(len(buf) - buf_idx)
+		}
+
+		n, err := segment.reader.ReadAt(
+			buf[buf_idx:buf_idx+int(to_read)], segment_offset)
+		buf_idx += n
+		offset += int64(n)
+
+		// Segments should not be shorter than when they were opened.
+		if int64(n) < to_read {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf_idx, err
+		}
+	}
+
+	if buf_idx < len(buf) {
+		return buf_idx, io.EOF
+	}
+	return buf_idx, nil
+}
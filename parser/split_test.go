@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestSegments(t *testing.T, dir string, names []string,
+	data []byte, segment_size int) {
+	for i, name := range names {
+		end := (i + 1) * segment_size
+		if end > len(data) {
+			end = len(data)
+		}
+		err := ioutil.WriteFile(filepath.Join(dir, name),
+			data[i*segment_size:end], 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSplitReader(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	writeTestSegments(t, dir,
+		[]string{"image.001", "image.002", "image.003"}, data, 1000)
+	writeTestSegments(t, dir,
+		[]string{"image.dd.aa", "image.dd.ab", "image.dd.ac"}, data, 1000)
+
+	paths := SplitSegmentPaths(filepath.Join(dir, "image.dd.aa"))
+	if len(paths) != 3 || filepath.Base(paths[2]) != "image.dd.ac" {
+		t.Fatalf("Unexpected segments %v", paths)
+	}
+
+	// Not a split image.
+	paths = SplitSegmentPaths(filepath.Join(dir, "image.dd"))
+	if len(paths) != 1 {
+		t.Fatalf("Unexpected segments %v", paths)
+	}
+
+	split, err := OpenSplitImage(filepath.Join(dir, "image.001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer split.Close()
+
+	if split.Size() != 2500 {
+		t.Fatalf("Unexpected size %v", split.Size())
+	}
+
+	// Reads spanning all segments through the offset and paged
+	// readers and the recorder.
+	record_dir := t.TempDir()
+	paged, err := NewPagedReader(&OffsetReader{
+		Offset: 100,
+		Reader: NewRecorder(record_dir, split),
+	}, 256, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2000)
+	n, err := paged.ReadAt(buf, 300)
+	if err != nil || n != 2000 || !bytes.Equal(buf, data[400:2400]) {
+		t.Fatalf("Unexpected data %v %v", n, err)
+	}
+
+	// Reading past the end is short.
+	n, err = split.ReadAt(buf, 1500)
+	if err != io.EOF || n != 1000 || !bytes.Equal(buf[:n], data[1500:]) {
+		t.Fatalf("Unexpected data %v %v", n, err)
+	}
+
+	// An explicit list with a short middle segment is rejected.
+	writeTestSegments(t, dir, []string{"short.1"}, data[:500], 500)
+	_, err = OpenSplitImage(filepath.Join(dir, "image.001"),
+		filepath.Join(dir, "short.1"), filepath.Join(dir, "image.003"))
+	if !errors.Is(err, inconsistentSegmentsError) {
+		t.Fatalf("Expected inconsistent segments got %v", err)
+	}
+}
@@ -428,7 +428,7 @@ func CarveUSN(ctx context.Context,
 
 				case output <- &USNCarvedRecord{
 					USN_RECORD: record,
-					DiskOffset: j + i,
+					DiskOffset: carvedDiskOffset(stream, j+i),
 				}:
 				}
 			}
@@ -438,6 +438,16 @@ func CarveUSN(ctx context.Context,
 	return output
 }
 
+// When carving a stream mapped over the disk (e.g. unallocated
+// space) report the offset on the disk.
+func carvedDiskOffset(stream io.ReaderAt, offset int64) int64 {
+	unallocated, ok := stream.(*UnallocatedReader)
+	if ok {
+		return unallocated.VtoP(offset)
+	}
+	return offset
+}
+
 var (
 	year2020 = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
 	year2040 = time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
@@ -0,0 +1,117 @@
+// Common support for virtual disk formats.
+//
+// Differencing disks (VHD, VHDX, VMDK snapshots) only store the
+// blocks which changed since their parent was created. Sectors which
+// are not present in the child are read from the parent. The child
+// records hints where to find its parent (relative and absolute
+// paths), but the evidence is usually no longer at the original
+// location so the caller decides how to find it.
+
+package parser
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Differencing disks may not be nested deeper than this.
+	MAX_VIRTUAL_DISK_DEPTH = 32
+
+	// Limit the size of allocation tables we read into memory.
+	MAX_VIRTUAL_DISK_TABLE_SIZE = 256 * 1024 * 1024
+)
+
+var (
+	noParentResolverError = errors.New(
+		"Differencing disk requires a parent resolver")
+	parentMismatchError = errors.New("Parent disk identifier does not match")
+	parentTooDeepError  = errors.New("Too many nested differencing disks")
+)
+
+// Information stored in a differencing disk about its parent.
+type VirtualDiskParent struct {
+	// The unique identifier of the parent (if recorded).
+	ParentID string
+
+	// Paths to the parent file as recorded in the child. These may
+	// be relative to the child or absolute Windows paths.
+	Locators []string
+}
+
+// Find and open the parent of a differencing disk. The returned
+// reader is the parent's file (which may itself be a differencing
+// disk). The size of the file is also needed to locate trailers.
+type ParentResolver func(parent *VirtualDiskParent) (io.ReaderAt, int64, error)
+
+// Resolve parents on the local filesystem relative to the child's
+// path. Absolute paths recorded in the child are tried as is and
+// then by their base name in the child's directory.
+func NewFileParentResolver(child_path string) ParentResolver {
+	directory := filepath.Dir(child_path)
+
+	return func(parent *VirtualDiskParent) (io.ReaderAt, int64, error) {
+		candidates := []string{}
+		for _, locator := range parent.Locators {
+			if locator == "" {
+				continue
+			}
+
+			// Windows paths use \ separators.
+			normalized := strings.ReplaceAll(locator, "\\", "/")
+			if filepath.IsAbs(normalized) {
+				candidates = append(candidates, normalized)
+			} else if !strings.Contains(normalized, ":") {
+				candidates = append(candidates,
+					filepath.Join(directory, normalized))
+			}
+
+			candidates = append(candidates,
+				filepath.Join(directory, filepath.Base(normalized)))
+		}
+
+		for _, candidate := range candidates {
+			fd, err := os.Open(candidate)
+			if err != nil {
+				continue
+			}
+
+			stat, err := fd.Stat()
+			if err != nil {
+				fd.Close()
+				continue
+			}
+
+			DebugPrint(DEBUG_NTFS, "Resolved parent disk %v\n", candidate)
+			return fd, stat.Size(), nil
+		}
+
+		return nil, 0, os.ErrNotExist
+	}
+}
+
+// Read from the parent disk or fill with zeros when there is no
+// parent. Reads past the end of the parent are zero filled but any
+// other error is returned - we must not silently replace evidence
+// with zeros.
+func readParentOrZero(parent io.ReaderAt, buf []byte, offset int64) error {
+	if parent != nil {
+		n, err := parent.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		buf = buf[n:]
+	}
+
+	zeroFill(buf)
+	return nil
+}
+
+func zeroFill(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
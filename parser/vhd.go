@@ -0,0 +1,345 @@
+// A reader for Microsoft Virtual PC / Hyper-V VHD disks.
+//
+// All VHD files end with a 512 byte footer. Fixed disks are the raw
+// disk followed by the footer. Dynamic and differencing disks start
+// with a copy of the footer followed by a dynamic header which points
+// to the Block Allocation Table (BAT). Each BAT entry is the sector
+// of a block in the file (or 0xFFFFFFFF if the block is not
+// allocated). Blocks start with a bitmap of the sectors present in
+// the block followed by the data.
+//
+// For differencing disks the sectors which are not present in the
+// bitmap are read from the parent disk.
+//
+// Reference: Virtual Hard Disk Image Format Specification (Microsoft)
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	VHD_FOOTER_COOKIE  = "conectix"
+	VHD_DYNAMIC_COOKIE = "cxsparse"
+
+	VHD_FOOTER_SIZE         = 512
+	VHD_DYNAMIC_HEADER_SIZE = 1024
+	VHD_SECTOR_SIZE         = 512
+
+	VHD_DISK_TYPE_FIXED        = 2
+	VHD_DISK_TYPE_DYNAMIC      = 3
+	VHD_DISK_TYPE_DIFFERENCING = 4
+
+	VHD_UNALLOCATED_BLOCK = 0xFFFFFFFF
+)
+
+var (
+	notVHDError = errors.New("Not a VHD file")
+
+	vhdDiskTypeNames = map[uint32]string{
+		VHD_DISK_TYPE_FIXED:        "Fixed",
+		VHD_DISK_TYPE_DYNAMIC:      "Dynamic",
+		VHD_DISK_TYPE_DIFFERENCING: "Differencing",
+	}
+)
+
+type VHDReader struct {
+	reader io.ReaderAt
+
+	DiskType string
+	UniqueID string
+	size     int64
+
+	// Dynamic and differencing disks
+	block_size  int64
+	bitmap_size int64
+	bat         []uint32
+	parent      io.ReaderAt
+	Parent      *VirtualDiskParent
+}
+
+func vhdGUID(data []byte) string {
+	// Unlike Windows GUIDs the VHD identifiers are big endian.
+	return fmt.Sprintf("{%08x-%04x-%04x-%x-%x}",
+		binary.BigEndian.Uint32(data[0:]), binary.BigEndian.Uint16(data[4:]),
+		binary.BigEndian.Uint16(data[6:]), data[8:10], data[10:16])
+}
+
+// The footer checksum is the one's complement of the sum of all the
+// bytes excluding the checksum field.
+func vhdChecksum(data []byte, checksum_offset int) uint32 {
+	sum := uint32(0)
+	for i, b := range data {
+		if i >= checksum_offset && i < checksum_offset+4 {
+			continue
+		}
+		sum += uint32(b)
+	}
+	return ^sum
+}
+
+// Does the file look like a VHD? The footer is at the end of the file
+// (older tools wrote a 511 byte footer).
+func IsVHD(reader io.ReaderAt, size int64) bool {
+	_, err := findVHDFooter(reader, size)
+	return err == nil
+}
+
+func findVHDFooter(reader io.ReaderAt, size int64) ([]byte, error) {
+	for _, offset := range []int64{size - VHD_FOOTER_SIZE, size - 511, 0} {
+		if offset < 0 {
+			continue
+		}
+
+		footer := make([]byte, VHD_FOOTER_SIZE)
+		n, _ := reader.ReadAt(footer, offset)
+		if n < 511 || string(footer[:8]) != VHD_FOOTER_COOKIE {
+			continue
+		}
+
+		if vhdChecksum(footer, 64) != binary.BigEndian.Uint32(footer[64:]) {
+			DebugPrint(DEBUG_NTFS, "VHD footer at %#x has invalid checksum\n", offset)
+			continue
+		}
+		return footer, nil
+	}
+	return nil, notVHDError
+}
+
+// Open a VHD disk. The resolver is only needed for differencing disks
+// and may be nil otherwise.
+func NewVHDReader(reader io.ReaderAt, size int64,
+	resolver ParentResolver) (*VHDReader, error) {
+	return newVHDReader(reader, size, resolver, 0)
+}
+
+func newVHDReader(reader io.ReaderAt, size int64,
+	resolver ParentResolver, depth int) (*VHDReader, error) {
+	if depth > MAX_VIRTUAL_DISK_DEPTH {
+		return nil, parentTooDeepError
+	}
+
+	footer, err := findVHDFooter(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	disk_type := binary.BigEndian.Uint32(footer[60:])
+	result := &VHDReader{
+		reader:   reader,
+		DiskType: vhdDiskTypeNames[disk_type],
+		UniqueID: vhdGUID(footer[68:84]),
+		size:     int64(binary.BigEndian.Uint64(footer[48:])),
+	}
+
+	switch disk_type {
+	case VHD_DISK_TYPE_FIXED:
+		return result, nil
+
+	case VHD_DISK_TYPE_DYNAMIC, VHD_DISK_TYPE_DIFFERENCING:
+		err = result.parseDynamicHeader(
+			int64(binary.BigEndian.Uint64(footer[16:])),
+			disk_type == VHD_DISK_TYPE_DIFFERENCING)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported VHD disk type %v", disk_type)
+	}
+
+	if disk_type == VHD_DISK_TYPE_DIFFERENCING {
+		if resolver == nil {
+			return nil, noParentResolverError
+		}
+
+		parent_reader, parent_size, err := resolver(result.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("Can not open parent disk %v: %w",
+				result.Parent.Locators, err)
+		}
+
+		parent, err := newVHDReader(parent_reader, parent_size, resolver, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		if parent.UniqueID != result.Parent.ParentID {
+			return nil, fmt.Errorf("%w: expected %v got %v", parentMismatchError,
+				result.Parent.ParentID, parent.UniqueID)
+		}
+		result.parent = parent
+	}
+
+	return result, nil
+}
+
+func (self *VHDReader) parseDynamicHeader(offset int64, differencing bool) error {
+	header, err := readSector(self.reader, offset, VHD_DYNAMIC_HEADER_SIZE)
+	if err != nil {
+		return err
+	}
+
+	if string(header[:8]) != VHD_DYNAMIC_COOKIE {
+		return errors.New("Invalid VHD dynamic header")
+	}
+
+	if vhdChecksum(header, 36) != binary.BigEndian.Uint32(header[36:]) {
+		return errors.New("Invalid VHD dynamic header checksum")
+	}
+
+	table_offset := int64(binary.BigEndian.Uint64(header[16:]))
+	entries := int64(binary.BigEndian.Uint32(header[28:]))
+	self.block_size = int64(binary.BigEndian.Uint32(header[32:]))
+
+	if self.block_size < VHD_SECTOR_SIZE || self.block_size%VHD_SECTOR_SIZE != 0 ||
+		entries*self.block_size < self.size || entries*4 > MAX_VIRTUAL_DISK_TABLE_SIZE {
+		return fmt.Errorf("Invalid VHD block size %v", self.block_size)
+	}
+
+	// One bit per sector rounded up to whole sectors.
+	self.bitmap_size = (self.block_size/VHD_SECTOR_SIZE/8 +
+		VHD_SECTOR_SIZE - 1) / VHD_SECTOR_SIZE * VHD_SECTOR_SIZE
+
+	bat, err := readSector(self.reader, table_offset, entries*4)
+	if err != nil {
+		return err
+	}
+
+	self.bat = make([]uint32, entries)
+	for i := range self.bat {
+		self.bat[i] = binary.BigEndian.Uint32(bat[4*i:])
+	}
+
+	if !differencing {
+		return nil
+	}
+
+	// The parent name and locators of differencing disks.
+	self.Parent = &VirtualDiskParent{
+		ParentID: vhdGUID(header[40:56]),
+		Locators: []string{
+			UTF16BytesToUTF8(trimUTF16(header[64:576]), binary.BigEndian)},
+	}
+
+	for i := 0; i < 8; i++ {
+		entry := header[576+24*i:]
+		code := string(entry[:4])
+		length := int64(binary.BigEndian.Uint32(entry[8:]))
+		data_offset := int64(binary.BigEndian.Uint64(entry[16:]))
+
+		// We only support the Windows locators.
+		if (code != "W2ru" && code != "W2ku") || length <= 0 || length > 0x10000 {
+			continue
+		}
+
+		data, err := readSector(self.reader, data_offset, length)
+		if err != nil {
+			continue
+		}
+
+		// Relative locators go first.
+		locator := UTF16BytesToUTF8(trimUTF16(data), binary.LittleEndian)
+		if code == "W2ru" {
+			self.Parent.Locators = append(
+				[]string{locator}, self.Parent.Locators...)
+		} else {
+			self.Parent.Locators = append(self.Parent.Locators, locator)
+		}
+	}
+
+	return nil
+}
+
+func (self *VHDReader) Size() int64 {
+	return self.size
+}
+
+func (self *VHDReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= self.size {
+		return 0, io.EOF
+	}
+
+	to_read := int64(len(buf))
+	if offset+to_read > self.size {
+		to_read = self.size - offset
+	}
+
+	if self.bat == nil {
+		n, err := self.reader.ReadAt(buf[:to_read], offset)
+		if err == nil && to_read < int64(len(buf)) {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	buf_idx := int64(0)
+	for buf_idx < to_read {
+		block := offset / self.block_size
+		block_offset := offset % self.block_size
+
+		// Differencing disks are read sector by sector because
+		// each sector may come from the parent.
+		available := self.block_size - block_offset
+		if self.parent != nil {
+			available = VHD_SECTOR_SIZE - offset%VHD_SECTOR_SIZE
+		}
+		if available > to_read-buf_idx {
+			available = to_read - buf_idx
+		}
+		out := buf[buf_idx : buf_idx+available]
+
+		err := self.readSector(out, block, block_offset, offset)
+		if err != nil {
+			return int(buf_idx), err
+		}
+
+		buf_idx += available
+		offset += available
+	}
+
+	if to_read < int64(len(buf)) {
+		return int(buf_idx), io.EOF
+	}
+	return int(buf_idx), nil
+}
+
+func (self *VHDReader) readSector(out []byte,
+	block, block_offset, offset int64) error {
+	if block >= int64(len(self.bat)) ||
+		self.bat[block] == VHD_UNALLOCATED_BLOCK {
+		return readParentOrZero(self.parent, out, offset)
+	}
+
+	block_start := int64(self.bat[block]) * VHD_SECTOR_SIZE
+
+	// Only differencing disks need to check the sector bitmap - for
+	// dynamic disks all sectors in an allocated block are present.
+	if self.parent != nil {
+		sector := block_offset / VHD_SECTOR_SIZE
+		bitmap := make([]byte, 1)
+		_, err := self.reader.ReadAt(bitmap, block_start+sector/8)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if bitmap[0]&(0x80>>uint(sector%8)) == 0 {
+			return readParentOrZero(self.parent, out, offset)
+		}
+	}
+
+	n, err := self.reader.ReadAt(out,
+		block_start+self.bitmap_size+block_offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	for i := n; i < len(out); i++ {
+		out[i] = 0
+	}
+	return nil
+}
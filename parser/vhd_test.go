@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var testParentReadError = errors.New("Parent read failed")
+
+// A parent disk which fails all reads.
+type failingParent struct{}
+
+func (self failingParent) ReadAt(buf []byte, offset int64) (int, error) {
+	return 0, testParentReadError
+}
+
+func testVHDFooter(disk_type uint32, size int64,
+	unique_id byte, data_offset int64) []byte {
+	footer := make([]byte, VHD_FOOTER_SIZE)
+	copy(footer, VHD_FOOTER_COOKIE)
+	binary.BigEndian.PutUint64(footer[16:], uint64(data_offset))
+	binary.BigEndian.PutUint64(footer[40:], uint64(size))
+	binary.BigEndian.PutUint64(footer[48:], uint64(size))
+	binary.BigEndian.PutUint32(footer[60:], disk_type)
+	for i := 68; i < 84; i++ {
+		footer[i] = unique_id
+	}
+	binary.BigEndian.PutUint32(footer[64:], vhdChecksum(footer, 64))
+	return footer
+}
+
+// Build a dynamic (or differencing) disk with 4kb blocks. Blocks maps
+// the block number to its sector bitmap and data.
+func testVHDDynamic(disk_type uint32, size int64, unique_id byte,
+	parent_id byte, locator string, blocks map[int][2][]byte) []byte {
+	block_size := int64(4096)
+	entries := size / block_size
+
+	header := make([]byte, VHD_DYNAMIC_HEADER_SIZE)
+	copy(header, VHD_DYNAMIC_COOKIE)
+	binary.BigEndian.PutUint64(header[8:], 0xFFFFFFFFFFFFFFFF)
+	binary.BigEndian.PutUint64(header[16:], 1536)
+	binary.BigEndian.PutUint32(header[28:], uint32(entries))
+	binary.BigEndian.PutUint32(header[32:], uint32(block_size))
+
+	bat := make([]byte, (entries*4+511)/512*512)
+	for i := int64(0); i < entries; i++ {
+		binary.BigEndian.PutUint32(bat[4*i:], VHD_UNALLOCATED_BLOCK)
+	}
+
+	data := []byte{}
+	data_start := int64(1536 + len(bat))
+	for i := int64(0); i < entries; i++ {
+		block, pres := blocks[int(i)]
+		if !pres {
+			continue
+		}
+		binary.BigEndian.PutUint32(bat[4*i:],
+			uint32((data_start+int64(len(data)))/VHD_SECTOR_SIZE))
+
+		bitmap := make([]byte, 512)
+		copy(bitmap, block[0])
+		data = append(data, bitmap...)
+		data = append(data, block[1]...)
+	}
+
+	if parent_id != 0 {
+		for i := 40; i < 56; i++ {
+			header[i] = parent_id
+		}
+		copy(header[64:], utf16BE(filepath.Base(locator)))
+
+		// A single W2ku locator stored after the blocks.
+		value := utf16LE(locator)
+		copy(header[576:], "W2ku")
+		binary.BigEndian.PutUint32(header[584:], uint32(len(value)))
+		binary.BigEndian.PutUint64(header[592:],
+			uint64(data_start+int64(len(data))))
+		data = append(data, value...)
+	}
+	binary.BigEndian.PutUint32(header[36:], vhdChecksum(header, 36))
+
+	footer := testVHDFooter(disk_type, size, unique_id, 512)
+	result := append([]byte{}, footer...)
+	result = append(result, header...)
+	result = append(result, bat...)
+	result = append(result, data...)
+	return append(result, footer...)
+}
+
+func utf16BE(s string) []byte {
+	result := []byte{}
+	for _, c := range s {
+		result = append(result, byte(c>>8), byte(c))
+	}
+	return result
+}
+
+func TestVHDReader(t *testing.T) {
+	// A fixed disk is the raw data followed by the footer.
+	raw := bytes.Repeat([]byte("fixed..."), 1024)
+	image := append(append([]byte{}, raw...), testVHDFooter(
+		VHD_DISK_TYPE_FIXED, int64(len(raw)), 1, -1)...)
+
+	vhd, err := NewVHDReader(bytes.NewReader(image), int64(len(image)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vhd.DiskType != "Fixed" || vhd.Size() != int64(len(raw)) {
+		t.Fatalf("Unexpected fixed disk %v %v", vhd.DiskType, vhd.Size())
+	}
+
+	buf := make([]byte, 100)
+	vhd.ReadAt(buf, 4000)
+	if !bytes.Equal(buf, raw[4000:4100]) {
+		t.Fatalf("Unexpected fixed data")
+	}
+
+	// A dynamic disk with blocks 0 and 2 allocated.
+	block0 := bytes.Repeat([]byte{'A'}, 4096)
+	block2 := bytes.Repeat([]byte{'C'}, 4096)
+	parent_image := testVHDDynamic(VHD_DISK_TYPE_DYNAMIC, 16384, 2, 0, "",
+		map[int][2][]byte{0: {nil, block0}, 2: {nil, block2}})
+
+	vhd, err = NewVHDReader(bytes.NewReader(parent_image),
+		int64(len(parent_image)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(append(append(append([]byte{}, block0...),
+		make([]byte, 4096)...), block2...), make([]byte, 4096)...)
+
+	// Read across block boundaries.
+	buf = make([]byte, 16384)
+	n, err := vhd.ReadAt(buf, 0)
+	if err != nil || n != 16384 || !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected dynamic data %v %v", n, err)
+	}
+
+	// The differencing child only has the first sector of block 2 and
+	// refers to the parent by its absolute path.
+	dir := t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(dir, "parent.vhd"), parent_image, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child_block := bytes.Repeat([]byte{'c'}, 4096)
+	child_image := testVHDDynamic(VHD_DISK_TYPE_DIFFERENCING, 16384, 3, 2,
+		"C:\\VMs\\parent.vhd",
+		map[int][2][]byte{2: {[]byte{0x80}, child_block}})
+
+	child_path := filepath.Join(dir, "child.vhd")
+	_, err = NewVHDReader(bytes.NewReader(child_image),
+		int64(len(child_image)), nil)
+	if !errors.Is(err, noParentResolverError) {
+		t.Fatalf("Expected resolver error got %v", err)
+	}
+
+	vhd, err = NewVHDReader(bytes.NewReader(child_image),
+		int64(len(child_image)), NewFileParentResolver(child_path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(expected[8192:8704], child_block)
+	n, err = vhd.ReadAt(buf, 0)
+	if err != nil || n != 16384 || !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected differencing data %v %v", n, err)
+	}
+
+	// Errors reading the parent are not replaced with zeros.
+	vhd.parent = failingParent{}
+	_, err = vhd.ReadAt(buf, 0)
+	if !errors.Is(err, testParentReadError) {
+		t.Fatalf("Expected parent read error got %v", err)
+	}
+
+	// A parent with a different identifier is rejected.
+	other_parent := testVHDDynamic(VHD_DISK_TYPE_DYNAMIC, 16384, 9, 0, "", nil)
+	err = ioutil.WriteFile(filepath.Join(dir, "parent.vhd"), other_parent, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewVHDReader(bytes.NewReader(child_image),
+		int64(len(child_image)), NewFileParentResolver(child_path))
+	if !errors.Is(err, parentMismatchError) {
+		t.Fatalf("Expected parent mismatch got %v", err)
+	}
+}
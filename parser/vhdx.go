@@ -0,0 +1,715 @@
+// A reader for Hyper-V VHDX disks.
+//
+// A VHDX file starts with a file identifier followed by two copies of
+// the header (the one with the highest sequence number is current)
+// and two copies of the region table. The region table locates the
+// Block Allocation Table (BAT) and the metadata region which holds
+// the disk geometry and the parent locator of differencing disks.
+//
+// BAT entries hold the state and file offset (in MB) of each payload
+// block. Every chunk of payload block entries is followed by the
+// entry of a sector bitmap block which records which sectors of a
+// partially present block (in a differencing disk) are in this file.
+//
+// Metadata updates are first written to a circular log. If the disk
+// was not closed cleanly the log must be replayed to get a
+// consistent view. We replay the log into an in memory overlay so
+// the evidence file is never modified.
+//
+// Reference: [MS-VHDX] Virtual Hard Disk v2 (VHDX) File Format
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	VHDX_SIGNATURE = "vhdxfile"
+
+	VHDX_HEADER_SIZE       = 4096
+	VHDX_REGION_TABLE_SIZE = 64 * 1024
+	VHDX_LOG_SECTOR_SIZE   = 4096
+	VHDX_MB                = 1024 * 1024
+
+	// Payload block states in the BAT.
+	VHDX_PAYLOAD_BLOCK_NOT_PRESENT       = 0
+	VHDX_PAYLOAD_BLOCK_UNDEFINED         = 1
+	VHDX_PAYLOAD_BLOCK_ZERO              = 2
+	VHDX_PAYLOAD_BLOCK_UNMAPPED          = 3
+	VHDX_PAYLOAD_BLOCK_FULLY_PRESENT     = 6
+	VHDX_PAYLOAD_BLOCK_PARTIALLY_PRESENT = 7
+
+	vhdxBATRegion         = "{2dc27766-f623-4200-9d64-115e9bfd4a08}"
+	vhdxMetadataRegion    = "{8b7ca206-4790-4b9a-b8fe-575f050f886e}"
+	vhdxFileParameters    = "{caa16737-fa36-4d43-b3b6-33f0aa44e76b}"
+	vhdxVirtualDiskSize   = "{2fa54224-cd1b-4876-b211-5dbed83bf4b8}"
+	vhdxLogicalSectorSize = "{8141bf1d-a96f-4709-ba47-f233a8faab5f}"
+	vhdxParentLocator     = "{a8d35f2d-b30b-454d-abf7-d3d84834ab0c}"
+	vhdxNullGUID          = "{00000000-0000-0000-0000-000000000000}"
+)
+
+var (
+	notVHDXError = errors.New("Not a VHDX file")
+
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type vhdxHeader struct {
+	sequence        uint64
+	data_write_guid string
+	log_guid        string
+	log_length      int64
+	log_offset      int64
+}
+
+type VHDXReader struct {
+	reader io.ReaderAt
+
+	// The GUID of the last write to the disk's data. Differencing
+	// disks refer to their parent with it.
+	DataWriteGUID string
+
+	BlockSize         int64
+	LogicalSectorSize int64
+	size              int64
+
+	// The number of log entries replayed when the disk was opened.
+	LogEntriesReplayed int
+
+	chunk_ratio int64
+	bat         []uint64
+
+	parent io.ReaderAt
+	Parent *VirtualDiskParent
+}
+
+// Does the file start with the VHDX signature?
+func IsVHDX(reader io.ReaderAt) bool {
+	signature := make([]byte, 8)
+	n, _ := reader.ReadAt(signature, 0)
+	return n == 8 && string(signature) == VHDX_SIGNATURE
+}
+
+// Verify the CRC32C checksum of a structure. The checksum is
+// calculated with the checksum field zeroed.
+func vhdxChecksumValid(data []byte, checksum_offset int) bool {
+	expected := binary.LittleEndian.Uint32(data[checksum_offset:])
+	zeroed := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(zeroed[checksum_offset:], 0)
+	return crc32.Checksum(zeroed, castagnoliTable) == expected
+}
+
+func parseVHDXHeader(reader io.ReaderAt, offset int64) (*vhdxHeader, error) {
+	data, err := readSector(reader, offset, VHDX_HEADER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(data[:4]) != "head" || !vhdxChecksumValid(data, 4) {
+		return nil, fmt.Errorf("Invalid VHDX header at %#x", offset)
+	}
+
+	return &vhdxHeader{
+		sequence:        binary.LittleEndian.Uint64(data[8:]),
+		data_write_guid: guidString(data[32:48]),
+		log_guid:        guidString(data[48:64]),
+		log_length:      int64(binary.LittleEndian.Uint32(data[68:])),
+		log_offset:      int64(binary.LittleEndian.Uint64(data[72:])),
+	}, nil
+}
+
+// Parse the region table and return the offset and length of each
+// region by GUID.
+func parseVHDXRegionTable(reader io.ReaderAt) (map[string][2]int64, error) {
+	var last_err error
+	for _, offset := range []int64{3 * 64 * 1024, 4 * 64 * 1024} {
+		data, err := readSector(reader, offset, VHDX_REGION_TABLE_SIZE)
+		if err != nil {
+			last_err = err
+			continue
+		}
+
+		if string(data[:4]) != "regi" || !vhdxChecksumValid(data, 4) {
+			last_err = fmt.Errorf("Invalid VHDX region table at %#x", offset)
+			continue
+		}
+
+		count := int(binary.LittleEndian.Uint32(data[8:]))
+		if count > (VHDX_REGION_TABLE_SIZE-16)/32 {
+			last_err = errors.New("Too many VHDX regions")
+			continue
+		}
+
+		result := make(map[string][2]int64)
+		for i := 0; i < count; i++ {
+			entry := data[16+32*i:]
+			result[guidString(entry[:16])] = [2]int64{
+				int64(binary.LittleEndian.Uint64(entry[16:])),
+				int64(binary.LittleEndian.Uint32(entry[24:])),
+			}
+		}
+		return result, nil
+	}
+
+	return nil, last_err
+}
+
+// Open a VHDX disk. The resolver is only needed for differencing
+// disks and may be nil otherwise.
+func NewVHDXReader(reader io.ReaderAt, resolver ParentResolver) (*VHDXReader, error) {
+	return newVHDXReader(reader, resolver, 0)
+}
+
+func newVHDXReader(reader io.ReaderAt,
+	resolver ParentResolver, depth int) (*VHDXReader, error) {
+	if depth > MAX_VIRTUAL_DISK_DEPTH {
+		return nil, parentTooDeepError
+	}
+
+	if !IsVHDX(reader) {
+		return nil, notVHDXError
+	}
+
+	// Use the valid header with the highest sequence number.
+	var header *vhdxHeader
+	for _, offset := range []int64{64 * 1024, 128 * 1024} {
+		candidate, err := parseVHDXHeader(reader, offset)
+		if err != nil {
+			DebugPrint(DEBUG_NTFS, "VHDX: %v\n", err)
+			continue
+		}
+		if header == nil || candidate.sequence > header.sequence {
+			header = candidate
+		}
+	}
+
+	if header == nil {
+		return nil, errors.New("No valid VHDX header")
+	}
+
+	result := &VHDXReader{
+		reader:        reader,
+		DataWriteGUID: header.data_write_guid,
+	}
+
+	// A non zero log GUID means the log must be replayed.
+	if header.log_guid != vhdxNullGUID {
+		overlay, count, err := replayVHDXLog(reader, header)
+		if err != nil {
+			return nil, err
+		}
+		result.reader = overlay
+		result.LogEntriesReplayed = count
+	}
+
+	regions, err := parseVHDXRegionTable(result.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, pres := regions[vhdxMetadataRegion]
+	if !pres {
+		return nil, errors.New("VHDX has no metadata region")
+	}
+
+	has_parent, err := result.parseMetadata(metadata[0], metadata[1])
+	if err != nil {
+		return nil, err
+	}
+
+	bat, pres := regions[vhdxBATRegion]
+	if !pres {
+		return nil, errors.New("VHDX has no BAT region")
+	}
+
+	err = result.parseBAT(bat[0], bat[1], has_parent)
+	if err != nil {
+		return nil, err
+	}
+
+	if has_parent {
+		if resolver == nil {
+			return nil, noParentResolverError
+		}
+
+		parent_reader, _, err := resolver(result.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("Can not open parent disk %v: %w",
+				result.Parent.Locators, err)
+		}
+
+		parent, err := newVHDXReader(parent_reader, resolver, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.EqualFold(parent.DataWriteGUID, result.Parent.ParentID) {
+			return nil, fmt.Errorf("%w: expected %v got %v", parentMismatchError,
+				result.Parent.ParentID, parent.DataWriteGUID)
+		}
+		result.parent = parent
+	}
+
+	return result, nil
+}
+
+// Parse the metadata items we need. Returns true if the disk is a
+// differencing disk.
+func (self *VHDXReader) parseMetadata(offset, length int64) (bool, error) {
+	if length < 32 || length > MAX_VIRTUAL_DISK_TABLE_SIZE {
+		return false, errors.New("Invalid VHDX metadata region")
+	}
+
+	data, err := readSector(self.reader, offset, length)
+	if err != nil {
+		return false, err
+	}
+
+	if string(data[:8]) != "metadata" {
+		return false, errors.New("Invalid VHDX metadata signature")
+	}
+
+	items := make(map[string][]byte)
+	count := int(binary.LittleEndian.Uint16(data[10:]))
+	for i := 0; i < count && 32+32*(i+1) <= len(data); i++ {
+		entry := data[32+32*i:]
+		item_offset := int64(binary.LittleEndian.Uint32(entry[16:]))
+		item_length := int64(binary.LittleEndian.Uint32(entry[20:]))
+		if item_offset+item_length > length {
+			continue
+		}
+		items[guidString(entry[:16])] = data[item_offset : item_offset+item_length]
+	}
+
+	parameters := items[vhdxFileParameters]
+	disk_size := items[vhdxVirtualDiskSize]
+	sector_size := items[vhdxLogicalSectorSize]
+	if len(parameters) < 8 || len(disk_size) < 8 || len(sector_size) < 4 {
+		return false, errors.New("VHDX metadata missing required items")
+	}
+
+	self.BlockSize = int64(binary.LittleEndian.Uint32(parameters))
+	self.size = int64(binary.LittleEndian.Uint64(disk_size))
+	self.LogicalSectorSize = int64(binary.LittleEndian.Uint32(sector_size))
+
+	// Blocks are between 1MB and 256MB and sectors 512 or 4096 bytes.
+	if self.BlockSize < VHDX_MB || self.BlockSize > 256*VHDX_MB ||
+		self.BlockSize&(self.BlockSize-1) != 0 ||
+		(self.LogicalSectorSize != 512 && self.LogicalSectorSize != 4096) {
+		return false, fmt.Errorf("Invalid VHDX geometry %v/%v",
+			self.BlockSize, self.LogicalSectorSize)
+	}
+
+	has_parent := binary.LittleEndian.Uint32(parameters[4:])&2 != 0
+	if has_parent {
+		self.Parent = parseVHDXParentLocator(items[vhdxParentLocator])
+	}
+
+	return has_parent, nil
+}
+
+// The parent locator is a list of UTF16 key value pairs.
+func parseVHDXParentLocator(data []byte) *VirtualDiskParent {
+	result := &VirtualDiskParent{}
+	if len(data) < 20 {
+		return result
+	}
+
+	values := make(map[string]string)
+	count := int(binary.LittleEndian.Uint16(data[18:]))
+	for i := 0; i < count && 20+12*(i+1) <= len(data); i++ {
+		entry := data[20+12*i:]
+		key_offset := int(binary.LittleEndian.Uint32(entry[0:]))
+		value_offset := int(binary.LittleEndian.Uint32(entry[4:]))
+		key_length := int(binary.LittleEndian.Uint16(entry[8:]))
+		value_length := int(binary.LittleEndian.Uint16(entry[10:]))
+
+		if key_offset+key_length > len(data) ||
+			value_offset+value_length > len(data) {
+			continue
+		}
+
+		key := UTF16BytesToUTF8(
+			data[key_offset:key_offset+key_length], binary.LittleEndian)
+		values[key] = UTF16BytesToUTF8(
+			data[value_offset:value_offset+value_length], binary.LittleEndian)
+	}
+
+	result.ParentID = values["parent_linkage"]
+	for _, key := range []string{
+		"relative_path", "absolute_win32_path", "volume_path"} {
+		if values[key] != "" {
+			result.Locators = append(result.Locators, values[key])
+		}
+	}
+
+	return result
+}
+
+func (self *VHDXReader) parseBAT(offset, length int64, has_parent bool) error {
+	// The number of payload blocks covered by a sector bitmap block.
+	self.chunk_ratio = (1 << 23) * self.LogicalSectorSize / self.BlockSize
+
+	blocks := (self.size + self.BlockSize - 1) / self.BlockSize
+	entries := blocks + (blocks-1)/self.chunk_ratio
+	if has_parent {
+		entries = (blocks + self.chunk_ratio - 1) / self.chunk_ratio *
+			(self.chunk_ratio + 1)
+	}
+
+	if entries*8 > length || entries*8 > MAX_VIRTUAL_DISK_TABLE_SIZE {
+		return fmt.Errorf("VHDX BAT too small for %v entries", entries)
+	}
+
+	data, err := readSector(self.reader, offset, entries*8)
+	if err != nil {
+		return err
+	}
+
+	self.bat = make([]uint64, entries)
+	for i := range self.bat {
+		self.bat[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	return nil
+}
+
+func (self *VHDXReader) Size() int64 {
+	return self.size
+}
+
+func (self *VHDXReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= self.size {
+		return 0, io.EOF
+	}
+
+	to_read := int64(len(buf))
+	if offset+to_read > self.size {
+		to_read = self.size - offset
+	}
+
+	buf_idx := int64(0)
+	for buf_idx < to_read {
+		block := offset / self.BlockSize
+		block_offset := offset % self.BlockSize
+
+		available := self.BlockSize - block_offset
+		if available > to_read-buf_idx {
+			available = to_read - buf_idx
+		}
+
+		entry := self.bat[block+block/self.chunk_ratio]
+		state := entry & 7
+		file_offset := int64(entry &^ (VHDX_MB - 1))
+
+		// Partially present blocks are read sector by sector.
+		if state == VHDX_PAYLOAD_BLOCK_PARTIALLY_PRESENT {
+			available = self.LogicalSectorSize - offset%self.LogicalSectorSize
+			if available > to_read-buf_idx {
+				available = to_read - buf_idx
+			}
+		}
+		out := buf[buf_idx : buf_idx+available]
+
+		switch state {
+		case VHDX_PAYLOAD_BLOCK_FULLY_PRESENT:
+			err := self.readFile(out, file_offset+block_offset)
+			if err != nil {
+				return int(buf_idx), err
+			}
+
+		case VHDX_PAYLOAD_BLOCK_PARTIALLY_PRESENT:
+			present, err := self.isSectorPresent(block, block_offset)
+			if err != nil {
+				return int(buf_idx), err
+			}
+
+			if present {
+				err = self.readFile(out, file_offset+block_offset)
+			} else {
+				err = readParentOrZero(self.parent, out, offset)
+			}
+			if err != nil {
+				return int(buf_idx), err
+			}
+
+		case VHDX_PAYLOAD_BLOCK_NOT_PRESENT:
+			err := readParentOrZero(self.parent, out, offset)
+			if err != nil {
+				return int(buf_idx), err
+			}
+
+		default:
+			zeroFill(out)
+		}
+
+		buf_idx += available
+		offset += available
+	}
+
+	if to_read < int64(len(buf)) {
+		return int(buf_idx), io.EOF
+	}
+	return int(buf_idx), nil
+}
+
+func (self *VHDXReader) readFile(out []byte, offset int64) error {
+	n, err := self.reader.ReadAt(out, offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < len(out); i++ {
+		out[i] = 0
+	}
+	return nil
+}
+
+// Check the sector bitmap of a partially present block.
+func (self *VHDXReader) isSectorPresent(block, block_offset int64) (bool, error) {
+	chunk := block / self.chunk_ratio
+	bitmap_idx := chunk*(self.chunk_ratio+1) + self.chunk_ratio
+	if bitmap_idx >= int64(len(self.bat)) {
+		return false, nil
+	}
+
+	bitmap_entry := self.bat[bitmap_idx]
+	if bitmap_entry&7 != VHDX_PAYLOAD_BLOCK_FULLY_PRESENT {
+		return false, nil
+	}
+
+	sectors_per_block := self.BlockSize / self.LogicalSectorSize
+	sector := (block%self.chunk_ratio)*sectors_per_block +
+		block_offset/self.LogicalSectorSize
+
+	bitmap := make([]byte, 1)
+	_, err := self.reader.ReadAt(bitmap,
+		int64(bitmap_entry&^(VHDX_MB-1))+sector/8)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	return bitmap[0]&(1<<uint(sector%8)) != 0, nil
+}
+
+// An overlay of 4kb pages written by the log over the file.
+type vhdxLogOverlay struct {
+	reader io.ReaderAt
+	pages  map[int64][]byte
+}
+
+func (self *vhdxLogOverlay) ReadAt(buf []byte, offset int64) (int, error) {
+	n, err := self.reader.ReadAt(buf, offset)
+
+	for buf_idx := 0; buf_idx < len(buf); {
+		page_offset := (offset + int64(buf_idx)) % VHDX_LOG_SECTOR_SIZE
+		page_start := offset + int64(buf_idx) - page_offset
+		available := VHDX_LOG_SECTOR_SIZE - int(page_offset)
+		if available > len(buf)-buf_idx {
+			available = len(buf) - buf_idx
+		}
+
+		page, pres := self.pages[page_start]
+		if pres {
+			copy(buf[buf_idx:buf_idx+available], page[page_offset:])
+			if buf_idx+available > n {
+				n = buf_idx + available
+			}
+		}
+		buf_idx += available
+	}
+
+	if n == len(buf) {
+		err = nil
+	}
+	return n, err
+}
+
+type vhdxLogEntry struct {
+	offset   int64
+	length   int64
+	tail     int64
+	sequence uint64
+	data     []byte
+}
+
+// Read a log entry at the offset within the log. Entries may wrap
+// around the end of the circular log.
+func readVHDXLogEntry(reader io.ReaderAt, header *vhdxHeader,
+	offset int64) (*vhdxLogEntry, error) {
+	read_log := func(offset, length int64) ([]byte, error) {
+		result := make([]byte, 0, length)
+		for int64(len(result)) < length {
+			log_offset := offset % header.log_length
+			to_read := header.log_length - log_offset
+			if to_read > length-int64(len(result)) {
+				to_read = length - int64(len(result))
+			}
+			data, err := readSector(reader, header.log_offset+log_offset, to_read)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, data...)
+			offset += to_read
+		}
+		return result, nil
+	}
+
+	data, err := read_log(offset, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(data[:4]) != "loge" {
+		return nil, errors.New("Invalid log entry signature")
+	}
+
+	length := int64(binary.LittleEndian.Uint32(data[8:]))
+	if length < VHDX_LOG_SECTOR_SIZE || length%VHDX_LOG_SECTOR_SIZE != 0 ||
+		length > header.log_length {
+		return nil, errors.New("Invalid log entry length")
+	}
+
+	if guidString(data[32:48]) != header.log_guid {
+		return nil, errors.New("Log entry belongs to another log")
+	}
+
+	data, err = read_log(offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if !vhdxChecksumValid(data, 4) {
+		return nil, errors.New("Invalid log entry checksum")
+	}
+
+	return &vhdxLogEntry{
+		offset:   offset,
+		length:   length,
+		tail:     int64(binary.LittleEndian.Uint32(data[12:])),
+		sequence: binary.LittleEndian.Uint64(data[16:]),
+		data:     data,
+	}, nil
+}
+
+// Apply the descriptors of the log entry to the overlay.
+func (self *vhdxLogOverlay) apply(entry *vhdxLogEntry) error {
+	data := entry.data
+	count := int(binary.LittleEndian.Uint32(data[24:]))
+
+	// Data sectors follow the descriptors.
+	data_sector := (64 + 32*count + VHDX_LOG_SECTOR_SIZE - 1) /
+		VHDX_LOG_SECTOR_SIZE * VHDX_LOG_SECTOR_SIZE
+
+	for i := 0; i < count; i++ {
+		descriptor := data[64+32*i : 64+32*(i+1)]
+		file_offset := int64(binary.LittleEndian.Uint64(descriptor[16:]))
+		if file_offset%VHDX_LOG_SECTOR_SIZE != 0 {
+			return errors.New("Unaligned log descriptor")
+		}
+
+		switch string(descriptor[:4]) {
+		case "zero":
+			length := int64(binary.LittleEndian.Uint64(descriptor[8:]))
+			if length > MAX_VIRTUAL_DISK_TABLE_SIZE {
+				return errors.New("Log zero descriptor too large")
+			}
+			for o := int64(0); o < length; o += VHDX_LOG_SECTOR_SIZE {
+				self.pages[file_offset+o] = make([]byte, VHDX_LOG_SECTOR_SIZE)
+			}
+
+		case "desc":
+			if data_sector+VHDX_LOG_SECTOR_SIZE > len(data) {
+				return errors.New("Log entry too short")
+			}
+			sector := data[data_sector : data_sector+VHDX_LOG_SECTOR_SIZE]
+			data_sector += VHDX_LOG_SECTOR_SIZE
+
+			if string(sector[:4]) != "data" {
+				return errors.New("Invalid log data sector")
+			}
+
+			// The first 8 and last 4 bytes of the page are in the
+			// descriptor.
+			page := make([]byte, 0, VHDX_LOG_SECTOR_SIZE)
+			page = append(page, descriptor[8:16]...)
+			page = append(page, sector[8:4092]...)
+			page = append(page, descriptor[4:8]...)
+			self.pages[file_offset] = page
+
+		default:
+			return errors.New("Invalid log descriptor")
+		}
+	}
+
+	return nil
+}
+
+// Find the active sequence of log entries and replay it. The active
+// sequence is the longest run of consecutive entries with the highest
+// sequence number which contains its own tail.
+func replayVHDXLog(reader io.ReaderAt,
+	header *vhdxHeader) (*vhdxLogOverlay, int, error) {
+	if header.log_length < VHDX_LOG_SECTOR_SIZE ||
+		header.log_length%VHDX_LOG_SECTOR_SIZE != 0 ||
+		header.log_length > MAX_VIRTUAL_DISK_TABLE_SIZE {
+		return nil, 0, errors.New("Invalid VHDX log")
+	}
+
+	entries := make(map[int64]*vhdxLogEntry)
+	for offset := int64(0); offset < header.log_length; offset += VHDX_LOG_SECTOR_SIZE {
+		entry, err := readVHDXLogEntry(reader, header, offset)
+		if err == nil {
+			entries[offset] = entry
+		}
+	}
+
+	var active []*vhdxLogEntry
+	for _, start := range entries {
+		sequence := []*vhdxLogEntry{start}
+		for len(sequence) <= len(entries) {
+			last := sequence[len(sequence)-1]
+			next, pres := entries[(last.offset+last.length)%header.log_length]
+			if !pres || next.sequence != last.sequence+1 {
+				break
+			}
+			sequence = append(sequence, next)
+		}
+
+		// The sequence must start at the tail of its last entry.
+		last := sequence[len(sequence)-1]
+		for i, entry := range sequence {
+			if entry.offset == last.tail {
+				sequence = sequence[i:]
+				if active == nil ||
+					last.sequence > active[len(active)-1].sequence {
+					active = sequence
+				}
+				break
+			}
+		}
+	}
+
+	if active == nil {
+		return nil, 0, errors.New("No valid VHDX log sequence")
+	}
+
+	overlay := &vhdxLogOverlay{
+		reader: reader,
+		pages:  make(map[int64][]byte),
+	}
+
+	for _, entry := range active {
+		err := overlay.apply(entry)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	DebugPrint(DEBUG_NTFS, "VHDX: Replayed %v log entries\n", len(active))
+	return overlay, len(active), nil
+}
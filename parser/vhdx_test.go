@@ -0,0 +1,307 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Encode a GUID string in the Windows mixed endian layout.
+func testGUIDBytes(guid string) []byte {
+	data, _ := hex.DecodeString(strings.Map(func(r rune) rune {
+		if strings.ContainsRune("{}-", r) {
+			return -1
+		}
+		return r
+	}, guid))
+
+	return []byte{data[3], data[2], data[1], data[0], data[5], data[4],
+		data[7], data[6], data[8], data[9], data[10], data[11], data[12],
+		data[13], data[14], data[15]}
+}
+
+func testVHDXChecksum(data []byte, checksum_offset int) {
+	binary.LittleEndian.PutUint32(data[checksum_offset:], 0)
+	binary.LittleEndian.PutUint32(data[checksum_offset:],
+		crc32.Checksum(data, castagnoliTable))
+}
+
+const (
+	testVHDXLogOffset      = 1 * VHDX_MB
+	testVHDXLogLength      = 64 * 1024
+	testVHDXMetadataOffset = 2 * VHDX_MB
+	testVHDXBATOffset      = 3 * VHDX_MB
+)
+
+type testVHDX struct {
+	buf []byte
+}
+
+// Build a VHDX with 1MB blocks and 512 byte sectors. Payload blocks
+// are allocated from 4MB.
+func newTestVHDX(size int64, data_write_guid, log_guid string,
+	parent_locator map[string]string) *testVHDX {
+	self := &testVHDX{buf: make([]byte, 4*VHDX_MB)}
+	copy(self.buf, VHDX_SIGNATURE)
+
+	for i, offset := range []int{64 * 1024, 128 * 1024} {
+		header := self.buf[offset : offset+VHDX_HEADER_SIZE]
+		copy(header, "head")
+		binary.LittleEndian.PutUint64(header[8:], uint64(i))
+		copy(header[32:], testGUIDBytes(data_write_guid))
+		copy(header[48:], testGUIDBytes(log_guid))
+		binary.LittleEndian.PutUint32(header[68:], testVHDXLogLength)
+		binary.LittleEndian.PutUint64(header[72:], testVHDXLogOffset)
+		testVHDXChecksum(header, 4)
+	}
+
+	regions := self.buf[3*64*1024 : 4*64*1024]
+	copy(regions, "regi")
+	binary.LittleEndian.PutUint32(regions[8:], 2)
+	copy(regions[16:], testGUIDBytes(vhdxBATRegion))
+	binary.LittleEndian.PutUint64(regions[32:], testVHDXBATOffset)
+	binary.LittleEndian.PutUint32(regions[40:], VHDX_MB)
+	copy(regions[48:], testGUIDBytes(vhdxMetadataRegion))
+	binary.LittleEndian.PutUint64(regions[64:], testVHDXMetadataOffset)
+	binary.LittleEndian.PutUint32(regions[72:], VHDX_MB)
+	testVHDXChecksum(regions, 4)
+
+	// Metadata items are stored from offset 64kb in the region.
+	parameters := make([]byte, 8)
+	binary.LittleEndian.PutUint32(parameters, VHDX_MB)
+	disk_size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(disk_size, uint64(size))
+	sector_size := []byte{0, 2, 0, 0}
+
+	items := map[string][]byte{
+		vhdxFileParameters:    parameters,
+		vhdxVirtualDiskSize:   disk_size,
+		vhdxLogicalSectorSize: sector_size,
+	}
+
+	if parent_locator != nil {
+		parameters[4] = 2
+
+		locator := make([]byte, 20+12*len(parent_locator))
+		binary.LittleEndian.PutUint16(locator[18:], uint16(len(parent_locator)))
+		i := 0
+		for k, v := range parent_locator {
+			entry := locator[20+12*i:]
+			binary.LittleEndian.PutUint32(entry[0:], uint32(len(locator)))
+			binary.LittleEndian.PutUint16(entry[8:], uint16(2*len(k)))
+			locator = append(locator, utf16LE(k)...)
+			entry = locator[20+12*i:]
+			binary.LittleEndian.PutUint32(entry[4:], uint32(len(locator)))
+			binary.LittleEndian.PutUint16(entry[10:], uint16(2*len(v)))
+			locator = append(locator, utf16LE(v)...)
+			i++
+		}
+		items[vhdxParentLocator] = locator
+	}
+
+	metadata := self.buf[testVHDXMetadataOffset:]
+	copy(metadata, "metadata")
+	binary.LittleEndian.PutUint16(metadata[10:], uint16(len(items)))
+	i, item_offset := 0, 64*1024
+	for guid, item := range items {
+		entry := metadata[32+32*i:]
+		copy(entry, testGUIDBytes(guid))
+		binary.LittleEndian.PutUint32(entry[16:], uint32(item_offset))
+		binary.LittleEndian.PutUint32(entry[20:], uint32(len(item)))
+		copy(metadata[item_offset:], item)
+		item_offset += len(item)
+		i++
+	}
+
+	return self
+}
+
+// Append a 1MB block and point the BAT entry at it.
+func (self *testVHDX) SetBlock(bat_idx int, state uint64, data []byte) {
+	offset := uint64(len(self.buf))
+	block := make([]byte, VHDX_MB)
+	copy(block, data)
+	self.buf = append(self.buf, block...)
+
+	binary.LittleEndian.PutUint64(
+		self.buf[testVHDXBATOffset+8*bat_idx:], offset|state)
+}
+
+func (self *testVHDX) SetState(bat_idx int, state uint64) {
+	binary.LittleEndian.PutUint64(
+		self.buf[testVHDXBATOffset+8*bat_idx:], state)
+}
+
+// Write a log entry with a single data descriptor and a single zero
+// descriptor.
+func (self *testVHDX) AddLogEntry(log_offset int, sequence uint64,
+	tail int, log_guid string, file_offset int64, page []byte,
+	zero_offset int64) {
+	entry := make([]byte, 2*VHDX_LOG_SECTOR_SIZE)
+	copy(entry, "loge")
+	binary.LittleEndian.PutUint32(entry[8:], uint32(len(entry)))
+	binary.LittleEndian.PutUint32(entry[12:], uint32(tail))
+	binary.LittleEndian.PutUint64(entry[16:], sequence)
+	binary.LittleEndian.PutUint32(entry[24:], 2)
+	copy(entry[32:], testGUIDBytes(log_guid))
+
+	descriptor := entry[64:]
+	copy(descriptor, "desc")
+	copy(descriptor[4:8], page[4092:])
+	copy(descriptor[8:16], page[:8])
+	binary.LittleEndian.PutUint64(descriptor[16:], uint64(file_offset))
+	binary.LittleEndian.PutUint64(descriptor[24:], sequence)
+
+	descriptor = entry[96:]
+	copy(descriptor, "zero")
+	binary.LittleEndian.PutUint64(descriptor[8:], VHDX_LOG_SECTOR_SIZE)
+	binary.LittleEndian.PutUint64(descriptor[16:], uint64(zero_offset))
+	binary.LittleEndian.PutUint64(descriptor[24:], sequence)
+
+	sector := entry[VHDX_LOG_SECTOR_SIZE:]
+	copy(sector, "data")
+	binary.LittleEndian.PutUint32(sector[4:], uint32(sequence>>32))
+	copy(sector[8:4092], page[8:4092])
+	binary.LittleEndian.PutUint32(sector[4092:], uint32(sequence))
+
+	testVHDXChecksum(entry, 4)
+	copy(self.buf[testVHDXLogOffset+log_offset:], entry)
+}
+
+const (
+	testVHDXNoLog       = vhdxNullGUID
+	testVHDXLogGUID     = "{11111111-2222-3333-4444-555555555555}"
+	testVHDXParentGUID  = "{aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee}"
+	testVHDXChildGUID   = "{12345678-9abc-def0-1234-56789abcdef0}"
+	testVHDXMissingGUID = "{99999999-9999-9999-9999-999999999999}"
+)
+
+func TestVHDXReader(t *testing.T) {
+	// Block 0 is present, block 1 is zero and block 2 is not present.
+	parent := newTestVHDX(3*VHDX_MB, testVHDXParentGUID, testVHDXNoLog, nil)
+	parent.SetBlock(0, VHDX_PAYLOAD_BLOCK_FULLY_PRESENT,
+		bytes.Repeat([]byte{'A'}, VHDX_MB))
+	parent.SetState(1, VHDX_PAYLOAD_BLOCK_ZERO)
+
+	vhdx, err := NewVHDXReader(bytes.NewReader(parent.buf), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vhdx.Size() != 3*VHDX_MB || vhdx.DataWriteGUID != testVHDXParentGUID {
+		t.Fatalf("Unexpected disk %v %v", vhdx.Size(), vhdx.DataWriteGUID)
+	}
+
+	expected := append(bytes.Repeat([]byte{'A'}, VHDX_MB),
+		make([]byte, 2*VHDX_MB)...)
+
+	buf := make([]byte, 3*VHDX_MB)
+	n, err := vhdx.ReadAt(buf, 0)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected data %v %v", n, err)
+	}
+
+	// A dirty disk: the stale entry is superseded by the later one
+	// which writes a page into block 0 and zeros another.
+	dirty := newTestVHDX(3*VHDX_MB, testVHDXParentGUID, testVHDXLogGUID, nil)
+	dirty.SetBlock(0, VHDX_PAYLOAD_BLOCK_FULLY_PRESENT,
+		bytes.Repeat([]byte{'A'}, VHDX_MB))
+	dirty.AddLogEntry(0, 10, 0, testVHDXLogGUID, 4*VHDX_MB,
+		bytes.Repeat([]byte{'S'}, 4096), 4*VHDX_MB+4096)
+	dirty.AddLogEntry(8192, 20, 8192, testVHDXLogGUID, 4*VHDX_MB,
+		bytes.Repeat([]byte{'L'}, 4096), 4*VHDX_MB+8192)
+
+	// An entry from another log is ignored.
+	dirty.AddLogEntry(16384, 30, 16384, testVHDXMissingGUID, 4*VHDX_MB,
+		bytes.Repeat([]byte{'X'}, 4096), 4*VHDX_MB)
+
+	original := append([]byte{}, dirty.buf...)
+	vhdx, err = NewVHDXReader(bytes.NewReader(dirty.buf), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vhdx.LogEntriesReplayed != 1 {
+		t.Fatalf("Expected 1 replayed entry got %v", vhdx.LogEntriesReplayed)
+	}
+
+	copy(expected, bytes.Repeat([]byte{'L'}, 4096))
+	copy(expected[8192:], make([]byte, 4096))
+	n, err = vhdx.ReadAt(buf, 0)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected replayed data %v %v", n, err)
+	}
+
+	// The image itself is not modified.
+	if !bytes.Equal(original, dirty.buf) {
+		t.Fatalf("Log replay modified the image")
+	}
+
+	// A differencing child with the first sector of block 0 and all
+	// of block 2.
+	child := newTestVHDX(3*VHDX_MB, testVHDXChildGUID, testVHDXNoLog,
+		map[string]string{
+			"parent_linkage": testVHDXParentGUID,
+			"relative_path":  ".\\parent.vhdx",
+		})
+	child.SetBlock(0, VHDX_PAYLOAD_BLOCK_PARTIALLY_PRESENT,
+		bytes.Repeat([]byte{'c'}, VHDX_MB))
+	child.SetBlock(2, VHDX_PAYLOAD_BLOCK_FULLY_PRESENT,
+		bytes.Repeat([]byte{'Z'}, VHDX_MB))
+
+	// The sector bitmap block follows the chunk's 4096 payload entries.
+	child.SetBlock(4096, VHDX_PAYLOAD_BLOCK_FULLY_PRESENT, []byte{1})
+
+	_, err = NewVHDXReader(bytes.NewReader(child.buf), nil)
+	if !errors.Is(err, noParentResolverError) {
+		t.Fatalf("Expected resolver error got %v", err)
+	}
+
+	var locators []string
+	resolver := func(p *VirtualDiskParent) (io.ReaderAt, int64, error) {
+		locators = p.Locators
+		return bytes.NewReader(parent.buf), int64(len(parent.buf)), nil
+	}
+
+	vhdx, err = NewVHDXReader(bytes.NewReader(child.buf), resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(locators) != 1 || locators[0] != ".\\parent.vhdx" {
+		t.Fatalf("Unexpected locators %v", locators)
+	}
+
+	expected = append(bytes.Repeat([]byte{'A'}, VHDX_MB),
+		make([]byte, VHDX_MB)...)
+	expected = append(expected, bytes.Repeat([]byte{'Z'}, VHDX_MB)...)
+	copy(expected, bytes.Repeat([]byte{'c'}, 512))
+
+	n, err = vhdx.ReadAt(buf, 0)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected differencing data %v %v", n, err)
+	}
+
+	// Errors reading the parent are not replaced with zeros.
+	vhdx.parent = failingParent{}
+	_, err = vhdx.ReadAt(buf, 0)
+	if !errors.Is(err, testParentReadError) {
+		t.Fatalf("Expected parent read error got %v", err)
+	}
+
+	// The parent linkage must match the parent's data write GUID.
+	resolver = func(p *VirtualDiskParent) (io.ReaderAt, int64, error) {
+		return bytes.NewReader(dirty.buf), int64(len(dirty.buf)), nil
+	}
+	dirty_child := newTestVHDX(3*VHDX_MB, testVHDXChildGUID, testVHDXNoLog,
+		map[string]string{"parent_linkage": testVHDXMissingGUID})
+	_, err = NewVHDXReader(bytes.NewReader(dirty_child.buf), resolver)
+	if !errors.Is(err, parentMismatchError) {
+		t.Fatalf("Expected parent mismatch got %v", err)
+	}
+}
@@ -0,0 +1,639 @@
+// A reader for VMware VMDK disks.
+//
+// A VMDK disk is described by a text descriptor which lists the
+// extents making up the disk. The descriptor may be a separate file
+// (e.g. split sparse or flat disks) or embedded in a monolithic
+// sparse extent.
+//
+// Sparse extents start with a header pointing to the grain directory
+// which points to grain tables. Each grain table entry is the sector
+// of a grain (0 means not allocated and 1 means a zero grain). Stream
+// optimized disks compress each grain and store the grain directory
+// at the end of the file.
+//
+// Snapshots are sparse disks which refer to their parent through the
+// parentCID which must match the parent's CID. Grains not allocated
+// in the snapshot are read from the parent.
+//
+// Reference: VMware Virtual Disk Format 5.0
+
+package parser
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	VMDK_SPARSE_MAGIC     = "KDMV"
+	VMDK_DESCRIPTOR_MAGIC = "# Disk DescriptorFile"
+
+	VMDK_SECTOR_SIZE = 512
+
+	// The grain directory of stream optimized disks is in the footer.
+	VMDK_GD_AT_END = 0xFFFFFFFFFFFFFFFF
+
+	VMDK_FLAG_COMPRESSED = 1 << 16
+
+	// No parent is recorded as parentCID=ffffffff
+	VMDK_NO_PARENT = "ffffffff"
+
+	// Limit the size of text descriptors we read.
+	MAX_VMDK_DESCRIPTOR_SIZE = 1024 * 1024
+
+	// The number of decompressed grains to cache.
+	VMDK_GRAIN_CACHE_SIZE = 64
+)
+
+var (
+	notVMDKError = errors.New("Not a VMDK file")
+
+	vmdkExtentRegex = regexp.MustCompile(
+		`^(RW|RDONLY|NOACCESS)\s+(\d+)\s+(\w+)(?:\s+"([^"]*)"(?:\s+(\d+))?)?`)
+)
+
+// An extent line in the descriptor.
+type VMDKExtentDescription struct {
+	Access   string
+	Sectors  int64
+	Type     string
+	FileName string
+
+	// The offset of flat extents in the file (in sectors).
+	Offset int64
+}
+
+type VMDKDescriptor struct {
+	// Key values from the descriptor (e.g. createType, ddb.uuid)
+	Values map[string]string
+
+	CID                string
+	ParentCID          string
+	CreateType         string
+	ParentFileNameHint string
+
+	Extents []VMDKExtentDescription
+}
+
+func ParseVMDKDescriptor(text string) (*VMDKDescriptor, error) {
+	result := &VMDKDescriptor{
+		Values: make(map[string]string),
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		match := vmdkExtentRegex.FindStringSubmatch(line)
+		if match != nil {
+			sectors, _ := strconv.ParseInt(match[2], 10, 64)
+			offset, _ := strconv.ParseInt(match[5], 10, 64)
+			result.Extents = append(result.Extents, VMDKExtentDescription{
+				Access:   match[1],
+				Sectors:  sectors,
+				Type:     strings.ToUpper(match[3]),
+				FileName: match[4],
+				Offset:   offset,
+			})
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		result.Values[strings.TrimSpace(parts[0])] = strings.Trim(
+			strings.TrimSpace(parts[1]), "\"")
+	}
+
+	result.CID = strings.ToLower(result.Values["CID"])
+	result.ParentCID = strings.ToLower(result.Values["parentCID"])
+	result.CreateType = result.Values["createType"]
+	result.ParentFileNameHint = result.Values["parentFileNameHint"]
+
+	if len(result.Extents) == 0 {
+		return nil, errors.New("VMDK descriptor has no extents")
+	}
+
+	return result, nil
+}
+
+// Opens the extent files named in the descriptor.
+type VMDKExtentOpener func(name string) (io.ReaderAt, int64, error)
+
+type vmdkExtent struct {
+	// The offset and size of the extent in the disk (in bytes).
+	start int64
+	size  int64
+
+	extent_type string
+	reader      io.ReaderAt
+	offset      int64
+	sparse      *vmdkSparseExtent
+}
+
+type VMDKReader struct {
+	Descriptor *VMDKDescriptor
+
+	extents []*vmdkExtent
+	size    int64
+
+	parent io.ReaderAt
+	Parent *VirtualDiskParent
+}
+
+// Does the file start with a sparse header or a text descriptor?
+func IsVMDK(reader io.ReaderAt) bool {
+	header := make([]byte, len(VMDK_DESCRIPTOR_MAGIC))
+	n, _ := reader.ReadAt(header, 0)
+	return (n >= 4 && string(header[:4]) == VMDK_SPARSE_MAGIC) ||
+		string(header[:n]) == VMDK_DESCRIPTOR_MAGIC
+}
+
+// Open a VMDK from the filesystem. Extents and parents are found
+// relative to the descriptor.
+func OpenVMDK(path string) (*VMDKReader, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	directory := filepath.Dir(path)
+	opener := func(name string) (io.ReaderAt, int64, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(directory, name)
+		}
+
+		fd, err := os.Open(name)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		stat, err := fd.Stat()
+		if err != nil {
+			fd.Close()
+			return nil, 0, err
+		}
+		return fd, stat.Size(), nil
+	}
+
+	result, err := NewVMDKReader(fd, stat.Size(), opener,
+		NewFileParentResolver(path))
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// Open a VMDK from its descriptor file or monolithic sparse extent.
+// The opener finds extents named in a descriptor file and the
+// resolver finds the parent of snapshots. Extents of parents are
+// opened with the same opener.
+func NewVMDKReader(reader io.ReaderAt, size int64,
+	opener VMDKExtentOpener, resolver ParentResolver) (*VMDKReader, error) {
+	return newVMDKReader(reader, size, opener, resolver, 0)
+}
+
+func newVMDKReader(reader io.ReaderAt, size int64,
+	opener VMDKExtentOpener, resolver ParentResolver,
+	depth int) (*VMDKReader, error) {
+	if depth > MAX_VIRTUAL_DISK_DEPTH {
+		return nil, parentTooDeepError
+	}
+
+	if !IsVMDK(reader) {
+		return nil, notVMDKError
+	}
+
+	result := &VMDKReader{}
+
+	// A monolithic sparse extent with an embedded descriptor.
+	var sparse *vmdkSparseExtent
+	magic := make([]byte, 4)
+	reader.ReadAt(magic, 0)
+
+	if string(magic) == VMDK_SPARSE_MAGIC {
+		var err error
+		sparse, err = newVMDKSparseExtent(reader, size)
+		if err != nil {
+			return nil, err
+		}
+
+		// Without a descriptor the extent is the whole disk.
+		if sparse.descriptor == "" {
+			result.Descriptor = &VMDKDescriptor{
+				Values:    make(map[string]string),
+				ParentCID: VMDK_NO_PARENT,
+				Extents: []VMDKExtentDescription{{
+					Access:  "RW",
+					Sectors: sparse.capacity / VMDK_SECTOR_SIZE,
+					Type:    "SPARSE",
+				}},
+			}
+		} else {
+			result.Descriptor, err = ParseVMDKDescriptor(sparse.descriptor)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	} else {
+		if size > MAX_VMDK_DESCRIPTOR_SIZE {
+			return nil, errors.New("VMDK descriptor too large")
+		}
+
+		data, err := readSector(reader, 0, size)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Descriptor, err = ParseVMDKDescriptor(string(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, description := range result.Descriptor.Extents {
+		extent := &vmdkExtent{
+			start:       result.size,
+			size:        description.Sectors * VMDK_SECTOR_SIZE,
+			extent_type: description.Type,
+			offset:      description.Offset * VMDK_SECTOR_SIZE,
+		}
+		result.size += extent.size
+
+		if description.Type == "ZERO" {
+			result.extents = append(result.extents, extent)
+			continue
+		}
+
+		// The embedded descriptor of a monolithic disk describes
+		// the file itself.
+		if sparse != nil && len(result.Descriptor.Extents) == 1 {
+			extent.reader = reader
+			extent.sparse = sparse
+			result.extents = append(result.extents, extent)
+			continue
+		}
+
+		if opener == nil {
+			return nil, fmt.Errorf("No opener for VMDK extent %v",
+				description.FileName)
+		}
+
+		extent_reader, extent_size, err := opener(description.FileName)
+		if err != nil {
+			return nil, fmt.Errorf("Can not open VMDK extent %v: %w",
+				description.FileName, err)
+		}
+		extent.reader = extent_reader
+
+		switch description.Type {
+		case "FLAT", "VMFS", "VMFSRAW", "VMFSRDM":
+
+		case "SPARSE":
+			extent.sparse, err = newVMDKSparseExtent(extent_reader, extent_size)
+			if err != nil {
+				return nil, fmt.Errorf("VMDK extent %v: %w",
+					description.FileName, err)
+			}
+
+		default:
+			return nil, fmt.Errorf("Unsupported VMDK extent type %v",
+				description.Type)
+		}
+
+		result.extents = append(result.extents, extent)
+	}
+
+	parent_cid := result.Descriptor.ParentCID
+	if parent_cid != "" && parent_cid != VMDK_NO_PARENT {
+		if resolver == nil {
+			return nil, noParentResolverError
+		}
+
+		result.Parent = &VirtualDiskParent{
+			ParentID: parent_cid,
+			Locators: []string{result.Descriptor.ParentFileNameHint},
+		}
+
+		parent_reader, parent_size, err := resolver(result.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("Can not open parent disk %v: %w",
+				result.Parent.Locators, err)
+		}
+
+		parent, err := newVMDKReader(parent_reader, parent_size,
+			opener, resolver, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		if parent.Descriptor.CID != parent_cid {
+			return nil, fmt.Errorf("%w: expected %v got %v", parentMismatchError,
+				parent_cid, parent.Descriptor.CID)
+		}
+		result.parent = parent
+	}
+
+	return result, nil
+}
+
+func (self *VMDKReader) Size() int64 {
+	return self.size
+}
+
+func (self *VMDKReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= self.size {
+		return 0, io.EOF
+	}
+
+	to_read := int64(len(buf))
+	if offset+to_read > self.size {
+		to_read = self.size - offset
+	}
+
+	buf_idx := int64(0)
+	for _, extent := range self.extents {
+		if buf_idx >= to_read {
+			break
+		}
+
+		if offset >= extent.start+extent.size {
+			continue
+		}
+
+		extent_offset := offset - extent.start
+		available := extent.size - extent_offset
+		if available > to_read-buf_idx {
+			available = to_read - buf_idx
+		}
+
+		err := self.readExtent(extent, buf[buf_idx:buf_idx+available],
+			extent_offset, offset)
+		if err != nil {
+			return int(buf_idx), err
+		}
+
+		buf_idx += available
+		offset += available
+	}
+
+	if to_read < int64(len(buf)) {
+		return int(buf_idx), io.EOF
+	}
+	return int(buf_idx), nil
+}
+
+func (self *VMDKReader) readExtent(extent *vmdkExtent, out []byte,
+	extent_offset, offset int64) error {
+	if extent.reader == nil {
+		zeroFill(out)
+		return nil
+	}
+
+	if extent.sparse == nil {
+		n, err := extent.reader.ReadAt(out, extent.offset+extent_offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		zeroFill(out[n:])
+		return nil
+	}
+
+	// Sparse extents are read grain by grain since each grain may
+	// come from the parent.
+	for len(out) > 0 {
+		grain_size := extent.sparse.grain_size
+		available := grain_size - extent_offset%grain_size
+		if available > int64(len(out)) {
+			available = int64(len(out))
+		}
+
+		present, err := extent.sparse.readGrain(out[:available], extent_offset)
+		if err != nil {
+			return err
+		}
+
+		if !present {
+			err = readParentOrZero(self.parent, out[:available], offset)
+			if err != nil {
+				return err
+			}
+		}
+
+		out = out[available:]
+		extent_offset += available
+		offset += available
+	}
+
+	return nil
+}
+
+type vmdkSparseExtent struct {
+	reader io.ReaderAt
+
+	// In bytes
+	capacity    int64
+	grain_size  int64
+	gtes_per_gt int64
+	compressed  bool
+
+	gd         []uint32
+	descriptor string
+
+	cache *LRU
+}
+
+func newVMDKSparseExtent(reader io.ReaderAt, size int64) (*vmdkSparseExtent, error) {
+	header, err := readSector(reader, 0, VMDK_SECTOR_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(header[:4]) != VMDK_SPARSE_MAGIC {
+		return nil, notVMDKError
+	}
+
+	gd_offset := binary.LittleEndian.Uint64(header[56:])
+
+	// Stream optimized disks have the real header in the footer
+	// before the end of stream marker.
+	if gd_offset == VMDK_GD_AT_END {
+		header, err = readSector(reader, size-2*VMDK_SECTOR_SIZE, VMDK_SECTOR_SIZE)
+		if err != nil {
+			return nil, err
+		}
+
+		if string(header[:4]) != VMDK_SPARSE_MAGIC {
+			return nil, errors.New("Invalid VMDK footer")
+		}
+		gd_offset = binary.LittleEndian.Uint64(header[56:])
+	}
+
+	cache, err := NewLRU(VMDK_GRAIN_CACHE_SIZE, nil, "VMDKGrains")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &vmdkSparseExtent{
+		reader:      reader,
+		capacity:    int64(binary.LittleEndian.Uint64(header[12:])) * VMDK_SECTOR_SIZE,
+		grain_size:  int64(binary.LittleEndian.Uint64(header[20:])) * VMDK_SECTOR_SIZE,
+		gtes_per_gt: int64(binary.LittleEndian.Uint32(header[44:])),
+		compressed:  binary.LittleEndian.Uint32(header[8:])&VMDK_FLAG_COMPRESSED != 0,
+		cache:       cache,
+	}
+
+	if result.grain_size <= 0 || result.grain_size > 128*1024*1024 ||
+		result.grain_size&(result.grain_size-1) != 0 ||
+		result.gtes_per_gt <= 0 || result.gtes_per_gt > 0x10000 {
+		return nil, fmt.Errorf("Invalid VMDK grain size %v", result.grain_size)
+	}
+
+	gd_entries := (result.capacity + result.grain_size*result.gtes_per_gt - 1) /
+		(result.grain_size * result.gtes_per_gt)
+	if gd_entries*4 > MAX_VIRTUAL_DISK_TABLE_SIZE {
+		return nil, errors.New("VMDK grain directory too large")
+	}
+
+	gd, err := readSector(reader, int64(gd_offset)*VMDK_SECTOR_SIZE, gd_entries*4)
+	if err != nil {
+		return nil, err
+	}
+
+	result.gd = make([]uint32, gd_entries)
+	for i := range result.gd {
+		result.gd[i] = binary.LittleEndian.Uint32(gd[4*i:])
+	}
+
+	descriptor_offset := int64(binary.LittleEndian.Uint64(header[28:]))
+	descriptor_size := int64(binary.LittleEndian.Uint64(header[36:])) * VMDK_SECTOR_SIZE
+	if descriptor_offset > 0 && descriptor_size > 0 &&
+		descriptor_size <= MAX_VMDK_DESCRIPTOR_SIZE {
+		data, err := readSector(reader,
+			descriptor_offset*VMDK_SECTOR_SIZE, descriptor_size)
+		if err != nil {
+			return nil, err
+		}
+
+		// The descriptor is padded with nulls.
+		end := bytes.IndexByte(data, 0)
+		if end >= 0 {
+			data = data[:end]
+		}
+		result.descriptor = string(data)
+	}
+
+	return result, nil
+}
+
+// Read from a single grain. Returns false if the grain is not
+// allocated in this extent.
+func (self *vmdkSparseExtent) readGrain(out []byte, offset int64) (bool, error) {
+	if offset >= self.capacity {
+		return false, nil
+	}
+
+	grain := offset / self.grain_size
+	gd_idx := grain / self.gtes_per_gt
+	gt_sector := self.gd[gd_idx]
+	if gt_sector == 0 {
+		return false, nil
+	}
+
+	gte, err := readSector(self.reader,
+		int64(gt_sector)*VMDK_SECTOR_SIZE+(grain%self.gtes_per_gt)*4, 4)
+	if err != nil {
+		return false, err
+	}
+
+	grain_sector := int64(binary.LittleEndian.Uint32(gte))
+	switch grain_sector {
+	case 0:
+		return false, nil
+
+	// A zeroed grain hides the parent.
+	case 1:
+		zeroFill(out)
+		return true, nil
+	}
+
+	grain_offset := offset % self.grain_size
+	if !self.compressed {
+		n, err := self.reader.ReadAt(out,
+			grain_sector*VMDK_SECTOR_SIZE+grain_offset)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		zeroFill(out[n:])
+		return true, nil
+	}
+
+	data, err := self.getCompressedGrain(grain_sector)
+	if err != nil {
+		return false, err
+	}
+
+	n := 0
+	if grain_offset < int64(len(data)) {
+		n = copy(out, data[grain_offset:])
+	}
+	zeroFill(out[n:])
+	return true, nil
+}
+
+// Compressed grains start with the LBA and the compressed size
+// followed by the zlib stream.
+func (self *vmdkSparseExtent) getCompressedGrain(sector int64) ([]byte, error) {
+	cached, pres := self.cache.Get(int(sector))
+	if pres {
+		return cached.([]byte), nil
+	}
+
+	header, err := readSector(self.reader, sector*VMDK_SECTOR_SIZE, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(binary.LittleEndian.Uint32(header[8:]))
+	if size > 2*self.grain_size+VMDK_SECTOR_SIZE {
+		return nil, fmt.Errorf("Invalid VMDK compressed grain at sector %v", sector)
+	}
+
+	data, err := readSector(self.reader, sector*VMDK_SECTOR_SIZE+12, size)
+	if err != nil {
+		return nil, err
+	}
+
+	zlib_reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	result, err := io.ReadAll(io.LimitReader(zlib_reader, self.grain_size))
+	zlib_reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	self.cache.Add(int(sector), result)
+	return result, nil
+}
@@ -0,0 +1,248 @@
+package parser
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// Build a sparse extent with 4kb grains and a single grain table.
+// Grains of nil are zero grains.
+func testVMDKSparse(capacity int64, descriptor string,
+	grains map[int][]byte, compressed bool) []byte {
+	header := make([]byte, VMDK_SECTOR_SIZE)
+	copy(header, VMDK_SPARSE_MAGIC)
+	binary.LittleEndian.PutUint32(header[4:], 1)
+	binary.LittleEndian.PutUint64(header[12:], uint64(capacity/VMDK_SECTOR_SIZE))
+	binary.LittleEndian.PutUint64(header[20:], 8)
+	binary.LittleEndian.PutUint32(header[44:], 512)
+
+	if descriptor != "" {
+		binary.LittleEndian.PutUint64(header[28:], 1)
+		binary.LittleEndian.PutUint64(header[36:], 20)
+	}
+
+	// The grain directory at sector 21 and the grain table at 22.
+	gd := make([]byte, VMDK_SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(gd, 22)
+	gt := make([]byte, 4*VMDK_SECTOR_SIZE)
+
+	data := []byte{}
+	data_sector := 26
+	for i := 0; i < 512; i++ {
+		grain, pres := grains[i]
+		if !pres {
+			continue
+		}
+
+		if grain == nil {
+			binary.LittleEndian.PutUint32(gt[4*i:], 1)
+			continue
+		}
+
+		binary.LittleEndian.PutUint32(gt[4*i:],
+			uint32(data_sector+len(data)/VMDK_SECTOR_SIZE))
+
+		if compressed {
+			b := &bytes.Buffer{}
+			w := zlib.NewWriter(b)
+			w.Write(grain)
+			w.Close()
+
+			marker := make([]byte, 12)
+			binary.LittleEndian.PutUint64(marker, uint64(i*8))
+			binary.LittleEndian.PutUint32(marker[8:], uint32(b.Len()))
+			grain = append(marker, b.Bytes()...)
+		}
+
+		padded := make([]byte, (len(grain)+VMDK_SECTOR_SIZE-1)/
+			VMDK_SECTOR_SIZE*VMDK_SECTOR_SIZE)
+		copy(padded, grain)
+		data = append(data, padded...)
+	}
+
+	desc := make([]byte, 20*VMDK_SECTOR_SIZE)
+	copy(desc, descriptor)
+
+	if !compressed {
+		binary.LittleEndian.PutUint64(header[56:], 21)
+	} else {
+		binary.LittleEndian.PutUint32(header[8:], VMDK_FLAG_COMPRESSED)
+		binary.LittleEndian.PutUint64(header[56:], VMDK_GD_AT_END)
+	}
+
+	result := append([]byte{}, header...)
+	result = append(result, desc...)
+	result = append(result, gd...)
+	result = append(result, gt...)
+	result = append(result, data...)
+
+	// The footer holds the real grain directory offset followed by
+	// the end of stream marker.
+	if compressed {
+		footer := append([]byte{}, header...)
+		binary.LittleEndian.PutUint64(footer[56:], 21)
+		result = append(result, make([]byte, VMDK_SECTOR_SIZE)...)
+		result = append(result, footer...)
+		result = append(result, make([]byte, VMDK_SECTOR_SIZE)...)
+	}
+
+	return result
+}
+
+func testVMDKDescriptor(cid, parent_cid, create_type, extents string) string {
+	return fmt.Sprintf(`# Disk DescriptorFile
+version=1
+CID=%s
+parentCID=%s
+createType="%s"
+parentFileNameHint="base.vmdk"
+
+# Extent description
+%s
+
+# The Disk Data Base
+ddb.adapterType = "lsilogic"
+`, cid, parent_cid, create_type, extents)
+}
+
+func testVMDKOpener(files map[string][]byte) VMDKExtentOpener {
+	return func(name string) (io.ReaderAt, int64, error) {
+		data, pres := files[name]
+		if !pres {
+			return nil, 0, os.ErrNotExist
+		}
+		return bytes.NewReader(data), int64(len(data)), nil
+	}
+}
+
+func TestVMDKReader(t *testing.T) {
+	grain_a := bytes.Repeat([]byte{'A'}, 4096)
+	grain_b := bytes.Repeat([]byte{'B'}, 4096)
+	grains := map[int][]byte{0: grain_a, 3: grain_b, 5: nil}
+
+	expected := make([]byte, 32768)
+	copy(expected, grain_a)
+	copy(expected[3*4096:], grain_b)
+
+	// Monolithic sparse and stream optimized disks embed the
+	// descriptor.
+	for _, create_type := range []string{"monolithicSparse", "streamOptimized"} {
+		image := testVMDKSparse(32768, testVMDKDescriptor(
+			"0000000a", VMDK_NO_PARENT, create_type,
+			`RW 64 SPARSE "base.vmdk"`),
+			grains, create_type == "streamOptimized")
+
+		vmdk, err := NewVMDKReader(bytes.NewReader(image),
+			int64(len(image)), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if vmdk.Size() != 32768 || vmdk.Descriptor.CreateType != create_type ||
+			vmdk.Descriptor.Values["ddb.adapterType"] != "lsilogic" {
+			t.Fatalf("Unexpected disk %v %v", vmdk.Size(), vmdk.Descriptor)
+		}
+
+		buf := make([]byte, 32768)
+		n, err := vmdk.ReadAt(buf, 0)
+		if err != nil || n != len(buf) || !bytes.Equal(buf, expected) {
+			t.Fatalf("%v: Unexpected data %v %v", create_type, n, err)
+		}
+
+		// Unaligned reads within a grain.
+		n, err = vmdk.ReadAt(buf[:100], 3*4096+10)
+		if err != nil || n != 100 || !bytes.Equal(buf[:100], grain_b[:100]) {
+			t.Fatalf("%v: Unexpected data %v %v", create_type, n, err)
+		}
+	}
+
+	// A descriptor file with a flat, zero and split sparse extents.
+	files := map[string][]byte{
+		"disk-flat.vmdk": append(make([]byte, 1024),
+			bytes.Repeat([]byte{'F'}, 4096)...),
+		"disk-s001.vmdk": testVMDKSparse(32768, "", grains, false),
+	}
+	descriptor := testVMDKDescriptor("0000000b", VMDK_NO_PARENT, "custom",
+		`RW 8 FLAT "disk-flat.vmdk" 2
+RW 8 ZERO
+RW 64 SPARSE "disk-s001.vmdk"`)
+
+	vmdk, err := NewVMDKReader(bytes.NewReader([]byte(descriptor)),
+		int64(len(descriptor)), testVMDKOpener(files), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	split_expected := append(bytes.Repeat([]byte{'F'}, 4096),
+		make([]byte, 4096)...)
+	split_expected = append(split_expected, expected...)
+
+	buf := make([]byte, len(split_expected)+10)
+	n, err := vmdk.ReadAt(buf, 0)
+	if err != io.EOF || n != len(split_expected) ||
+		!bytes.Equal(buf[:n], split_expected) {
+		t.Fatalf("Unexpected split data %v %v", n, err)
+	}
+
+	// A snapshot with a new grain 1 and a zero grain 3 hiding the
+	// parent's data.
+	files["base.vmdk"] = testVMDKSparse(32768, testVMDKDescriptor(
+		"0000000a", VMDK_NO_PARENT, "monolithicSparse",
+		`RW 64 SPARSE "base.vmdk"`), grains, false)
+
+	grain_c := bytes.Repeat([]byte{'C'}, 4096)
+	snapshot := testVMDKSparse(32768, testVMDKDescriptor(
+		"0000000c", "0000000a", "monolithicSparse",
+		`RW 64 SPARSE "snapshot.vmdk"`),
+		map[int][]byte{1: grain_c, 3: nil}, false)
+
+	resolver := func(parent *VirtualDiskParent) (io.ReaderAt, int64, error) {
+		return testVMDKOpener(files)(parent.Locators[0])
+	}
+
+	_, err = NewVMDKReader(bytes.NewReader(snapshot),
+		int64(len(snapshot)), nil, nil)
+	if !errors.Is(err, noParentResolverError) {
+		t.Fatalf("Expected resolver error got %v", err)
+	}
+
+	vmdk, err = NewVMDKReader(bytes.NewReader(snapshot),
+		int64(len(snapshot)), testVMDKOpener(files), resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot_expected := append([]byte{}, expected...)
+	copy(snapshot_expected[4096:], grain_c)
+	copy(snapshot_expected[3*4096:], make([]byte, 4096))
+
+	buf = make([]byte, 32768)
+	n, err = vmdk.ReadAt(buf, 0)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, snapshot_expected) {
+		t.Fatalf("Unexpected snapshot data %v %v", n, err)
+	}
+
+	// Errors reading the parent are not replaced with zeros.
+	vmdk.parent = failingParent{}
+	_, err = vmdk.ReadAt(buf, 0)
+	if !errors.Is(err, testParentReadError) {
+		t.Fatalf("Expected parent read error got %v", err)
+	}
+
+	// The parent's CID must match the snapshot's parentCID.
+	files["base.vmdk"] = testVMDKSparse(32768, testVMDKDescriptor(
+		"0000000d", VMDK_NO_PARENT, "monolithicSparse",
+		`RW 64 SPARSE "base.vmdk"`), grains, false)
+
+	_, err = NewVMDKReader(bytes.NewReader(snapshot),
+		int64(len(snapshot)), testVMDKOpener(files), resolver)
+	if !errors.Is(err, parentMismatchError) {
+		t.Fatalf("Expected parent mismatch got %v", err)
+	}
+}
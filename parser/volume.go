@@ -0,0 +1,192 @@
+// Volume metadata.
+//
+// The boot sector only describes the geometry of the volume. The
+// $Volume file (MFT entry 3) holds the volume label in its
+// $VOLUME_NAME attribute and the NTFS version and volume flags in
+// $VOLUME_INFORMATION. A dirty volume was not cleanly unmounted so
+// the $LogFile may hold transactions which were never applied.
+//
+// References:
+// https://github.com/tuxera/ntfs-3g/blob/edge/include/ntfs-3g/layout.h (VOLUME_INFORMATION)
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	VOLUME_MFT_ID = 3
+
+	VOLUME_INFORMATION_SIZE = 12
+
+	VOLUME_IS_DIRTY            = 0x0001
+	VOLUME_RESIZE_LOG_FILE     = 0x0002
+	VOLUME_UPGRADE_ON_MOUNT    = 0x0004
+	VOLUME_MOUNTED_ON_NT4      = 0x0008
+	VOLUME_DELETE_USN_UNDERWAY = 0x0010
+	VOLUME_REPAIR_OBJECT_ID    = 0x0020
+	VOLUME_CHKDSK_UNDERWAY     = 0x4000
+	VOLUME_MODIFIED_BY_CHKDSK  = 0x8000
+)
+
+var (
+	volumeInformationTooShortError = errors.New("$VOLUME_INFORMATION too short")
+
+	volumeFlagNames = map[uint32]string{
+		VOLUME_IS_DIRTY:            "DIRTY",
+		VOLUME_RESIZE_LOG_FILE:     "RESIZE_LOG_FILE",
+		VOLUME_UPGRADE_ON_MOUNT:    "UPGRADE_ON_MOUNT",
+		VOLUME_MOUNTED_ON_NT4:      "MOUNTED_ON_NT4",
+		VOLUME_DELETE_USN_UNDERWAY: "DELETE_USN_UNDERWAY",
+		VOLUME_REPAIR_OBJECT_ID:    "REPAIR_OBJECT_ID",
+		VOLUME_CHKDSK_UNDERWAY:     "CHKDSK_UNDERWAY",
+		VOLUME_MODIFIED_BY_CHKDSK:  "MODIFIED_BY_CHKDSK",
+	}
+)
+
+type VolumeInformation struct {
+	MajorVersion uint8
+	MinorVersion uint8
+	Flags        uint16
+}
+
+func (self *VolumeInformation) Version() string {
+	return fmt.Sprintf("%d.%d", self.MajorVersion, self.MinorVersion)
+}
+
+func (self *VolumeInformation) IsDirty() bool {
+	return self.Flags&VOLUME_IS_DIRTY != 0
+}
+
+// Combines the boot sector with the $Volume metadata.
+type VolumeInfo struct {
+	VolumeName string
+
+	// The serial number as shown by vol (e.g. 1234-ABCD) and the
+	// full 64 bit serial number.
+	VolumeSerial string `json:"VolumeSerial,omitempty"`
+	SerialNumber string `json:"SerialNumber,omitempty"`
+	OEMName      string `json:"OEMName,omitempty"`
+
+	Version string `json:"Version,omitempty"`
+	Flags   []string
+	Dirty   bool
+
+	SectorSize      int64 `json:"SectorSize,omitempty"`
+	ClusterSize     int64
+	RecordSize      int64
+	IndexRecordSize int64 `json:"IndexRecordSize,omitempty"`
+	VolumeSize      int64 `json:"VolumeSize,omitempty"`
+	ClusterCount    int64 `json:"ClusterCount,omitempty"`
+
+	MFTCluster     int64 `json:"MFTCluster,omitempty"`
+	MFTOffset      int64 `json:"MFTOffset,omitempty"`
+	MFTMirrCluster int64 `json:"MFTMirrCluster,omitempty"`
+	MFTMirrOffset  int64 `json:"MFTMirrOffset,omitempty"`
+}
+
+// Parse the content of the $VOLUME_INFORMATION attribute: 8 reserved
+// bytes, the major and minor version and the flags.
+func ParseVolumeInformation(data []byte) (*VolumeInformation, error) {
+	if len(data) < VOLUME_INFORMATION_SIZE {
+		return nil, volumeInformationTooShortError
+	}
+
+	return &VolumeInformation{
+		MajorVersion: data[8],
+		MinorVersion: data[9],
+		Flags:        binary.LittleEndian.Uint16(data[10:]),
+	}, nil
+}
+
+// Extract the $VOLUME_NAME attribute from the MFT.
+func (self *MFT_ENTRY) VolumeName(ntfs *NTFSContext) (string, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_VOLUME_NAME {
+			buf := make([]byte, CapInt64(attr.DataSize(), MAX_FILENAME_LENGTH))
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return UTF16BytesToUTF8(buf[:n], binary.LittleEndian), nil
+		}
+	}
+
+	return "", errors.New("$VOLUME_NAME not found!")
+}
+
+// Extract the $VOLUME_INFORMATION attribute from the MFT.
+func (self *MFT_ENTRY) VolumeInformation(
+	ntfs *NTFSContext) (*VolumeInformation, error) {
+	for _, attr := range self.EnumerateAttributes(ntfs) {
+		if attr.Type().Value == ATTR_TYPE_VOLUME_INFORMATION {
+			buf := make([]byte, VOLUME_INFORMATION_SIZE)
+			n, _ := attr.Data(ntfs).ReadAt(buf, 0)
+			return ParseVolumeInformation(buf[:n])
+		}
+	}
+
+	return nil, errors.New("$VOLUME_INFORMATION not found!")
+}
+
+// Describe the volume. Contexts built from a raw MFT have no boot
+// sector so only the $Volume metadata is available.
+func (self *NTFSContext) VolumeInfo() (*VolumeInfo, error) {
+	result := &VolumeInfo{
+		ClusterSize: self.ClusterSize,
+		RecordSize:  self.RecordSize,
+	}
+
+	boot := self.Boot
+	if boot != nil {
+		serial := ParseUint64(boot.Reader,
+			boot.Profile.Off_NTFS_BOOT_SECTOR_Serial+boot.Offset)
+
+		result.VolumeSerial = fmt.Sprintf("%04X-%04X",
+			uint16(serial>>16), uint16(serial))
+		result.SerialNumber = fmt.Sprintf("%016X", serial)
+		result.OEMName = strings.TrimSpace(boot.Oemname())
+		result.SectorSize = int64(boot.Sector_size())
+		result.ClusterSize = boot.ClusterSize()
+		result.RecordSize = boot.RecordSize()
+		result.IndexRecordSize = boot.IndexRecordSize()
+
+		// The boot sector records the volume size in sectors.
+		result.VolumeSize = boot.VolumeSize() * result.SectorSize
+		result.ClusterCount = result.VolumeSize / result.ClusterSize
+		result.MFTCluster = int64(boot._mft_cluster())
+		result.MFTOffset = result.MFTCluster * result.ClusterSize
+		result.MFTMirrCluster = int64(boot._mirror_mft_cluster())
+		result.MFTMirrOffset = result.MFTMirrCluster * result.ClusterSize
+	}
+
+	volume, err := self.GetMFT(VOLUME_MFT_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result.VolumeName, _ = volume.VolumeName(self)
+
+	info, err := volume.VolumeInformation(self)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Version = info.Version()
+	result.Flags = flagNames(uint32(info.Flags), volumeFlagNames)
+	result.Dirty = info.IsDirty()
+
+	return result, nil
+}
+
+func init() {
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_VOLUME_NAME,
+		func(data []byte) (interface{}, error) {
+			return UTF16BytesToUTF8(data, binary.LittleEndian), nil
+		})
+	DefaultAttributeTypes.RegisterDecoder(ATTR_TYPE_VOLUME_INFORMATION,
+		func(data []byte) (interface{}, error) {
+			return ParseVolumeInformation(data)
+		})
+}
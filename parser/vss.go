@@ -0,0 +1,510 @@
+// Reading Volume Shadow Copy snapshots.
+//
+// VSS keeps snapshots of an NTFS volume by copying 16kb blocks into a
+// store before they are overwritten. The volume header at 0x1e00
+// points to the catalog which lists each store with its creation time
+// and the locations of its lists:
+//
+//   - The block list maps original volume offsets to the copy of the
+//     block in the store. Forwarder descriptors say the block's data
+//     is the original data at another offset, and overlay descriptors
+//     replace some 512 byte sectors of the block.
+//   - The block range list maps the store file to the volume.
+//   - The current and previous bitmaps hold one bit per block.
+//
+// A block of a snapshot is read from its own store if it was copied
+// there. Otherwise it was not changed before the next snapshot was
+// taken, so it is read from the next (newer) snapshot and finally
+// from the live volume.
+//
+// Windows exposes each snapshot as a
+// \\?\GLOBALROOT\Device\HarddiskVolumeShadowCopyN device but N is
+// allocated across all the volumes of the system so it can not be
+// recovered from the volume itself. Snapshots are instead identified
+// by their shadow copy id (as shown by "vssadmin list shadows").
+//
+// Reference: libvshadow - Volume Shadow Snapshot (VSS) format
+
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VSS_VOLUME_HEADER_OFFSET  = 0x1e00
+	VSS_IDENTIFIER            = "{3808876b-c176-4e48-b7ae-04046e6cc752}"
+	VSS_BLOCK_SIZE            = 0x4000
+	VSS_BLOCK_HEADER_SIZE     = 128
+	VSS_CATALOG_ENTRY_SIZE    = 128
+	VSS_BLOCK_DESCRIPTOR_SIZE = 32
+	VSS_BLOCK_RANGE_SIZE      = 24
+
+	// Block list header record types
+	VSS_RECORD_CATALOG     = 2
+	VSS_RECORD_BLOCK_LIST  = 3
+	VSS_RECORD_BLOCK_RANGE = 5
+	VSS_RECORD_BITMAP      = 6
+
+	// Block descriptor flags
+	VSS_BLOCK_IS_FORWARDER = 1
+	VSS_BLOCK_IS_OVERLAY   = 2
+	VSS_BLOCK_NOT_USED     = 4
+
+	// Stop following list chains after this many blocks.
+	MAX_VSS_LIST_BLOCKS = 1024 * 1024
+)
+
+var (
+	noVSSError = errors.New("Volume has no shadow copies")
+
+	vssPathRegex = regexp.MustCompile(
+		`(?i)^\{([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\}(.*)$`)
+)
+
+type VSSBlockRange struct {
+	StoreOffset    int64
+	RelativeOffset int64
+	Size           int64
+}
+
+type vssOverlay struct {
+	store_offset int64
+	bitmap       uint32
+}
+
+type VSSSnapshot struct {
+	// Snapshots are numbered from 1 in order of creation on this
+	// volume. This is not the N of the HarddiskVolumeShadowCopyN
+	// device which is numbered across all volumes.
+	Ordinal int
+
+	StoreGUID         string
+	ShadowCopyGUID    string
+	ShadowCopySetGUID string
+	CreationTime      time.Time
+	VolumeSize        int64
+	SnapshotContext   uint32
+	AttributeFlags    []string
+	OperatingMachine  string
+	ServiceMachine    string
+
+	volume  io.ReaderAt
+	profile *NTFSProfile
+
+	block_list_offset       int64
+	block_range_list_offset int64
+	bitmap_offset           int64
+	previous_bitmap_offset  int64
+
+	// Loaded on first use.
+	once       sync.Once
+	load_err   error
+	blocks     map[int64]int64
+	forwarders map[int64]int64
+	overlays   map[int64][]vssOverlay
+
+	BlockRanges    []VSSBlockRange
+	CurrentBitmap  []byte
+	PreviousBitmap []byte
+
+	next *VSSSnapshot
+}
+
+func (self *VSSSnapshot) Size() int64 {
+	return self.VolumeSize
+}
+
+// Open the filesystem as it was when the snapshot was taken.
+func (self *VSSSnapshot) NTFSContext() (*NTFSContext, error) {
+	reader, err := NewPagedReader(self, 1024, 10000)
+	if err != nil {
+		return nil, err
+	}
+	return GetNTFSContext(reader, 0)
+}
+
+// Follow a chain of list blocks of the expected record type, calling
+// cb with the data of each block after its header.
+func readVSSList(volume io.ReaderAt, offset int64,
+	record_type uint32, cb func(data []byte)) error {
+	profile := NewNTFSProfile()
+	seen := make(map[int64]bool)
+
+	for offset > 0 && !seen[offset] {
+		seen[offset] = true
+		if len(seen) > MAX_VSS_LIST_BLOCKS {
+			return errors.New("VSS list too long")
+		}
+
+		data, err := readSector(volume, offset, VSS_BLOCK_SIZE)
+		if err != nil {
+			return err
+		}
+
+		header := profile.VSS_STORE_BLOCK_HEADER(bytes.NewReader(data), 0)
+		if header.Identifier().AsString() != VSS_IDENTIFIER ||
+			binary.LittleEndian.Uint32(data[20:]) != record_type {
+			return fmt.Errorf("Invalid VSS list block at %#x", offset)
+		}
+
+		cb(data[VSS_BLOCK_HEADER_SIZE:])
+		offset = header.NextOffset()
+	}
+	return nil
+}
+
+// Enumerate the snapshots on an NTFS volume, oldest first.
+func GetVSSSnapshots(volume io.ReaderAt) ([]*VSSSnapshot, error) {
+	profile := NewNTFSProfile()
+
+	vss_header := profile.VSS_VOLUME_HEADER(volume, VSS_VOLUME_HEADER_OFFSET)
+	if vss_header.Identifier().AsString() != VSS_IDENTIFIER ||
+		vss_header.CatalogOffset() <= 0 {
+		return nil, noVSSError
+	}
+
+	snapshots := make(map[string]*VSSSnapshot)
+	get_snapshot := func(guid string) *VSSSnapshot {
+		snapshot, pres := snapshots[guid]
+		if !pres {
+			snapshot = &VSSSnapshot{
+				StoreGUID: guid,
+				volume:    volume,
+				profile:   profile,
+			}
+			snapshots[guid] = snapshot
+		}
+		return snapshot
+	}
+
+	err := readVSSList(volume, vss_header.CatalogOffset(), VSS_RECORD_CATALOG,
+		func(data []byte) {
+			reader := bytes.NewReader(data)
+			for offset := 0; offset+VSS_CATALOG_ENTRY_SIZE <= len(data); offset += VSS_CATALOG_ENTRY_SIZE {
+				switch binary.LittleEndian.Uint64(data[offset:]) {
+				case 2:
+					entry := profile.VSS_CATALOG_ENTRY_2(reader, int64(offset))
+					snapshot := get_snapshot(entry.StoreGUID().AsString())
+					snapshot.VolumeSize = entry.VolumeSize()
+					snapshot.CreationTime = entry.CreationTime().Time
+
+				case 3:
+					entry := profile.VSS_CATALOG_ENTRY_3(reader, int64(offset))
+					snapshot := get_snapshot(entry.StoreGUID().AsString())
+					snapshot.block_list_offset = entry.StoreBlockListOffset()
+					snapshot.block_range_list_offset = entry.StoreBlockRangeListOffset()
+					snapshot.bitmap_offset = entry.StoreBitmapOffset()
+					snapshot.previous_bitmap_offset = entry.StorePreviousBitmapOffset()
+					snapshot.parseStoreInformation(entry.StoreHeaderOffset())
+				}
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*VSSSnapshot{}
+	for _, snapshot := range snapshots {
+		// Both catalog entries are needed.
+		if snapshot.VolumeSize == 0 || snapshot.block_list_offset == 0 {
+			continue
+		}
+		result = append(result, snapshot)
+	}
+
+	if len(result) == 0 {
+		return nil, noVSSError
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreationTime.Before(result[j].CreationTime)
+	})
+
+	for idx, snapshot := range result {
+		snapshot.Ordinal = idx + 1
+		if idx+1 < len(result) {
+			snapshot.next = result[idx+1]
+		}
+	}
+
+	return result, nil
+}
+
+// The store header is followed by the store information.
+func (self *VSSSnapshot) parseStoreInformation(offset int64) {
+	if offset <= 0 {
+		return
+	}
+
+	data, err := readSector(self.volume, offset, VSS_BLOCK_SIZE)
+	if err != nil {
+		return
+	}
+
+	info := self.profile.VSS_STORE_INFORMATION(
+		bytes.NewReader(data), VSS_BLOCK_HEADER_SIZE)
+	self.ShadowCopyGUID = info.ShadowCopyGUID().AsString()
+	self.ShadowCopySetGUID = info.ShadowCopySetGUID().AsString()
+	self.SnapshotContext = info.SnapshotContext()
+	self.AttributeFlags = info.AttributeFlags().Values()
+	sort.Strings(self.AttributeFlags)
+
+	// Two length prefixed UTF16 strings follow.
+	names := []string{}
+	string_offset := VSS_BLOCK_HEADER_SIZE + 64
+	for i := 0; i < 2 && string_offset+2 <= len(data); i++ {
+		length := int(binary.LittleEndian.Uint16(data[string_offset:]))
+		string_offset += 2
+		if string_offset+length > len(data) {
+			break
+		}
+		names = append(names, UTF16BytesToUTF8(
+			data[string_offset:string_offset+length], binary.LittleEndian))
+		string_offset += length
+	}
+
+	if len(names) > 0 {
+		self.OperatingMachine = names[0]
+	}
+	if len(names) > 1 {
+		self.ServiceMachine = names[1]
+	}
+}
+
+// Load the block lists and bitmaps of the store.
+func (self *VSSSnapshot) load() error {
+	self.once.Do(func() {
+		self.blocks = make(map[int64]int64)
+		self.forwarders = make(map[int64]int64)
+		self.overlays = make(map[int64][]vssOverlay)
+
+		self.load_err = readVSSList(self.volume, self.block_list_offset,
+			VSS_RECORD_BLOCK_LIST, func(data []byte) {
+				for i := 0; i+VSS_BLOCK_DESCRIPTOR_SIZE <= len(data); i += VSS_BLOCK_DESCRIPTOR_SIZE {
+					self.addBlockDescriptor(data[i : i+VSS_BLOCK_DESCRIPTOR_SIZE])
+				}
+			})
+		if self.load_err != nil {
+			return
+		}
+
+		self.load_err = readVSSList(self.volume, self.block_range_list_offset,
+			VSS_RECORD_BLOCK_RANGE, func(data []byte) {
+				for i := 0; i+VSS_BLOCK_RANGE_SIZE <= len(data); i += VSS_BLOCK_RANGE_SIZE {
+					block_range := VSSBlockRange{
+						StoreOffset:    int64(binary.LittleEndian.Uint64(data[i:])),
+						RelativeOffset: int64(binary.LittleEndian.Uint64(data[i+8:])),
+						Size:           int64(binary.LittleEndian.Uint64(data[i+16:])),
+					}
+					if block_range.Size > 0 {
+						self.BlockRanges = append(self.BlockRanges, block_range)
+					}
+				}
+			})
+		if self.load_err != nil {
+			return
+		}
+
+		// The bitmap covers the volume.
+		bitmap_size := (self.VolumeSize/VSS_BLOCK_SIZE + 7) / 8
+		for _, bitmap := range []struct {
+			offset int64
+			result *[]byte
+		}{
+			{self.bitmap_offset, &self.CurrentBitmap},
+			{self.previous_bitmap_offset, &self.PreviousBitmap},
+		} {
+			self.load_err = readVSSList(self.volume, bitmap.offset,
+				VSS_RECORD_BITMAP, func(data []byte) {
+					*bitmap.result = append(*bitmap.result, data...)
+				})
+			if self.load_err != nil {
+				return
+			}
+
+			if int64(len(*bitmap.result)) > bitmap_size {
+				*bitmap.result = (*bitmap.result)[:bitmap_size]
+			}
+		}
+	})
+
+	return self.load_err
+}
+
+func (self *VSSSnapshot) addBlockDescriptor(data []byte) {
+	original_offset := int64(binary.LittleEndian.Uint64(data[0:]))
+	relative_offset := int64(binary.LittleEndian.Uint64(data[8:]))
+	store_offset := int64(binary.LittleEndian.Uint64(data[16:]))
+	flags := binary.LittleEndian.Uint32(data[24:])
+	bitmap := binary.LittleEndian.Uint32(data[28:])
+
+	// Unused slots are empty.
+	if store_offset == 0 && relative_offset == 0 && original_offset == 0 ||
+		flags&VSS_BLOCK_NOT_USED != 0 ||
+		original_offset%VSS_BLOCK_SIZE != 0 {
+		return
+	}
+
+	switch {
+	case flags&VSS_BLOCK_IS_OVERLAY != 0:
+		self.overlays[original_offset] = append(self.overlays[original_offset],
+			vssOverlay{store_offset: store_offset, bitmap: bitmap})
+
+	case flags&VSS_BLOCK_IS_FORWARDER != 0:
+		delete(self.blocks, original_offset)
+		self.forwarders[original_offset] = relative_offset
+
+	default:
+		delete(self.forwarders, original_offset)
+		self.blocks[original_offset] = store_offset
+	}
+}
+
+// Read the block at the original offset as it was when the snapshot
+// was taken.
+func (self *VSSSnapshot) readBlock(out []byte, offset int64) error {
+	err := self.load()
+	if err != nil {
+		return err
+	}
+
+	store_offset, pres := self.blocks[offset]
+	if pres {
+		_, err = readVSSVolume(self.volume, out, store_offset)
+
+	} else {
+		// A forwarded block has the original data of another block.
+		source_offset := offset
+		forwarded, pres := self.forwarders[offset]
+		if pres {
+			source_offset = forwarded
+		}
+
+		if self.next != nil {
+			err = self.next.readBlock(out, source_offset)
+		} else {
+			_, err = readVSSVolume(self.volume, out, source_offset)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Overlays replace individual sectors in the block.
+	for _, overlay := range self.overlays[offset] {
+		for sector := int64(0); sector < 32; sector++ {
+			if overlay.bitmap&(1<<uint(sector)) == 0 {
+				continue
+			}
+			_, err = readVSSVolume(self.volume, out[sector*512:(sector+1)*512],
+				overlay.store_offset+sector*512)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readVSSVolume(volume io.ReaderAt, out []byte, offset int64) (int, error) {
+	n, err := volume.ReadAt(out, offset)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	for i := n; i < len(out); i++ {
+		out[i] = 0
+	}
+	return n, nil
+}
+
+func (self *VSSSnapshot) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= self.VolumeSize {
+		return 0, io.EOF
+	}
+
+	to_read := int64(len(buf))
+	if offset+to_read > self.VolumeSize {
+		to_read = self.VolumeSize - offset
+	}
+
+	block := make([]byte, VSS_BLOCK_SIZE)
+	buf_idx := int64(0)
+	for buf_idx < to_read {
+		block_offset := offset - offset%VSS_BLOCK_SIZE
+		err := self.readBlock(block, block_offset)
+		if err != nil {
+			return int(buf_idx), err
+		}
+
+		n := int64(copy(buf[buf_idx:to_read], block[offset-block_offset:]))
+		buf_idx += n
+		offset += n
+	}
+
+	if to_read < int64(len(buf)) {
+		return int(buf_idx), io.EOF
+	}
+	return int(buf_idx), nil
+}
+
+// Split a path prefixed with the shadow copy id (e.g.
+// {b5946137-7b9f-4925-af80-51abd60b20d5}\Windows) into the id and
+// the path within the snapshot.
+func ParseVSSPath(path string) (string, string, bool) {
+	match := vssPathRegex.FindStringSubmatch(path)
+	if match == nil {
+		return "", "", false
+	}
+
+	remainder := match[2]
+	if remainder == "" {
+		remainder = "\\"
+	}
+
+	// The id must end at a separator.
+	if remainder[0] != '\\' && remainder[0] != '/' {
+		return "", "", false
+	}
+
+	return "{" + strings.ToLower(match[1]) + "}", remainder, true
+}
+
+// Open the snapshot named by the shadow copy id at the start of the
+// path. Returns the context of the snapshot and the path within it.
+func OpenVSSPath(volume io.ReaderAt, path string) (*NTFSContext, string, error) {
+	shadow_copy_id, remainder, ok := ParseVSSPath(path)
+	if !ok {
+		return nil, "", fmt.Errorf("Not a shadow copy path: %v", path)
+	}
+
+	snapshots, err := GetVSSSnapshots(volume)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.ShadowCopyGUID != shadow_copy_id {
+			continue
+		}
+
+		ntfs, err := snapshot.NTFSContext()
+		if err != nil {
+			return nil, "", err
+		}
+		return ntfs, remainder, nil
+	}
+
+	return nil, "", fmt.Errorf("Shadow copy %v not found", shadow_copy_id)
+}
@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type testVSSVolume struct {
+	buf []byte
+}
+
+func (self *testVSSVolume) Block(idx int) []byte {
+	return self.buf[idx*VSS_BLOCK_SIZE : (idx+1)*VSS_BLOCK_SIZE]
+}
+
+// Write a list block header at the block.
+func (self *testVSSVolume) ListBlock(idx int, record_type uint32, next int) []byte {
+	block := self.Block(idx)
+	for i := range block {
+		block[i] = 0
+	}
+	copy(block, testGUIDBytes(VSS_IDENTIFIER))
+	binary.LittleEndian.PutUint32(block[16:], 1)
+	binary.LittleEndian.PutUint32(block[20:], record_type)
+	binary.LittleEndian.PutUint64(block[32:], uint64(idx*VSS_BLOCK_SIZE))
+	binary.LittleEndian.PutUint64(block[40:], uint64(next*VSS_BLOCK_SIZE))
+	return block[VSS_BLOCK_HEADER_SIZE:]
+}
+
+func testVSSDescriptor(original, relative, store int, flags, bitmap uint32) []byte {
+	result := make([]byte, VSS_BLOCK_DESCRIPTOR_SIZE)
+	binary.LittleEndian.PutUint64(result[0:], uint64(original*VSS_BLOCK_SIZE))
+	binary.LittleEndian.PutUint64(result[8:], uint64(relative*VSS_BLOCK_SIZE))
+	binary.LittleEndian.PutUint64(result[16:], uint64(store*VSS_BLOCK_SIZE))
+	binary.LittleEndian.PutUint32(result[24:], flags)
+	binary.LittleEndian.PutUint32(result[28:], bitmap)
+	return result
+}
+
+func testFileTime(t time.Time) uint64 {
+	return uint64(t.Unix()+11644473600) * 10000000
+}
+
+// Add the catalog entries and store header of a snapshot.
+func (self *testVSSVolume) AddStore(catalog []byte, guid string,
+	created time.Time, header, block_list, range_list, bitmap int) {
+	entry := catalog[:VSS_CATALOG_ENTRY_SIZE]
+	binary.LittleEndian.PutUint64(entry, 2)
+	binary.LittleEndian.PutUint64(entry[8:], uint64(len(self.buf)))
+	copy(entry[16:], testGUIDBytes(guid))
+	binary.LittleEndian.PutUint64(entry[48:], testFileTime(created))
+
+	entry = catalog[VSS_CATALOG_ENTRY_SIZE:]
+	binary.LittleEndian.PutUint64(entry, 3)
+	binary.LittleEndian.PutUint64(entry[8:], uint64(block_list*VSS_BLOCK_SIZE))
+	copy(entry[16:], testGUIDBytes(guid))
+	binary.LittleEndian.PutUint64(entry[32:], uint64(header*VSS_BLOCK_SIZE))
+	binary.LittleEndian.PutUint64(entry[40:], uint64(range_list*VSS_BLOCK_SIZE))
+	binary.LittleEndian.PutUint64(entry[48:], uint64(bitmap*VSS_BLOCK_SIZE))
+
+	// The store information follows the store header.
+	info := self.ListBlock(header, 4, 0)
+	copy(info[16:], testGUIDBytes(guid))
+	binary.LittleEndian.PutUint32(info[56:], 1)
+	machine := utf16LE("WORKSTATION")
+	binary.LittleEndian.PutUint16(info[64:], uint16(len(machine)))
+	copy(info[66:], machine)
+}
+
+func TestVSSSnapshots(t *testing.T) {
+	volume := &testVSSVolume{buf: make([]byte, 32*VSS_BLOCK_SIZE)}
+	for i := 0; i < 32; i++ {
+		copy(volume.Block(i), bytes.Repeat([]byte{byte('a' + i)}, VSS_BLOCK_SIZE))
+	}
+
+	header := volume.buf[VSS_VOLUME_HEADER_OFFSET:]
+	copy(header, testGUIDBytes(VSS_IDENTIFIER))
+	binary.LittleEndian.PutUint32(header[16:], 1)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	binary.LittleEndian.PutUint64(header[48:], 20*VSS_BLOCK_SIZE)
+
+	// The newer snapshot is listed first.
+	older_guid := "{11111111-0000-0000-0000-000000000001}"
+	newer_guid := "{22222222-0000-0000-0000-000000000002}"
+	older_time := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer_time := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	catalog := volume.ListBlock(20, VSS_RECORD_CATALOG, 0)
+	volume.AddStore(catalog, newer_guid, newer_time, 25, 26, 0, 0)
+	volume.AddStore(catalog[2*VSS_CATALOG_ENTRY_SIZE:],
+		older_guid, older_time, 21, 22, 23, 24)
+
+	// The older snapshot has its own copy of block 2, block 3 is
+	// forwarded to block 5 and the first sector of block 4 is
+	// overlaid.
+	list := volume.ListBlock(22, VSS_RECORD_BLOCK_LIST, 0)
+	copy(list, testVSSDescriptor(2, 0, 27, 0, 0))
+	copy(list[32:], testVSSDescriptor(3, 5, 0, VSS_BLOCK_IS_FORWARDER, 0))
+	copy(list[64:], testVSSDescriptor(4, 0, 30, VSS_BLOCK_IS_OVERLAY, 1))
+	copy(list[96:], testVSSDescriptor(6, 0, 31, VSS_BLOCK_NOT_USED, 0))
+
+	ranges := volume.ListBlock(23, VSS_RECORD_BLOCK_RANGE, 0)
+	binary.LittleEndian.PutUint64(ranges, 27*VSS_BLOCK_SIZE)
+	binary.LittleEndian.PutUint64(ranges[16:], 4*VSS_BLOCK_SIZE)
+
+	bitmap := volume.ListBlock(24, VSS_RECORD_BITMAP, 0)
+	bitmap[0] = 0xff
+
+	// The newer snapshot copied blocks 2 and 5.
+	list = volume.ListBlock(26, VSS_RECORD_BLOCK_LIST, 0)
+	copy(list, testVSSDescriptor(2, 0, 28, 0, 0))
+	copy(list[32:], testVSSDescriptor(5, 0, 29, 0, 0))
+
+	store_data := map[int]byte{27: '1', 28: '2', 29: '5', 30: 'O', 31: 'X'}
+	for idx, value := range store_data {
+		copy(volume.Block(idx), bytes.Repeat([]byte{value}, VSS_BLOCK_SIZE))
+	}
+
+	snapshots, err := GetVSSSnapshots(bytes.NewReader(volume.buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 2 || snapshots[0].StoreGUID != older_guid ||
+		!snapshots[0].CreationTime.Equal(older_time) ||
+		snapshots[1].Ordinal != 2 {
+		t.Fatalf("Unexpected snapshots %v", snapshots)
+	}
+
+	older := snapshots[0]
+	if older.ShadowCopyGUID != older_guid || older.OperatingMachine != "WORKSTATION" ||
+		len(older.AttributeFlags) != 1 ||
+		older.AttributeFlags[0] != "VSS_VOLSNAP_ATTR_PERSISTENT" {
+		t.Fatalf("Unexpected store information %v", older)
+	}
+
+	block := func(value byte) []byte {
+		return bytes.Repeat([]byte{value}, VSS_BLOCK_SIZE)
+	}
+
+	buf := make([]byte, 6*VSS_BLOCK_SIZE)
+	n, err := older.ReadAt(buf, VSS_BLOCK_SIZE)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Read failed %v %v", n, err)
+	}
+
+	overlaid := block('e')
+	copy(overlaid, bytes.Repeat([]byte{'O'}, 512))
+	expected := bytes.Join([][]byte{
+		block('b'), block('1'), block('5'), overlaid, block('5'), block('g'),
+	}, nil)
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected older snapshot data")
+	}
+
+	if len(older.BlockRanges) != 1 || older.BlockRanges[0].Size != 4*VSS_BLOCK_SIZE ||
+		len(older.CurrentBitmap) != 4 || older.CurrentBitmap[0] != 0xff {
+		t.Fatalf("Unexpected store lists %v %v", older.BlockRanges, older.CurrentBitmap)
+	}
+
+	n, err = snapshots[1].ReadAt(buf, VSS_BLOCK_SIZE)
+	expected = bytes.Join([][]byte{
+		block('b'), block('2'), block('d'), block('e'), block('5'), block('g'),
+	}, nil)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected newer snapshot data %v %v", n, err)
+	}
+
+	// Snapshots are selected by the shadow copy id.
+	shadow_copy_id, path, ok := ParseVSSPath(
+		`{22222222-0000-0000-0000-00000000000A}\Windows\notepad.exe`)
+	if !ok || shadow_copy_id != "{22222222-0000-0000-0000-00000000000a}" ||
+		path != `\Windows\notepad.exe` {
+		t.Fatalf("Unexpected path %v %v %v", shadow_copy_id, path, ok)
+	}
+
+	_, _, ok = ParseVSSPath(`\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\`)
+	if ok {
+		t.Fatalf("Unexpected match")
+	}
+
+	_, _, ok = ParseVSSPath(`{22222222-0000-0000-0000-000000000002}x\`)
+	if ok {
+		t.Fatalf("Unexpected match")
+	}
+}
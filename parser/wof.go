@@ -0,0 +1,273 @@
+// Support for files compressed by the Windows Overlay Filter (WOF).
+//
+// Windows 10 "CompactOS" (or compact.exe /EXE) compresses files
+// through the WOF driver. The unnamed $DATA stream of such files is
+// sparse (but has the correct uncompressed size), the compressed data
+// is stored in the "WofCompressedData" alternate data stream and a
+// $REPARSE_POINT attribute with the WOF tag records the compression
+// algorithm.
+//
+// The WofCompressedData stream starts with a chunk table: one entry
+// for each chunk after the first, holding the offset (relative to the
+// end of the table) where the next chunk begins. Entries are 4 bytes,
+// or 8 bytes if the uncompressed size is larger than 4gb. A chunk
+// whose compressed size equals its uncompressed size is stored
+// uncompressed.
+//
+// References:
+// https://github.com/ebiggers/ntfs-3g-system-compression
+// https://learn.microsoft.com/en-us/windows-hardware/drivers/ddi/ntifs/ns-ntifs-_wof_external_info
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	WOF_COMPRESSED_DATA_STREAM = "WofCompressedData"
+
+	WOF_PROVIDER_FILE = 2
+
+	WOF_COMPRESSION_XPRESS4K  = 0
+	WOF_COMPRESSION_LZX       = 1
+	WOF_COMPRESSION_XPRESS8K  = 2
+	WOF_COMPRESSION_XPRESS16K = 3
+)
+
+var (
+	notWofError = errors.New("Not a WOF compressed file")
+)
+
+func WofAlgorithmName(algorithm uint32) string {
+	switch algorithm {
+	case WOF_COMPRESSION_XPRESS4K:
+		return "XPRESS4K"
+	case WOF_COMPRESSION_LZX:
+		return "LZX"
+	case WOF_COMPRESSION_XPRESS8K:
+		return "XPRESS8K"
+	case WOF_COMPRESSION_XPRESS16K:
+		return "XPRESS16K"
+	}
+	return fmt.Sprintf("Unknown (%d)", algorithm)
+}
+
+func wofChunkSize(algorithm uint32) (int64, error) {
+	switch algorithm {
+	case WOF_COMPRESSION_XPRESS4K:
+		return 4096, nil
+	case WOF_COMPRESSION_LZX:
+		return 32768, nil
+	case WOF_COMPRESSION_XPRESS8K:
+		return 8192, nil
+	case WOF_COMPRESSION_XPRESS16K:
+		return 16384, nil
+	}
+	return 0, fmt.Errorf("Unsupported WOF compression algorithm %d", algorithm)
+}
+
+// Find the WOF compression algorithm from the $REPARSE_POINT
+// attribute of the MFT entry.
+func getWofAlgorithm(ntfs *NTFSContext, mft_entry *MFT_ENTRY) (uint32, error) {
+	reparse, err := mft_entry.ReparsePoint(ntfs)
+	if err != nil || reparse.Tag != IO_REPARSE_TAG_WOF ||
+		reparse.WofProvider != WOF_PROVIDER_FILE {
+		return 0, notWofError
+	}
+
+	return reparse.wof_algorithm, nil
+}
+
+// Open a reader over the decompressed content of a WOF compressed
+// file. Returns notWofError if the file is not WOF compressed.
+func openWofStream(ntfs *NTFSContext,
+	mft_entry *MFT_ENTRY, size int64) (RangeReaderAt, error) {
+	algorithm, err := getWofAlgorithm(ntfs, mft_entry)
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, err := OpenStream(ntfs, mft_entry, ATTR_TYPE_DATA,
+		WILDCARD_STREAM_ID, WOF_COMPRESSED_DATA_STREAM)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWofReader(compressed, RangeSize(compressed), size, algorithm)
+}
+
+// A reader over the decompressed content of a WofCompressedData
+// stream.
+type WofReader struct {
+	mu sync.Mutex
+
+	reader          io.ReaderAt
+	compressed_size int64
+	size            int64
+	algorithm       uint32
+	chunk_size      int64
+
+	// Offsets of each chunk in the compressed stream. There is one
+	// more offset than chunks so the last chunk's size can be
+	// determined.
+	chunk_offsets []int64
+
+	// Cache decompressed chunks.
+	lru *LRU
+}
+
+func NewWofReader(reader io.ReaderAt, compressed_size int64,
+	size int64, algorithm uint32) (*WofReader, error) {
+	chunk_size, err := wofChunkSize(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	number_of_chunks := (size + chunk_size - 1) / chunk_size
+
+	entry_size := int64(4)
+	if size > 0xFFFFFFFF {
+		entry_size = 8
+	}
+
+	table_size := entry_size * (number_of_chunks - 1)
+	if number_of_chunks == 0 {
+		table_size = 0
+	}
+
+	if table_size > compressed_size {
+		return nil, errors.New("WOF: chunk table is larger than the stream")
+	}
+
+	table := make([]byte, table_size)
+	n, err := reader.ReadAt(table, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if int64(n) < table_size {
+		return nil, errors.New("WOF: short read of chunk table")
+	}
+
+	chunk_offsets := make([]int64, 0, number_of_chunks+1)
+	chunk_offsets = append(chunk_offsets, table_size)
+	for i := int64(0); i < table_size; i += entry_size {
+		var offset int64
+		if entry_size == 4 {
+			offset = int64(binary.LittleEndian.Uint32(table[i:]))
+		} else {
+			offset = int64(binary.LittleEndian.Uint64(table[i:]))
+		}
+		chunk_offsets = append(chunk_offsets, offset+table_size)
+	}
+	chunk_offsets = append(chunk_offsets, compressed_size)
+
+	lru, err := NewLRU(16, nil, "WofReader")
+	if err != nil {
+		return nil, err
+	}
+
+	return &WofReader{
+		reader:          reader,
+		compressed_size: compressed_size,
+		size:            size,
+		algorithm:       algorithm,
+		chunk_size:      chunk_size,
+		chunk_offsets:   chunk_offsets,
+		lru:             lru,
+	}, nil
+}
+
+func (self *WofReader) Ranges() []Range {
+	return []Range{{Offset: 0, Length: self.size}}
+}
+
+func (self *WofReader) DebugString() string {
+	return fmt.Sprintf("WofReader %v: %v chunks of %v bytes (size %v)",
+		WofAlgorithmName(self.algorithm), len(self.chunk_offsets)-1,
+		self.chunk_size, self.size)
+}
+
+func (self *WofReader) getChunk(idx int64) ([]byte, error) {
+	cached, pres := self.lru.Get(int(idx))
+	if pres {
+		return cached.([]byte), nil
+	}
+
+	start := self.chunk_offsets[idx]
+	end := self.chunk_offsets[idx+1]
+	if end < start || end-start > self.chunk_size {
+		return nil, fmt.Errorf("WOF: invalid chunk %d at %d", idx, start)
+	}
+
+	uncompressed_size := self.chunk_size
+	if (idx+1)*self.chunk_size > self.size {
+		uncompressed_size = self.size - idx*self.chunk_size
+	}
+
+	compressed := make([]byte, end-start)
+	n, err := self.reader.ReadAt(compressed, start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	compressed = compressed[:n]
+
+	var result []byte
+
+	// The chunk is stored uncompressed.
+	if end-start == uncompressed_size {
+		result = compressed
+
+	} else {
+		switch self.algorithm {
+		case WOF_COMPRESSION_LZX:
+			result, err = LZXDecompress(compressed, int(uncompressed_size))
+		default:
+			result, err = XpressHuffmanDecompress(
+				compressed, int(uncompressed_size))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	self.lru.Add(int(idx), result)
+	return result, nil
+}
+
+func (self *WofReader) ReadAt(buf []byte, offset int64) (int, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if offset < 0 || offset >= self.size {
+		return 0, io.EOF
+	}
+
+	buf_idx := 0
+	for buf_idx < len(buf) && offset < self.size {
+		chunk_idx := offset / self.chunk_size
+		chunk, err := self.getChunk(chunk_idx)
+		if err != nil {
+			return buf_idx, err
+		}
+
+		chunk_offset := offset - chunk_idx*self.chunk_size
+		if chunk_offset >= int64(len(chunk)) {
+			break
+		}
+
+		n := copy(buf[buf_idx:], chunk[chunk_offset:])
+		buf_idx += n
+		offset += int64(n)
+	}
+
+	if buf_idx < len(buf) {
+		return buf_idx, io.EOF
+	}
+
+	return buf_idx, nil
+}
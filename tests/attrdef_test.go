@@ -0,0 +1,160 @@
+package ntfs
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const testCustomAttributeType = 0x1000
+
+func attrDefEntry(name string, attr_type, flags uint32,
+	min_size, max_size int64) []byte {
+	entry := make([]byte, parser.ATTRDEF_ENTRY_SIZE)
+	copy(entry, utf16Bytes(name))
+	putU32(entry, 0x80, attr_type)
+	putU32(entry, 0x8c, flags)
+	putU64(entry, 0x90, uint64(min_size))
+	putU64(entry, 0x98, uint64(max_size))
+	return entry
+}
+
+func TestAttrDef(t *testing.T) {
+	// The volume defines an additional type.
+	attrdef := append(
+		attrDefEntry("$STANDARD_INFORMATION",
+			parser.ATTR_TYPE_STANDARD_INFORMATION, parser.ATTR_DEF_RESIDENT, 0x30, 0x48),
+		attrDefEntry("$CUSTOM", testCustomAttributeType, 0, 4, 8)...)
+	attrdef = append(attrdef, make([]byte, parser.ATTRDEF_ENTRY_SIZE)...)
+
+	object_id := mustHex("8089aa53a77ceb1192340050568a1b2c")
+
+	mft := newTestMFT(32)
+	mft.Entry(4, testFlagAllocated).AddName(5, "$AttrDef").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", attrdef)
+	mft.Entry(30, testFlagAllocated).AddName(5, "odd.txt").
+		AddAttribute(parser.ATTR_TYPE_OBJECT_ID, "", object_id).
+		AddAttribute(parser.ATTR_TYPE_VOLUME_INFORMATION, "", make([]byte, 4)).
+		AddAttribute(testCustomAttributeType, "", make([]byte, 16))
+	ntfs := mft.Context()
+
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+
+	// The volume's $AttrDef is loaded on first use so the model is
+	// checked against the volume's definitions.
+	info, err := parser.ModelMFTEntry(ntfs, mft_entry)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"$VOLUME_INFORMATION (112-3): Size 0x4 is less than the minimum 0xc",
+		"$CUSTOM (4096-4): Size 0x10 is more than the maximum 0x8",
+	}, info.Anomalies)
+
+	// Names resolve from the volume's definitions.
+	attributes := mft_entry.EnumerateAttributes(ntfs)
+	assert.Equal(t, "$CUSTOM", attributes[len(attributes)-1].Type().Name)
+
+	registry, err := ntfs.LoadAttrDef()
+	assert.NoError(t, err)
+
+	definition, pres := registry.Get(testCustomAttributeType)
+	assert.True(t, pres)
+	assert.Equal(t, "$CUSTOM", definition.Name)
+	assert.Equal(t, int64(8), definition.MaxSize)
+
+	// Other contexts are not affected.
+	assert.Equal(t, "Unknown",
+		parser.DefaultAttributeTypes.Name(testCustomAttributeType))
+
+	// Attributes are decoded by the decoder registered for their type.
+	for _, attr := range attributes {
+		if attr.Type().Value == parser.ATTR_TYPE_OBJECT_ID {
+			decoded, err := attr.Decode(ntfs)
+			assert.NoError(t, err)
+			assert.Equal(t, "{53aa8980-7ca7-11eb-9234-0050568a1b2c}",
+				decoded.(*parser.ObjectId).ObjectId.GUID)
+		}
+	}
+
+	_, err = attributes[len(attributes)-1].Decode(ntfs)
+	assert.Error(t, err)
+}
+
+func TestAttrDefConcurrentLoad(t *testing.T) {
+	attrdef := append(attrDefEntry("$CUSTOM", testCustomAttributeType, 0, 4, 8),
+		make([]byte, parser.ATTRDEF_ENTRY_SIZE)...)
+
+	mft := newTestMFT(32)
+	mft.Entry(4, testFlagAllocated).AddName(5, "$AttrDef").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", attrdef)
+	ntfs := mft.Context()
+
+	// No caller sees the registry before the volume's definitions
+	// are merged in.
+	results := make(chan string)
+	for i := 0; i < 10; i++ {
+		go func() {
+			registry, err := ntfs.LoadAttrDef()
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- registry.Name(testCustomAttributeType)
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "$CUSTOM", <-results)
+	}
+}
+
+func TestAttrDefMissing(t *testing.T) {
+	mft := newTestMFT(32)
+	mft.Entry(4, testFlagAllocated).AddName(5, "$AttrDef")
+	ntfs := mft.Context()
+
+	// Names fall back to the standard definitions and the failure
+	// is cached.
+	assert.Equal(t, "$DATA", ntfs.AttributeTypes().Name(parser.ATTR_TYPE_DATA))
+
+	_, err := ntfs.LoadAttrDef()
+	assert.Error(t, err)
+
+	_, err = ntfs.LoadAttrDef()
+	assert.Error(t, err)
+}
+
+func TestAttrDefRenamedAttributeList(t *testing.T) {
+	// A crafted $AttrDef renames the $ATTRIBUTE_LIST type.
+	attrdef := append(attrDefEntry("$RENAMED", parser.ATTR_TYPE_ATTRIBUTE_LIST, 0, 0, -1),
+		make([]byte, parser.ATTRDEF_ENTRY_SIZE)...)
+
+	// The list points at the $DATA attribute of entry 31.
+	attr_list := make([]byte, 0x20)
+	putU32(attr_list, 0, parser.ATTR_TYPE_DATA)
+	putU16(attr_list, 4, 0x20)
+	putU64(attr_list, 16, 31)
+
+	mft := newTestMFT(32)
+	mft.Entry(4, testFlagAllocated).AddName(5, "$AttrDef").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", attrdef)
+	mft.Entry(30, testFlagAllocated).
+		AddAttribute(parser.ATTR_TYPE_ATTRIBUTE_LIST, "", attr_list)
+	mft.Entry(31, testFlagAllocated).
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("hello"))
+	ntfs := mft.Context()
+
+	_, err := ntfs.LoadAttrDef()
+	assert.NoError(t, err)
+
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+
+	// The list is still expanded.
+	types := []string{}
+	for _, attr := range mft_entry.EnumerateAttributes(ntfs) {
+		types = append(types, attr.Type().Name)
+	}
+	assert.Equal(t, []string{"$DATA", "$RENAMED"}, types)
+}
@@ -0,0 +1,92 @@
+package ntfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Filetime of 2020-01-01
+const testFiletime = 132223104000000000
+
+func indexEntry(mft_id, parent uint64, name string) []byte {
+	file_name := fileNameContent(parent, name)
+	for i := 8; i < 40; i += 8 {
+		putU64(file_name, i, testFiletime)
+	}
+
+	size := align8(16 + len(file_name))
+	entry := make([]byte, size)
+	putU64(entry, 0, mft_id|1<<48)
+	putU16(entry, 8, uint16(size))
+	putU16(entry, 10, uint16(len(file_name)))
+	copy(entry[16:], file_name)
+	return entry
+}
+
+// Build an INDX record of a directory with a live and a slack entry.
+func indxRecord(parent uint64, live, slack string) []byte {
+	indx := make([]byte, 0x1000)
+	copy(indx, "INDX")
+
+	entries := indexEntry(40, parent, live)
+	last := make([]byte, 16)
+	putU16(last, 8, 16)
+	putU32(last, 12, 2)
+	entries = append(entries, last...)
+
+	// Node header
+	putU32(indx, 0x18, 0x40)
+	putU32(indx, 0x1c, uint32(0x40+len(entries)))
+	putU32(indx, 0x20, 0x1000-0x18)
+	copy(indx[0x58:], entries)
+	copy(indx[0x58+len(entries):], indexEntry(41, parent, slack))
+
+	return withFixups(indx, 0x28)
+}
+
+func TestCarveI30(t *testing.T) {
+	ntfs_ctx := buildDeletedTree().Context()
+
+	image := bytes.Repeat([]byte{0xaa}, 0x1000)
+	image = append(image, indxRecord(30, "report.doc", "draft.doc")...)
+	image = append(image, indxRecord(35, "nested.txt", "old.txt")...)
+
+	records := []*parser.I30CarvedRecord{}
+	for record := range parser.CarveI30(context.Background(), ntfs_ctx,
+		bytes.NewReader(image), int64(len(image))) {
+		records = append(records, record)
+	}
+
+	assert.Equal(t, 4, len(records))
+
+	// The parent directory was deleted since.
+	assert.Equal(t, "report.doc", records[0].Name)
+	assert.Equal(t, "40", records[0].MFTId)
+	assert.False(t, records[0].IsSlack)
+	assert.Equal(t, int64(0x1000+0x58), records[0].DiskOffset)
+	assert.Equal(t, uint64(30), records[0].ParentEntryNumber)
+	assert.Equal(t, "/Old", records[0].ParentPath)
+	assert.True(t, records[0].ParentDeleted)
+
+	assert.Equal(t, "draft.doc", records[1].Name)
+	assert.True(t, records[1].IsSlack)
+	assert.Equal(t, int64(0x1000)+records[1].SlackOffset,
+		records[1].DiskOffset)
+
+	assert.Equal(t, "nested.txt", records[2].Name)
+	assert.Equal(t, "/Live", records[2].ParentPath)
+	assert.False(t, records[2].ParentDeleted)
+
+	// Without a file system the parents are not resolved.
+	count := 0
+	for record := range parser.CarveI30(context.Background(), nil,
+		bytes.NewReader(image), int64(len(image))) {
+		assert.Equal(t, "", record.ParentPath)
+		count++
+	}
+	assert.Equal(t, 4, count)
+}
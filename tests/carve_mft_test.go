@@ -0,0 +1,57 @@
+package ntfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Protect the record with an update sequence array like on disk.
+func withFixups(record []byte, fixup_offset int) []byte {
+	result := append([]byte{}, record...)
+	count := len(result)/512 + 1
+	putU16(result, 4, uint16(fixup_offset))
+	putU16(result, 6, uint16(count))
+	putU16(result, fixup_offset, 0x0707)
+	for i := 1; i < count; i++ {
+		copy(result[fixup_offset+2*i:], result[i*512-2:i*512])
+		putU16(result, i*512-2, 0x0707)
+	}
+	return result
+}
+
+func TestCarveMFT(t *testing.T) {
+	mft := newTestMFT(40)
+	mft.Entry(35, testFlagAllocated).AddName(5, "carved.txt")
+	mft.Entry(36, testFlagAllocated|testFlagDirectory).AddName(5, "Dir")
+
+	// A truncated entry (the allocated size is too small).
+	bogus := make([]byte, 512)
+	copy(bogus, "FILE")
+
+	image := bytes.Repeat([]byte{0xaa}, 3*512)
+	image = append(image, withFixups(mft.entries[35].buf, 0x30)...)
+	image = append(image, bogus...)
+
+	// Entries without fixups are not valid on disk.
+	image = append(image, mft.entries[36].buf...)
+	image = append(image, withFixups(mft.entries[36].buf, 0x30)...)
+
+	records := []*parser.MFTCarvedRecord{}
+	for record := range parser.CarveMFT(context.Background(), nil,
+		bytes.NewReader(image), int64(len(image))) {
+		records = append(records, record)
+	}
+
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, int64(3*512), records[0].DiskOffset)
+	assert.Equal(t, int64(35), records[0].EntryNumber)
+	assert.Equal(t, "/carved.txt", records[0].FullPath())
+
+	assert.Equal(t, int64(3*512+1024+512+1024), records[1].DiskOffset)
+	assert.Equal(t, "Dir", records[1].FileName())
+	assert.True(t, records[1].IsDir)
+}
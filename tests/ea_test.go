@@ -0,0 +1,123 @@
+package ntfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Build a list of FILE_FULL_EA_INFORMATION entries.
+func eaEntries(eas ...*parser.ExtendedAttribute) []byte {
+	result := []byte{}
+	for i, ea := range eas {
+		entry := make([]byte, 8)
+		entry[4] = ea.Flags
+		entry[5] = byte(len(ea.Name))
+		putU16(entry, 6, uint16(len(ea.Value)))
+		entry = append(append(entry, ea.Name...), 0)
+		entry = append(entry, ea.Value...)
+
+		// Entries are 4 byte aligned.
+		for len(entry)%4 != 0 {
+			entry = append(entry, 0)
+		}
+		if i < len(eas)-1 {
+			putU32(entry, 0, uint32(len(entry)))
+		}
+		result = append(result, entry...)
+	}
+	return result
+}
+
+func eaUint32(value uint32) []byte {
+	result := make([]byte, 4)
+	putU32(result, 0, value)
+	return result
+}
+
+func lxattrb(mode, uid, gid uint32, ts time.Time) []byte {
+	result := make([]byte, parser.LXATTRB_SIZE)
+	putU32(result, 4, mode)
+	putU32(result, 8, uid)
+	putU32(result, 12, gid)
+	for i := 0; i < 3; i++ {
+		putU32(result, 20+4*i, uint32(ts.Nanosecond()))
+		putU64(result, 32+8*i, uint64(ts.Unix()))
+	}
+	return result
+}
+
+func TestExtendedAttributes(t *testing.T) {
+	payload := []byte("MZ\x90\x00hidden payload")
+	ts := time.Date(2022, 5, 6, 7, 8, 9, 123, time.UTC)
+
+	drvfs := eaEntries(
+		&parser.ExtendedAttribute{Name: "$LXUID", Value: eaUint32(1000)},
+		&parser.ExtendedAttribute{Name: "$LXGID", Value: eaUint32(0)},
+		&parser.ExtendedAttribute{Name: "$LXMOD", Value: eaUint32(0100755)},
+		&parser.ExtendedAttribute{Name: "PAYLOAD",
+			Flags: parser.FILE_NEED_EA, Value: payload})
+
+	ea_information := make([]byte, parser.EA_INFORMATION_SIZE)
+	putU16(ea_information, 0, uint16(len(drvfs)))
+	putU16(ea_information, 2, 1)
+	putU32(ea_information, 4, uint32(len(drvfs)))
+
+	lxfs := eaEntries(&parser.ExtendedAttribute{
+		Name: "LXATTRB", Value: lxattrb(040700|01000, 0, 0, ts)})
+
+	mft := newTestMFT(32)
+	mft.Entry(5, testFlagAllocated|testFlagDirectory).AddName(5, ".").
+		AddChildren(map[uint64]string{30: "script.sh", 31: "tmp"})
+	mft.Entry(30, testFlagAllocated).AddName(5, "script.sh").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("#!/bin/sh\n")).
+		AddAttribute(parser.ATTR_TYPE_EA_INFORMATION, "", ea_information).
+		AddAttribute(parser.ATTR_TYPE_EA, "", drvfs)
+	mft.Entry(31, testFlagAllocated|testFlagDirectory).AddName(5, "tmp").
+		AddAttribute(parser.ATTR_TYPE_EA, "", lxfs).
+		AddChildren(map[uint64]string{})
+	ntfs := mft.Context()
+
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+
+	info, err := parser.ModelMFTEntry(ntfs, mft_entry)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1), info.EAInformation.NeedEACount)
+	assert.Equal(t, uint32(len(drvfs)), info.EAInformation.UnpackedSize)
+
+	assert.Equal(t, 4, len(info.ExtendedAttributes))
+	assert.Equal(t, "PAYLOAD", info.ExtendedAttributes[3].Name)
+	assert.Equal(t, uint8(parser.FILE_NEED_EA), info.ExtendedAttributes[3].Flags)
+
+	assert.Equal(t, uint32(1000), *info.WSL.UID)
+	assert.Equal(t, uint32(0), *info.WSL.GID)
+	assert.Equal(t, "-rwxr-xr-x", info.WSL.ModeString)
+	assert.Nil(t, info.WSL.Mtime)
+
+	// The raw value can be dumped.
+	ea, err := parser.GetExtendedAttribute(ntfs, mft_entry, "payload")
+	assert.NoError(t, err)
+	assert.Equal(t, payload, ea.Value)
+
+	_, err = parser.GetExtendedAttribute(ntfs, mft_entry, "missing")
+	assert.Error(t, err)
+
+	stat := parser.Stat(ntfs, mft_entry)
+	assert.Equal(t, 1, len(stat))
+	assert.Equal(t, uint32(1000), *stat[0].WSL.UID)
+	assert.Equal(t, 4, len(stat[0].ExtendedAttributes))
+
+	// LxFs stores the timestamps in LXATTRB.
+	mft_entry, err = ntfs.GetMFT(31)
+	assert.NoError(t, err)
+
+	stat = parser.Stat(ntfs, mft_entry)
+	assert.Equal(t, 1, len(stat))
+	assert.Equal(t, "drwx-----T", stat[0].WSL.ModeString)
+	assert.Equal(t, uint32(0), *stat[0].WSL.UID)
+	assert.Equal(t, ts, *stat[0].WSL.Mtime)
+	assert.Equal(t, ts, *stat[0].WSL.Ctime)
+}
@@ -0,0 +1,174 @@
+package ntfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// S-1-5-21-1-2-3-1001
+var testEFSSID = []byte{
+	1, 5, 0, 0, 0, 0, 0, 5,
+	21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 0xe9, 3, 0, 0,
+}
+
+// A DDF or DRF entry with its credential header.
+func efsKeyEntry(credential []byte) []byte {
+	fek := bytes.Repeat([]byte{0xfe}, 16)
+	entry := make([]byte, 20)
+	putU32(entry, 0, uint32(20+len(credential)+len(fek)))
+	putU32(entry, 4, 20)
+	putU32(entry, 8, uint32(len(fek)))
+	putU32(entry, 12, uint32(20+len(credential)))
+
+	entry = append(entry, credential...)
+	return append(entry, fek...)
+}
+
+func efsThumbprintCredential() []byte {
+	thumbprint := bytes.Repeat([]byte{0xab}, 20)
+	container := utf16Bytes("container-guid\x00")
+	user := utf16Bytes(`DESKTOP\alice` + "\x00")
+
+	header := make([]byte, 20)
+	putU32(header, 0, 20)
+	putU32(header, 4, uint32(len(thumbprint)))
+	putU32(header, 8, uint32(20+len(thumbprint)))
+	putU32(header, 16, uint32(20+len(thumbprint)+len(container)))
+	header = append(append(append(header, thumbprint...), container...), user...)
+
+	credential := make([]byte, 28)
+	putU32(credential, 4, 28)
+	putU32(credential, 8, parser.EFS_CREDENTIAL_CERT_THUMBPRINT)
+	putU32(credential, 12, uint32(len(header)))
+	putU32(credential, 16, uint32(28+len(testEFSSID)))
+	credential = append(append(credential, testEFSSID...), header...)
+	putU32(credential, 0, uint32(len(credential)))
+	return credential
+}
+
+func efsContainerCredential() []byte {
+	container := utf16Bytes("recovery\x00")
+	provider := utf16Bytes("Microsoft Enhanced Cryptographic Provider v1.0\x00")
+
+	credential := make([]byte, 28)
+	putU32(credential, 8, parser.EFS_CREDENTIAL_CRYPTOAPI_CONTAINER)
+	putU32(credential, 12, 28)
+	putU32(credential, 16, uint32(28+len(container)))
+	credential = append(append(credential, container...), provider...)
+	putU32(credential, 0, uint32(len(credential)))
+	return credential
+}
+
+func efsStream() []byte {
+	ddf := append([]byte{1, 0, 0, 0}, efsKeyEntry(efsThumbprintCredential())...)
+	drf := append([]byte{1, 0, 0, 0}, efsKeyEntry(efsContainerCredential())...)
+
+	header := make([]byte, parser.EFS_HEADER_SIZE)
+	putU32(header, 0, uint32(len(header)+len(ddf)+len(drf)))
+	putU32(header, 8, 2)
+	putU32(header, 0x40, uint32(len(header)))
+	putU32(header, 0x44, uint32(len(header)+len(ddf)))
+	return append(append(header, ddf...), drf...)
+}
+
+func TestEFS(t *testing.T) {
+	efs := efsStream()
+	cipher_text := bytes.Repeat([]byte("encrypted"), 10)
+
+	mft := newTestMFT(32)
+	mft.Entry(5, testFlagAllocated|testFlagDirectory).AddName(5, ".").
+		AddChildren(map[uint64]string{30: "secret.txt"})
+	mft.Entry(30, testFlagAllocated).AddName(5, "secret.txt").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", cipher_text).
+		AddAttribute(parser.ATTR_TYPE_LOGGED_UTILITY_STREAM, "$EFS", efs)
+	ntfs := mft.Context()
+
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+
+	info, err := parser.ModelMFTEntry(ntfs, mft_entry)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), info.EFS.Version)
+	assert.Equal(t, 1, len(info.EFS.DDF))
+
+	ddf := info.EFS.DDF[0]
+	assert.Equal(t, "S-1-5-21-1-2-3-1001", ddf.SID)
+	assert.Equal(t, "abababababababababababababababababababab", ddf.Thumbprint)
+	assert.Equal(t, "container-guid", ddf.ContainerName)
+	assert.Equal(t, `DESKTOP\alice`, ddf.UserName)
+	assert.Equal(t, 16, ddf.EncryptedFEKSize)
+
+	drf := info.EFS.DRF[0]
+	assert.Equal(t, "", drf.SID)
+	assert.Equal(t, "recovery", drf.ContainerName)
+	assert.Equal(t, "Microsoft Enhanced Cryptographic Provider v1.0", drf.ProviderName)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, parser.ExportEncryptedFileRaw(ntfs, mft_entry, out))
+	raw := out.Bytes()
+
+	// The header and the $EFS stream.
+	assert.Equal(t, []byte("\x00\x01\x00\x00R\x00O\x00B\x00S\x00"), raw[:12])
+	raw = raw[20:]
+	assert.Equal(t, utf16Bytes("::$EFS"), raw[28:40])
+	raw = raw[40:]
+	assert.Equal(t, uint32(20+len(efs)), binary.LittleEndian.Uint32(raw))
+	assert.Equal(t, utf16Bytes("GURE"), raw[4:12])
+	assert.Equal(t, efs, raw[20:20+len(efs)])
+	raw = raw[20+len(efs):]
+
+	// The $DATA stream is padded to 512 bytes.
+	assert.Equal(t, utf16Bytes("::$DATA"), raw[28:42])
+	raw = raw[42:]
+	assert.Equal(t, uint32(20+26+512), binary.LittleEndian.Uint32(raw))
+	assert.Equal(t, uint64(0), binary.LittleEndian.Uint64(raw[20:]))
+	assert.Equal(t, uint32(len(cipher_text)), binary.LittleEndian.Uint32(raw[32:]))
+	assert.Equal(t, cipher_text, raw[46:46+len(cipher_text)])
+	assert.Equal(t, 46+512, len(raw))
+
+	// Files without $EFS are not exported.
+	mft_entry, _ = ntfs.GetMFT(5)
+	assert.Error(t, parser.ExportEncryptedFileRaw(ntfs, mft_entry, out))
+}
+
+var testDiskError = errors.New("Disk read error")
+
+type failingDisk struct{}
+
+func (self failingDisk) ReadAt(buf []byte, offset int64) (int, error) {
+	return 0, testDiskError
+}
+
+func TestEFSReadError(t *testing.T) {
+	mft := newTestMFT(32)
+	mft.Entry(30, testFlagAllocated).AddName(5, "secret.txt").
+		AddNonResidentAttribute(parser.ATTR_TYPE_DATA, "", 100,
+			testRun{Cluster: 1, Length: 1}).
+		AddAttribute(parser.ATTR_TYPE_LOGGED_UTILITY_STREAM, "$EFS", efsStream())
+	mft.Entry(31, testFlagAllocated).AddName(5, "other.txt").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("encrypted")).
+		AddNonResidentAttribute(parser.ATTR_TYPE_LOGGED_UTILITY_STREAM,
+			"$EFS", 0x200, testRun{Cluster: 2, Length: 1})
+	ntfs := mft.Context()
+	ntfs.DiskReader = failingDisk{}
+
+	// Read errors are returned instead of exporting zeros as the
+	// ciphertext.
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+	assert.Equal(t, testDiskError,
+		parser.ExportEncryptedFileRaw(ntfs, mft_entry, &bytes.Buffer{}))
+
+	mft_entry, err = ntfs.GetMFT(31)
+	assert.NoError(t, err)
+	assert.Equal(t, testDiskError,
+		parser.ExportEncryptedFileRaw(ntfs, mft_entry, &bytes.Buffer{}))
+
+	_, err = mft_entry.EFS(ntfs)
+	assert.Equal(t, testDiskError, err)
+}
@@ -0,0 +1,147 @@
+package ntfs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Fail any read which was not previously recorded so the recorder
+// does not try to add new sectors to the test data.
+type unrecordedReader struct{}
+
+func (self unrecordedReader) ReadAt(buf []byte, offset int64) (int, error) {
+	return 0, errors.New("Sector was not recorded")
+}
+
+func openRecordedNTFS(t *testing.T, record_dir string) *parser.NTFSContext {
+	reader, err := parser.NewPagedReader(
+		parser.NewRecorder(record_dir, unrecordedReader{}), 1024, 10000)
+	assert.NoError(t, err)
+
+	ntfs_ctx, err := parser.GetNTFSContext(reader, 0)
+	assert.NoError(t, err)
+
+	return ntfs_ctx
+}
+
+func TestFSAdapter(t *testing.T) {
+	ntfs_ctx := openRecordedNTFS(t, "ads_with_same_ids")
+	fsys := parser.NewNTFSFS(ntfs_ctx)
+
+	entries, err := fs.ReadDir(fsys, ".")
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	// ADS are not listed as directory entries.
+	assert.Contains(t, names, "Nine.txt")
+	assert.Contains(t, names, "System Volume Information")
+	assert.NotContains(t, names, "Nine.txt:111")
+	assert.NotContains(t, names, ".")
+
+	// The root is a directory called "."
+	info, err := fs.Stat(fsys, ".")
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, ".", info.Name())
+
+	info, err = fs.Stat(fsys, "Nine.txt")
+	assert.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, int64(5000), info.Size())
+
+	// Sys() carries the FileInfo model
+	model, ok := info.Sys().(*parser.FileInfo)
+	assert.True(t, ok)
+	assert.Equal(t, "38-128-3", model.MFTId)
+
+	data, err := fs.ReadFile(fsys, "Nine.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, len(data))
+	assert.True(t, strings.Contains(string(data), "9999"))
+
+	// ADS are accessible with the path:stream syntax
+	data, err = fs.ReadFile(fsys, "Nine.txt:111")
+	assert.NoError(t, err)
+	assert.Equal(t, 5005, len(data))
+	assert.True(t, strings.Contains(string(data), "1111"))
+
+	_, err = fs.Stat(fsys, "Missing.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	_, err = fsys.Open("/Nine.txt")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+}
+
+// The recorded volume only contains the sectors for some of the
+// files, so restrict the root directory to those files.
+type knownFilesFS struct {
+	fsys  fs.FS
+	known map[string]bool
+}
+
+func (self knownFilesFS) Open(name string) (fs.File, error) {
+	fd, err := self.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "." {
+		return &knownFilesDir{File: fd, known: self.known}, nil
+	}
+
+	if !self.known[name] {
+		fd.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return fd, nil
+}
+
+type knownFilesDir struct {
+	fs.File
+	known map[string]bool
+}
+
+func (self *knownFilesDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	entries, err := self.File.(fs.ReadDirFile).ReadDir(count)
+	result := []fs.DirEntry{}
+	for _, e := range entries {
+		if self.known[e.Name()] {
+			result = append(result, e)
+		}
+	}
+	return result, err
+}
+
+func TestFSAdapterConformance(t *testing.T) {
+	ntfs_ctx := openRecordedNTFS(t, "ads_with_same_ids")
+	fsys := parser.NewNTFSFS(ntfs_ctx)
+
+	known := knownFilesFS{fsys: fsys, known: map[string]bool{
+		"Nine.txt": true, "$AttrDef": true,
+	}}
+	assert.NoError(t, fstest.TestFS(known, "Nine.txt", "$AttrDef"))
+
+	// ADS are not directory entries so TestFS can not find them
+	// - check the stream readers directly.
+	for _, name := range []string{"Nine.txt:111", "Nine.txt:222"} {
+		expected, err := fs.ReadFile(fsys, name)
+		assert.NoError(t, err)
+
+		fd, err := fsys.Open(name)
+		assert.NoError(t, err)
+		assert.NoError(t, iotest.TestReader(fd, expected), name)
+		fd.Close()
+	}
+}
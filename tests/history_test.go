@@ -0,0 +1,109 @@
+package ntfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Two versions of a small volume.
+func buildHistoryTrees() (*testMFT, *testMFT) {
+	dir := uint16(testFlagAllocated | testFlagDirectory)
+
+	old := newTestMFT(34)
+	old.Entry(5, dir).AddName(5, ".").AddChildren(
+		map[uint64]string{30: "a.txt", 31: "b.txt", 32: "c.txt"})
+	old.Entry(30, testFlagAllocated).AddName(5, "a.txt").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("hello"))
+	old.Entry(31, testFlagAllocated).AddName(5, "b.txt")
+	old.Entry(32, testFlagAllocated).AddName(5, "c.txt")
+
+	// a.txt grew, b.txt was renamed, c.txt was deleted and its entry
+	// reused and e.txt was added with an ADS.
+	new := newTestMFT(34)
+	new.Entry(5, dir).AddName(5, ".").AddChildren(
+		map[uint64]string{30: "a.txt", 31: "b2.txt", 32: "d.txt", 33: "e.txt"})
+	new.Entry(30, testFlagAllocated).AddName(5, "a.txt").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("hello world"))
+	new.Entry(31, testFlagAllocated).AddName(5, "b2.txt")
+	new.Entry(32, testFlagAllocated).SetSequence(2).AddName(5, "d.txt")
+	new.Entry(33, testFlagAllocated).AddName(5, "e.txt").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("e")).
+		AddAttribute(parser.ATTR_TYPE_DATA, "Zone.Identifier", []byte("zone"))
+
+	return old, new
+}
+
+func testMFTSource(mft *testMFT) *parser.MFTSource {
+	data := mft.Bytes()
+	return &parser.MFTSource{
+		Reader:      bytes.NewReader(data),
+		Size:        int64(len(data)),
+		ClusterSize: 4096,
+		RecordSize:  testRecordSize,
+	}
+}
+
+func TestDiffMFT(t *testing.T) {
+	old, new := buildHistoryTrees()
+
+	changes := []*parser.VolumeDiffEntry{}
+	for entry := range parser.DiffMFT(context.Background(),
+		testMFTSource(old), testMFTSource(new), parser.GetDefaultOptions()) {
+		changes = append(changes, entry)
+	}
+
+	assert.Equal(t, 5, len(changes))
+
+	assert.Equal(t, parser.DIFF_MODIFIED, changes[0].Change)
+	assert.Equal(t, int64(30), changes[0].EntryNumber)
+	assert.Equal(t, []string{"Size"}, changes[0].Changes)
+	assert.Equal(t, int64(11), changes[0].NewSize)
+
+	assert.Equal(t, parser.DIFF_RENAMED, changes[1].Change)
+	assert.Equal(t, "/b.txt", changes[1].OldPath)
+	assert.Equal(t, "/b2.txt", changes[1].NewPath)
+
+	// The reused entry is a different file.
+	assert.Equal(t, parser.DIFF_DELETED, changes[2].Change)
+	assert.Equal(t, "/c.txt", changes[2].OldPath)
+	assert.Equal(t, parser.DIFF_ADDED, changes[3].Change)
+	assert.Equal(t, uint16(2), changes[3].SequenceNumber)
+	assert.Equal(t, "/d.txt", changes[3].NewPath)
+
+	assert.Equal(t, parser.DIFF_ADDED, changes[4].Change)
+	assert.Equal(t, "/e.txt", changes[4].NewPath)
+}
+
+func TestFileHistory(t *testing.T) {
+	old, new := buildHistoryTrees()
+	versions := []*parser.VolumeVersion{
+		{Source: "Snapshot", SnapshotOrdinal: 1, NTFS: old.Context()},
+		{Source: "Live", NTFS: new.Context()},
+	}
+
+	history := parser.GetFileHistory(context.Background(), versions, "/a.txt")
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, int64(5), history[0].Size)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", history[0].Streams[0].MD5)
+	assert.Equal(t, int64(11), history[1].Size)
+	assert.Equal(t,
+		"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		history[1].Streams[0].SHA256)
+
+	// By MFT id the renamed file is found in both versions.
+	history = parser.GetFileHistory(context.Background(), versions, "31")
+	assert.Equal(t, "/b.txt", history[0].FullPath)
+	assert.Equal(t, "/b2.txt", history[1].FullPath)
+
+	// The file only exists in the live volume where its ADS is listed.
+	history = parser.GetFileHistory(context.Background(), versions, "/e.txt")
+	assert.NotEqual(t, "", history[0].Error)
+	assert.Equal(t, "", history[1].Error)
+	assert.Equal(t, 2, len(history[1].Streams))
+	assert.Equal(t, "Zone.Identifier", history[1].Streams[1].Name)
+	assert.Equal(t, int64(4), history[1].Streams[1].Size)
+}
@@ -0,0 +1,250 @@
+package ntfs
+
+import (
+	"bytes"
+	"unicode/utf16"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Helpers to build a synthetic raw $MFT with a small directory tree
+// for use with parser.GetNTFSContextFromRawMFT().
+
+const (
+	testRecordSize = 1024
+
+	testFlagAllocated = 1
+	testFlagDirectory = 2
+)
+
+type testMFT struct {
+	entries []*testMFTEntry
+}
+
+type testMFTEntry struct {
+	id      int
+	buf     []byte
+	offset  int
+	attr_id uint16
+}
+
+func newTestMFT(count int) *testMFT {
+	result := &testMFT{}
+	for i := 0; i < count; i++ {
+		result.entries = append(result.entries, nil)
+	}
+	return result
+}
+
+// Create a new MFT entry with the given id. Entries which are not
+// created are left zeroed.
+func (self *testMFT) Entry(id int, flags uint16) *testMFTEntry {
+	buf := make([]byte, testRecordSize)
+	copy(buf[0:4], []byte("FILE"))
+	putU16(buf, 4, 0x30)            // Fixup_offset
+	putU16(buf, 6, 0)               // Fixup_count = 0 -> skip fixups
+	putU16(buf, 16, 1)              // Sequence_value
+	putU16(buf, 18, 1)              // Link_count
+	putU16(buf, 20, 0x38)           // Attribute_offset
+	putU16(buf, 22, flags)          // Flags
+	putU32(buf, 24, testRecordSize) // Mft_entry_size
+	putU32(buf, 28, testRecordSize) // Mft_entry_allocated
+	putU32(buf, 44, uint32(id))     // Record_number
+
+	entry := &testMFTEntry{id: id, buf: buf, offset: 0x38}
+	self.entries[id] = entry
+	return entry
+}
+
+// Deleted entries have their sequence number incremented.
+func (self *testMFTEntry) SetSequence(seq uint16) *testMFTEntry {
+	putU16(self.buf, 16, seq)
+	return self
+}
+
+func (self *testMFT) Bytes() []byte {
+	result := make([]byte, 0, len(self.entries)*testRecordSize)
+	for _, entry := range self.entries {
+		if entry == nil {
+			result = append(result, make([]byte, testRecordSize)...)
+			continue
+		}
+		result = append(result, entry.buf...)
+	}
+	return result
+}
+
+func (self *testMFT) Context() *parser.NTFSContext {
+	return parser.GetNTFSContextFromRawMFT(
+		bytes.NewReader(self.Bytes()), 4096, testRecordSize)
+}
+
+// Add a resident attribute.
+func (self *testMFTEntry) AddAttribute(
+	attr_type uint32, name string, content []byte) *testMFTEntry {
+	name_bytes := utf16Bytes(name)
+	content_offset := align8(24 + len(name_bytes))
+	length := align8(content_offset + len(content))
+
+	a := self.offset
+	buf := self.buf
+	putU32(buf, a+0, attr_type)
+	putU32(buf, a+4, uint32(length))
+	buf[a+8] = 0 // Resident
+	buf[a+9] = byte(len(name_bytes) / 2)
+	putU16(buf, a+10, 24)
+	putU16(buf, a+14, self.attr_id)
+	putU32(buf, a+16, uint32(len(content)))
+	putU16(buf, a+20, uint16(content_offset))
+	copy(buf[a+24:], name_bytes)
+	copy(buf[a+content_offset:], content)
+
+	self.offset += length
+	self.attr_id++
+	return self
+}
+
+// A run of clusters for AddNonResidentAttribute(). Both must be less
+// than 128.
+type testRun struct {
+	Cluster, Length int
+}
+
+// Add a non-resident attribute stored in the runs.
+func (self *testMFTEntry) AddNonResidentAttribute(
+	attr_type uint32, name string, size int64,
+	runs ...testRun) *testMFTEntry {
+	runlist := []byte{}
+	clusters, last_cluster := 0, 0
+	for _, run := range runs {
+		runlist = append(runlist, 0x11, byte(run.Length),
+			byte(run.Cluster-last_cluster))
+		clusters += run.Length
+		last_cluster = run.Cluster
+	}
+	runlist = append(runlist, 0)
+
+	name_bytes := utf16Bytes(name)
+	runlist_offset := align8(0x40 + len(name_bytes))
+	length := align8(runlist_offset + len(runlist))
+
+	a := self.offset
+	buf := self.buf
+	putU32(buf, a+0, attr_type)
+	putU32(buf, a+4, uint32(length))
+	buf[a+8] = 1 // Non resident
+	buf[a+9] = byte(len(name_bytes) / 2)
+	putU16(buf, a+10, 0x40)
+	putU16(buf, a+14, self.attr_id)
+	putU64(buf, a+0x18, uint64(clusters-1))     // Last VCN
+	putU16(buf, a+0x20, uint16(runlist_offset)) // Runlist offset
+	putU64(buf, a+0x28, uint64(clusters*4096))  // Allocated size
+	putU64(buf, a+0x30, uint64(size))           // Actual size
+	putU64(buf, a+0x38, uint64(size))           // Initialized size
+	copy(buf[a+0x40:], name_bytes)
+	copy(buf[a+runlist_offset:], runlist)
+
+	self.offset += length
+	self.attr_id++
+	return self
+}
+
+// Add $STANDARD_INFORMATION and $FILE_NAME attributes.
+func (self *testMFTEntry) AddName(parent uint64, name string) *testMFTEntry {
+	self.AddAttribute(parser.ATTR_TYPE_STANDARD_INFORMATION, "",
+		make([]byte, 72))
+	return self.AddAttribute(parser.ATTR_TYPE_FILE_NAME, "",
+		fileNameContent(parent, name))
+}
+
+// Add an $INDEX_ROOT attribute holding the children of a directory.
+func (self *testMFTEntry) AddChildren(
+	children map[uint64]string) *testMFTEntry {
+	entries := []byte{}
+	for mft_id, name := range children {
+		file_name := fileNameContent(uint64(self.id), name)
+		size := align8(16 + len(file_name))
+		entry := make([]byte, size)
+		putU64(entry, 0, mft_id|1<<48) // MftReference and Seq_num
+		putU16(entry, 8, uint16(size))
+		putU16(entry, 10, uint16(len(file_name)))
+		copy(entry[16:], file_name)
+		entries = append(entries, entry...)
+	}
+
+	return self.AddIndexRoot("$I30", parser.ATTR_TYPE_FILE_NAME, 1, entries)
+}
+
+// Add an $INDEX_ROOT attribute holding the encoded index entries.
+func (self *testMFTEntry) AddIndexRoot(name string,
+	indexed_type, collation uint32, entries []byte) *testMFTEntry {
+	// The last entry
+	last := make([]byte, 16)
+	putU16(last, 8, 16)
+	putU32(last, 12, 2)
+	entries = append(entries, last...)
+
+	content := make([]byte, 32)
+	putU32(content, 0, indexed_type)             // Indexed type
+	putU32(content, 4, collation)                // Collation
+	putU32(content, 8, 4096)                     // Index size
+	putU32(content, 16, 16)                      // Offset to first entry
+	putU32(content, 20, uint32(16+len(entries))) // Offset to end
+	putU32(content, 24, uint32(16+len(entries))) // Allocated size
+	content = append(content, entries...)
+
+	return self.AddAttribute(parser.ATTR_TYPE_INDEX_ROOT, name, content)
+}
+
+// Encode a view index entry.
+func viewIndexEntry(key, data []byte) []byte {
+	size := align8(16 + len(key) + len(data))
+	entry := make([]byte, size)
+	putU16(entry, 0, uint16(16+len(key))) // DataOffset
+	putU16(entry, 2, uint16(len(data)))   // DataLength
+	putU16(entry, 8, uint16(size))        // EntrySize
+	putU16(entry, 10, uint16(len(key)))   // KeySize
+	copy(entry[16:], key)
+	copy(entry[16+len(key):], data)
+	return entry
+}
+
+func fileNameContent(parent uint64, name string) []byte {
+	name_bytes := utf16Bytes(name)
+	result := make([]byte, 66+len(name_bytes))
+	putU64(result, 0, parent|1<<48)
+	result[64] = byte(len(name_bytes) / 2)
+	result[65] = 1 // Win32
+	copy(result[66:], name_bytes)
+	return result
+}
+
+func utf16Bytes(s string) []byte {
+	result := []byte{}
+	for _, c := range utf16.Encode([]rune(s)) {
+		result = append(result, byte(c), byte(c>>8))
+	}
+	return result
+}
+
+func align8(v int) int {
+	return (v + 7) &^ 7
+}
+
+// Encode an INDX record of an $INDEX_ALLOCATION holding the index
+// entries.
+func indxRecordWithEntries(entries []byte) []byte {
+	last := make([]byte, 16)
+	putU16(last, 8, 16)
+	putU32(last, 12, 2)
+	entries = append(append([]byte{}, entries...), last...)
+
+	indx := make([]byte, 0x1000)
+	copy(indx, "INDX")
+	putU32(indx, 0x18, 0x40)
+	putU32(indx, 0x1c, uint32(0x40+len(entries)))
+	putU32(indx, 0x20, 0x1000-0x18)
+	copy(indx[0x58:], entries)
+
+	return withFixups(indx, 0x28)
+}
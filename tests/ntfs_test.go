@@ -150,6 +150,17 @@ func (self *NTFSTestSuite) TestMultipleADSWithSameID() {
 	g = goldie.New(self.T(), goldie.WithFixtureDir(record_dir+"/fixtures"))
 	g.Assert(self.T(), "stat", out_b)
 
+	// The volume label and version come from $Volume.
+	cmd = exec.Command(self.binary, "--record", record_dir, "fsstat", self.binary)
+	out_b, err = cmd.CombinedOutput()
+	assert.NoError(self.T(), err, string(out_b))
+	g.Assert(self.T(), "fsstat", out_b)
+
+	cmd = exec.Command(self.binary, "--record", record_dir, "attrdef", self.binary)
+	out_b, err = cmd.CombinedOutput()
+	assert.NoError(self.T(), err, string(out_b))
+	g.Assert(self.T(), "attrdef", out_b)
+
 	// Search first stream with id of 0 will select the first stream :111
 	cmd = exec.Command(self.binary, "--record", record_dir, "cat", self.binary, "38-128-0")
 	out_b, err = cmd.CombinedOutput()
@@ -0,0 +1,127 @@
+package ntfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+func mustHex(value string) []byte {
+	result, err := hex.DecodeString(value)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+var (
+	// {53aa8980-7ca7-11eb-9234-0050568a1b2c} created 2021-03-04 05:06:07
+	testObjectId = mustHex("8089aa53a77ceb1192340050568a1b2c")
+
+	testBirthVolumeId = mustHex("11111111222233334444555555555555")
+)
+
+func buildObjIdTree() *testMFT {
+	dir := uint16(testFlagAllocated | testFlagDirectory)
+
+	mft := newTestMFT(32)
+	mft.Entry(5, dir).AddName(5, ".").AddChildren(
+		map[uint64]string{11: "$Extend", 30: "target.txt"})
+	mft.Entry(11, dir).AddName(5, "$Extend").AddChildren(
+		map[uint64]string{25: "$ObjId"})
+
+	// The target moved: the birth object id is its original id.
+	object_id := append(append(append([]byte{}, testObjectId...),
+		testBirthVolumeId...), testObjectId...)
+	mft.Entry(30, testFlagAllocated).SetSequence(3).
+		AddName(5, "target.txt").
+		AddAttribute(parser.ATTR_TYPE_OBJECT_ID, "", object_id)
+
+	index_data := make([]byte, parser.OBJID_INDEX_DATA_SIZE)
+	putU64(index_data, 0, 30|3<<48)
+	copy(index_data[8:], object_id[16:])
+
+	// The collation of $O is COLLATION_NTOFS_ULONGS (0x13).
+	mft.Entry(25, testFlagAllocated).AddName(11, "$ObjId").AddIndexRoot(
+		"$O", 0, 0x13, viewIndexEntry(testObjectId, index_data))
+
+	return mft
+}
+
+func TestObjectId(t *testing.T) {
+	ntfs := buildObjIdTree().Context()
+
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+
+	info, err := parser.ModelMFTEntry(ntfs, mft_entry)
+	assert.NoError(t, err)
+
+	object_id := info.ObjectId.ObjectId
+	assert.Equal(t, "{53aa8980-7ca7-11eb-9234-0050568a1b2c}", object_id.GUID)
+	assert.Equal(t, 1, object_id.Version)
+	assert.Equal(t, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), *object_id.Timestamp)
+	assert.Equal(t, uint16(0x1234), object_id.ClockSequence)
+	assert.Equal(t, "00:50:56:8a:1b:2c", object_id.MAC)
+
+	// Only version 1 GUIDs have a timestamp.
+	assert.Equal(t, "{11111111-2222-3333-4444-555555555555}",
+		info.ObjectId.BirthVolumeId.GUID)
+	assert.Equal(t, 3, info.ObjectId.BirthVolumeId.Version)
+	assert.Nil(t, info.ObjectId.BirthVolumeId.Timestamp)
+	assert.Nil(t, info.ObjectId.DomainId)
+
+	// Resolve the id from a LNK file to the file.
+	resolved, err := parser.LookupObjectId(ntfs, "53AA8980-7CA7-11EB-9234-0050568A1B2C")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), resolved.MFTID)
+	assert.Equal(t, uint16(3), resolved.SequenceNumber)
+	assert.Equal(t, object_id.GUID, resolved.BirthObjectId.GUID)
+
+	_, err = parser.LookupObjectId(ntfs, "{00000000-7ca7-11eb-9234-0050568a1b2c}")
+	assert.Error(t, err)
+
+	all, err := parser.ParseObjIdIndex(ntfs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(all))
+}
+
+func TestObjectIdUnusedIndexRecord(t *testing.T) {
+	mft := buildObjIdTree()
+
+	object_id := append(append(append([]byte{}, testObjectId...),
+		testBirthVolumeId...), testObjectId...)
+	index_entry := func(mft_id uint64) []byte {
+		index_data := make([]byte, parser.OBJID_INDEX_DATA_SIZE)
+		putU64(index_data, 0, mft_id|3<<48)
+		copy(index_data[8:], object_id[16:])
+		return viewIndexEntry(testObjectId, index_data)
+	}
+
+	// The first INDX record is no longer in use but still holds the
+	// entry of a file which was deleted.
+	bitmap := []byte{0x02}
+	mft.Entry(25, testFlagAllocated).AddName(11, "$ObjId").
+		AddIndexRoot("$O", 0, 0x13, nil).
+		AddNonResidentAttribute(parser.ATTR_TYPE_INDEX_ALLOCATION, "$O",
+			0x2000, testRun{Cluster: 1, Length: 2}).
+		AddAttribute(parser.ATTR_TYPE_BITMAP, "$O", bitmap)
+	ntfs := mft.Context()
+
+	image := make([]byte, 0x1000)
+	image = append(image, indxRecordWithEntries(index_entry(29))...)
+	image = append(image, indxRecordWithEntries(index_entry(30))...)
+	ntfs.DiskReader = bytes.NewReader(image)
+
+	resolved, err := parser.LookupObjectId(ntfs, "53AA8980-7CA7-11EB-9234-0050568A1B2C")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), resolved.MFTID)
+
+	all, err := parser.ParseObjIdIndex(ntfs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(all))
+}
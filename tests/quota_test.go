@@ -0,0 +1,142 @@
+package ntfs
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const testQuotaOwnerId = 0x100
+
+// A file charged to the owner.
+func addQuotaFile(mft *testMFT, id int, name string,
+	owner_id uint32, charged uint64) {
+	si := make([]byte, 72)
+	putU32(si, 48, owner_id)
+	putU64(si, 56, charged)
+
+	mft.Entry(id, testFlagAllocated).
+		AddAttribute(parser.ATTR_TYPE_STANDARD_INFORMATION, "", si).
+		AddAttribute(parser.ATTR_TYPE_FILE_NAME, "", fileNameContent(5, name))
+}
+
+func quotaControlEntry(flags uint32, used uint64, limit int64, sid []byte) []byte {
+	result := make([]byte, parser.QUOTA_CONTROL_ENTRY_SIZE)
+	putU32(result, 0, 2)
+	putU32(result, 4, flags)
+	putU64(result, 8, used)
+	putU64(result, 16, 0x01d8754a895c8000) // 2022-06-01 00:00:00
+	putU64(result, 24, uint64(limit))
+	putU64(result, 32, uint64(limit))
+	return append(result, sid...)
+}
+
+func TestQuota(t *testing.T) {
+	dir := uint16(testFlagAllocated | testFlagDirectory)
+
+	mft := newTestMFT(40)
+	mft.Entry(5, dir).AddName(5, ".").AddChildren(
+		map[uint64]string{11: "$Extend", 30: "a.txt", 31: "b.txt"})
+	mft.Entry(11, dir).AddName(5, "$Extend").AddChildren(
+		map[uint64]string{24: "$Quota"})
+
+	owner_id := make([]byte, 4)
+	putU32(owner_id, 0, testQuotaOwnerId)
+	default_id := make([]byte, 4)
+	putU32(default_id, 0, parser.QUOTA_DEFAULTS_ID)
+
+	// $O collates by SID (0x11) and $Q by ULONG (0x10). The $Q entry
+	// of the owner has no SID so it comes from $O.
+	mft.Entry(24, testFlagAllocated).AddName(11, "$Quota").
+		AddIndexRoot("$O", 0, 0x11, viewIndexEntry(testEFSSID, owner_id)).
+		AddIndexRoot("$Q", 0, 0x10, append(
+			viewIndexEntry(default_id, quotaControlEntry(
+				parser.QUOTA_FLAG_TRACKING_ENABLED, 0, -1, nil)),
+			viewIndexEntry(owner_id, quotaControlEntry(
+				parser.QUOTA_FLAG_LIMIT_REACHED, 3000, 2048, nil))...))
+
+	addQuotaFile(mft, 30, "a.txt", testQuotaOwnerId, 1000)
+	addQuotaFile(mft, 31, "b.txt", testQuotaOwnerId, 2000)
+
+	// An owner id which is not in $Q.
+	addQuotaFile(mft, 32, "c.txt", 0x101, 10)
+	ntfs := mft.Context()
+
+	entries, err := parser.ParseQuotaIndex(ntfs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	assert.Equal(t, uint32(parser.QUOTA_DEFAULTS_ID), entries[0].OwnerId)
+	assert.Equal(t, []string{"TRACKING_ENABLED"}, entries[0].Flags)
+	assert.Equal(t, int64(-1), entries[0].HardLimit)
+	assert.Equal(t, "", entries[0].SID)
+
+	owner := entries[1]
+	assert.Equal(t, "S-1-5-21-1-2-3-1001", owner.SID)
+	assert.Equal(t, []string{"LIMIT_REACHED"}, owner.Flags)
+	assert.Equal(t, uint64(3000), owner.BytesUsed)
+	assert.Equal(t, int64(2048), owner.WarningLimit)
+	assert.Equal(t, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), owner.ChangeTime)
+	assert.Nil(t, owner.ExceededTime)
+
+	// Each file is mapped to its owner.
+	mft_entry, err := ntfs.GetMFT(31)
+	assert.NoError(t, err)
+
+	info, err := parser.ModelMFTEntry(ntfs, mft_entry)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(testQuotaOwnerId), info.OwnerId)
+	assert.Equal(t, uint64(2000), info.QuotaCharged)
+	assert.Equal(t, "S-1-5-21-1-2-3-1001", info.QuotaOwnerSID)
+
+	census, err := parser.QuotaCensus(ntfs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(census))
+	assert.Equal(t, &parser.QuotaUsage{
+		OwnerId:      testQuotaOwnerId,
+		SID:          "S-1-5-21-1-2-3-1001",
+		Files:        2,
+		ChargedBytes: 3000,
+		BytesUsed:    3000,
+	}, census[0])
+	assert.Equal(t, &parser.QuotaUsage{
+		OwnerId: 0x101, Files: 1, ChargedBytes: 10}, census[1])
+}
+
+func TestQuotaUnusedIndexRecord(t *testing.T) {
+	owner_id := make([]byte, 4)
+	putU32(owner_id, 0, testQuotaOwnerId)
+
+	// The SID of a previous owner with the same id.
+	stale_sid := append([]byte{}, testEFSSID...)
+	stale_sid[len(stale_sid)-4] = 0xea
+
+	// Only the first INDX record is in use.
+	bitmap := []byte{0x01}
+	mft := newTestMFT(40)
+	mft.Entry(5, testFlagAllocated|testFlagDirectory).AddName(5, ".").
+		AddChildren(map[uint64]string{11: "$Extend"})
+	mft.Entry(11, testFlagAllocated|testFlagDirectory).AddName(5, "$Extend").
+		AddChildren(map[uint64]string{24: "$Quota"})
+	mft.Entry(24, testFlagAllocated).AddName(11, "$Quota").
+		AddIndexRoot("$O", 0, 0x11, nil).
+		AddNonResidentAttribute(parser.ATTR_TYPE_INDEX_ALLOCATION, "$O",
+			0x2000, testRun{Cluster: 1, Length: 2}).
+		AddAttribute(parser.ATTR_TYPE_BITMAP, "$O", bitmap)
+	ntfs := mft.Context()
+
+	image := make([]byte, 0x1000)
+	image = append(image, indxRecordWithEntries(
+		viewIndexEntry(testEFSSID, owner_id))...)
+	image = append(image, indxRecordWithEntries(
+		viewIndexEntry(stale_sid, owner_id))...)
+	ntfs.DiskReader = bytes.NewReader(image)
+
+	owners, err := parser.ParseQuotaOwners(ntfs)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint32]string{
+		testQuotaOwnerId: "S-1-5-21-1-2-3-1001"}, owners)
+}
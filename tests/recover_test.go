@@ -0,0 +1,84 @@
+package ntfs
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+func buildDeletedTree() *testMFT {
+	mft := newTestMFT(36)
+	dir := uint16(testFlagAllocated | testFlagDirectory)
+
+	mft.Entry(5, dir).AddName(5, ".").AddChildren(
+		map[uint64]string{33: "Reused"})
+
+	// A deleted directory and a deleted file within it.
+	mft.Entry(30, testFlagDirectory).SetSequence(2).AddName(5, "Old")
+	mft.Entry(31, 0).SetSequence(2).AddName(30, "secret.txt").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("hello world"))
+
+	// The parent of this file was reused for another directory.
+	mft.Entry(32, 0).SetSequence(2).AddName(33, "orphan.txt")
+	mft.Entry(33, dir).SetSequence(3).AddName(5, "Reused")
+
+	// An allocated file is not deleted.
+	mft.Entry(34, testFlagAllocated).AddName(5, "live.txt")
+	mft.Entry(35, dir).AddName(5, "Live")
+
+	return mft
+}
+
+func TestRecoverDeletedFiles(t *testing.T) {
+	ntfs_ctx := buildDeletedTree().Context()
+
+	mft_entry, err := ntfs_ctx.GetMFT(31)
+	assert.NoError(t, err)
+
+	deleted, err := parser.AnalyzeDeletedFile(ntfs_ctx, mft_entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "/Old/secret.txt", deleted.FullPath)
+	assert.True(t, deleted.PathComplete)
+	assert.True(t, deleted.Resident)
+	assert.Equal(t, parser.RECOVERY_RESIDENT, deleted.Status)
+	assert.Equal(t, int64(11), deleted.Size)
+
+	reader, err := parser.OpenDeletedFile(ntfs_ctx, mft_entry)
+	assert.NoError(t, err)
+
+	buf := make([]byte, 100)
+	n, err := reader.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello world", string(buf[:n]))
+
+	mft_entry, err = ntfs_ctx.GetMFT(32)
+	assert.NoError(t, err)
+
+	path, complete := parser.RecoverPath(ntfs_ctx, mft_entry)
+	assert.Equal(t, "/<Orphan 33-1>/orphan.txt", path)
+	assert.False(t, complete)
+
+	mft_entry, err = ntfs_ctx.GetMFT(34)
+	assert.NoError(t, err)
+
+	_, err = parser.AnalyzeDeletedFile(ntfs_ctx, mft_entry)
+	assert.Error(t, err)
+}
+
+func TestScanDeletedFiles(t *testing.T) {
+	ntfs_ctx := buildDeletedTree().Context()
+
+	names := []string{}
+	for deleted := range parser.ScanDeletedFiles(
+		context.Background(), ntfs_ctx) {
+		names = append(names, deleted.FullPath)
+	}
+
+	assert.Equal(t, []string{
+		"/Old", "/Old/secret.txt", "/<Orphan 33-1>/orphan.txt"}, names)
+}
@@ -0,0 +1,203 @@
+package ntfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Build a junction or symlink reparse buffer.
+func reparseContent(tag uint32, target string, relative bool) []byte {
+	sub := utf16Bytes(target)
+
+	header_size := 8
+	if tag == parser.IO_REPARSE_TAG_SYMLINK {
+		header_size = 12
+	}
+
+	data := make([]byte, header_size+2*len(sub)+4)
+	putU16(data, 2, uint16(len(sub)))   // SubstituteNameLength
+	putU16(data, 4, uint16(len(sub)+2)) // PrintNameOffset
+	putU16(data, 6, uint16(len(sub)))   // PrintNameLength
+	if relative {
+		putU32(data, 8, parser.SYMLINK_FLAG_RELATIVE)
+	}
+	copy(data[header_size:], sub)
+	copy(data[header_size+len(sub)+2:], sub)
+
+	result := make([]byte, 8)
+	putU32(result, 0, tag)
+	putU16(result, 4, uint16(len(data)))
+	return append(result, data...)
+}
+
+func buildReparseTree() *testMFT {
+	mft := newTestMFT(26)
+	dir := uint16(testFlagAllocated | testFlagDirectory)
+
+	mft.Entry(5, dir).AddName(5, ".").AddChildren(map[uint64]string{
+		16: "Users",
+		17: "Documents and Settings",
+		18: "Loop",
+		19: "Rel",
+		20: "OtherDrive",
+		21: "Volume",
+	})
+
+	mft.Entry(16, dir).AddName(5, "Users").AddChildren(
+		map[uint64]string{22: "Public"})
+
+	// Junctions are directories with an empty $I30.
+	mft.Entry(17, dir).AddName(5, "Documents and Settings").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_MOUNT_POINT, `\??\C:\Users`, false)).
+		AddChildren(map[uint64]string{})
+
+	mft.Entry(18, dir).AddName(5, "Loop").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_MOUNT_POINT, `\??\C:\Loop`, false))
+
+	mft.Entry(19, dir).AddName(5, "Rel").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_SYMLINK, `Users\Public`, true))
+
+	mft.Entry(20, dir).AddName(5, "OtherDrive").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_MOUNT_POINT, `\??\D:\Data`, false))
+
+	mft.Entry(21, dir).AddName(5, "Volume").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_MOUNT_POINT,
+			`\??\Volume{6a1ba3a1-0000-0000-0000-100000000000}\`, false))
+
+	mft.Entry(22, dir).AddName(16, "Public").AddChildren(
+		map[uint64]string{23: "file.txt", 24: "Up", 25: "RootRel"})
+
+	mft.Entry(23, testFlagAllocated).AddName(22, "file.txt")
+
+	mft.Entry(24, testFlagAllocated).AddName(22, "Up").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_SYMLINK, `..\Public\file.txt`, true))
+
+	// A relative symlink to a path from the root of the volume.
+	mft.Entry(25, testFlagAllocated).AddName(22, "RootRel").AddAttribute(
+		parser.ATTR_TYPE_REPARSE_POINT, "", reparseContent(
+			parser.IO_REPARSE_TAG_SYMLINK, `\Users\Public\file.txt`, true))
+
+	return mft
+}
+
+func openPath(ntfs_ctx *parser.NTFSContext, path string) (int64, error) {
+	root, err := ntfs_ctx.GetMFT(5)
+	if err != nil {
+		return 0, err
+	}
+
+	mft_entry, err := root.Open(ntfs_ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return int64(mft_entry.Record_number()), nil
+}
+
+func TestReparsePointModel(t *testing.T) {
+	ntfs_ctx := buildReparseTree().Context()
+
+	mft_entry, err := ntfs_ctx.GetMFT(17)
+	assert.NoError(t, err)
+
+	model, err := parser.ModelMFTEntry(ntfs_ctx, mft_entry)
+	assert.NoError(t, err)
+	assert.NotNil(t, model.ReparsePoint)
+	assert.Equal(t, "MOUNT_POINT", model.ReparsePoint.TagName)
+	assert.Equal(t, `\??\C:\Users`, model.ReparsePoint.SubstituteName)
+	assert.Equal(t, `\??\C:\Users`, model.ReparsePoint.PrintName)
+
+	infos := parser.Stat(ntfs_ctx, mft_entry)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, "MOUNT_POINT", infos[0].ReparsePoint.TagName)
+	assert.Equal(t, `\??\C:\Users`, infos[0].ReparsePoint.SubstituteName)
+}
+
+func TestReparsePointMFTHighlight(t *testing.T) {
+	mft := buildReparseTree().Bytes()
+
+	rows := make(map[int64]*parser.MFTHighlight)
+	for row := range parser.ParseMFTFileWithOptions(context.Background(),
+		bytes.NewReader(mft), int64(len(mft)), 4096, testRecordSize,
+		0, parser.GetDefaultOptions()) {
+		rows[row.EntryNumber] = row
+	}
+
+	junction, pres := rows[17]
+	assert.True(t, pres)
+	assert.Equal(t, "MOUNT_POINT", junction.ReparseTag)
+	assert.Equal(t, `\??\C:\Users`, junction.ReparseSubstituteName)
+	assert.Equal(t, `\??\C:\Users`, junction.ReparsePrintName)
+
+	symlink, pres := rows[24]
+	assert.True(t, pres)
+	assert.Equal(t, "SYMLINK", symlink.ReparseTag)
+	assert.Equal(t, `..\Public\file.txt`, symlink.ReparseSubstituteName)
+
+	// Files without a reparse point have no tag.
+	assert.Equal(t, "", rows[23].ReparseTag)
+}
+
+func TestFollowReparsePoints(t *testing.T) {
+	ntfs_ctx := buildReparseTree().Context()
+
+	// By default reparse points are not followed.
+	id, err := openPath(ntfs_ctx, "Documents and Settings")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(17), id)
+
+	_, err = openPath(ntfs_ctx, `Documents and Settings\Public\file.txt`)
+	assert.Error(t, err)
+
+	options := parser.GetDefaultOptions()
+	options.FollowReparsePoints = true
+	options.VolumeDriveLetter = "C"
+	ntfs_ctx.SetOptions(options)
+
+	for path, expected := range map[string]int64{
+		`Documents and Settings\Public\file.txt`: 23,
+		`Documents and Settings`:                 16,
+		`Rel\file.txt`:                           23,
+		`Users\Public\Up`:                        23,
+		`Documents and Settings\Public\Up`:       23,
+		`Users\Public\..\..\Rel\file.txt`:        23,
+		`Users\Public\RootRel`:                   23,
+	} {
+		id, err := openPath(ntfs_ctx, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, expected, id, path)
+	}
+
+	_, err = openPath(ntfs_ctx, `Loop\file.txt`)
+	assert.Error(t, err)
+
+	for _, path := range []string{`OtherDrive\file.txt`, `Volume`} {
+		_, err = openPath(ntfs_ctx, path)
+		off_volume := &parser.ReparseOffVolumeError{}
+		assert.True(t, errors.As(err, &off_volume), path)
+	}
+
+	// Without a drive letter all drives are assumed to be this
+	// volume.
+	options.VolumeDriveLetter = ""
+	options.MaxReparseHops = 1
+	ntfs_ctx.SetOptions(options)
+
+	_, err = openPath(ntfs_ctx, `OtherDrive\file.txt`)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, new(*parser.ReparseOffVolumeError)))
+
+	// Too many hops
+	_, err = openPath(ntfs_ctx, `Documents and Settings\Public\Up`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package ntfs
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+func TestSecureIndexes(t *testing.T) {
+	ntfs_ctx := openRecordedNTFS(t, "ads_with_same_ids")
+
+	sii, err := parser.ParseSII(ntfs_ctx)
+	assert.NoError(t, err)
+	assert.True(t, len(sii) > 0)
+
+	// The entries are sorted by security id.
+	for idx := 1; idx < len(sii); idx++ {
+		assert.True(t, sii[idx-1].SecurityId < sii[idx].SecurityId)
+	}
+
+	descriptors, err := parser.ParseSecureDescriptors(ntfs_ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(sii), len(descriptors))
+
+	// Security id 256 is owned by SYSTEM
+	sd, err := parser.GetSecurityDescriptor(ntfs_ctx, 256)
+	assert.NoError(t, err)
+	assert.Equal(t, "S-1-5-18", sd.Owner)
+	assert.Equal(t, "S-1-5-32-544", sd.Group)
+	assert.Equal(t, "SYSTEM", sd.DACL.ACEs[0].Name)
+}
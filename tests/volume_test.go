@@ -0,0 +1,35 @@
+package ntfs
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+func TestVolumeInfo(t *testing.T) {
+	volume_information := make([]byte, parser.VOLUME_INFORMATION_SIZE)
+	volume_information[8] = 3
+	volume_information[9] = 1
+	putU16(volume_information, 10,
+		parser.VOLUME_IS_DIRTY|parser.VOLUME_CHKDSK_UNDERWAY)
+
+	mft := newTestMFT(32)
+	mft.Entry(5, testFlagAllocated|testFlagDirectory).AddName(5, ".").
+		AddChildren(map[uint64]string{3: "$Volume"})
+	mft.Entry(3, testFlagAllocated).AddName(5, "$Volume").
+		AddAttribute(parser.ATTR_TYPE_VOLUME_NAME, "", utf16Bytes("Evidence")).
+		AddAttribute(parser.ATTR_TYPE_VOLUME_INFORMATION, "", volume_information)
+	ntfs := mft.Context()
+
+	info, err := ntfs.VolumeInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, "Evidence", info.VolumeName)
+	assert.Equal(t, "3.1", info.Version)
+	assert.True(t, info.Dirty)
+	assert.Equal(t, []string{"CHKDSK_UNDERWAY", "DIRTY"}, info.Flags)
+
+	// There is no boot sector in a raw MFT.
+	assert.Equal(t, "", info.VolumeSerial)
+	assert.Equal(t, ntfs.RecordSize, info.RecordSize)
+}
@@ -0,0 +1,83 @@
+package ntfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Build a WOF reparse buffer for the file provider.
+func wofReparseContent(algorithm uint32) []byte {
+	buffer := make([]byte, 24)
+	putU32(buffer, 0, parser.IO_REPARSE_TAG_WOF)
+	putU16(buffer, 4, 16)
+	putU32(buffer, 8, 1)                         // WOF_EXTERNAL_INFO version
+	putU32(buffer, 12, parser.WOF_PROVIDER_FILE) // Provider
+	putU32(buffer, 16, 1)                        // FILE_PROVIDER_EXTERNAL_INFO_V1 version
+	putU32(buffer, 20, algorithm)
+	return buffer
+}
+
+func readStream(t *testing.T, ntfs *parser.NTFSContext, mft_id int64) []byte {
+	mft_entry, err := ntfs.GetMFT(mft_id)
+	assert.NoError(t, err)
+
+	reader, err := parser.OpenStream(ntfs, mft_entry,
+		parser.ATTR_TYPE_DATA, parser.WILDCARD_STREAM_ID, "")
+	assert.NoError(t, err)
+
+	buf := make([]byte, 64)
+	n, err := reader.ReadAt(buf, 0)
+	if err != io.EOF {
+		assert.NoError(t, err)
+	}
+	return buf[:n]
+}
+
+func TestWofOpenStream(t *testing.T) {
+	// The unnamed $DATA stream of a WOF compressed file only holds
+	// zeros. A single chunk whose compressed size is the same as
+	// its size is stored uncompressed.
+	sparse := make([]byte, 10)
+	chunk := []byte("0123456789")
+
+	mft := newTestMFT(32)
+	mft.Entry(30, testFlagAllocated).AddName(5, "compact.exe").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", sparse).
+		AddAttribute(parser.ATTR_TYPE_DATA, parser.WOF_COMPRESSED_DATA_STREAM, chunk).
+		AddAttribute(parser.ATTR_TYPE_REPARSE_POINT, "",
+			wofReparseContent(parser.WOF_COMPRESSION_XPRESS4K))
+
+	// Without the WofCompressedData stream the $DATA stream is
+	// read as is.
+	mft.Entry(31, testFlagAllocated).AddName(5, "missing.exe").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", []byte("plain data")).
+		AddAttribute(parser.ATTR_TYPE_REPARSE_POINT, "",
+			wofReparseContent(parser.WOF_COMPRESSION_XPRESS4K))
+
+	// Files without the WOF reparse point are not decompressed.
+	mft.Entry(29, testFlagAllocated).AddName(5, "other.exe").
+		AddAttribute(parser.ATTR_TYPE_DATA, "", sparse).
+		AddAttribute(parser.ATTR_TYPE_DATA, parser.WOF_COMPRESSED_DATA_STREAM, chunk)
+	ntfs := mft.Context()
+
+	assert.Equal(t, chunk, readStream(t, ntfs, 30))
+	assert.Equal(t, []byte("plain data"), readStream(t, ntfs, 31))
+	assert.Equal(t, sparse, readStream(t, ntfs, 29))
+
+	// The compressed data is still available as a stream.
+	mft_entry, err := ntfs.GetMFT(30)
+	assert.NoError(t, err)
+
+	reader, err := parser.OpenStream(ntfs, mft_entry, parser.ATTR_TYPE_DATA,
+		parser.WILDCARD_STREAM_ID, parser.WOF_COMPRESSED_DATA_STREAM)
+	assert.NoError(t, err)
+
+	buf := make([]byte, len(chunk))
+	_, err = reader.ReadAt(buf, 0)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(chunk, buf))
+}